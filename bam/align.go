@@ -0,0 +1,192 @@
+package bam
+
+import (
+	"math"
+
+	"github.com/edotau/goFish/code"
+)
+
+// AlignMode selects which ends of the reference and query may be left unaligned.
+type AlignMode byte
+
+const (
+	// LocalAlign is smith-waterman: the best scoring segments of both sequences are aligned and the
+	// rest of the query is soft clipped.
+	LocalAlign AlignMode = iota
+	// GlobalAlign is needleman-wunsch: both sequences are aligned from end to end.
+	GlobalAlign
+	// GlocalAlign aligns the whole query to any segment of the reference, which is how reads are placed
+	// against a reference window.
+	GlocalAlign
+)
+
+// Scoring holds the substitution scores of A, C, G, T and N along with affine gap penalties. A gap of
+// length k scores GapOpen + k*GapExtend, so both penalties are expected to be negative.
+type Scoring struct {
+	Matrix    [5][5]int64
+	GapOpen   int64
+	GapExtend int64
+}
+
+// DefaultScoring uses the same scores as bwa mem: match 1, mismatch -4, gap open -6 and gap extend -1.
+// Any comparison to an N scores -1.
+var DefaultScoring Scoring = NewScoring(1, -4, -6, -1)
+
+// Alignment is the result of a pairwise alignment. Start and end coordinates are zero-based and half-open.
+// The cigar describes the query against the reference starting at RefStart and includes soft clips for
+// query bases left unaligned in local mode.
+type Alignment struct {
+	Score      int64
+	RefStart   int
+	RefEnd     int
+	QueryStart int
+	QueryEnd   int
+	Cigar      []ByteCigar
+}
+
+// NewScoring will create a scoring matrix from a match and mismatch score. Comparisons to N score -1.
+func NewScoring(match int64, mismatch int64, gapOpen int64, gapExtend int64) Scoring {
+	ans := Scoring{GapOpen: gapOpen, GapExtend: gapExtend}
+	for i := range ans.Matrix {
+		for j := range ans.Matrix[i] {
+			switch {
+			case i == 4 || j == 4:
+				ans.Matrix[i][j] = -1
+			case i == j:
+				ans.Matrix[i][j] = match
+			default:
+				ans.Matrix[i][j] = mismatch
+			}
+		}
+	}
+	return ans
+}
+
+// scoreIndex returns the row of the scoring matrix for a base. Lower case bases are scored as upper case,
+// and gaps or ambiguous bases are scored as N.
+func scoreIndex(b code.Dna) int {
+	switch b {
+	case code.A, code.MaskA:
+		return 0
+	case code.C, code.MaskC:
+		return 1
+	case code.G, code.MaskG:
+		return 2
+	case code.T, code.MaskT:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// negInf is low enough to mark unreachable cells without overflowing when penalties are added.
+const negInf int64 = math.MinInt64 / 4
+
+// Align will perform a pairwise alignment of a query to a reference with affine gap penalties using the
+// gotoh algorithm. Three matrices track alignments ending in a match or mismatch (M), an insertion in the
+// query (I) and a deletion from the query (D). Ties are broken in the order M, I, D by ByteMatrixTrace.
+func Align(ref []code.Dna, query []code.Dna, mode AlignMode, scoring Scoring) Alignment {
+	rows, cols := len(query)+1, len(ref)+1
+	m, ins, del := make([]int64, rows*cols), make([]int64, rows*cols), make([]int64, rows*cols)
+	// each trace records the state of the previous cell, or 0 where an alignment starts
+	mTrace, insTrace, delTrace := make([]byte, rows*cols), make([]byte, rows*cols), make([]byte, rows*cols)
+	for k := range m {
+		m[k], ins[k], del[k] = negInf, negInf, negInf
+	}
+	open, extend := scoring.GapOpen+scoring.GapExtend, scoring.GapExtend
+	if mode != LocalAlign {
+		m[0] = 0
+		for i := 1; i < rows; i++ {
+			ins[i*cols], insTrace[i*cols] = open+int64(i-1)*extend, 'I'
+		}
+		if rows > 1 {
+			insTrace[cols] = 'M'
+		}
+	}
+	switch mode {
+	case GlobalAlign:
+		for j := 1; j < cols; j++ {
+			del[j], delTrace[j] = open+int64(j-1)*extend, 'D'
+		}
+		if cols > 1 {
+			delTrace[1] = 'M'
+		}
+	case GlocalAlign:
+		for j := 1; j < cols; j++ {
+			m[j] = 0
+		}
+	}
+
+	best, bestI, bestJ := int64(0), 0, 0
+	var prev int64
+	var op byte
+	for i := 1; i < rows; i++ {
+		q := scoreIndex(query[i-1])
+		for j := 1; j < cols; j++ {
+			k, diag, up, left := i*cols+j, (i-1)*cols+j-1, (i-1)*cols+j, i*cols+j-1
+			prev, op = ByteMatrixTrace(m[diag], ins[diag], del[diag])
+			if mode == LocalAlign && prev <= 0 {
+				prev, op = 0, 0
+			}
+			if prev > negInf {
+				m[k], mTrace[k] = prev+scoring.Matrix[q][scoreIndex(ref[j-1])], op
+			}
+			ins[k], insTrace[k] = ByteMatrixTrace(m[up]+open, ins[up]+extend, del[up]+open)
+			del[k], delTrace[k] = ByteMatrixTrace(m[left]+open, ins[left]+open, del[left]+extend)
+			if mode == LocalAlign && m[k] > best {
+				best, bestI, bestJ = m[k], i, j
+			}
+		}
+	}
+
+	var state byte = 'M'
+	switch mode {
+	case GlobalAlign:
+		bestI, bestJ = rows-1, cols-1
+		best, state = ByteMatrixTrace(m[len(m)-1], ins[len(m)-1], del[len(m)-1])
+	case GlocalAlign:
+		bestI, best = rows-1, negInf
+		for j := 0; j < cols; j++ {
+			k := bestI*cols + j
+			if score, s := ByteMatrixTrace(m[k], ins[k], negInf); score > best {
+				best, bestJ, state = score, j, s
+			}
+		}
+	}
+
+	ans := Alignment{Score: best, RefEnd: bestJ, QueryEnd: bestI}
+	i, j := bestI, bestJ
+	if mode == LocalAlign && best == 0 {
+		i, j = 0, 0
+		ans.RefEnd, ans.QueryEnd = 0, 0
+	}
+	for i > 0 || (mode == GlobalAlign && j > 0) {
+		k := i*cols + j
+		var next byte
+		switch state {
+		case 'M':
+			next = mTrace[k]
+			i, j = i-1, j-1
+		case 'I':
+			next = insTrace[k]
+			i--
+		case 'D':
+			next = delTrace[k]
+			j--
+		}
+		ans.Cigar = AddCigarByte(ans.Cigar, ByteCigar{RunLen: 1, Op: state})
+		if next == 0 {
+			break
+		}
+		state = next
+	}
+	ReverseBytesCigar(ans.Cigar)
+	ans.RefStart, ans.QueryStart = j, i
+	if ans.QueryStart > 0 {
+		ans.Cigar = append([]ByteCigar{{RunLen: uint16(ans.QueryStart), Op: SoftClip}}, ans.Cigar...)
+	}
+	if ans.QueryEnd < len(query) {
+		ans.Cigar = AddCigarByte(ans.Cigar, ByteCigar{RunLen: uint16(len(query) - ans.QueryEnd), Op: SoftClip})
+	}
+	return ans
+}
@@ -0,0 +1,56 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+)
+
+var alignTests = []struct {
+	ref        string
+	query      string
+	mode       AlignMode
+	score      int64
+	refStart   int
+	refEnd     int
+	queryStart int
+	cigar      string
+}{
+	{"ACGTACGT", "ACGTACGT", GlobalAlign, 16, 0, 8, 0, "8M"},
+	{"AACCGGTT", "AACCTT", GlobalAlign, 3, 0, 8, 0, "4M2D2M"},
+	{"AACCTT", "AACCGGTT", GlobalAlign, 3, 0, 6, 0, "4M2I2M"},
+	{"ACGTACGT", "ACGAACGT", GlobalAlign, 11, 0, 8, 0, "8M"},
+	{"GGACGTACGTAA", "ACGTACGT", GlobalAlign, -2, 0, 12, 0, "2D8M2D"},
+	{"GGACGTACGTAA", "ACGTACGT", GlocalAlign, 16, 2, 10, 0, "8M"},
+	{"ACGTACGT", "TTACGTACGTGG", GlocalAlign, -2, 0, 8, 0, "2I8M2I"},
+	{"TTTTACGTACGTTTTT", "GGACGTACGTGG", LocalAlign, 16, 4, 12, 2, "2S8M2S"},
+	{"CCCCAAGACCGGTTCCCCAAAACCCC", "AAGACCTTCCCCAAAA", LocalAlign, 23, 4, 22, 0, "6M2D10M"},
+	{"CCCC", "GGGG", LocalAlign, 0, 0, 0, 0, "4S"},
+	// one gap of two bases scores better than two gaps of one base
+	{"AAAACCCGTTTT", "AAAACGTTTT", GlobalAlign, 11, 0, 12, 0, "4M2D6M"},
+}
+
+func TestAlign(t *testing.T) {
+	scoring := NewScoring(2, -3, -5, -2)
+	for _, test := range alignTests {
+		ref, query := code.ToDna([]byte(test.ref)), code.ToDna([]byte(test.query))
+		ans := Align(ref, query, test.mode, scoring)
+		if ans.Score != test.score || ans.RefStart != test.refStart || ans.RefEnd != test.refEnd || ans.QueryStart != test.queryStart || ByteCigarToString(ans.Cigar) != test.cigar {
+			t.Errorf("Error: aligning %s to %s in mode %d returned %+v with cigar %s, expected score %d, ref %d-%d and cigar %s...\n",
+				test.query, test.ref, test.mode, ans, ByteCigarToString(ans.Cigar), test.score, test.refStart, test.refEnd, test.cigar)
+		}
+		if QueryRunLen(ans.Cigar) != len(query) || ReferenceLength(ans.Cigar) != ans.RefEnd-ans.RefStart {
+			t.Errorf("Error: cigar %s does not match the aligned coordinates %+v...\n", ByteCigarToString(ans.Cigar), ans)
+		}
+	}
+}
+
+func TestDefaultScoring(t *testing.T) {
+	ref := code.ToDna([]byte("acgtNACGTTTGCA"))
+	if ans := Align(ref, ref, GlobalAlign, DefaultScoring); ans.Score != 12 || ByteCigarToString(ans.Cigar) != "14M" {
+		t.Errorf("Error: self alignment returned score %d and cigar %s...\n", ans.Score, ByteCigarToString(ans.Cigar))
+	}
+	if ans := Align(ref, nil, GlobalAlign, DefaultScoring); ans.Score != -20 || ByteCigarToString(ans.Cigar) != "14D" {
+		t.Errorf("Error: aligning an empty query returned score %d and cigar %s...\n", ans.Score, ByteCigarToString(ans.Cigar))
+	}
+}
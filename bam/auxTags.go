@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"log"
 	"math"
+	"strconv"
+	"strings"
 	"unsafe"
+
+	"github.com/edotau/goFish/simpleio"
 )
 
 // ASCII is a printable ASCII character included in an Aux tag.
@@ -135,3 +140,336 @@ func (a Aux) Value() interface{} {
 }
 
 func (a Aux) Type() byte { return a[2] }
+
+// NewAux will parse a single sam formatted auxiliary field, TAG:TYPE:VALUE, and encode the value into the binary
+// representation used by bam files. Sam integers are stored using the smallest type able to hold the value.
+func NewAux(field string) Aux {
+	if len(field) < 5 || field[2] != ':' || field[4] != ':' {
+		log.Fatalf("Error: auxiliary field %s is not in TAG:TYPE:VALUE format...\n", field)
+	}
+	ans := Aux{field[0], field[1], field[3]}
+	value := field[5:]
+	switch ans.Type() {
+	case 'A':
+		if len(value) != 1 {
+			log.Fatalf("Error: auxiliary field %s must contain a single character...\n", field)
+		}
+		ans = append(ans, value[0])
+	case 'i':
+		n, err := strconv.ParseInt(value, 10, 64)
+		simpleio.StdError(err)
+		ans = appendInt(ans, n)
+	case 'f':
+		f, err := strconv.ParseFloat(value, 32)
+		simpleio.StdError(err)
+		ans = appendUint32(ans, math.Float32bits(float32(f)))
+	case 'Z', 'H':
+		ans = append(ans, value...)
+	case 'B':
+		words := strings.Split(value, ",")
+		ans = append(ans, words[0][0])
+		ans = appendUint32(ans, uint32(len(words)-1))
+		for _, word := range words[1:] {
+			switch words[0][0] {
+			case 'c', 'C':
+				n, err := strconv.ParseInt(word, 10, 64)
+				simpleio.StdError(err)
+				ans = append(ans, byte(n))
+			case 's', 'S':
+				n, err := strconv.ParseInt(word, 10, 64)
+				simpleio.StdError(err)
+				ans = appendUint16(ans, uint16(n))
+			case 'i', 'I':
+				n, err := strconv.ParseInt(word, 10, 64)
+				simpleio.StdError(err)
+				ans = appendUint32(ans, uint32(n))
+			case 'f':
+				f, err := strconv.ParseFloat(word, 32)
+				simpleio.StdError(err)
+				ans = appendUint32(ans, math.Float32bits(float32(f)))
+			default:
+				log.Fatalf("Error: encountered unknown auxiliary array value type %c...\n", words[0][0])
+			}
+		}
+	default:
+		log.Fatalf("Error: Found invalid auxiliary value type %c...\n", ans.Type())
+	}
+	return ans
+}
+
+// appendInt will encode an integer auxiliary value using the smallest bam integer type that can represent it.
+func appendInt(a Aux, n int64) Aux {
+	switch {
+	case n >= 0 && n <= math.MaxUint8:
+		a[2] = 'C'
+		return append(a, byte(n))
+	case n >= math.MinInt8 && n < 0:
+		a[2] = 'c'
+		return append(a, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		a[2] = 'S'
+		return appendUint16(a, uint16(n))
+	case n >= math.MinInt16 && n < 0:
+		a[2] = 's'
+		return appendUint16(a, uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		a[2] = 'I'
+		return appendUint32(a, uint32(n))
+	case n >= math.MinInt32 && n < 0:
+		a[2] = 'i'
+		return appendUint32(a, uint32(n))
+	default:
+		log.Fatalf("Error: auxiliary integer %d does not fit into 32 bits...\n", n)
+		return a
+	}
+}
+
+// MarshalAux will convert an Aux into the bytes stored in a bam record. Z and H values are terminated with a zero byte.
+func MarshalAux(a Aux) []byte {
+	if t := a.Type(); t == 'Z' || t == 'H' {
+		return append(a[:len(a):len(a)], 0)
+	}
+	return a
+}
+
+// appendUint16 will append a little endian uint16 to a byte slice.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+// appendUint32 will append a little endian uint32 to a byte slice.
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// Name returns the two character tag of an auxiliary field.
+func (a Aux) Name() string { return string(a[:2]) }
+
+// Int will return the value of an integer auxiliary field, regardless of the size used to encode it.
+func (a Aux) Int() (int, bool) {
+	switch v := a.Value().(type) {
+	case int8:
+		return int(v), true
+	case uint8:
+		if a.Type() == 'C' {
+			return int(v), true
+		}
+	case int16:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// ToString will format an auxiliary field as sam text, TAG:TYPE:VALUE. Integers of every size are written with the i type.
+func (a Aux) ToString() string {
+	switch t := a.Type(); t {
+	case 'A':
+		return fmt.Sprintf("%s:A:%c", a.Name(), a[3])
+	case 'c', 'C', 's', 'S', 'i', 'I':
+		n, _ := a.Int()
+		return fmt.Sprintf("%s:i:%d", a.Name(), n)
+	case 'f':
+		return fmt.Sprintf("%s:f:%v", a.Name(), a.Value())
+	case 'Z', 'H':
+		return fmt.Sprintf("%s:%c:%s", a.Name(), t, a[3:])
+	case 'B':
+		return fmt.Sprintf("%s:B:%s", a.Name(), auxArrayToString(a.Value()))
+	default:
+		log.Fatalf("Error: Found invalid auxiliary value type %c...\n", t)
+		return ""
+	}
+}
+
+// NewAuxValue will encode a go value into an auxiliary field. ASCII values are stored as A, strings and Text as Z,
+// Hex as H, integers using the smallest type able to hold the value, floats as f and slices of numbers as B arrays.
+// Note that []byte is the same type as []uint8 and will be encoded as an array, use Text or Hex for byte strings.
+func NewAuxValue(tag string, value interface{}) Aux {
+	if len(tag) != 2 {
+		log.Fatalf("Error: auxiliary tag %s must be two characters...\n", tag)
+	}
+	ans := Aux{tag[0], tag[1], 0}
+	switch v := value.(type) {
+	case ASCII:
+		ans[2] = 'A'
+		return append(ans, byte(v))
+	case int:
+		return appendInt(ans, int64(v))
+	case int8:
+		return appendInt(ans, int64(v))
+	case int16:
+		return appendInt(ans, int64(v))
+	case int32:
+		return appendInt(ans, int64(v))
+	case int64:
+		return appendInt(ans, v)
+	case uint:
+		return appendInt(ans, int64(v))
+	case uint8:
+		return appendInt(ans, int64(v))
+	case uint16:
+		return appendInt(ans, int64(v))
+	case uint32:
+		return appendInt(ans, int64(v))
+	case float32:
+		ans[2] = 'f'
+		return appendUint32(ans, math.Float32bits(v))
+	case float64:
+		ans[2] = 'f'
+		return appendUint32(ans, math.Float32bits(float32(v)))
+	case string:
+		ans[2] = 'Z'
+		return append(ans, v...)
+	case Text:
+		ans[2] = 'Z'
+		return append(ans, v...)
+	case Hex:
+		ans[2] = 'H'
+		return append(ans, fmt.Sprintf("%X", []byte(v))...)
+	case []int8, []uint8, []int16, []uint16, []int32, []uint32, []float32:
+		ans[2] = 'B'
+		values := auxArrayToString(v)
+		ans = append(ans, values[0])
+		ans = appendUint32(ans, uint32(binary.Size(v)/arraySize(values[0])))
+		var buf bytes.Buffer
+		simpleio.StdError(binary.Write(&buf, binary.LittleEndian, v))
+		return append(ans, buf.Bytes()...)
+	default:
+		log.Fatalf("Error: cannot encode %T as an auxiliary value...\n", value)
+		return nil
+	}
+}
+
+// arraySize returns the number of bytes used by each value in a B auxiliary array.
+func arraySize(t byte) int {
+	switch t {
+	case 'c', 'C':
+		return 1
+	case 's', 'S':
+		return 2
+	default:
+		return 4
+	}
+}
+
+// AuxFields is the list of auxiliary fields of a single alignment.
+type AuxFields []Aux
+
+// ParseAux will parse the tab delimited auxiliary fields of a sam record into typed auxiliary tags.
+func ParseAux(aux string) AuxFields {
+	if aux == "" {
+		return nil
+	}
+	words := strings.Split(aux, "\t")
+	ans := make(AuxFields, len(words))
+	for i := range words {
+		ans[i] = NewAux(words[i])
+	}
+	return ans
+}
+
+// Get will return the auxiliary field with a matching tag.
+func (fields AuxFields) Get(tag string) (Aux, bool) {
+	for _, a := range fields {
+		if a.Name() == tag {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Set will replace the value of an auxiliary field with a matching tag, or append a new field if the tag is not present.
+func (fields *AuxFields) Set(tag string, value interface{}) {
+	a := NewAuxValue(tag, value)
+	for i := range *fields {
+		if (*fields)[i].Name() == tag {
+			(*fields)[i] = a
+			return
+		}
+	}
+	*fields = append(*fields, a)
+}
+
+// Delete will remove the auxiliary field with a matching tag and return true if the tag was found.
+func (fields *AuxFields) Delete(tag string) bool {
+	for i := range *fields {
+		if (*fields)[i].Name() == tag {
+			*fields = append((*fields)[:i], (*fields)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ToString will format the auxiliary fields as tab delimited sam text.
+func (fields AuxFields) ToString() string {
+	words := make([]string, len(fields))
+	for i, a := range fields {
+		words[i] = a.ToString()
+	}
+	return strings.Join(words, "\t")
+}
+
+// AuxFields will parse every auxiliary field of a sam record into typed tags.
+func (s *Sam) AuxFields() AuxFields {
+	return ParseAux(s.Aux)
+}
+
+// Tag will return the typed value of an auxiliary field, for example rec.Tag("NM"). Only the matching field is parsed.
+func (s *Sam) Tag(tag string) (Aux, bool) {
+	if i, j := findAuxField(s.Aux, tag); i >= 0 {
+		return NewAux(s.Aux[i:j]), true
+	}
+	return nil, false
+}
+
+// SetTag will set the value of an auxiliary field, for example rec.SetTag("RG", "x"). Every other field of the
+// record is left untouched, so the original text of the remaining tags is preserved.
+func (s *Sam) SetTag(tag string, value interface{}) {
+	field := NewAuxValue(tag, value).ToString()
+	if i, j := findAuxField(s.Aux, tag); i >= 0 {
+		s.Aux = s.Aux[:i] + field + s.Aux[j:]
+	} else if s.Aux == "" {
+		s.Aux = field
+	} else {
+		s.Aux = s.Aux + "\t" + field
+	}
+}
+
+// DeleteTag will remove an auxiliary field from a record and return true if the tag was found.
+func (s *Sam) DeleteTag(tag string) bool {
+	i, j := findAuxField(s.Aux, tag)
+	switch {
+	case i < 0:
+		return false
+	case j < len(s.Aux):
+		s.Aux = s.Aux[:i] + s.Aux[j+1:]
+	case i > 0:
+		s.Aux = s.Aux[:i-1]
+	default:
+		s.Aux = ""
+	}
+	return true
+}
+
+// findAuxField returns the start and end index of an auxiliary field in the sam text, or -1 if the tag is not present.
+func findAuxField(aux string, tag string) (int, int) {
+	for i := 0; i < len(aux); {
+		j := strings.IndexByte(aux[i:], '\t')
+		if j < 0 {
+			j = len(aux)
+		} else {
+			j += i
+		}
+		if j-i > 3 && aux[i:i+2] == tag && aux[i+2] == ':' {
+			return i, j
+		}
+		i = j + 1
+	}
+	return -1, -1
+}
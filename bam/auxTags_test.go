@@ -0,0 +1,115 @@
+package bam
+
+import (
+	"reflect"
+	"testing"
+)
+
+var auxValueTests = []struct {
+	tag      string
+	value    interface{}
+	expected string
+}{
+	{"XA", ASCII('x'), "XA:A:x"},
+	{"NM", 3, "NM:i:3"},
+	{"XN", -200, "XN:i:-200"},
+	{"XL", uint32(70000), "XL:i:70000"},
+	{"XF", 1.5, "XF:f:1.5"},
+	{"RG", "group one", "RG:Z:group one"},
+	{"XT", Text("text"), "XT:Z:text"},
+	{"XH", Hex{0x1a, 0xe3, 0x01}, "XH:H:1AE301"},
+	{"XB", []int8{-1, 2, 3}, "XB:B:c,-1,2,3"},
+	{"XC", []uint8{1, 255}, "XC:B:C,1,255"},
+	{"XS", []uint16{1, 65000}, "XS:B:S,1,65000"},
+	{"XI", []int32{-5, 100000}, "XI:B:i,-5,100000"},
+	{"XG", []float32{0.5, 2}, "XG:B:f,0.5,2"},
+}
+
+func TestNewAuxValue(t *testing.T) {
+	for _, test := range auxValueTests {
+		a := NewAuxValue(test.tag, test.value)
+		if a.ToString() != test.expected {
+			t.Errorf("Error: %v was encoded as %s, expected %s...\n", test.value, a.ToString(), test.expected)
+		}
+		if !reflect.DeepEqual(NewAux(test.expected), a) {
+			t.Errorf("Error: %s parsed from sam text does not match the encoded value...\n", test.expected)
+		}
+	}
+}
+
+func TestSamTags(t *testing.T) {
+	record := Sam{Aux: "NM:i:1\tXF:f:0.25\tXB:B:s,-1,2"}
+	if nm, ok := record.Tag("NM"); !ok {
+		t.Errorf("Error: NM tag was not found...\n")
+	} else if n, _ := nm.Int(); n != 1 {
+		t.Errorf("Error: NM tag value %d, expected 1...\n", n)
+	}
+	if b, ok := record.Tag("XB"); !ok || !reflect.DeepEqual(b.Value(), []int16{-1, 2}) {
+		t.Errorf("Error: XB array was not parsed...\n")
+	}
+	if _, ok := record.Tag("RG"); ok {
+		t.Errorf("Error: found RG tag which does not exist...\n")
+	}
+	record.SetTag("RG", "x")
+	record.SetTag("NM", 4)
+	if record.Aux != "NM:i:4\tXF:f:0.25\tXB:B:s,-1,2\tRG:Z:x" {
+		t.Errorf("Error: setting tags returned %s...\n", record.Aux)
+	}
+	fields := record.AuxFields()
+	if fields.ToString() != record.Aux {
+		t.Errorf("Error: auxiliary fields did not round trip: %s...\n", fields.ToString())
+	}
+	fields.Set("XF", float32(2))
+	if !fields.Delete("NM") || fields.Delete("NM") {
+		t.Errorf("Error: NM tag was not deleted...\n")
+	}
+	if fields.ToString() != "XF:f:2\tXB:B:s,-1,2\tRG:Z:x" {
+		t.Errorf("Error: auxiliary fields were not updated: %s...\n", fields.ToString())
+	}
+	if !record.DeleteTag("XF") || !record.DeleteTag("RG") || record.DeleteTag("XF") {
+		t.Errorf("Error: tags were not deleted...\n")
+	}
+	if record.Aux != "NM:i:4\tXB:B:s,-1,2" {
+		t.Errorf("Error: deleting tags returned %s...\n", record.Aux)
+	}
+	record.DeleteTag("NM")
+	record.DeleteTag("XB")
+	if record.Aux != "" {
+		t.Errorf("Error: deleting every tag returned %s...\n", record.Aux)
+	}
+}
+
+// TestTagsRoundTrip will set tags on the test records and check the values are the same after writing and reading the bam.
+func TestTagsRoundTrip(t *testing.T) {
+	for _, test := range readBamTests {
+		header, records := Read(test.bam)
+		var expected []Sam
+		filename := t.TempDir() + "/tags.bam"
+		writer := WriteBinaryHeader(filename, header)
+		for i := range records {
+			i.SetTag("RG", "x")
+			i.SetTag("NM", len(expected))
+			i.SetTag("XB", []uint16{uint16(len(expected)), 7})
+			i.SetTag("XH", Hex{0xbe, 0xef})
+			expected = append(expected, i)
+			WriteBam(writer, &i)
+		}
+		writer.Close()
+		_, results := BasicRead(filename)
+		if len(results) != len(expected) {
+			t.Fatalf("Error: wrote %d records, but read back %d...\n", len(expected), len(results))
+		}
+		for i := range results {
+			if results[i].Aux != expected[i].Aux || ToString(results[i]) != ToString(&expected[i]) {
+				t.Fatalf("Error: tags did not round trip, %s != %s...\n", results[i].Aux, expected[i].Aux)
+			}
+			for _, tag := range []string{"RG", "NM", "XB", "XH"} {
+				a, _ := results[i].Tag(tag)
+				b, _ := expected[i].Tag(tag)
+				if !reflect.DeepEqual(a.Value(), b.Value()) {
+					t.Fatalf("Error: %s tag did not round trip...\n", tag)
+				}
+			}
+		}
+	}
+}
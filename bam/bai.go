@@ -222,3 +222,71 @@ func (i *Bai) AllOffsets() map[int][]bgzf.Offset {
 	}
 	return m
 }
+
+// Chunks will use the bins and linear index of a reference to collect the bgzf chunks that may contain
+// alignments overlapping the zero-based, half-open interval start-end. Chunks are sorted and merged
+// so each region of the bam file only needs to be decompressed once.
+func (i *Bai) Chunks(refId int, start int, end int) []Chunk {
+	if refId < 0 || refId >= len(i.Refs) || start >= end {
+		return nil
+	}
+	ref := i.Refs[refId]
+	var minOffset bgzf.Offset
+	if len(ref.Intervals) > 0 {
+		if idx := start >> 14; idx < len(ref.Intervals) {
+			minOffset = ref.Intervals[idx]
+		} else {
+			minOffset = ref.Intervals[len(ref.Intervals)-1]
+		}
+	}
+	overlap := make(map[uint32]bool)
+	for _, b := range reg2bins(start, end) {
+		overlap[b] = true
+	}
+	var chunks []Chunk
+	for _, b := range ref.Bins {
+		if !overlap[b.binNum] {
+			continue
+		}
+		for _, c := range b.Chunks {
+			if fromOffset(c.End) > fromOffset(minOffset) {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	sort.Slice(chunks, func(a, b int) bool { return fromOffset(chunks[a].Begin) < fromOffset(chunks[b].Begin) })
+	var ans []Chunk
+	for _, c := range chunks {
+		if len(ans) > 0 && fromOffset(c.Begin) <= fromOffset(ans[len(ans)-1].End) {
+			if fromOffset(c.End) > fromOffset(ans[len(ans)-1].End) {
+				ans[len(ans)-1].End = c.End
+			}
+		} else {
+			ans = append(ans, c)
+		}
+	}
+	return ans
+}
+
+// reg2bins will calculate the list of bins that may overlap with the zero-based, half-open region beg-end.
+// The function follows the binning scheme described in section 5.3 of the sam/bam specs.
+func reg2bins(beg int, end int) []uint32 {
+	var ans []uint32 = []uint32{0}
+	end--
+	for k := 1 + (beg >> 26); k <= 1+(end>>26); k++ {
+		ans = append(ans, uint32(k))
+	}
+	for k := 9 + (beg >> 23); k <= 9+(end>>23); k++ {
+		ans = append(ans, uint32(k))
+	}
+	for k := 73 + (beg >> 20); k <= 73+(end>>20); k++ {
+		ans = append(ans, uint32(k))
+	}
+	for k := 585 + (beg >> 17); k <= 585+(end>>17); k++ {
+		ans = append(ans, uint32(k))
+	}
+	for k := 4681 + (beg >> 14); k <= 4681+(end>>14); k++ {
+		ans = append(ans, uint32(k))
+	}
+	return ans
+}
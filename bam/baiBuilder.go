@@ -0,0 +1,207 @@
+package bam
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/edotau/goFish/simpleio"
+)
+
+// metaBin is the pseudo-bin used by samtools to store the Metadata of each reference in a bai index.
+const metaBin uint32 = 37450
+
+// BaiBuilder accumulates the bins, chunks and 16kb linear index of a coordinate sorted bam file.
+// Records are added in the same order they appear in the file along with the bgzf chunk they occupy.
+type BaiBuilder struct {
+	refs    []*refBuilder
+	noCoor  uint64
+	lastRef int
+	lastPos int
+}
+
+// refBuilder holds the index data of a single reference sequence while it is being built.
+type refBuilder struct {
+	bins      map[uint32]*bin
+	intervals []uint64
+	meta      Metadata
+	used      bool
+}
+
+// NewBaiBuilder allocates memory for a new bai index builder.
+func NewBaiBuilder() *BaiBuilder {
+	return &BaiBuilder{lastRef: -1, lastPos: -1}
+}
+
+// Add will record a single alignment in the index. RefId and pos are taken from the bam record
+// (zero-based, -1 if unplaced), end is the zero-based, exclusive end of the alignment on the reference,
+// and chunk contains the virtual offsets where the record begins and ends in the bgzf file.
+func (b *BaiBuilder) Add(refId int, pos int, end int, flag uint16, chunk Chunk) {
+	if refId < 0 {
+		b.noCoor++
+		b.lastRef, b.lastPos = maxCoord, maxCoord
+		return
+	}
+	if refId < b.lastRef || (refId == b.lastRef && pos < b.lastPos) {
+		log.Fatalf("Error: bam records must be sorted by coordinate to build an index, found %d:%d after %d:%d...\n", refId, pos, b.lastRef, b.lastPos)
+	}
+	b.lastRef, b.lastPos = refId, pos
+	for len(b.refs) <= refId {
+		b.refs = append(b.refs, &refBuilder{bins: make(map[uint32]*bin)})
+	}
+	ref := b.refs[refId]
+	if !ref.used {
+		ref.used = true
+		ref.meta.UnmappedBegin = fromOffset(chunk.Begin)
+	}
+	ref.meta.UnmappedEnd = fromOffset(chunk.End)
+	if flag&0x4 != 0 {
+		ref.meta.UnmappedCount++
+	} else {
+		ref.meta.MappedCount++
+	}
+	if end <= pos {
+		end = pos + 1
+	}
+	binNum := reg2bin(pos, end)
+	curr, ok := ref.bins[binNum]
+	if !ok {
+		curr = &bin{binNum: binNum}
+		ref.bins[binNum] = curr
+	}
+	if n := len(curr.Chunks); n > 0 && curr.Chunks[n-1].End == chunk.Begin {
+		curr.Chunks[n-1].End = chunk.End
+	} else {
+		curr.Chunks = append(curr.Chunks, chunk)
+	}
+	for window := pos >> 14; window <= (end-1)>>14; window++ {
+		for len(ref.intervals) <= window {
+			ref.intervals = append(ref.intervals, 0)
+		}
+		if ref.intervals[window] == 0 {
+			ref.intervals[window] = fromOffset(chunk.Begin)
+		}
+	}
+}
+
+// Bai will finish building the index for a bam file containing numRefs reference sequences in its header.
+// Empty linear index windows are filled with the previous offset so every window points to a valid location.
+func (b *BaiBuilder) Bai(numRefs int) *Bai {
+	ans := &Bai{Magic: baiMagic, Refs: make([]Reference, numRefs)}
+	var offset uint64
+	for refId := 0; refId < len(b.refs) && refId < numRefs; refId++ {
+		ref := b.refs[refId]
+		if !ref.used {
+			continue
+		}
+		for _, curr := range ref.bins {
+			ans.Refs[refId].Bins = append(ans.Refs[refId].Bins, *curr)
+		}
+		sort.Slice(ans.Refs[refId].Bins, func(i, j int) bool { return ans.Refs[refId].Bins[i].binNum < ans.Refs[refId].Bins[j].binNum })
+		offset = ref.meta.UnmappedBegin
+		ans.Refs[refId].Intervals = make([]bgzf.Offset, len(ref.intervals))
+		for i, voffset := range ref.intervals {
+			if voffset != 0 {
+				offset = voffset
+			}
+			ans.Refs[refId].Intervals[i] = toOffset(offset)
+		}
+		ans.Refs[refId].Meta = ref.meta
+	}
+	noCoor := b.noCoor
+	ans.UnmappedCount = &noCoor
+	return ans
+}
+
+// IndexBam will walk through a coordinate sorted bam file, track the bgzf virtual offsets of each record and return a bai index.
+func IndexBam(filename string) *Bai {
+	reader := &BamReader{File: simpleio.Vim(filename)}
+	defer reader.File.Close()
+	bg, err := bgzf.NewReader(reader.File, 1)
+	simpleio.StdError(err)
+	reader.Gunzip = bg
+	header := ReadHeader(reader)
+	builder := NewBaiBuilder()
+	var blockSize int32
+	var begin bgzf.Offset
+	var block *BinaryDecoder
+	for {
+		reader.error = binary.Read(bg, binary.LittleEndian, &blockSize)
+		if reader.error == io.EOF {
+			break
+		}
+		simpleio.StdError(reader.error)
+		begin = bg.LastChunk().Begin
+		block = decodeBlock(reader, blockSize)
+		builder.Add(int(block.RefID), int(block.Pos), int(block.Pos)+alignedLength(Uint32ToByteCigar(block.Cigar)), block.Flag, Chunk{Begin: begin, End: bg.LastChunk().End})
+	}
+	return builder.Bai(len(header.Chroms))
+}
+
+// IndexWriter will write a bai index to a file.
+func IndexWriter(filename string, bai *Bai) {
+	file := simpleio.NewWriter(filename)
+	WriteBai(file, bai)
+	file.Close()
+}
+
+// WriteBai will encode a bai index into binary following the sam/bam specs, including the metadata pseudo-bins used by samtools.
+func WriteBai(writer io.Writer, bai *Bai) {
+	var err error
+	writeLE := func(data interface{}) {
+		if err == nil {
+			err = binary.Write(writer, binary.LittleEndian, data)
+		}
+	}
+	writeLE(baiMagic)
+	writeLE(int32(len(bai.Refs)))
+	for _, ref := range bai.Refs {
+		hasMeta := ref.Meta != Metadata{}
+		if hasMeta {
+			writeLE(int32(len(ref.Bins) + 1))
+		} else {
+			writeLE(int32(len(ref.Bins)))
+		}
+		for _, b := range ref.Bins {
+			writeLE(b.binNum)
+			writeLE(int32(len(b.Chunks)))
+			for _, c := range b.Chunks {
+				writeLE(fromOffset(c.Begin))
+				writeLE(fromOffset(c.End))
+			}
+		}
+		if hasMeta {
+			writeLE(metaBin)
+			writeLE(int32(2))
+			writeLE([]uint64{ref.Meta.UnmappedBegin, ref.Meta.UnmappedEnd, ref.Meta.MappedCount, ref.Meta.UnmappedCount})
+		}
+		writeLE(int32(len(ref.Intervals)))
+		for _, interval := range ref.Intervals {
+			writeLE(fromOffset(interval))
+		}
+	}
+	if bai.UnmappedCount != nil {
+		writeLE(*bai.UnmappedCount)
+	}
+	simpleio.StdError(err)
+}
+
+// reg2bin will calculate the bin of an alignment spanning the zero-based, half-open region beg-end.
+func reg2bin(beg int, end int) uint32 {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return uint32(((1<<15)-1)/7 + (beg >> 14))
+	case beg>>17 == end>>17:
+		return uint32(((1<<12)-1)/7 + (beg >> 17))
+	case beg>>20 == end>>20:
+		return uint32(((1<<9)-1)/7 + (beg >> 20))
+	case beg>>23 == end>>23:
+		return uint32(((1<<6)-1)/7 + (beg >> 23))
+	case beg>>26 == end>>26:
+		return uint32(((1<<3)-1)/7 + (beg >> 26))
+	}
+	return 0
+}
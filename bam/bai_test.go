@@ -1,6 +1,7 @@
 package bam
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -8,3 +9,38 @@ func TestReadIndex(t *testing.T) {
 	idx := IndexReader("testdata/tenXbarcodeTest.bam.bai")
 	t.Logf("%v\n", idx.Refs)
 }
+
+// TestIndexBam will build a bai index from a sorted bam file, perform a round trip through the binary encoding
+// and check that region queries return the same alignments as the index created by samtools.
+func TestIndexBam(t *testing.T) {
+	for _, test := range readBamTests {
+		bai := IndexBam(test.bam)
+		filename := t.TempDir() + "/test.bam.bai"
+		IndexWriter(filename, bai)
+		var expected, decoded bytes.Buffer
+		WriteBai(&expected, bai)
+		WriteBai(&decoded, IndexReader(filename))
+		if !bytes.Equal(expected.Bytes(), decoded.Bytes()) {
+			t.Errorf("Error: bai index did not round trip through the binary encoding...\n")
+		}
+		samtools := IndexReader(test.bam + ".bai")
+		for _, q := range queryTests {
+			chrom, start, end := ParseRegion(q.region)
+			var expected, results []Sam
+			for each := range Query(NewBamReader(test.bam), samtools, chrom, start, end) {
+				expected = append(expected, each)
+			}
+			for each := range Query(NewBamReader(test.bam), bai, chrom, start, end) {
+				results = append(results, each)
+			}
+			if len(expected) != len(results) {
+				t.Fatalf("Error: query %s returned %d alignments with the generated index, expected %d...\n", q.region, len(results), len(expected))
+			}
+			for i := range results {
+				if !IsEqualDebug(&results[i], &expected[i]) {
+					t.Fatalf("Error: query %s did not return the expected alignments...\n", q.region)
+				}
+			}
+		}
+	}
+}
@@ -18,9 +18,13 @@ import (
 )
 
 // BamReader contains data fields used to read and process binary file.
+// Gunzip is the decompressed stream records are decoded from: a sequential Bgzip reader by default,
+// or a seekable bgzf reader when bam records are retrieved through an index.
 type BamReader struct {
 	File      *os.File
-	Gunzip    *Bgzip
+	Gunzip    io.Reader
+	source    io.ReadSeeker
+	header    *Header
 	data      []byte
 	bytesRead int
 	error     error
@@ -68,8 +72,23 @@ type CigarByte struct {
 	Op  byte
 }
 
-// Read will process a bam file and return a slice of sam records that were decoded from binary.
+// cramReader is set by the cram package, which imports bam, so Read can open cram files without an import cycle.
+var cramReader func(filename string) (*Header, <-chan Sam)
+
+// RegisterCramReader will set the function Read uses to decode files ending in .cram. It is called when the cram package is imported.
+func RegisterCramReader(reader func(filename string) (*Header, <-chan Sam)) {
+	cramReader = reader
+}
+
+// Read will process a sam, bam or cram file and return a channel of the sam records. Reading cram files requires
+// importing the cram package.
 func Read(filename string) (*Header, <-chan Sam) {
+	if strings.HasSuffix(filename, ".cram") {
+		if cramReader == nil {
+			log.Fatalf("Error: reading %s requires importing the github.com/edotau/goFish/cram package...\n", filename)
+		}
+		return cramReader(filename)
+	}
 	header := &Header{}
 	sams := make(chan Sam)
 	wg := sync.WaitGroup{}
@@ -104,9 +123,24 @@ func Read(filename string) (*Header, <-chan Sam) {
 	return header, sams
 }
 
+// Write will write a header and every record received from a channel to a sam or bam file, determined by the file suffix.
+func Write(filename string, header *Header, records <-chan Sam) {
+	if strings.HasSuffix(filename, ".bam") {
+		WriteBamFile(filename, header, records, false)
+		return
+	}
+	writer := simpleio.NewWriter(filename)
+	_, err := writer.Write(header.Text.Bytes())
+	simpleio.StdError(err)
+	for i := range records {
+		simpleio.WriteLine(writer, ToString(&i))
+	}
+	writer.Close()
+}
+
 func BasicRead(filename string) (*Header, []*Sam) {
 	bamFile := NewBamReader(filename)
-	defer bamFile.File.Close()
+	defer bamFile.Close()
 	h := ReadHeader(bamFile)
 	binaryData := make(chan *BinaryDecoder)
 	var ans []*Sam
@@ -149,20 +183,44 @@ func HttpReaderDev(url string) *Bgzip {
 // NewBamReader is similar to fileio.EasyVim/fileio.EasyReader
 // which will allocate memory for the struct fields
 // and is ready to start processing bam lines after calling this function.
+// Bgzf blocks are inflated concurrently using simpleio.DefaultThreads.
 func NewBamReader(filename string) *BamReader {
+	return NewBamReaderThreads(filename, simpleio.DefaultThreads)
+}
+
+// NewBamReaderThreads will open a bam file and use a number of threads to inflate bgzf blocks concurrently.
+// The blocks are handed back in order, so records are decoded in the same order as the file.
+func NewBamReaderThreads(filename string, threads int) *BamReader {
 	var bamR *BamReader = &BamReader{}
+	if IsUrl(filename) {
+		return NewBamUrlReader(filename)
+	}
 	bamR.File = simpleio.Vim(filename)
-	bamR.Gunzip = NewBgzipReader(bamR.File)
+	bamR.source = bamR.File
+	bamR.Gunzip = simpleio.NewBgzfReader(bamR.File, threads)
 	return bamR
 }
 
+// Close will stop any goroutines used to inflate the bam file and close the file.
+func (reader *BamReader) Close() {
+	if closer, ok := reader.Gunzip.(io.Closer); ok {
+		simpleio.StdError(closer.Close())
+	}
+	if reader.File != nil {
+		simpleio.StdError(reader.File.Close())
+	} else if closer, ok := reader.source.(io.Closer); ok {
+		simpleio.StdError(closer.Close())
+	}
+}
+
 // ReadHeader will take a BamReader structure as an input
 // performs a quick check to make sure the binary file is a valid bam
 // then process header lines and returns a BamHeader (similar to samHeader).
 func ReadHeader(reader *BamReader) *Header {
 	bamHeader := MakeHeader()
 	magic := make([]byte, 4)
-	magic = BgzipBuffer(reader.Gunzip, magic)
+	_, reader.error = io.ReadFull(reader.Gunzip, magic)
+	simpleio.StdError(reader.error)
 	if string(magic) != "BAM\001" {
 		log.Fatalf("Not a BAM file: %s\n", string(reader.data))
 	}
@@ -194,6 +252,7 @@ func ReadHeader(reader *BamReader) *Header {
 		simpleio.StdError(reader.error)
 		bamHeader.Chroms = append(bamHeader.Chroms, ChromSize{Name: strings.Trim(string(reader.data), "\n\000"), Size: int(lengthSeq), Order: len(bamHeader.Chroms)})
 	}
+	reader.header = bamHeader
 	return bamHeader
 }
 
@@ -206,16 +265,8 @@ func MakeHeader() *Header {
 // and send them off to a channel that could be processed into a sam record further downstream.
 func BamToChannel(reader *BamReader, binaryData chan<- *BinaryDecoder) {
 	var blockSize int32
-	var bitFlag uint32
-	var stats uint32
-	var i, j int
-	var b byte
-	var block *BinaryDecoder
-	buf := bytes.NewBuffer([]byte{})
 	// This loop will attempt to decode a bam block, which is equivalent to one sam line in this case
 	for {
-		block = &BinaryDecoder{}
-		buf.Reset()
 		// read block size
 		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &blockSize)
 		if reader.error == io.EOF {
@@ -223,79 +274,94 @@ func BamToChannel(reader *BamReader, binaryData chan<- *BinaryDecoder) {
 			break
 		}
 		simpleio.StdError(reader.error)
-		//read block data
-		block.BlockSize = blockSize
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.RefID)
-		simpleio.StdError(reader.error)
+		binaryData <- decodeBlock(reader, blockSize)
+	}
+}
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Pos)
-		simpleio.StdError(reader.error)
+// decodeBlock will decode the remaining fields of a single bam record after the block size has been read from the stream.
+func decodeBlock(reader *BamReader, blockSize int32) *BinaryDecoder {
+	var bitFlag uint32
+	var stats uint32
+	var i, j int
+	var b byte
+	block := &BinaryDecoder{}
+	buf := bytes.NewBuffer([]byte{})
+	//read block data
+	block.BlockSize = blockSize
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.RefID)
+	simpleio.StdError(reader.error)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &stats)
-		simpleio.StdError(reader.error)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Pos)
+	simpleio.StdError(reader.error)
 
-		block.Bai = uint16((stats >> 16) & 0xffff)
-		block.MapQ = uint8((stats >> 8) & 0xff)
-		block.RNLength = uint8((stats >> 0) & 0xff)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &stats)
+	simpleio.StdError(reader.error)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &bitFlag)
-		simpleio.StdError(reader.error)
+	block.Bai = uint16((stats >> 16) & 0xffff)
+	block.MapQ = uint8((stats >> 8) & 0xff)
+	block.RNLength = uint8((stats >> 0) & 0xff)
 
-		// get Flag and NCigarOp from bitFlag
-		block.Flag = uint16(bitFlag >> 16)
-		block.NCigarOp = uint16(bitFlag & 0xffff)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &bitFlag)
+	simpleio.StdError(reader.error)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.LSeq)
-		simpleio.StdError(reader.error)
+	// get Flag and NCigarOp from bitFlag
+	block.Flag = uint16(bitFlag >> 16)
+	block.NCigarOp = uint16(bitFlag & 0xffff)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.NextRefID)
-		simpleio.StdError(reader.error)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.LSeq)
+	simpleio.StdError(reader.error)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.NextPos)
-		simpleio.StdError(reader.error)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.NextRefID)
+	simpleio.StdError(reader.error)
 
-		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.TLength)
-		simpleio.StdError(reader.error)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.NextPos)
+	simpleio.StdError(reader.error)
 
-		// parse the read name
-		block.QName = binaryByteToString(reader, b, buf)
+	reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.TLength)
+	simpleio.StdError(reader.error)
 
-		// parse cigar block
-		block.Cigar = make([]uint32, block.NCigarOp)
-		for i = 0; i < int(block.NCigarOp) && reader.error == nil; i++ {
-			reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Cigar[i])
-			simpleio.StdError(reader.error)
+	// parse the read name
+	block.QName = binaryByteToString(reader, b, buf)
 
-		}
-		// parse seq
-		block.Seq = make([]byte, (block.LSeq+1)/2)
-		for i = 0; i < int((block.LSeq+1)/2); i++ {
-			reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Seq[i])
-			simpleio.StdError(reader.error)
+	// parse cigar block
+	block.Cigar = make([]uint32, block.NCigarOp)
+	for i = 0; i < int(block.NCigarOp) && reader.error == nil; i++ {
+		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Cigar[i])
+		simpleio.StdError(reader.error)
 
-		}
-		block.Qual = make([]byte, block.LSeq)
-		for i = 0; i < int(block.LSeq); i++ {
-			reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Qual[i])
-			simpleio.StdError(reader.error)
-		}
-		// read auxiliary data
-		j = 8*4 + int(block.RNLength) + 4*int(block.NCigarOp) + int((block.LSeq+1)/2) + int(block.LSeq)
-		for i = 0; j+i < int(blockSize); {
-			block.Aux = append(block.Aux, decodeAuxiliary(reader))
-			i += reader.bytesRead
-		}
-		binaryData <- block
 	}
+	// parse seq
+	block.Seq = make([]byte, (block.LSeq+1)/2)
+	for i = 0; i < int((block.LSeq+1)/2); i++ {
+		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Seq[i])
+		simpleio.StdError(reader.error)
+
+	}
+	block.Qual = make([]byte, block.LSeq)
+	for i = 0; i < int(block.LSeq); i++ {
+		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &block.Qual[i])
+		simpleio.StdError(reader.error)
+	}
+	// read auxiliary data
+	j = 8*4 + int(block.RNLength) + 4*int(block.NCigarOp) + int((block.LSeq+1)/2) + int(block.LSeq)
+	for i = 0; j+i < int(blockSize); {
+		block.Aux = append(block.Aux, decodeAuxiliary(reader))
+		i += reader.bytesRead
+	}
+	return block
 }
 
 // BamBlockToSam is a function that will convert a decoded
 // (already processed binary structure) to a human readable sam data.
 func BamBlockToSam(header *Header, bam *BinaryDecoder) *Sam {
+	rName := "*"
+	if bam.RefID >= 0 {
+		rName = header.Chroms[bam.RefID].Name
+	}
 	return &Sam{
 		QName:   bam.QName,
 		Flag:    bam.Flag,
-		RName:   header.Chroms[bam.RefID].Name,
+		RName:   rName,
 		Pos:     int(bam.Pos + 1),
 		MapQ:    bam.MapQ,
 		Cigar:   Uint32ToByteCigar(bam.Cigar),
@@ -311,12 +377,12 @@ func BamBlockToSam(header *Header, bam *BinaryDecoder) *Sam {
 // setRNext will process the reference name of the mate pair alignment,
 //if the alignment is on the same fragment, then will set to "=".
 func setRNext(header *Header, bam *BinaryDecoder) string {
-	if bam.NextRefID == bam.RefID {
+	if bam.NextRefID < 0 {
+		return "*"
+	} else if bam.NextRefID == bam.RefID {
 		return "="
-	} else if bam.NextRefID > 0 {
-		return header.Chroms[bam.NextRefID].Name //fmt.Sprintf("Chrom: %d", bam.NextRefID)
 	} else {
-		return "*"
+		return header.Chroms[bam.NextRefID].Name
 	}
 }
 
@@ -365,17 +431,65 @@ func formatQual(q []byte) []byte {
 func auxToString(aux []*BamAux) string {
 	var ans []string
 	for i := 0; i < len(aux); i++ {
-		ans = append(ans, fmt.Sprintf("%c%c:%c:%v", aux[i].Tag[0], aux[i].Tag[1], aux[i].Type, aux[i].Value))
+		if aux[i].Type == 'B' {
+			ans = append(ans, fmt.Sprintf("%c%c:B:%s", aux[i].Tag[0], aux[i].Tag[1], auxArrayToString(aux[i].Value)))
+		} else if aux[i].Type == 'A' {
+			ans = append(ans, fmt.Sprintf("%c%c:A:%c", aux[i].Tag[0], aux[i].Tag[1], aux[i].Value))
+		} else {
+			ans = append(ans, fmt.Sprintf("%c%c:%c:%v", aux[i].Tag[0], aux[i].Tag[1], aux[i].Type, aux[i].Value))
+		}
 	}
 	return strings.Join(ans, "\t")
 }
 
+// auxArrayToString will format the values of a B auxiliary array as a sam string: subtype followed by comma separated values.
+func auxArrayToString(value interface{}) string {
+	var str strings.Builder
+	switch v := value.(type) {
+	case []int8:
+		str.WriteByte('c')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []uint8:
+		str.WriteByte('C')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []int16:
+		str.WriteByte('s')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []uint16:
+		str.WriteByte('S')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []int32:
+		str.WriteByte('i')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []uint32:
+		str.WriteByte('I')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%d", i)
+		}
+	case []float32:
+		str.WriteByte('f')
+		for _, i := range v {
+			fmt.Fprintf(&str, ",%v", i)
+		}
+	}
+	return str.String()
+}
+
 // decodeAuxiliary will use the bam reader struct to decode binary text to sam auxilary fields.
 //In giraf this is what we are calling notes.
 //TODO: Look to synchronize auxilary and notes.
 func decodeAuxiliary(reader *BamReader) *BamAux {
 	aux := &BamAux{}
-	var i int
 	// number of read bytes
 	reader.bytesRead = 0
 	// read data
@@ -443,6 +557,7 @@ func decodeAuxiliary(reader *BamReader) *BamAux {
 		value := uint32(0)
 		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, &value)
 		simpleio.StdError(reader.error)
+		aux.Type = 'i'
 		aux.Value = value
 		reader.bytesRead += 4
 	case 'f':
@@ -473,7 +588,7 @@ func decodeAuxiliary(reader *BamReader) *BamAux {
 			if b == 0 {
 				break
 			}
-			fmt.Fprintf(&buffer, "%X", b)
+			buffer.WriteByte(b)
 		}
 		aux.Value = buffer.String()
 	case 'B':
@@ -489,56 +604,25 @@ func decodeAuxiliary(reader *BamReader) *BamAux {
 		reader.bytesRead += 4
 		switch t {
 		case 'c':
-			data := make([]int32, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 1
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]int8, k)
 		case 'C':
-			data := make([]uint8, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 1
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]uint8, k)
 		case 's':
-			data := make([]int16, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 2
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]int16, k)
 		case 'S':
-			data := make([]uint16, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 2
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]uint16, k)
 		case 'i':
-			data := make([]int32, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 4
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]int32, k)
 		case 'I':
-			data := make([]uint32, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 4
-			}
-			aux.Value = data
+			aux.Value = make([]uint32, k)
 		case 'f':
-			data := make([]float32, k)
-			for i, reader.error = 0, binary.Read(reader.Gunzip, binary.LittleEndian, &data[i]); i < int(k) && reader.error == nil; i++ {
-				reader.bytesRead += 4
-			}
-			simpleio.StdError(reader.error)
-			aux.Value = data
+			aux.Value = make([]float32, k)
 		default:
 			log.Fatalf("Error: encountered unknown auxiliary array value type %c...\n", t)
 		}
+		reader.error = binary.Read(reader.Gunzip, binary.LittleEndian, aux.Value)
+		simpleio.StdError(reader.error)
+		reader.bytesRead += binary.Size(aux.Value)
 
 	default:
 		log.Fatalf("Error: Found invalid auxiliary value type %c...\n", aux.Type)
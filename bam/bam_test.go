@@ -2,6 +2,7 @@ package bam
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"strings"
 	"testing"
@@ -111,3 +112,23 @@ func IsEqualDebug(a *Sam, b *Sam) bool {
 
 	return true
 }
+
+// BenchmarkBamReaderThreads will benchmark decoding a bam file while inflating bgzf blocks with an increasing number of threads.
+func BenchmarkBamReaderThreads(b *testing.B) {
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, test := range readBamTests {
+					reader := NewBamReaderThreads(test.bam, threads)
+					header := ReadHeader(reader)
+					binaryData := make(chan *BinaryDecoder)
+					go BamToChannel(reader, binaryData)
+					for each := range binaryData {
+						BamBlockToSam(header, each)
+					}
+					reader.Close()
+				}
+			}
+		})
+	}
+}
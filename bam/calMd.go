@@ -0,0 +1,131 @@
+package bam
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+)
+
+// BaseMismatch is a read base that does not match the reference. RefPos is one-based and QueryPos is the zero-based
+// index of the base in the sequence stored in the record.
+type BaseMismatch struct {
+	RefPos   int
+	QueryPos int
+	Ref      code.Dna
+	Base     code.Dna
+}
+
+// MdResult contains the MD and NM tags calculated from the reference along with the tags already in the record.
+// OldMd is empty and OldNm is -1 when the record did not contain the tag.
+type MdResult struct {
+	Md         string
+	Nm         int
+	OldMd      string
+	OldNm      int
+	Mismatches []BaseMismatch
+}
+
+// Agrees returns true if the tags found in the record match the calculated tags. Missing tags are not counted as disagreements.
+func (r *MdResult) Agrees() bool {
+	return (r.OldMd == "" || r.OldMd == r.Md) && (r.OldNm < 0 || r.OldNm == r.Nm)
+}
+
+// CalcMd will walk the cigar of an alignment along the reference and return the MD string, the edit distance and
+// every mismatched base. Bases are compared without the soft mask and N never matches, the same as samtools calmd.
+// The last return value is false for unmapped reads, reads without a sequence and alignments past the end of ref.
+func CalcMd(s *Sam, ref []code.Dna) (string, int, []BaseMismatch, bool) {
+	if s.IsUnmapped() || len(s.Cigar) == 0 || len(s.Seq) == 0 || s.Pos < 1 || s.Pos-1+ReferenceLength(s.Cigar) > len(ref) {
+		return "", 0, nil, false
+	}
+	var md strings.Builder
+	var mismatches []BaseMismatch
+	var refPos, queryPos, matches, nm, k int = s.Pos - 1, 0, 0, 0, 0
+	var a, b code.Dna
+	for _, c := range s.Cigar {
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			if queryPos+int(c.RunLen) > len(s.Seq) {
+				return "", 0, nil, false
+			}
+			for k = 0; k < int(c.RunLen); k, refPos, queryPos = k+1, refPos+1, queryPos+1 {
+				a, b = code.ToUpper(s.Seq[queryPos]), code.ToUpper(ref[refPos])
+				if a == b && a != code.N {
+					matches++
+					continue
+				}
+				md.WriteString(strconv.Itoa(matches))
+				md.WriteByte(code.DnaToByte(b))
+				matches = 0
+				nm++
+				mismatches = append(mismatches, BaseMismatch{RefPos: refPos + 1, QueryPos: queryPos, Ref: b, Base: a})
+			}
+		case Insertion:
+			nm += int(c.RunLen)
+			queryPos += int(c.RunLen)
+		case Deletion:
+			md.WriteString(strconv.Itoa(matches))
+			md.WriteByte('^')
+			for k = 0; k < int(c.RunLen); k, refPos = k+1, refPos+1 {
+				md.WriteByte(code.DnaToByte(code.ToUpper(ref[refPos])))
+			}
+			matches = 0
+			nm += int(c.RunLen)
+		case N:
+			refPos += int(c.RunLen)
+		case SoftClip:
+			queryPos += int(c.RunLen)
+		}
+	}
+	md.WriteString(strconv.Itoa(matches))
+	return md.String(), nm, mismatches, true
+}
+
+// CheckMd will calculate the MD and NM tags of a record and compare them to the tags already present.
+func CheckMd(s *Sam, ref []code.Dna) (MdResult, bool) {
+	var ans MdResult
+	var ok bool
+	if ans.Md, ans.Nm, ans.Mismatches, ok = CalcMd(s, ref); !ok {
+		return ans, false
+	}
+	ans.OldNm = -1
+	if md, found := s.Tag("MD"); found {
+		ans.OldMd, _ = md.Value().(string)
+	}
+	if nm, found := s.Tag("NM"); found {
+		if n, isInt := nm.Int(); isInt {
+			ans.OldNm = n
+		}
+	}
+	return ans, true
+}
+
+// CalMd will replace the MD and NM tags of a record with values calculated from the reference. Records that cannot
+// be compared to the reference are left untouched.
+func CalMd(s *Sam, ref []code.Dna) (MdResult, bool) {
+	ans, ok := CheckMd(s, ref)
+	if ok {
+		s.SetTag("MD", ans.Md)
+		s.SetTag("NM", ans.Nm)
+	}
+	return ans, ok
+}
+
+// CalMdSam will set the MD and NM tags of each record as it passes through the channel. Records aligned to
+// chromosomes missing from the reference are passed through without changes.
+func CalMdSam(records <-chan Sam, reference []fasta.Fasta) <-chan Sam {
+	refs := make(map[string][]code.Dna)
+	for _, fa := range reference {
+		refs[fa.Name] = fa.Seq
+	}
+	ans := make(chan Sam, 1000)
+	go func() {
+		for i := range records {
+			CalMd(&i, refs[i.RName])
+			ans <- i
+		}
+		close(ans)
+	}()
+	return ans
+}
@@ -0,0 +1,61 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+)
+
+var calMdRef = code.ToDna([]byte("ACGTACGTACGTacgtNCGTACGTAC"))
+
+var calMdTests = []struct {
+	record     Sam
+	md         string
+	nm         int
+	mismatches []BaseMismatch
+}{
+	{Sam{Pos: 1, Cigar: ReadToBytesCigar([]byte("8M")), Seq: code.ToDna([]byte("ACGTACGT"))}, "8", 0, nil},
+	{Sam{Pos: 3, Cigar: ReadToBytesCigar([]byte("2S6M")), Seq: code.ToDna([]byte("TTGTTCGA"))}, "2A2T0", 2,
+		[]BaseMismatch{{RefPos: 5, QueryPos: 4, Ref: code.A, Base: code.T}, {RefPos: 8, QueryPos: 7, Ref: code.T, Base: code.A}}},
+	{Sam{Pos: 1, Cigar: ReadToBytesCigar([]byte("4M2D2M")), Seq: code.ToDna([]byte("ACGTGT"))}, "4^AC2", 2, nil},
+	{Sam{Pos: 1, Cigar: ReadToBytesCigar([]byte("2M3I2M")), Seq: code.ToDna([]byte("ACTTTGT"))}, "4", 3, nil},
+	{Sam{Pos: 11, Cigar: ReadToBytesCigar([]byte("3M2N4M")), Seq: code.ToDna([]byte("GTAtnCG"))}, "4N2", 1,
+		[]BaseMismatch{{RefPos: 17, QueryPos: 4, Ref: code.N, Base: code.N}}},
+}
+
+func TestCalcMd(t *testing.T) {
+	for _, test := range calMdTests {
+		md, nm, mismatches, ok := CalcMd(&test.record, calMdRef)
+		if !ok || md != test.md || nm != test.nm || len(mismatches) != len(test.mismatches) {
+			t.Errorf("Error: %s returned MD:%s NM:%d with %d mismatches, expected MD:%s NM:%d with %d mismatches...\n",
+				ByteCigarToString(test.record.Cigar), md, nm, len(mismatches), test.md, test.nm, len(test.mismatches))
+			continue
+		}
+		for i := range mismatches {
+			if mismatches[i] != test.mismatches[i] {
+				t.Errorf("Error: mismatch %+v, expected %+v...\n", mismatches[i], test.mismatches[i])
+			}
+		}
+	}
+	unmapped := Sam{Flag: FlagUnmapped, Pos: 1, Cigar: ReadToBytesCigar([]byte("4M")), Seq: code.ToDna([]byte("ACGT"))}
+	past := Sam{Pos: 24, Cigar: ReadToBytesCigar([]byte("4M")), Seq: code.ToDna([]byte("ACGT"))}
+	for _, s := range []Sam{unmapped, past} {
+		if _, _, _, ok := CalcMd(&s, calMdRef); ok {
+			t.Errorf("Error: expected MD to be skipped for flag %d at position %d...\n", s.Flag, s.Pos)
+		}
+	}
+}
+
+func TestCalMd(t *testing.T) {
+	s := Sam{QName: "a", Pos: 3, Cigar: ReadToBytesCigar([]byte("2S6M")), Seq: code.ToDna([]byte("TTGTTCGA")), Aux: "MD:Z:6\tAS:i:5"}
+	result, ok := CalMd(&s, calMdRef)
+	if !ok || result.Agrees() || result.OldMd != "6" || result.OldNm != -1 {
+		t.Errorf("Error: expected the old MD tag to disagree, found %+v...\n", result)
+	}
+	if s.Aux != "MD:Z:2A2T0\tAS:i:5\tNM:i:2" {
+		t.Errorf("Error: unexpected tags after calmd %s...\n", s.Aux)
+	}
+	if result, _ = CheckMd(&s, calMdRef); !result.Agrees() || result.OldNm != 2 {
+		t.Errorf("Error: recalculated tags should agree, found %+v...\n", result)
+	}
+}
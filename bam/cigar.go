@@ -236,3 +236,14 @@ func ByteCigarToUint32(cigar []ByteCigar) []uint32 {
 	}
 	return answer
 }
+
+// ReferenceLength calculates the number of reference bases spanned by a slice of cigar structs.
+func ReferenceLength(c []ByteCigar) int {
+	var ans int
+	for _, v := range c {
+		if v.Op != Unknown && ConsumesReference(v.Op) {
+			ans += int(v.RunLen)
+		}
+	}
+	return ans
+}
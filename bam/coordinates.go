@@ -0,0 +1,153 @@
+package bam
+
+// Reference positions used by the functions below are one-based like Sam.Pos, while query positions are
+// zero-based indexes into Sam.Seq. Hard clipped bases are not part of Seq and have no query position.
+
+// AlignedBlock is a run of query bases aligned to the reference without gaps.
+type AlignedBlock struct {
+	RefStart   int
+	QueryStart int
+	Length     int
+}
+
+// ConsumesQuery returns true for cigar operations that use bases of the read sequence.
+func ConsumesQuery(op byte) bool {
+	switch op {
+	case Match, Insertion, SoftClip, EqualByte, Mismatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClipLengths returns the number of soft and hard clipped bases at the start and end of a cigar. When every
+// operation is a clip all bases are counted at the start.
+func ClipLengths(cigar []ByteCigar) (int, int) {
+	return clipLengths(cigar, true)
+}
+
+// SoftClipLengths returns the number of soft clipped bases at the start and end of a cigar.
+func SoftClipLengths(cigar []ByteCigar) (int, int) {
+	return clipLengths(cigar, false)
+}
+
+func clipLengths(cigar []ByteCigar, hard bool) (int, int) {
+	isClip := func(op byte) bool { return op == SoftClip || (op == HardClip && hard) }
+	skip := func(op byte) bool { return op == HardClip && !hard }
+	var left, right, i, j int
+	for i = 0; i < len(cigar) && (isClip(cigar[i].Op) || skip(cigar[i].Op)); i++ {
+		if isClip(cigar[i].Op) {
+			left += int(cigar[i].RunLen)
+		}
+	}
+	for j = len(cigar) - 1; j >= i && (isClip(cigar[j].Op) || skip(cigar[j].Op)); j-- {
+		if isClip(cigar[j].Op) {
+			right += int(cigar[j].RunLen)
+		}
+	}
+	return left, right
+}
+
+// RefEnd returns the last reference position covered by an alignment. Reads without reference consuming
+// operations are treated as covering a single base at Pos.
+func (s *Sam) RefEnd() int {
+	return s.Pos + alignedLength(s.Cigar) - 1
+}
+
+// UnclippedStart returns the reference position the first base of the read would align to if the soft and hard
+// clipped bases at the start were aligned without gaps.
+func (s *Sam) UnclippedStart() int {
+	left, _ := ClipLengths(s.Cigar)
+	return s.Pos - left
+}
+
+// UnclippedEnd returns the reference position the last base of the read would align to if the clipped bases at the
+// end were aligned without gaps.
+func (s *Sam) UnclippedEnd() int {
+	_, right := ClipLengths(s.Cigar)
+	return s.RefEnd() + right
+}
+
+// AlignedBlocks returns the gapless runs of M, = and X operations. Neighboring operations that continue on both the
+// reference and the query, such as 5=1X4=, are merged into one block.
+func (s *Sam) AlignedBlocks() []AlignedBlock {
+	var ans []AlignedBlock
+	refPos, queryPos := s.Pos, 0
+	for _, c := range s.Cigar {
+		n := int(c.RunLen)
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			if k := len(ans) - 1; k >= 0 && ans[k].RefStart+ans[k].Length == refPos && ans[k].QueryStart+ans[k].Length == queryPos {
+				ans[k].Length += n
+			} else {
+				ans = append(ans, AlignedBlock{RefStart: refPos, QueryStart: queryPos, Length: n})
+			}
+			refPos, queryPos = refPos+n, queryPos+n
+		case Insertion, SoftClip:
+			queryPos += n
+		case Deletion, N:
+			refPos += n
+		}
+	}
+	return ans
+}
+
+// RefToQuery returns the query base aligned to a reference position along with the cigar operation covering it.
+// For positions inside a deletion or skipped region the query position of the next aligned base is returned with
+// a D or N operation. Positions outside of the alignment return -1 and Unknown.
+func (s *Sam) RefToQuery(refPos int) (int, byte) {
+	curr, queryPos := s.Pos, 0
+	for _, c := range s.Cigar {
+		n := int(c.RunLen)
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			if refPos >= curr && refPos < curr+n {
+				return queryPos + refPos - curr, c.Op
+			}
+			curr, queryPos = curr+n, queryPos+n
+		case Deletion, N:
+			if refPos >= curr && refPos < curr+n {
+				return queryPos, c.Op
+			}
+			curr += n
+		case Insertion, SoftClip:
+			queryPos += n
+		}
+	}
+	return -1, Unknown
+}
+
+// QueryToRef returns the reference position of a query base along with the cigar operation covering it.
+// Inserted bases return the position of the reference base before the insertion. Soft clipped bases return the
+// position they would align to if the clip was extended without gaps, which locates read ends such as the 3' end of
+// a clipped read. Positions outside of Seq return -1 and Unknown.
+func (s *Sam) QueryToRef(queryPos int) (int, byte) {
+	refPos, curr := s.Pos, 0
+	var aligned bool
+	for _, c := range s.Cigar {
+		n := int(c.RunLen)
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			if queryPos >= curr && queryPos < curr+n {
+				return refPos + queryPos - curr, c.Op
+			}
+			refPos, curr, aligned = refPos+n, curr+n, true
+		case Insertion:
+			if queryPos >= curr && queryPos < curr+n {
+				return refPos - 1, c.Op
+			}
+			curr, aligned = curr+n, true
+		case SoftClip:
+			if queryPos >= curr && queryPos < curr+n {
+				if aligned {
+					return refPos + queryPos - curr, c.Op
+				}
+				return refPos - (curr + n - queryPos), c.Op
+			}
+			curr += n
+		case Deletion, N:
+			refPos, aligned = refPos+n, true
+		}
+	}
+	return -1, Unknown
+}
@@ -0,0 +1,91 @@
+package bam
+
+import (
+	"reflect"
+	"testing"
+)
+
+// projectionRead has each kind of cigar operation. Query bases 0-2 are soft clipped, 3-6 align to 100-103,
+// 7 is inserted, 104-105 are deleted, 8-11 align to 106-109, 110-111 are skipped, 12-13 align to 112-113
+// and 14-15 are soft clipped.
+var projectionRead = Sam{Pos: 100, Cigar: ReadToBytesCigar([]byte("2H3S4M1I2D3=1X2N2M2S1H"))}
+
+func TestAlignedBlocks(t *testing.T) {
+	expected := []AlignedBlock{{RefStart: 100, QueryStart: 3, Length: 4}, {RefStart: 106, QueryStart: 8, Length: 4}, {RefStart: 112, QueryStart: 12, Length: 2}}
+	if ans := projectionRead.AlignedBlocks(); !reflect.DeepEqual(ans, expected) {
+		t.Errorf("Error: aligned blocks are %v, expected %v...\n", ans, expected)
+	}
+	padded := Sam{Pos: 10, Cigar: ReadToBytesCigar([]byte("3M1P1I3M"))}
+	expected = []AlignedBlock{{RefStart: 10, QueryStart: 0, Length: 3}, {RefStart: 13, QueryStart: 4, Length: 3}}
+	if ans := padded.AlignedBlocks(); !reflect.DeepEqual(ans, expected) {
+		t.Errorf("Error: aligned blocks are %v, expected %v...\n", ans, expected)
+	}
+}
+
+func TestClipLengths(t *testing.T) {
+	var tests = []struct {
+		cigar                 string
+		left, right           int
+		softLeft, softRight   int
+		refEnd, start, finish int
+	}{
+		{"2H3S4M1I2D3=1X2N2M2S1H", 5, 3, 3, 2, 113, 95, 116},
+		{"10M", 0, 0, 0, 0, 109, 100, 109},
+		{"5H10M", 5, 0, 0, 0, 109, 95, 109},
+		{"5S", 5, 0, 5, 0, 100, 95, 100},
+		{"2H3S2H", 7, 0, 3, 0, 100, 93, 100},
+	}
+	for _, test := range tests {
+		s := Sam{Pos: 100, Cigar: ReadToBytesCigar([]byte(test.cigar))}
+		if left, right := ClipLengths(s.Cigar); left != test.left || right != test.right {
+			t.Errorf("Error: clip lengths of %s are %d and %d, expected %d and %d...\n", test.cigar, left, right, test.left, test.right)
+		}
+		if left, right := SoftClipLengths(s.Cigar); left != test.softLeft || right != test.softRight {
+			t.Errorf("Error: soft clip lengths of %s are %d and %d, expected %d and %d...\n", test.cigar, left, right, test.softLeft, test.softRight)
+		}
+		if s.RefEnd() != test.refEnd || s.UnclippedStart() != test.start || s.UnclippedEnd() != test.finish {
+			t.Errorf("Error: %s ends at %d with unclipped ends %d-%d, expected %d and %d-%d...\n", test.cigar, s.RefEnd(), s.UnclippedStart(), s.UnclippedEnd(), test.refEnd, test.start, test.finish)
+		}
+	}
+}
+
+func TestRefToQuery(t *testing.T) {
+	var tests = []struct {
+		ref, query int
+		op         byte
+	}{
+		{99, -1, Unknown}, {100, 3, Match}, {103, 6, Match}, {104, 8, Deletion}, {105, 8, Deletion}, {106, 8, EqualByte},
+		{109, 11, Mismatch}, {110, 12, N}, {111, 12, N}, {113, 13, Match}, {114, -1, Unknown},
+	}
+	for _, test := range tests {
+		if query, op := projectionRead.RefToQuery(test.ref); query != test.query || op != test.op {
+			t.Errorf("Error: reference position %d projected to %d %c, expected %d %c...\n", test.ref, query, op, test.query, test.op)
+		}
+	}
+}
+
+func TestQueryToRef(t *testing.T) {
+	var tests = []struct {
+		query, ref int
+		op         byte
+	}{
+		{-1, -1, Unknown}, {0, 97, SoftClip}, {2, 99, SoftClip}, {3, 100, Match}, {7, 103, Insertion}, {8, 106, EqualByte},
+		{11, 109, Mismatch}, {13, 113, Match}, {14, 114, SoftClip}, {15, 115, SoftClip}, {16, -1, Unknown},
+	}
+	for _, test := range tests {
+		if ref, op := projectionRead.QueryToRef(test.query); ref != test.ref || op != test.op {
+			t.Errorf("Error: query position %d projected to %d %c, expected %d %c...\n", test.query, ref, op, test.ref, test.op)
+		}
+	}
+	// every aligned base should project back to itself
+	for _, block := range projectionRead.AlignedBlocks() {
+		for k := 0; k < block.Length; k++ {
+			if query, _ := projectionRead.RefToQuery(block.RefStart + k); query != block.QueryStart+k {
+				t.Errorf("Error: reference position %d projected to query %d, expected %d...\n", block.RefStart+k, query, block.QueryStart+k)
+			}
+			if ref, _ := projectionRead.QueryToRef(block.QueryStart + k); ref != block.RefStart+k {
+				t.Errorf("Error: query position %d projected to reference %d, expected %d...\n", block.QueryStart+k, ref, block.RefStart+k)
+			}
+		}
+	}
+}
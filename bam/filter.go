@@ -0,0 +1,522 @@
+package bam
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+)
+
+// Filter selects alignments similar to samtools view. Records must contain every bit of RequireFlags, none of
+// ExcludeFlags, have a mapping quality of at least MinMapQ, overlap the region if one is set and satisfy the expression.
+type Filter struct {
+	RequireFlags uint16
+	ExcludeFlags uint16
+	MinMapQ      uint8
+	Chrom        string
+	Start        int
+	End          int
+	expr         exprNode
+}
+
+// NewFilter will compile a filter expression over the fields, flags and tags of a sam record, for example:
+//
+//	mapq >= 30 && !secondary && [NM] < 4
+//
+// Fields are qname, flag, rname, pos, endpos, mapq, cigar, mrname, mpos, tlen, seq, qlen, rlen and ncigar. Flags are
+// paired, proper_pair, unmapped, mate_unmapped, reverse, mate_reverse, read1, read2, secondary, qcfail, duplicate and
+// supplementary. Auxiliary tags are written in brackets, [NM], and evaluate to false when missing. Numbers, "strings",
+// the arithmetic and bitwise operators + - * / % & | and comparisons == != < <= > >= =~ !~ are supported using the
+// operator precedence of go, along with !, && and || and parentheses. An empty expression keeps every record.
+func NewFilter(expression string) (*Filter, error) {
+	ans := &Filter{}
+	if strings.TrimSpace(expression) == "" {
+		return ans, nil
+	}
+	p := &exprParser{}
+	var err error
+	if p.tokens, err = lexExpr(expression); err != nil {
+		return nil, err
+	}
+	if ans.expr, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s in filter expression", p.tokens[p.pos].text)
+	}
+	return ans, nil
+}
+
+// SetRegion will restrict the filter to alignments overlapping a samtools style region, chr:start-end.
+func (f *Filter) SetRegion(region string) {
+	f.Chrom, f.Start, f.End = ParseRegion(region)
+}
+
+// Keep returns true if a record passes every part of the filter.
+func (f *Filter) Keep(s *Sam) bool {
+	if s.Flag&f.RequireFlags != f.RequireFlags || s.Flag&f.ExcludeFlags != 0 || s.MapQ < f.MinMapQ {
+		return false
+	}
+	if f.Chrom != "" && (s.RName != f.Chrom || s.Pos-1 >= f.End || s.Pos-1+alignedLength(s.Cigar) <= f.Start) {
+		return false
+	}
+	return f.expr == nil || f.expr(s).truth()
+}
+
+// FilterSam will return a channel of the records that pass the filter.
+func FilterSam(records <-chan Sam, f *Filter) <-chan Sam {
+	ans := make(chan Sam, 1000)
+	go func() {
+		for i := range records {
+			if f.Keep(&i) {
+				ans <- i
+			}
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// FilterFile will read a sam or bam file and return the records that pass the filter. If the filter contains a region
+// and the file is a bam with a .bai index, only the region is read from the file. Bam urls are always queried with
+// the index found at the same url with a .bai suffix.
+func FilterFile(filename string, f *Filter) (*Header, <-chan Sam) {
+	if f.Chrom != "" && strings.HasSuffix(filename, ".bam") {
+		if _, err := os.Stat(filename + ".bai"); err == nil || IsUrl(filename) {
+			header, records := QueryFile(filename, f.Chrom, f.Start, f.End)
+			return header, FilterSam(records, f)
+		}
+	}
+	header, records := Read(filename)
+	return header, FilterSam(records, f)
+}
+
+// exprValue is the result of evaluating part of a filter expression, which is either missing, a number or a string.
+type exprValue struct {
+	kind byte
+	num  float64
+	str  string
+}
+
+const (
+	nullValue byte = iota
+	numValue
+	strValue
+)
+
+func number(n float64) exprValue { return exprValue{kind: numValue, num: n} }
+
+func boolean(b bool) exprValue {
+	if b {
+		return number(1)
+	}
+	return number(0)
+}
+
+// truth returns false for missing values, zero and empty strings.
+func (v exprValue) truth() bool {
+	switch v.kind {
+	case numValue:
+		return v.num != 0
+	case strValue:
+		return v.str != ""
+	default:
+		return false
+	}
+}
+
+// exprNode evaluates one part of a compiled filter expression.
+type exprNode func(s *Sam) exprValue
+
+// exprToken is a single token of a filter expression.
+type exprToken struct {
+	kind byte
+	text string
+}
+
+const (
+	opToken byte = iota
+	numToken
+	strToken
+	identToken
+	tagToken
+)
+
+// lexNumber returns the end of the number starting at i, which is either a hex literal such as 0x10 or a decimal
+// matching [0-9.]+([eE][+-]?[0-9]+)?.
+func lexNumber(expression string, i int) int {
+	j := i
+	if strings.HasPrefix(expression[i:], "0x") || strings.HasPrefix(expression[i:], "0X") {
+		for j += 2; j < len(expression) && strings.IndexByte("0123456789abcdefABCDEF", expression[j]) >= 0; j++ {
+		}
+		return j
+	}
+	for j < len(expression) && (isDigit(expression[j]) || expression[j] == '.') {
+		j++
+	}
+	if j < len(expression) && (expression[j] == 'e' || expression[j] == 'E') {
+		k := j + 1
+		if k < len(expression) && (expression[k] == '+' || expression[k] == '-') {
+			k++
+		}
+		if k < len(expression) && isDigit(expression[k]) {
+			for j = k; j < len(expression) && isDigit(expression[j]); j++ {
+			}
+		}
+	}
+	return j
+}
+
+// exprOperators are the operators of the expression language, longest first so they are matched greedily.
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "+", "-", "*", "/", "%", "&", "|", "(", ")"}
+
+// lexExpr will split a filter expression into tokens.
+func lexExpr(expression string) ([]exprToken, error) {
+	var ans []exprToken
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			j := lexNumber(expression, i)
+			ans = append(ans, exprToken{kind: numToken, text: expression[i:j]})
+			i = j
+		case c == '"':
+			j := strings.IndexByte(expression[i+1:], '"')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string in filter expression: %s", expression[i:])
+			}
+			ans = append(ans, exprToken{kind: strToken, text: expression[i+1 : i+1+j]})
+			i += j + 2
+		case c == '[':
+			if i+3 >= len(expression) || expression[i+3] != ']' {
+				return nil, fmt.Errorf("auxiliary tags must be two characters in brackets, such as [NM]: %s", expression[i:])
+			}
+			ans = append(ans, exprToken{kind: tagToken, text: expression[i+1 : i+3]})
+			i += 4
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(expression) && (expression[j] == '_' || isDigit(expression[j]) || expression[j]|0x20 >= 'a' && expression[j]|0x20 <= 'z') {
+				j++
+			}
+			ans = append(ans, exprToken{kind: identToken, text: expression[i:j]})
+			i = j
+		default:
+			var found bool
+			for _, op := range exprOperators {
+				if strings.HasPrefix(expression[i:], op) {
+					ans = append(ans, exprToken{kind: opToken, text: op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %c in filter expression", c)
+			}
+		}
+	}
+	return ans, nil
+}
+
+// exprParser is a recursive descent parser that compiles filter expressions into a tree of closures.
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// accept will consume the next token if it is one of the operators provided.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == opToken {
+		for _, op := range ops {
+			if p.tokens[p.pos].text == op {
+				p.pos++
+				return op, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil {
+		if _, ok := p.accept("||"); !ok {
+			break
+		}
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			a, b := left, right
+			left = func(s *Sam) exprValue { return boolean(a(s).truth() || b(s).truth()) }
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseCompare()
+	for err == nil {
+		if _, ok := p.accept("&&"); !ok {
+			break
+		}
+		var right exprNode
+		if right, err = p.parseCompare(); err == nil {
+			a, b := left, right
+			left = func(s *Sam) exprValue { return boolean(a(s).truth() && b(s).truth()) }
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+	if op == "=~" || op == "!~" {
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != strToken {
+			return nil, fmt.Errorf("regular expressions must be a string after %s", op)
+		}
+		re, err := regexp.Compile(p.tokens[p.pos].text)
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		match := op == "=~"
+		return func(s *Sam) exprValue {
+			v := left(s)
+			return boolean(v.kind != nullValue && re.MatchString(v.toString()) == match)
+		}, nil
+	}
+	right, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	return func(s *Sam) exprValue {
+		a, b := left(s), right(s)
+		if a.kind == nullValue || b.kind == nullValue {
+			return boolean(false)
+		}
+		var c int
+		if a.kind == numValue && b.kind == numValue {
+			c = compareFloat(a.num, b.num)
+		} else {
+			c = strings.Compare(a.toString(), b.toString())
+		}
+		switch op {
+		case "==":
+			return boolean(c == 0)
+		case "!=":
+			return boolean(c != 0)
+		case "<":
+			return boolean(c < 0)
+		case "<=":
+			return boolean(c <= 0)
+		case ">":
+			return boolean(c > 0)
+		default:
+			return boolean(c >= 0)
+		}
+	}, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	left, err := p.parseMultiply()
+	for err == nil {
+		op, ok := p.accept("+", "-", "|")
+		if !ok {
+			break
+		}
+		var right exprNode
+		if right, err = p.parseMultiply(); err == nil {
+			left = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseMultiply() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op, ok := p.accept("*", "/", "%", "&")
+		if !ok {
+			break
+		}
+		var right exprNode
+		if right, err = p.parseUnary(); err == nil {
+			left = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == "!" {
+		return func(s *Sam) exprValue { return boolean(!operand(s).truth()) }, nil
+	}
+	return func(s *Sam) exprValue {
+		v := operand(s)
+		if v.kind != numValue {
+			return exprValue{}
+		}
+		return number(-v.num)
+	}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("filter expression ended unexpectedly")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case numToken:
+		var n float64
+		var err error
+		if len(t.text) > 1 && (t.text[1] == 'x' || t.text[1] == 'X') {
+			var i int64
+			i, err = strconv.ParseInt(t.text[2:], 16, 64)
+			n = float64(i)
+		} else {
+			n, err = strconv.ParseFloat(t.text, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s in filter expression", t.text)
+		}
+		return func(s *Sam) exprValue { return number(n) }, nil
+	case strToken:
+		v := exprValue{kind: strValue, str: t.text}
+		return func(s *Sam) exprValue { return v }, nil
+	case tagToken:
+		return tagNode(t.text), nil
+	case identToken:
+		if flag, ok := flagNames[t.text]; ok {
+			return func(s *Sam) exprValue { return boolean(s.HasFlag(flag)) }, nil
+		}
+		if field, ok := exprFields[t.text]; ok {
+			return field, nil
+		}
+		return nil, fmt.Errorf("unknown field %s in filter expression", t.text)
+	default:
+		if t.text != "(" {
+			return nil, fmt.Errorf("unexpected %s in filter expression", t.text)
+		}
+		ans, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing ) in filter expression")
+		}
+		return ans, nil
+	}
+}
+
+// arithmetic will combine two numeric values, returning a missing value if either is not a number.
+func arithmetic(op string, left exprNode, right exprNode) exprNode {
+	return func(s *Sam) exprValue {
+		a, b := left(s), right(s)
+		if a.kind != numValue || b.kind != numValue {
+			return exprValue{}
+		}
+		switch op {
+		case "+":
+			return number(a.num + b.num)
+		case "-":
+			return number(a.num - b.num)
+		case "*":
+			return number(a.num * b.num)
+		case "/":
+			if b.num == 0 {
+				return exprValue{}
+			}
+			return number(a.num / b.num)
+		case "%":
+			if int64(b.num) == 0 {
+				return exprValue{}
+			}
+			return number(float64(int64(a.num) % int64(b.num)))
+		case "&":
+			return number(float64(int64(a.num) & int64(b.num)))
+		default:
+			return number(float64(int64(a.num) | int64(b.num)))
+		}
+	}
+}
+
+// tagNode returns the value of an auxiliary tag, or a missing value if the record does not contain the tag.
+func tagNode(tag string) exprNode {
+	return func(s *Sam) exprValue {
+		a, ok := s.Tag(tag)
+		if !ok {
+			return exprValue{}
+		}
+		if n, ok := a.Int(); ok {
+			return number(float64(n))
+		}
+		switch a.Type() {
+		case 'f':
+			return number(float64(a.Value().(float32)))
+		case 'A':
+			return exprValue{kind: strValue, str: string(a[3])}
+		default:
+			field := a.ToString()
+			return exprValue{kind: strValue, str: field[5:]}
+		}
+	}
+}
+
+// stringValue will wrap a string as the value of an expression.
+func stringValue(v string) exprValue { return exprValue{kind: strValue, str: v} }
+
+// exprFields are the sam record fields available to filter expressions.
+var exprFields = map[string]exprNode{
+	"qname":  func(s *Sam) exprValue { return stringValue(s.QName) },
+	"flag":   func(s *Sam) exprValue { return number(float64(s.Flag)) },
+	"rname":  func(s *Sam) exprValue { return stringValue(s.RName) },
+	"pos":    func(s *Sam) exprValue { return number(float64(s.Pos)) },
+	"endpos": func(s *Sam) exprValue { return number(float64(s.RefEnd())) },
+	"mapq":   func(s *Sam) exprValue { return number(float64(s.MapQ)) },
+	"cigar":  func(s *Sam) exprValue { return stringValue(ByteCigarToString(s.Cigar)) },
+	"mrname": func(s *Sam) exprValue { return stringValue(s.MateRef) },
+	"mpos":   func(s *Sam) exprValue { return number(float64(s.MatePos)) },
+	"tlen":   func(s *Sam) exprValue { return number(float64(s.TmpLen)) },
+	"seq":    func(s *Sam) exprValue { return stringValue(code.ToString(s.Seq)) },
+	"qlen":   func(s *Sam) exprValue { return number(float64(QueryRunLen(s.Cigar))) },
+	"rlen":   func(s *Sam) exprValue { return number(float64(ReferenceLength(s.Cigar))) },
+	"ncigar": func(s *Sam) exprValue { return number(float64(len(s.Cigar))) },
+}
+
+// toString will format a value so it can be compared to a string.
+func (v exprValue) toString() string {
+	if v.kind == numValue {
+		return strconv.FormatFloat(v.num, 'g', -1, 64)
+	}
+	return v.str
+}
+
+func compareFloat(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
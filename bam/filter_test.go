@@ -0,0 +1,125 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+)
+
+var filterRecord = Sam{QName: "read:1", Flag: FlagPaired | FlagProperPair | FlagReverse | FlagRead1, RName: "chr1", Pos: 100, MapQ: 40,
+	Cigar: ReadToBytesCigar([]byte("5S10M2D5M")), MateRef: "=", MatePos: 300, TmpLen: 250, Seq: code.ToDna([]byte("ACGTACGTACGTACGTACGT")),
+	Qual: []byte("IIIIIIIIIIIIIIIIIIII"), Aux: "NM:i:3\tAS:i:-12\tRG:Z:group1\tXF:f:0.5\tXA:A:q"}
+
+var filterTests = []struct {
+	expression string
+	expected   bool
+}{
+	{"", true},
+	{"mapq >= 30 && !secondary && [NM] < 4", true},
+	{"mapq >= 30 && !secondary && [NM] < 3", false},
+	{"paired && proper_pair && reverse && read1", true},
+	{"mate_reverse || read2 || unmapped || mate_unmapped || duplicate || qcfail || supplementary", false},
+	{"[XX] < 4", false},
+	{"![XX]", true},
+	{"[NM]", true},
+	{"[AS] > -20 && [AS] <= -12", true},
+	{"-[AS] == 12", true},
+	{"[RG] == \"group1\"", true},
+	{"[RG] =~ \"^group[0-9]$\" && [RG] !~ \"2\"", true},
+	{"[XF] * 4 == 2", true},
+	{"[XA] == \"q\"", true},
+	{"flag & 0x10", true},
+	{"(flag & 0x100) != 0", false},
+	{"flag == 0x53", true},
+	{"flag == 0X53 && 010 == 10 && 1.5e1 == 15 && 2E-1 * 10 == 2 && .5 * 2 == 1", true},
+	{"[XF] == 5e-1", true},
+	{"pos == 100 && endpos == 116 && rlen == 17 && qlen == 20 && ncigar == 4", true},
+	{"cigar == \"5S10M2D5M\" && rname == \"chr1\" && mrname == \"=\" && mpos - pos == 200 && tlen / 2 == 125", true},
+	{"qname =~ \":1$\" && seq =~ \"^ACGT\"", true},
+	{"mapq > 30 || mapq < 10 && mapq > 50", true},
+	{"(mapq > 30 || mapq < 10) && mapq > 50", false},
+	{"10 % 3 == 1 && 1 + 2 * 3 == 7 && (2 | 4) == 6", true},
+	{"[NM] / 0", false},
+}
+
+func TestFilter(t *testing.T) {
+	for _, test := range filterTests {
+		f, err := NewFilter(test.expression)
+		if err != nil {
+			t.Errorf("Error: could not compile %s: %v\n", test.expression, err)
+			continue
+		}
+		if f.Keep(&filterRecord) != test.expected {
+			t.Errorf("Error: %s returned %v, expected %v...\n", test.expression, !test.expected, test.expected)
+		}
+	}
+	f, _ := NewFilter("")
+	f.MinMapQ = 50
+	if f.Keep(&filterRecord) {
+		t.Errorf("Error: mapping quality filter did not remove the record...\n")
+	}
+	f.MinMapQ, f.ExcludeFlags = 0, FlagReverse
+	if f.Keep(&filterRecord) {
+		t.Errorf("Error: exclude flag filter did not remove the record...\n")
+	}
+	f.ExcludeFlags, f.RequireFlags = 0, FlagPaired|FlagRead2
+	if f.Keep(&filterRecord) {
+		t.Errorf("Error: require flag filter did not remove the record...\n")
+	}
+	f.RequireFlags = FlagPaired | FlagRead1
+	for region, expected := range map[string]bool{"chr1:110-120": true, "chr1:117-200": false, "chr1:116": true, "chr2": false, "chr1": true} {
+		f.SetRegion(region)
+		if f.Keep(&filterRecord) != expected {
+			t.Errorf("Error: region %s returned %v, expected %v...\n", region, !expected, expected)
+		}
+	}
+}
+
+var invalidFilterTests = []string{
+	"mapq >=",
+	"mapq >= 30 &&",
+	"(mapq >= 30",
+	"mapq >= 30)",
+	"quality > 2",
+	"[NMX] > 1",
+	"qname == \"x",
+	"qname =~ 5",
+	"qname =~ \"(\"",
+	"mapq # 3",
+	"mapq > 3a",
+	"mapq > 0x",
+	"mapq > 1.2.3",
+}
+
+func TestInvalidFilter(t *testing.T) {
+	for _, expression := range invalidFilterTests {
+		if _, err := NewFilter(expression); err == nil {
+			t.Errorf("Error: expected %s to be an invalid expression...\n", expression)
+		}
+	}
+}
+
+// TestFilterFile will compare filtering with an indexed region query to filtering every record of the sam file.
+func TestFilterFile(t *testing.T) {
+	for _, test := range readBamTests {
+		f, err := NewFilter("mapq >= 30 && !reverse")
+		if err != nil {
+			t.Fatalf("Error: %v\n", err)
+		}
+		f.SetRegion("tig00000004:1000-2000")
+		var expected int
+		for _, s := range ReadSamRecord(test.sam) {
+			if f.Keep(s) {
+				expected++
+			}
+		}
+		var count int
+		_, records := FilterFile(test.bam, f)
+		for range records {
+			count++
+		}
+		if count != expected || count == 0 {
+			t.Errorf("Error: filtering %s returned %d records, expected %d...\n", test.bam, count, expected)
+		}
+	}
+}
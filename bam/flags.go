@@ -0,0 +1,74 @@
+package bam
+
+// Bit values of the sam flag field.
+const (
+	FlagPaired        uint16 = 0x1
+	FlagProperPair    uint16 = 0x2
+	FlagUnmapped      uint16 = 0x4
+	FlagMateUnmapped  uint16 = 0x8
+	FlagReverse       uint16 = 0x10
+	FlagMateReverse   uint16 = 0x20
+	FlagRead1         uint16 = 0x40
+	FlagRead2         uint16 = 0x80
+	FlagSecondary     uint16 = 0x100
+	FlagQcFail        uint16 = 0x200
+	FlagDuplicate     uint16 = 0x400
+	FlagSupplementary uint16 = 0x800
+)
+
+// flagNames maps the names used by filter expressions to each bit of the sam flag.
+var flagNames = map[string]uint16{
+	"paired":        FlagPaired,
+	"proper_pair":   FlagProperPair,
+	"unmapped":      FlagUnmapped,
+	"mate_unmapped": FlagMateUnmapped,
+	"reverse":       FlagReverse,
+	"mate_reverse":  FlagMateReverse,
+	"read1":         FlagRead1,
+	"read2":         FlagRead2,
+	"secondary":     FlagSecondary,
+	"qcfail":        FlagQcFail,
+	"duplicate":     FlagDuplicate,
+	"supplementary": FlagSupplementary,
+}
+
+// HasFlag returns true if every bit of flag is set in the record.
+func (s *Sam) HasFlag(flag uint16) bool {
+	return s.Flag&flag == flag
+}
+
+// IsPaired returns true if the read was sequenced as part of a pair.
+func (s *Sam) IsPaired() bool { return s.HasFlag(FlagPaired) }
+
+// IsProperPair returns true if both reads of the pair were aligned as expected by the aligner.
+func (s *Sam) IsProperPair() bool { return s.HasFlag(FlagProperPair) }
+
+// IsUnmapped returns true if the read did not align.
+func (s *Sam) IsUnmapped() bool { return s.HasFlag(FlagUnmapped) }
+
+// IsMateUnmapped returns true if the mate of the read did not align.
+func (s *Sam) IsMateUnmapped() bool { return s.HasFlag(FlagMateUnmapped) }
+
+// IsReverse returns true if the read aligned to the reverse strand.
+func (s *Sam) IsReverse() bool { return s.HasFlag(FlagReverse) }
+
+// IsMateReverse returns true if the mate of the read aligned to the reverse strand.
+func (s *Sam) IsMateReverse() bool { return s.HasFlag(FlagMateReverse) }
+
+// IsRead1 returns true if the record is the first read of a pair.
+func (s *Sam) IsRead1() bool { return s.HasFlag(FlagRead1) }
+
+// IsRead2 returns true if the record is the second read of a pair.
+func (s *Sam) IsRead2() bool { return s.HasFlag(FlagRead2) }
+
+// IsSecondary returns true if the record is a secondary alignment.
+func (s *Sam) IsSecondary() bool { return s.HasFlag(FlagSecondary) }
+
+// IsQcFail returns true if the read did not pass quality checks.
+func (s *Sam) IsQcFail() bool { return s.HasFlag(FlagQcFail) }
+
+// IsDuplicate returns true if the read was marked as a pcr or optical duplicate.
+func (s *Sam) IsDuplicate() bool { return s.HasFlag(FlagDuplicate) }
+
+// IsSupplementary returns true if the record is a supplementary alignment of a chimeric read.
+func (s *Sam) IsSupplementary() bool { return s.HasFlag(FlagSupplementary) }
@@ -0,0 +1,504 @@
+package bam
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HeaderField is a single TAG:VALUE pair of a sam header line.
+type HeaderField struct {
+	Tag   string
+	Value string
+}
+
+// HeaderLine is a single sam header record, such as @SQ or @RG, with the fields kept in their original order.
+type HeaderLine struct {
+	Type   string
+	Fields []HeaderField
+}
+
+// SamHeader is a parsed sam header. The reference dictionary, read groups and programs keep the order in which they
+// were found, and all fields, including tags unknown to goFish, are preserved. Records are written back in the order
+// they were read, with new records placed after the last record of the same type. Record types that were not in the
+// parsed text follow the order recommended by the sam specs: @HD, @SQ, @RG, @PG, @CO followed by any other types.
+type SamHeader struct {
+	HD         *HeaderLine
+	Refs       []*HeaderLine
+	ReadGroups []*HeaderLine
+	Programs   []*HeaderLine
+	Comments   []string
+	Other      []*HeaderLine
+	order      []string // the type of each line in the parsed text, see lineGroup
+}
+
+// sortOrders and groupOrders are the values allowed by the sam specs for the SO and GO fields of the @HD line.
+var (
+	sortOrders  = map[string]bool{"unknown": true, "unsorted": true, "queryname": true, "coordinate": true}
+	groupOrders = map[string]bool{"none": true, "query": true, "reference": true}
+	headerTagRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]$`)
+	refNameRe   = regexp.MustCompile("^[0-9A-Za-z!#$%&+./:;?@^_|~-][0-9A-Za-z!#$%&*+./:;=?@^_|~-]*$")
+	versionRe   = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+)
+
+// NewHeaderLine will create a header record of a given type, for example NewHeaderLine("RG", "ID", "x", "SM", "sample").
+// Tags and values are provided in pairs.
+func NewHeaderLine(lineType string, tagValues ...string) *HeaderLine {
+	if len(tagValues)%2 != 0 {
+		log.Fatalf("Error: header tags and values must be provided in pairs...\n")
+	}
+	ans := &HeaderLine{Type: lineType}
+	for i := 0; i < len(tagValues); i += 2 {
+		ans.Fields = append(ans.Fields, HeaderField{Tag: tagValues[i], Value: tagValues[i+1]})
+	}
+	return ans
+}
+
+// Get will return the value of a tag in a header line.
+func (line *HeaderLine) Get(tag string) (string, bool) {
+	for _, f := range line.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set will replace the value of a tag in a header line or append the tag if it does not exist.
+func (line *HeaderLine) Set(tag string, value string) {
+	for i := range line.Fields {
+		if line.Fields[i].Tag == tag {
+			line.Fields[i].Value = value
+			return
+		}
+	}
+	line.Fields = append(line.Fields, HeaderField{Tag: tag, Value: value})
+}
+
+// Delete will remove a tag from a header line and return true if the tag was found.
+func (line *HeaderLine) Delete(tag string) bool {
+	for i := range line.Fields {
+		if line.Fields[i].Tag == tag {
+			line.Fields = append(line.Fields[:i], line.Fields[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ToString will format a header line as tab delimited sam text without a trailing newline.
+func (line *HeaderLine) ToString() string {
+	var str strings.Builder
+	str.WriteString("@" + line.Type)
+	for _, f := range line.Fields {
+		str.WriteString("\t" + f.Tag + ":" + f.Value)
+	}
+	return str.String()
+}
+
+// equal returns true if two header lines have the same type and fields.
+func (line *HeaderLine) equal(other *HeaderLine) bool {
+	if line.Type != other.Type || len(line.Fields) != len(other.Fields) {
+		return false
+	}
+	for i := range line.Fields {
+		if line.Fields[i] != other.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// copyLine will allocate a new header line with the same fields.
+func copyLine(line *HeaderLine) *HeaderLine {
+	return &HeaderLine{Type: line.Type, Fields: append([]HeaderField(nil), line.Fields...)}
+}
+
+// ParseHeaderLine will parse a single line of sam header text.
+func ParseHeaderLine(text string) *HeaderLine {
+	words := strings.Split(strings.TrimRight(text, "\r\n"), "\t")
+	if len(words[0]) != 3 || words[0][0] != '@' {
+		log.Fatalf("Error: %s is not a valid sam header line...\n", text)
+	}
+	ans := &HeaderLine{Type: words[0][1:]}
+	for _, w := range words[1:] {
+		if len(w) < 3 || w[2] != ':' {
+			log.Fatalf("Error: header field %s in %s is not in TAG:VALUE format...\n", w, text)
+		}
+		ans.Fields = append(ans.Fields, HeaderField{Tag: w[:2], Value: w[3:]})
+	}
+	return ans
+}
+
+// NewSamHeader will parse the text of a sam/bam header into typed records. If the text does not contain a reference
+// dictionary, which is allowed in bam files, the @SQ records are created from the binary reference list.
+func NewSamHeader(header *Header) *SamHeader {
+	ans := &SamHeader{}
+	for _, text := range strings.Split(header.Text.String(), "\n") {
+		text = strings.TrimRight(text, "\r\000")
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "@CO"):
+			ans.Comments = append(ans.Comments, strings.TrimPrefix(strings.TrimPrefix(text, "@CO"), "\t"))
+			ans.order = append(ans.order, "CO")
+		default:
+			line := ParseHeaderLine(text)
+			ans.add(line)
+			ans.order = append(ans.order, lineGroup(line.Type))
+		}
+	}
+	if len(ans.Refs) == 0 {
+		for _, c := range header.Chroms {
+			ans.Refs = append(ans.Refs, NewHeaderLine("SQ", "SN", c.Name, "LN", strconv.Itoa(c.Size)))
+		}
+	}
+	return ans
+}
+
+// add will place a header line into the list matching its type.
+func (s *SamHeader) add(line *HeaderLine) {
+	switch line.Type {
+	case "HD":
+		s.HD = line
+	case "SQ":
+		s.Refs = append(s.Refs, line)
+	case "RG":
+		s.ReadGroups = append(s.ReadGroups, line)
+	case "PG":
+		s.Programs = append(s.Programs, line)
+	default:
+		s.Other = append(s.Other, line)
+	}
+}
+
+// headerGroups are the groups of header lines in the order recommended by the sam specs.
+var headerGroups = []string{"HD", "SQ", "RG", "PG", "CO", "other"}
+
+// lineGroup returns the group of a header line type, which is the type itself for the types defined by the sam specs
+// and other for the rest.
+func lineGroup(lineType string) string {
+	switch lineType {
+	case "HD", "SQ", "RG", "PG", "CO":
+		return lineType
+	}
+	return "other"
+}
+
+// ToString will format the sam header as text, with every line terminated by a newline.
+func (s *SamHeader) ToString() string {
+	pending := map[string][]string{"CO": nil}
+	if s.HD != nil {
+		pending["HD"] = []string{s.HD.ToString()}
+	}
+	for group, lines := range map[string][]*HeaderLine{"SQ": s.Refs, "RG": s.ReadGroups, "PG": s.Programs, "other": s.Other} {
+		for _, line := range lines {
+			pending[group] = append(pending[group], line.ToString())
+		}
+	}
+	for _, c := range s.Comments {
+		pending["CO"] = append(pending["CO"], "@CO\t"+c)
+	}
+	// each line of the parsed text is a slot filled by the next line of its group, the last slot of a group takes
+	// the lines added since parsing, and groups without a slot are placed by the order of the sam specs
+	rank := make(map[string]int)
+	for i, group := range headerGroups {
+		rank[group] = i
+	}
+	last := make(map[string]int)
+	for i, group := range s.order {
+		last[group] = i
+	}
+	var str strings.Builder
+	write := func(group string, all bool) {
+		n := 1
+		if all {
+			n = len(pending[group])
+		}
+		for ; n > 0 && len(pending[group]) > 0; n-- {
+			str.WriteString(pending[group][0] + "\n")
+			pending[group] = pending[group][1:]
+		}
+	}
+	for i, group := range s.order {
+		for _, missing := range headerGroups {
+			if _, found := last[missing]; !found && rank[missing] < rank[group] {
+				write(missing, true)
+			}
+		}
+		write(group, last[group] == i)
+	}
+	for _, group := range headerGroups {
+		write(group, true)
+	}
+	return str.String()
+}
+
+// ToHeader will convert the parsed sam header into a Header used by the sam and bam readers and writers.
+func (s *SamHeader) ToHeader() *Header {
+	ans := MakeHeader()
+	ans.Text.WriteString(s.ToString())
+	for _, ref := range s.Refs {
+		name, _ := ref.Get("SN")
+		length, _ := ref.Get("LN")
+		size, _ := strconv.Atoi(length)
+		ans.ChromSize[name] = size
+		ans.Chroms = append(ans.Chroms, ChromSize{Name: name, Size: size, Order: len(ans.Chroms)})
+	}
+	return ans
+}
+
+// Validate will check the header follows the sam specs: tags are two characters, @HD contains a valid version and
+// sort order, reference names are unique and valid with positive lengths, and read group and program ids are unique
+// with every program PP field pointing to an existing program.
+func (s *SamHeader) Validate() error {
+	lines := append([]*HeaderLine{}, s.Refs...)
+	lines = append(append(append(lines, s.ReadGroups...), s.Programs...), s.Other...)
+	if s.HD != nil {
+		lines = append(lines, s.HD)
+		if version, ok := s.HD.Get("VN"); !ok || !versionRe.MatchString(version) {
+			return fmt.Errorf("@HD line must contain a VN field in major.minor format: %s", s.HD.ToString())
+		}
+		if so, ok := s.HD.Get("SO"); ok && !sortOrders[so] {
+			return fmt.Errorf("invalid sort order in @HD line: %s", so)
+		}
+		if group, ok := s.HD.Get("GO"); ok && !groupOrders[group] {
+			return fmt.Errorf("invalid group order in @HD line: %s", group)
+		}
+	}
+	for _, line := range lines {
+		if !headerTagRe.MatchString(line.Type) {
+			return fmt.Errorf("invalid header record type: @%s", line.Type)
+		}
+		tags := make(map[string]bool)
+		for _, f := range line.Fields {
+			if !headerTagRe.MatchString(f.Tag) {
+				return fmt.Errorf("invalid tag %s in header line: %s", f.Tag, line.ToString())
+			}
+			if tags[f.Tag] {
+				return fmt.Errorf("duplicate tag %s in header line: %s", f.Tag, line.ToString())
+			}
+			tags[f.Tag] = true
+		}
+	}
+	names := make(map[string]bool)
+	for _, ref := range s.Refs {
+		name, ok := ref.Get("SN")
+		if !ok || !refNameRe.MatchString(name) {
+			return fmt.Errorf("@SQ line must contain a valid SN field: %s", ref.ToString())
+		}
+		if names[name] {
+			return fmt.Errorf("reference %s is found more than once in the header", name)
+		}
+		names[name] = true
+		length, ok := ref.Get("LN")
+		if size, err := strconv.Atoi(length); !ok || err != nil || size < 1 || size > 1<<31-1 {
+			return fmt.Errorf("@SQ line must contain an LN field between 1 and 2^31-1: %s", ref.ToString())
+		}
+	}
+	if err := uniqueIds(s.ReadGroups); err != nil {
+		return err
+	}
+	if err := uniqueIds(s.Programs); err != nil {
+		return err
+	}
+	ids := make(map[string]bool)
+	for _, pg := range s.Programs {
+		id, _ := pg.Get("ID")
+		ids[id] = true
+	}
+	for _, pg := range s.Programs {
+		if prev, ok := pg.Get("PP"); ok && !ids[prev] {
+			return fmt.Errorf("@PG line refers to a program that does not exist: %s", pg.ToString())
+		}
+	}
+	return nil
+}
+
+// uniqueIds will check that every header line contains a unique ID field.
+func uniqueIds(lines []*HeaderLine) error {
+	ids := make(map[string]bool)
+	for _, line := range lines {
+		id, ok := line.Get("ID")
+		if !ok || id == "" {
+			return fmt.Errorf("@%s line must contain an ID field: %s", line.Type, line.ToString())
+		}
+		if ids[id] {
+			return fmt.Errorf("@%s ID %s is found more than once in the header", line.Type, id)
+		}
+		ids[id] = true
+	}
+	return nil
+}
+
+// ReadGroup will return the @RG line with a matching ID.
+func (s *SamHeader) ReadGroup(id string) (*HeaderLine, bool) {
+	return findId(s.ReadGroups, id)
+}
+
+// Program will return the @PG line with a matching ID.
+func (s *SamHeader) Program(id string) (*HeaderLine, bool) {
+	return findId(s.Programs, id)
+}
+
+func findId(lines []*HeaderLine, id string) (*HeaderLine, bool) {
+	for _, line := range lines {
+		if curr, _ := line.Get("ID"); curr == id {
+			return line, true
+		}
+	}
+	return nil, false
+}
+
+// AddReadGroup will append a new @RG line. An error is returned if the ID is missing or already in use.
+func (s *SamHeader) AddReadGroup(rg *HeaderLine) error {
+	id, ok := rg.Get("ID")
+	if !ok || id == "" {
+		return fmt.Errorf("@RG line must contain an ID field: %s", rg.ToString())
+	}
+	if _, found := s.ReadGroup(id); found {
+		return fmt.Errorf("@RG ID %s is found more than once in the header", id)
+	}
+	rg.Type = "RG"
+	s.ReadGroups = append(s.ReadGroups, rg)
+	return nil
+}
+
+// AddProgram will append a @PG line for a program run on the alignments. The ID is the program name, with a numeric
+// suffix added if needed to keep it unique, and PP links the new line to the last program in the chain.
+func (s *SamHeader) AddProgram(name string, version string, commandLine string) *HeaderLine {
+	id := name
+	for i := 1; ; i++ {
+		if _, found := s.Program(id); !found {
+			break
+		}
+		id = name + "." + strconv.Itoa(i)
+	}
+	pg := NewHeaderLine("PG", "ID", id, "PN", name)
+	if prev := s.lastProgram(); prev != "" {
+		pg.Set("PP", prev)
+	}
+	if version != "" {
+		pg.Set("VN", version)
+	}
+	if commandLine != "" {
+		pg.Set("CL", commandLine)
+	}
+	s.Programs = append(s.Programs, pg)
+	return pg
+}
+
+// lastProgram returns the ID of the program at the end of the PP chain, which is the most recent @PG line no other program refers to.
+func (s *SamHeader) lastProgram() string {
+	used := make(map[string]bool)
+	for _, pg := range s.Programs {
+		if prev, ok := pg.Get("PP"); ok {
+			used[prev] = true
+		}
+	}
+	for i := len(s.Programs) - 1; i >= 0; i-- {
+		if id, _ := s.Programs[i].Get("ID"); !used[id] {
+			return id
+		}
+	}
+	return ""
+}
+
+// SetSortOrder will set the SO field of the @HD line, adding an @HD line if one does not exist.
+func (s *SamHeader) SetSortOrder(order string) {
+	if s.HD == nil {
+		s.HD = NewHeaderLine("HD", "VN", "1.6")
+	}
+	s.HD.Set("SO", order)
+}
+
+// MergeHeaders will combine the headers of several sam/bam files. References are merged by name and must have the same
+// length, identical read groups are kept once while read groups that share an ID with different fields result in an error.
+// Programs sharing an ID are renamed with a numeric suffix and the PP fields of that header are updated to match.
+// The @HD line is taken from the first header that has one, and headers with a different format version (VN) or sort
+// order (SO) result in an error.
+func MergeHeaders(headers ...*SamHeader) (*SamHeader, error) {
+	ans := &SamHeader{}
+	refs := make(map[string]string)
+	comments := make(map[string]bool)
+	for _, h := range headers {
+		if h.HD != nil {
+			if ans.HD == nil {
+				ans.HD = copyLine(h.HD)
+			} else {
+				for _, tag := range []string{"VN", "SO"} {
+					prev, _ := ans.HD.Get(tag)
+					if curr, _ := h.HD.Get(tag); curr != prev {
+						return nil, fmt.Errorf("@HD %s is %q and %q in the headers being merged", tag, prev, curr)
+					}
+				}
+			}
+		}
+		for _, ref := range h.Refs {
+			name, _ := ref.Get("SN")
+			length, _ := ref.Get("LN")
+			if prev, found := refs[name]; found {
+				if prev != length {
+					return nil, fmt.Errorf("reference %s has different lengths, %s and %s, in the headers being merged", name, prev, length)
+				}
+				continue
+			}
+			refs[name] = length
+			ans.Refs = append(ans.Refs, copyLine(ref))
+		}
+		for _, rg := range h.ReadGroups {
+			id, _ := rg.Get("ID")
+			if prev, found := ans.ReadGroup(id); found {
+				if !prev.equal(rg) {
+					return nil, fmt.Errorf("read group %s has different fields in the headers being merged", id)
+				}
+				continue
+			}
+			ans.ReadGroups = append(ans.ReadGroups, copyLine(rg))
+		}
+		renamed := make(map[string]string)
+		var programs []*HeaderLine
+		for _, pg := range h.Programs {
+			curr := copyLine(pg)
+			id, _ := curr.Get("ID")
+			if prev, found := ans.Program(id); found && !prev.equal(pg) {
+				newId := id
+				for j := 1; found; j++ {
+					newId = id + "." + strconv.Itoa(j)
+					_, found = ans.Program(newId)
+				}
+				renamed[id] = newId
+				curr.Set("ID", newId)
+			} else if found {
+				continue
+			}
+			programs = append(programs, curr)
+		}
+		for _, pg := range programs {
+			if prev, ok := pg.Get("PP"); ok && renamed[prev] != "" {
+				pg.Set("PP", renamed[prev])
+			}
+		}
+		ans.Programs = append(ans.Programs, programs...)
+		for _, c := range h.Comments {
+			if !comments[c] {
+				comments[c] = true
+				ans.Comments = append(ans.Comments, c)
+			}
+		}
+		ans.Other = append(ans.Other, h.Other...)
+	}
+	return ans, nil
+}
+
+// AddProgramLine is a helper for goFish commands that rewrite alignments, which will append a @PG line with the
+// command name and arguments to the header and update the header text. The CL field is args joined by spaces, so
+// passing os.Args records the program path as well as its flags.
+func AddProgramLine(header *Header, name string, args []string) {
+	s := NewSamHeader(header)
+	s.AddProgram(name, "", strings.Join(args, " "))
+	header.Text.Reset()
+	header.Text.WriteString(s.ToString())
+}
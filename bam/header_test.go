@@ -0,0 +1,138 @@
+package bam
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSamHeader will check the parsed header of the test files is valid and writes back to identical sam and bam headers.
+func TestSamHeader(t *testing.T) {
+	for _, test := range readBamTests {
+		for _, input := range []string{test.bam, test.sam} {
+			header, _ := Read(input)
+			s := NewSamHeader(header)
+			if err := s.Validate(); err != nil {
+				t.Errorf("Error: header of %s is not valid: %v\n", input, err)
+			}
+			if s.ToString() != header.Text.String() {
+				t.Errorf("Error: header of %s did not round trip through the parsed header...\n", input)
+			}
+			if len(s.ReadGroups) != 1 || len(s.Programs) != 1 || len(s.Comments) != 3 || len(s.Refs) != len(header.Chroms) {
+				t.Errorf("Error: header of %s was not parsed into the expected records...\n", input)
+			}
+			if rg, ok := s.ReadGroup("rabsDraft4_10x_align:LibraryNotSpecified:1:unknown_fc:0"); !ok {
+				t.Errorf("Error: read group was not found...\n")
+			} else if sm, _ := rg.Get("SM"); sm != "rabsDraft4_10x_align" {
+				t.Errorf("Error: read group sample %s was not parsed...\n", sm)
+			}
+
+			filename := t.TempDir() + "/header.bam"
+			WriteBinaryHeader(filename, s.ToHeader()).Close()
+			h, _ := Read(filename)
+			if h.Text.String() != header.Text.String() || len(h.Chroms) != len(header.Chroms) || h.Chroms[10] != header.Chroms[10] {
+				t.Errorf("Error: header of %s did not round trip through a bam file...\n", input)
+			}
+		}
+	}
+}
+
+var headerValidateTests = []struct {
+	text  string
+	valid bool
+}{
+	{"@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\n", true},
+	{"@HD\tVN:1.6\tSO:random\n", false},
+	{"@HD\tSO:coordinate\n", false},
+	{"@HD\tVN:1.6\tGO:query\n", true},
+	{"@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr1\tLN:100\n", false},
+	{"@SQ\tSN:chr1\tLN:0\n", false},
+	{"@SQ\tSN:*chr1\tLN:10\n", false},
+	{"@SQ\tLN:10\n", false},
+	{"@RG\tID:a\n@RG\tID:a\n", false},
+	{"@RG\tSM:a\n", false},
+	{"@PG\tID:bwa\n@PG\tID:sortBam\tPP:bwa\n", true},
+	{"@PG\tID:sortBam\tPP:bwa\n", false},
+	{"@RG\tID:a\tSM:x\tSM:y\n", false},
+	{"@RG\tID:a\t1M:x\n", false},
+}
+
+func TestValidateHeader(t *testing.T) {
+	for _, test := range headerValidateTests {
+		header := MakeHeader()
+		header.Text.WriteString(test.text)
+		if err := NewSamHeader(header).Validate(); (err == nil) != test.valid {
+			t.Errorf("Error: validating %q returned %v...\n", test.text, err)
+		}
+	}
+}
+
+func TestMergeHeaders(t *testing.T) {
+	one, two := MakeHeader(), MakeHeader()
+	one.Text.WriteString("@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\n@RG\tID:a\tSM:x\n@PG\tID:bwa\tPN:bwa\n@PG\tID:sortBam\tPN:sortBam\tPP:bwa\n@CO\tone\n")
+	two.Text.WriteString("@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr2\tLN:50\n@RG\tID:a\tSM:x\n@RG\tID:b\tSM:y\n@PG\tID:bwa\tPN:bwa\tVN:2\n@CO\tone\n@CO\ttwo\n")
+	merged, err := MergeHeaders(NewSamHeader(one), NewSamHeader(two))
+	if err != nil {
+		t.Fatalf("Error: could not merge headers: %v\n", err)
+	}
+	expected := "@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr2\tLN:50\n@RG\tID:a\tSM:x\n@RG\tID:b\tSM:y\n" +
+		"@PG\tID:bwa\tPN:bwa\n@PG\tID:sortBam\tPN:sortBam\tPP:bwa\n@PG\tID:bwa.1\tPN:bwa\tVN:2\n@CO\tone\n@CO\ttwo\n"
+	if merged.ToString() != expected {
+		t.Errorf("Error: merged header did not match expected:\n%s\n", merged.ToString())
+	}
+	if err = merged.Validate(); err != nil {
+		t.Errorf("Error: merged header is not valid: %v\n", err)
+	}
+	conflict := MakeHeader()
+	conflict.Text.WriteString("@SQ\tSN:chr1\tLN:200\n")
+	if _, err = MergeHeaders(NewSamHeader(one), NewSamHeader(conflict)); err == nil {
+		t.Errorf("Error: merging references with different lengths should fail...\n")
+	}
+	conflict.Text.Reset()
+	conflict.Text.WriteString("@RG\tID:a\tSM:z\n")
+	if _, err = MergeHeaders(NewSamHeader(one), NewSamHeader(conflict)); err == nil {
+		t.Errorf("Error: merging read groups with different fields should fail...\n")
+	}
+	for _, hd := range []string{"@HD\tVN:1.6\tSO:queryname\n", "@HD\tVN:1.5\tSO:coordinate\n"} {
+		conflict.Text.Reset()
+		conflict.Text.WriteString(hd)
+		if _, err = MergeHeaders(NewSamHeader(one), NewSamHeader(conflict)); err == nil {
+			t.Errorf("Error: merging headers with %q should fail...\n", hd)
+		}
+	}
+}
+
+func TestSamHeaderOrder(t *testing.T) {
+	header := MakeHeader()
+	text := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n@PG\tID:bwa\tPN:bwa\n@CO\tmapped\n@XY\tAB:1\n@PG\tID:dedup\tPN:dedup\tPP:bwa\n@CO\tdeduplicated\n"
+	header.Text.WriteString(text)
+	s := NewSamHeader(header)
+	if s.ToString() != text {
+		t.Errorf("Error: interleaved header lines were reordered:\n%s\n", s.ToString())
+	}
+	s.AddProgram("sortBam", "", "")
+	s.Comments = append(s.Comments, "sorted")
+	expected := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n@PG\tID:bwa\tPN:bwa\n@CO\tmapped\n@XY\tAB:1\n@PG\tID:dedup\tPN:dedup\tPP:bwa\n" +
+		"@PG\tID:sortBam\tPN:sortBam\tPP:dedup\n@CO\tdeduplicated\n@CO\tsorted\n"
+	if s.ToString() != expected {
+		t.Errorf("Error: new header lines were not placed after the lines of the same type:\n%s\n", s.ToString())
+	}
+}
+
+func TestAddProgramLine(t *testing.T) {
+	header := MakeHeader()
+	header.Text.WriteString("@HD\tVN:1.6\n@PG\tID:bwa\tPN:bwa\n")
+	AddProgramLine(header, "sortBam", []string{"sortBam", "in.bam", "out.bam"})
+	AddProgramLine(header, "sortBam", []string{"sortBam", "-n", "out.bam", "name.bam"})
+	s := NewSamHeader(header)
+	if err := s.AddReadGroup(NewHeaderLine("RG", "ID", "x", "SM", "sample")); err != nil {
+		t.Errorf("Error: could not add read group: %v\n", err)
+	}
+	if err := s.AddReadGroup(NewHeaderLine("RG", "ID", "x")); err == nil {
+		t.Errorf("Error: adding a duplicate read group should fail...\n")
+	}
+	expected := "@HD\tVN:1.6\n@RG\tID:x\tSM:sample\n@PG\tID:bwa\tPN:bwa\n@PG\tID:sortBam\tPN:sortBam\tPP:bwa\tCL:sortBam in.bam out.bam\n" +
+		"@PG\tID:sortBam.1\tPN:sortBam\tPP:sortBam\tCL:sortBam -n out.bam name.bam\n"
+	if s.ToString() != expected || s.Validate() != nil || !strings.HasSuffix(header.Text.String(), "name.bam\n") {
+		t.Errorf("Error: program lines were not added as expected:\n%s\n", s.ToString())
+	}
+}
@@ -16,7 +16,7 @@ func ViewUrl(url string) {
 	if strings.HasSuffix(url, ".bam") {
 		reader := &BamReader{}
 		//reader.File = resp.Body
-		reader.Gunzip = NewBgzipReader(resp.Body)
+		reader.Gunzip = simpleio.NewBgzfReader(resp.Body, simpleio.DefaultThreads)
 		h := ReadHeader(reader)
 		binaryData := make(chan *BinaryDecoder)
 
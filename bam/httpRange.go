@@ -0,0 +1,221 @@
+package bam
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/edotau/goFish/simpleio"
+)
+
+const (
+	// DefaultHttpBlockSize is the number of bytes requested with each http range request.
+	DefaultHttpBlockSize int64 = 64 << 10
+	// DefaultHttpCacheBlocks is the number of fetched blocks kept in memory by an HttpRangeReader.
+	DefaultHttpCacheBlocks int = 64
+)
+
+// ErrNoRangeSupport is returned when a server sends the whole file in response to a range request.
+var ErrNoRangeSupport = errors.New("server does not support http range requests")
+
+// HttpRangeReader reads a remote file by requesting fixed size blocks with http range requests. Blocks are kept
+// in a small cache, so seeking back to a recently read offset, such as the start of a neighboring bai chunk, does
+// not fetch the data again. It implements io.ReadSeeker and io.ReaderAt and can be used by several goroutines.
+type HttpRangeReader struct {
+	Url         string
+	Client      *http.Client
+	BlockSize   int64
+	CacheBlocks int
+	size        int64
+	offset      int64
+	cache       map[int64][]byte
+	recent      []int64
+	lock        sync.Mutex
+}
+
+// NewHttpRangeReader will set up a reader for a url. No data is requested until the first read.
+func NewHttpRangeReader(url string) *HttpRangeReader {
+	return &HttpRangeReader{
+		Url:         url,
+		Client:      http.DefaultClient,
+		BlockSize:   DefaultHttpBlockSize,
+		CacheBlocks: DefaultHttpCacheBlocks,
+		size:        -1,
+		cache:       make(map[int64][]byte),
+	}
+}
+
+// IsUrl returns true if a filename is an http or https link.
+func IsUrl(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// ReadAt will fill p with the bytes starting at off, fetching any blocks that are not in the cache.
+func (r *HttpRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.readAt(p, off)
+}
+
+func (r *HttpRangeReader) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d reading %s", off, r.Url)
+	}
+	var n int
+	for n < len(p) {
+		index := (off + int64(n)) / r.BlockSize
+		data, err := r.block(index)
+		if err != nil {
+			return n, err
+		}
+		start := off + int64(n) - index*r.BlockSize
+		if start >= int64(len(data)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[start:])
+		if int64(len(data)) < r.BlockSize && n < len(p) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// Read implements io.Reader starting from the current offset.
+func (r *HttpRangeReader) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker. Seeking relative to the end will request the size of the file if it is not known.
+func (r *HttpRangeReader) Seek(offset int64, whence int) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.fileSize()
+		if err != nil {
+			return r.offset, err
+		}
+		offset += size
+	default:
+		return r.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("negative offset %d seeking %s", offset, r.Url)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Size returns the length of the remote file, which is learned from the first range request.
+func (r *HttpRangeReader) Size() (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.fileSize()
+}
+
+func (r *HttpRangeReader) fileSize() (int64, error) {
+	if r.size < 0 {
+		if _, err := r.block(0); err != nil && err != io.EOF {
+			return -1, err
+		}
+	}
+	return r.size, nil
+}
+
+// Close will release the cached blocks.
+func (r *HttpRangeReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cache, r.recent = make(map[int64][]byte), nil
+	return nil
+}
+
+// block returns a cached block or fetches it, evicting the least recently used block when the cache is full.
+func (r *HttpRangeReader) block(index int64) ([]byte, error) {
+	if data, ok := r.cache[index]; ok {
+		for i, b := range r.recent {
+			if b == index {
+				r.recent = append(append(r.recent[:i:i], r.recent[i+1:]...), index)
+				break
+			}
+		}
+		return data, nil
+	}
+	if r.size >= 0 && index*r.BlockSize >= r.size {
+		return nil, io.EOF
+	}
+	data, err := r.fetch(index*r.BlockSize, (index+1)*r.BlockSize-1)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.recent) >= r.CacheBlocks && len(r.recent) > 0 {
+		delete(r.cache, r.recent[0])
+		r.recent = r.recent[1:]
+	}
+	r.cache[index] = data
+	r.recent = append(r.recent, index)
+	return data, nil
+}
+
+// fetch will request the closed byte range start-end and record the file size from the Content-Range header.
+func (r *HttpRangeReader) fetch(start int64, end int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, r.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		r.size = contentRangeSize(resp.Header.Get("Content-Range"), r.size)
+		return nil, io.EOF
+	case http.StatusOK:
+		return nil, fmt.Errorf("%w: %s", ErrNoRangeSupport, r.Url)
+	default:
+		return nil, fmt.Errorf("requesting bytes %d-%d of %s: %s", start, end, r.Url, resp.Status)
+	}
+	r.size = contentRangeSize(resp.Header.Get("Content-Range"), r.size)
+	return io.ReadAll(resp.Body)
+}
+
+// contentRangeSize parses the total length from a Content-Range header such as bytes 0-99/1234.
+func contentRangeSize(header string, size int64) int64 {
+	slash := strings.LastIndexByte(header, '/')
+	if slash < 0 {
+		return size
+	}
+	total, err := strconv.ParseInt(header[slash+1:], 10, 64)
+	if err != nil {
+		return size
+	}
+	return total
+}
+
+// NewBamUrlReader will open a remote bam file. Only the bgzf blocks that are read are requested from the server,
+// so the header can be read and Query can seek to a region without downloading the whole file.
+func NewBamUrlReader(url string) *BamReader {
+	remote := NewHttpRangeReader(url)
+	bg, err := bgzf.NewReader(remote, 1)
+	simpleio.StdError(err)
+	return &BamReader{Gunzip: bg, source: remote}
+}
@@ -0,0 +1,134 @@
+package bam
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rangeServer serves the testdata directory and records the requests made for bam files.
+type rangeServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []string
+}
+
+func newRangeServer() *rangeServer {
+	s := &rangeServer{}
+	files := http.FileServer(http.Dir("testdata"))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".bam") {
+			s.lock.Lock()
+			s.requests = append(s.requests, r.Header.Get("Range"))
+			s.lock.Unlock()
+		}
+		files.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+func (s *rangeServer) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.requests)
+}
+
+func (s *rangeServer) ranges() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func TestHttpRangeReader(t *testing.T) {
+	server := newRangeServer()
+	defer server.Close()
+	expected, err := os.ReadFile("testdata/tenXbarcodeTest.bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := NewHttpRangeReader(server.URL + "/tenXbarcodeTest.bam")
+	reader.BlockSize, reader.CacheBlocks = 10000, 4
+	if size, err := reader.Size(); err != nil || size != int64(len(expected)) {
+		t.Errorf("Error: remote size is %d, expected %d, %v...\n", size, len(expected), err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(data, expected) {
+		t.Errorf("Error: reading the remote file returned %d bytes, expected %d, %v...\n", len(data), len(expected), err)
+	}
+	for _, r := range server.ranges() {
+		if !strings.HasPrefix(r, "bytes=") {
+			t.Errorf("Error: expected only range requests, found %q...\n", r)
+		}
+	}
+
+	// the last four blocks are cached, so reading the end of the file again should not make new requests
+	requests := server.count()
+	buf := make([]byte, 100)
+	if _, err = reader.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.ReadFull(reader, buf); n != 100 || err != nil || !bytes.Equal(buf, expected[len(expected)-100:]) {
+		t.Errorf("Error: unexpected bytes at the end of the file, %v...\n", err)
+	}
+	if server.count() != requests {
+		t.Errorf("Error: cached blocks were requested again...\n")
+	}
+	if n, err := reader.ReadAt(buf, 9950); n != 100 || err != nil || !bytes.Equal(buf, expected[9950:10050]) {
+		t.Errorf("Error: reading across two blocks returned %d bytes, %v...\n", n, err)
+	}
+	if server.count() != requests+2 {
+		t.Errorf("Error: expected two evicted blocks to be requested, found %d requests...\n", server.count()-requests)
+	}
+	if n, err := reader.ReadAt(buf, int64(len(expected))-10); n != 10 || err != io.EOF {
+		t.Errorf("Error: expected a short read at the end of the file, found %d bytes, %v...\n", n, err)
+	}
+
+	whole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(expected) }))
+	defer whole.Close()
+	if _, err = NewHttpRangeReader(whole.URL + "/tenXbarcodeTest.bam").Read(buf); !errors.Is(err, ErrNoRangeSupport) {
+		t.Errorf("Error: expected %v, found %v...\n", ErrNoRangeSupport, err)
+	}
+}
+
+// TestQueryUrl compares queries of a bam served over http to queries of the local file.
+func TestQueryUrl(t *testing.T) {
+	server := newRangeServer()
+	defer server.Close()
+	url := server.URL + "/tenXbarcodeTest.bam"
+	for _, q := range queryTests {
+		chrom, start, end := ParseRegion(q.region)
+		_, expected := QueryFile("testdata/tenXbarcodeTest.bam", chrom, start, end)
+		_, remote := QueryFile(url, chrom, start, end)
+		var i int
+		for each := range remote {
+			local, ok := <-expected
+			if !ok || ToString(&each) != ToString(&local) {
+				t.Fatalf("Error: remote query %s did not match the local bam...\n", q.region)
+			}
+			i++
+		}
+		if _, ok := <-expected; ok || i == 0 {
+			t.Errorf("Error: remote query %s returned %d records...\n", q.region, i)
+		}
+	}
+
+	reader := NewBamUrlReader(url)
+	defer reader.Close()
+	ReadHeader(reader)
+	bai := IndexReader(url + ".bai")
+	var counts []int
+	for k := 0; k < 2; k++ {
+		for range Query(reader, bai, "tig00000004", 1000, 2000) {
+		}
+		counts = append(counts, server.count())
+	}
+	if counts[1] != counts[0] {
+		t.Errorf("Error: repeating a query made %d new requests, expected the blocks to be cached...\n", counts[1]-counts[0])
+	}
+}
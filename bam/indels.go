@@ -1,7 +1,14 @@
 package bam
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/vcf"
 )
 
 func ConsumesReference(b byte) bool {
@@ -38,3 +45,332 @@ func GetIndel(c []ByteCigar) []ByteCigar {
 	}
 	return ans
 }
+
+// IndelSettings controls which reads are counted and how many reads must carry an indel to report it.
+type IndelSettings struct {
+	MinMapQ    uint8
+	MinSupport int
+}
+
+// DefaultIndelSettings reports indels found in at least two reads with a mapping quality of 20 or more.
+var DefaultIndelSettings IndelSettings = IndelSettings{MinMapQ: 20, MinSupport: 2}
+
+// Indel is an insertion or deletion moved to its left most position in the reference. Ref and Alt are vcf alleles
+// that start with the base before the event at the one-based position Pos. Reference reads span the locus without
+// an indel at Pos and Depth counts reference reads and reads with any indel at Pos.
+type Indel struct {
+	Chrom      string
+	Pos        int
+	Ref        string
+	Alt        string
+	Depth      int
+	RefForward int
+	RefReverse int
+	AltForward int
+	AltReverse int
+}
+
+// IndelCaller counts indels across reads in two passes over the same records. Every record is given to Add, Finish
+// will sort the loci, and Count is called on each record a second time to count the reads supporting the reference.
+// Records can be in any order.
+type IndelCaller struct {
+	settings IndelSettings
+	refs     map[string][]code.Dna
+	chroms   map[string]int
+	alleles  map[indelKey]*Indel
+	loci     map[string][]*indelLocus
+	index    map[indelLocus]*indelLocus
+}
+
+// indelKey is a normalized indel allele.
+type indelKey struct {
+	chrom string
+	pos   int
+	ref   string
+	alt   string
+}
+
+// indelLocus holds the reference read counts at one position. End is the first base after the longest reference
+// allele, which a read must cover to be counted for the reference.
+type indelLocus struct {
+	chrom      string
+	pos        int
+	end        int
+	refForward int
+	refReverse int
+}
+
+// NewIndelCaller will allocate memory to count indels of records described by header against the reference
+// sequences in refs. Reads on references missing from refs are skipped.
+func NewIndelCaller(header *Header, refs map[string][]code.Dna, settings IndelSettings) *IndelCaller {
+	ans := &IndelCaller{
+		settings: settings,
+		refs:     refs,
+		chroms:   make(map[string]int),
+		alleles:  make(map[indelKey]*Indel),
+		loci:     make(map[string][]*indelLocus),
+		index:    make(map[indelLocus]*indelLocus),
+	}
+	for i, c := range header.Chroms {
+		ans.chroms[c.Name] = i
+	}
+	return ans
+}
+
+// CallIndelsFile will read a sam or bam file twice and return the indels with enough support. Only records that pass
+// the filter are counted, and a nil filter keeps every record.
+func CallIndelsFile(filename string, refs map[string][]code.Dna, settings IndelSettings, f *Filter) (*Header, []Indel) {
+	read := func() (*Header, <-chan Sam) {
+		if f == nil {
+			return Read(filename)
+		}
+		return FilterFile(filename, f)
+	}
+	header, records := read()
+	caller := NewIndelCaller(header, refs, settings)
+	for i := range records {
+		caller.Add(&i)
+	}
+	caller.Finish()
+	_, records = read()
+	for i := range records {
+		caller.Count(&i)
+	}
+	return header, caller.Indels()
+}
+
+// keep returns true for primary alignments with a sequence and a reference.
+func (c *IndelCaller) keep(s *Sam) bool {
+	return !s.IsUnmapped() && !s.IsSecondary() && !s.IsSupplementary() && !s.IsDuplicate() && !s.IsQcFail() &&
+		s.MapQ >= c.settings.MinMapQ && len(s.Seq) > 0 && c.refs[s.RName] != nil
+}
+
+// Add will record the indels of a single alignment.
+func (c *IndelCaller) Add(s *Sam) {
+	if !c.keep(s) {
+		return
+	}
+	for _, key := range readIndels(s, c.refs[s.RName]) {
+		allele, ok := c.alleles[key]
+		if !ok {
+			allele = &Indel{Chrom: key.chrom, Pos: key.pos, Ref: key.ref, Alt: key.alt}
+			c.alleles[key] = allele
+		}
+		if s.IsReverse() {
+			allele.AltReverse++
+		} else {
+			allele.AltForward++
+		}
+		locus, ok := c.index[indelLocus{chrom: key.chrom, pos: key.pos}]
+		if !ok {
+			locus = &indelLocus{chrom: key.chrom, pos: key.pos}
+			c.index[indelLocus{chrom: key.chrom, pos: key.pos}] = locus
+			c.loci[key.chrom] = append(c.loci[key.chrom], locus)
+		}
+		if end := key.pos + len(key.ref); end > locus.end {
+			locus.end = end
+		}
+	}
+}
+
+// Finish will sort the loci of each reference so Count can find the loci covered by a read.
+func (c *IndelCaller) Finish() {
+	for _, loci := range c.loci {
+		sort.Slice(loci, func(i, j int) bool { return loci[i].pos < loci[j].pos })
+	}
+}
+
+// Count will add a read to the reference counts of every locus it spans without an indel at that position.
+func (c *IndelCaller) Count(s *Sam) {
+	loci := c.loci[s.RName]
+	if len(loci) == 0 || !c.keep(s) {
+		return
+	}
+	end := s.RefEnd()
+	var own map[int]bool
+	for i := sort.Search(len(loci), func(i int) bool { return loci[i].pos >= s.Pos }); i < len(loci) && loci[i].pos <= end; i++ {
+		if loci[i].end > end {
+			continue
+		}
+		if own == nil {
+			own = make(map[int]bool)
+			for _, key := range readIndels(s, c.refs[s.RName]) {
+				own[key.pos] = true
+			}
+		}
+		if own[loci[i].pos] {
+			continue
+		}
+		if s.IsReverse() {
+			loci[i].refReverse++
+		} else {
+			loci[i].refForward++
+		}
+	}
+}
+
+// Indels returns the indels supported by at least MinSupport reads, sorted by reference and position.
+func (c *IndelCaller) Indels() []Indel {
+	altDepth := make(map[indelLocus]int)
+	for key, allele := range c.alleles {
+		altDepth[indelLocus{chrom: key.chrom, pos: key.pos}] += allele.AltForward + allele.AltReverse
+	}
+	var ans []Indel
+	for key, allele := range c.alleles {
+		if allele.AltForward+allele.AltReverse < c.settings.MinSupport {
+			continue
+		}
+		locus := c.index[indelLocus{chrom: key.chrom, pos: key.pos}]
+		curr := *allele
+		curr.RefForward, curr.RefReverse = locus.refForward, locus.refReverse
+		curr.Depth = locus.refForward + locus.refReverse + altDepth[indelLocus{chrom: key.chrom, pos: key.pos}]
+		ans = append(ans, curr)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		a, b := ans[i], ans[j]
+		switch {
+		case a.Chrom != b.Chrom:
+			x, foundX := c.chroms[a.Chrom]
+			y, foundY := c.chroms[b.Chrom]
+			if foundX && foundY {
+				return x < y
+			}
+			return foundX || !foundY && a.Chrom < b.Chrom
+		case a.Pos != b.Pos:
+			return a.Pos < b.Pos
+		case a.Ref != b.Ref:
+			return a.Ref < b.Ref
+		default:
+			return a.Alt < b.Alt
+		}
+	})
+	return ans
+}
+
+// readIndels returns the left aligned insertions and deletions of a read. Indels at the start of the alignment or
+// extending past the end of ref are skipped.
+func readIndels(s *Sam, ref []code.Dna) []indelKey {
+	var ans []indelKey
+	refPos, queryPos := s.Pos, 0
+	for _, c := range s.Cigar {
+		n := int(c.RunLen)
+		switch {
+		case c.Op == Deletion && refPos > s.Pos && refPos-1+n <= len(ref):
+			pos, refAllele, alt := LeftAlignIndel(ref, refPos-1, code.ToUpperString(ref[refPos-2:refPos-1+n]), code.ToUpperString(ref[refPos-2:refPos-1]))
+			ans = append(ans, indelKey{chrom: s.RName, pos: pos, ref: refAllele, alt: alt})
+		case c.Op == Insertion && refPos > s.Pos && refPos-1 <= len(ref) && queryPos+n <= len(s.Seq):
+			anchor := code.ToUpperString(ref[refPos-2 : refPos-1])
+			pos, refAllele, alt := LeftAlignIndel(ref, refPos-1, anchor, anchor+code.ToUpperString(s.Seq[queryPos:queryPos+n]))
+			ans = append(ans, indelKey{chrom: s.RName, pos: pos, ref: refAllele, alt: alt})
+		}
+		if ConsumesReference(c.Op) {
+			refPos += n
+		}
+		if ConsumesQuery(c.Op) {
+			queryPos += n
+		}
+	}
+	return ans
+}
+
+// LeftAlignIndel will shift an indel to the left most position with the same alternate sequence and trim the
+// alleles to a single shared base. Pos is the one-based position of the first base of both alleles in ref.
+func LeftAlignIndel(ref []code.Dna, pos int, refAllele string, alt string) (int, string, string) {
+	pos, alleles := vcf.LeftAlign(ref, pos, []string{refAllele, alt})
+	return pos, alleles[0], alleles[1]
+}
+
+// IndelsToVcf will convert indels sorted by Indels to vcf records, with one multi-allelic record for the alleles that
+// share a position. REF is the longest reference allele and the other alleles are extended to match it. INFO has the
+// read depth and the allele frequency of each alternate allele, and the sample has the genotype, depth, read counts
+// of each allele and the strand counts of the reference and of all alternate alleles.
+func IndelsToVcf(indels []Indel) []vcf.Vcf {
+	var ans []vcf.Vcf
+	for start, end := 0, 0; start < len(indels); start = end {
+		for end = start + 1; end < len(indels) && indels[end].Chrom == indels[start].Chrom && indels[end].Pos == indels[start].Pos; end++ {
+		}
+		ans = append(ans, indelLocusToVcf(indels[start:end]))
+	}
+	return ans
+}
+
+// indelLocusToVcf will convert the alleles found at one position to a single vcf record.
+func indelLocusToVcf(alleles []Indel) vcf.Vcf {
+	first := alleles[0]
+	ref := first.Ref
+	for _, a := range alleles[1:] {
+		if len(a.Ref) > len(ref) {
+			ref = a.Ref
+		}
+	}
+	alts := make([]string, len(alleles))
+	af := make([]string, len(alleles))
+	ad := []int{first.RefForward + first.RefReverse}
+	var altForward, altReverse int
+	for i, a := range alleles {
+		alts[i] = a.Alt + ref[len(a.Ref):]
+		ad = append(ad, a.AltForward+a.AltReverse)
+		af[i] = "0.000"
+		if a.Depth > 0 {
+			af[i] = fmt.Sprintf("%.3f", float64(a.AltForward+a.AltReverse)/float64(a.Depth))
+		}
+		altForward, altReverse = altForward+a.AltForward, altReverse+a.AltReverse
+	}
+	depths := make([]string, len(ad))
+	for i := range ad {
+		depths[i] = strconv.Itoa(ad[i])
+	}
+	return vcf.Vcf{Chr: first.Chrom, Pos: first.Pos, Id: ".", Ref: ref, Alt: strings.Join(alts, ","), NoQual: true, Filter: "PASS",
+		Info:   fmt.Sprintf("DP=%d;AF=%s", first.Depth, strings.Join(af, ",")),
+		Format: []string{"GT", "DP", "AD", "SB"},
+		Genotypes: []string{fmt.Sprintf("%s:%d:%s:%d,%d,%d,%d", indelGenotype(first.Depth, ad), first.Depth, strings.Join(depths, ","),
+			first.RefForward, first.RefReverse, altForward, altReverse)},
+	}
+}
+
+// indelGenotype returns a homozygous genotype when at least 80% of the reads at the locus carry the same alternate
+// allele, and otherwise a heterozygous genotype of the alternate allele with the most reads and the next most common
+// allele. Ad has the read counts of the reference followed by each alternate allele.
+func indelGenotype(depth int, ad []int) string {
+	best := 1
+	for i := 2; i < len(ad); i++ {
+		if ad[i] > ad[best] {
+			best = i
+		}
+	}
+	if depth > 0 && float64(ad[best]) >= 0.8*float64(depth) {
+		return fmt.Sprintf("%d/%d", best, best)
+	}
+	other := 0
+	for i := range ad {
+		if i != best && ad[i] > ad[other] {
+			other = i
+		}
+	}
+	if other > best {
+		return fmt.Sprintf("%d/%d", best, other)
+	}
+	return fmt.Sprintf("%d/%d", other, best)
+}
+
+// IndelVcfHeader returns the vcf header lines describing the fields written by IndelsToVcf, with one sample column.
+func IndelVcfHeader(header *Header, sample string) string {
+	var ans strings.Builder
+	writeVcfContigs(&ans, header)
+	ans.WriteString("##INFO=<ID=DP,Number=1,Type=Integer,Description=\"Number of reads spanning the locus\">\n" +
+		"##INFO=<ID=AF,Number=A,Type=Float,Description=\"Fraction of reads with each alternate allele\">\n" +
+		"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n" +
+		"##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Number of reads spanning the locus\">\n" +
+		"##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Number of reads with each allele\">\n" +
+		"##FORMAT=<ID=SB,Number=4,Type=Integer,Description=\"Forward and reverse reads with the reference allele followed by forward and reverse reads with any alternate allele\">\n")
+	fmt.Fprintf(&ans, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s\n", sample)
+	return ans.String()
+}
+
+// writeVcfContigs will write the file format line and a contig line for each reference in a sam header.
+func writeVcfContigs(ans *strings.Builder, header *Header) {
+	ans.WriteString("##fileformat=VCFv4.2\n##source=goFish\n")
+	for _, c := range header.Chroms {
+		fmt.Fprintf(ans, "##contig=<ID=%s,length=%d>\n", c.Name, c.Size)
+	}
+}
@@ -0,0 +1,100 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/vcf"
+)
+
+// indelTestRef has a homopolymer at 11-16 and a CA repeat at 27-32.
+const indelTestRef string = "ACGTCGATGCAAAAAAGCTTGACCTGCACACATTGCATGCGTACGTAGCA"
+
+func TestLeftAlignIndel(t *testing.T) {
+	ref := code.ToDna([]byte(indelTestRef))
+	var tests = []struct {
+		pos      int
+		ref, alt string
+		ansPos   int
+		ansRef   string
+		ansAlt   string
+	}{
+		{15, "AA", "A", 10, "CA", "C"},
+		{32, "A", "ACA", 26, "G", "GCA"},
+		{28, "A", "ACA", 26, "G", "GCA"},
+		{30, "aca", "a", 26, "GCA", "G"},
+		{3, "GT", "G", 3, "GT", "G"},
+		{1, "AC", "A", 1, "AC", "A"},
+		{1, "AC", "AGC", 1, "A", "AG"},
+	}
+	for _, test := range tests {
+		if pos, a, b := LeftAlignIndel(ref, test.pos, test.ref, test.alt); pos != test.ansPos || a != test.ansRef || b != test.ansAlt {
+			t.Errorf("Error: %d %s>%s was aligned to %d %s>%s, expected %d %s>%s...\n", test.pos, test.ref, test.alt, pos, a, b, test.ansPos, test.ansRef, test.ansAlt)
+		}
+	}
+	if pos, a, b := LeftAlignIndel(code.ToDna([]byte("AAAC")), 2, "AA", "A"); pos != 1 || a != "AA" || b != "A" {
+		t.Errorf("Error: a deletion at the start of a reference was aligned to %d %s>%s...\n", pos, a, b)
+	}
+}
+
+func TestCallIndels(t *testing.T) {
+	reads := []*Sam{
+		ParseSam("delA	0	chr1	1	60	15M1D20M	*	0	0	ACGTCGATGCAAAAAGCTTGACCTGCACACATTGC	*"),
+		ParseSam("delB	16	chr1	1	60	12M1D23M	*	0	0	ACGTCGATGCAAAAAGCTTGACCTGCACACATTGC	*"),
+		ParseSam("insC	0	chr1	20	60	13M2I10M	*	0	0	TGACCTGCACACACATTGCATGCGT	*"),
+		ParseSam("insD	16	chr1	21	60	8M2I12M	*	0	0	GACCTGCACACACATTGCATGC	*"),
+		ParseSam("refE	0	chr1	1	60	35M	*	0	0	ACGTCGATGCAAAAAAGCTTGACCTGCACACATTG	*"),
+		ParseSam("refF	16	chr1	5	60	40M	*	0	0	CGATGCAAAAAAGCTTGACCTGCACACATTGCATGCGTAC	*"),
+		// a single read is not enough support and low quality reads are not counted
+		ParseSam("single	0	chr1	30	60	9M2D9M	*	0	0	ACATTGCATGTACGTAGC	*"),
+		ParseSam("lowq\t0\tchr1\t1\t3\t12M1D23M\t*\t0\t0\tACGTCGATGCAAAAAGCTTGACCTGCACACATTGC\t*"),
+	}
+	header := &Header{Chroms: []ChromSize{{Name: "chr1", Size: len(indelTestRef)}}}
+	caller := NewIndelCaller(header, map[string][]code.Dna{"chr1": code.ToDna([]byte(indelTestRef))}, DefaultIndelSettings)
+	for _, s := range reads {
+		caller.Add(s)
+	}
+	caller.Finish()
+	for _, s := range reads {
+		caller.Count(s)
+	}
+	expected := []Indel{
+		{Chrom: "chr1", Pos: 10, Ref: "CA", Alt: "C", Depth: 4, RefForward: 1, RefReverse: 1, AltForward: 1, AltReverse: 1},
+		{Chrom: "chr1", Pos: 26, Ref: "G", Alt: "GCA", Depth: 6, RefForward: 2, RefReverse: 2, AltForward: 1, AltReverse: 1},
+	}
+	indels := caller.Indels()
+	if len(indels) != len(expected) {
+		t.Fatalf("Error: found %d indels, expected %d: %v\n", len(indels), len(expected), indels)
+	}
+	for i := range indels {
+		if indels[i] != expected[i] {
+			t.Errorf("Error: indel %d is\n%+v\nexpected\n%+v\n", i, indels[i], expected[i])
+		}
+	}
+	records := IndelsToVcf(indels)
+	if ans := vcf.ToString(&records[1]); len(records) != 2 || ans != "chr1\t26\t.\tG\tGCA\t.\tPASS\tDP=6;AF=0.333\tGT:DP:AD:SB\t0/1:6:4,2:2,2,1,1" {
+		t.Errorf("Error: unexpected vcf record %s\n", ans)
+	}
+}
+
+func TestIndelsToVcf(t *testing.T) {
+	// an insertion and a deletion at the same position are written as one record with a shared REF
+	indels := []Indel{
+		{Chrom: "chr1", Pos: 10, Ref: "C", Alt: "CA", Depth: 6, RefForward: 1, AltForward: 1, AltReverse: 1},
+		{Chrom: "chr1", Pos: 10, Ref: "CA", Alt: "C", Depth: 6, RefForward: 1, AltForward: 2, AltReverse: 1},
+		{Chrom: "chr1", Pos: 26, Ref: "G", Alt: "GCA", Depth: 5, RefForward: 1, AltForward: 2, AltReverse: 2},
+	}
+	expected := []string{
+		"chr1\t10\t.\tCA\tCAA,C\t.\tPASS\tDP=6;AF=0.333,0.500\tGT:DP:AD:SB\t1/2:6:1,2,3:1,0,3,2",
+		"chr1\t26\t.\tG\tGCA\t.\tPASS\tDP=5;AF=0.800\tGT:DP:AD:SB\t1/1:5:1,4:1,0,2,2",
+	}
+	records := IndelsToVcf(indels)
+	if len(records) != len(expected) {
+		t.Fatalf("Error: found %d vcf records, expected %d\n", len(records), len(expected))
+	}
+	for i := range records {
+		if ans := vcf.ToString(&records[i]); ans != expected[i] {
+			t.Errorf("Error: unexpected vcf record\n%s\nexpected\n%s\n", ans, expected[i])
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package bam
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/simpleio"
+)
+
+// DuplicateSettings controls how duplicates are reported. Duplicates are flagged with 0x400 unless Remove is set,
+// and pairs with duplicates closer than OpticalDistance pixels on the same flow cell tile are counted as optical
+// duplicates. An OpticalDistance of zero will skip optical duplicate detection.
+type DuplicateSettings struct {
+	Remove          bool
+	OpticalDistance int
+}
+
+// DefaultOpticalDistance is the pixel distance used by Picard for unpatterned flow cells.
+const DefaultOpticalDistance int = 100
+
+// minDuplicateBaseQual is the smallest base quality that counts toward the score used to pick the best read of a duplicate set.
+const minDuplicateBaseQual int = 15
+
+// unknownLibrary is used for reads without a read group or a read group without a library.
+const unknownLibrary string = "Unknown Library"
+
+// DuplicateMetrics summarizes duplicate marking for a single library, using the same categories as Picard MarkDuplicates.
+// Read pairs are counted once per pair and unpaired reads include reads with an unmapped mate.
+type DuplicateMetrics struct {
+	Library                   string
+	UnpairedReads             int
+	ReadPairs                 int
+	SecondaryOrSupplementary  int
+	UnmappedReads             int
+	UnpairedDuplicates        int
+	ReadPairDuplicates        int
+	ReadPairOpticalDuplicates int
+	PercentDuplication        float64
+	EstimatedLibrarySize      int
+}
+
+// DuplicateMarker finds duplicates in two passes over the same records. Every record is given to Add, Finish will
+// then resolve the duplicate sets, and Mark is called on each record a second time to set the duplicate flag.
+// Records can be in any order, only the fragment ends of each primary alignment are kept in memory.
+type DuplicateMarker struct {
+	settings   DuplicateSettings
+	metrics    []DuplicateMetrics
+	libraries  map[string]int
+	readGroups map[string]string
+	chroms     *chromIndex
+	pending    map[string]*pendingEnd
+	pairs      map[pairKey][]*duplicateRead
+	fragments  map[endKey][]*duplicateRead
+	pairEnds   map[endKey]bool
+	duplicates map[string]bool
+	order      int
+}
+
+// endKey is the unclipped five prime position and strand of a read within a library.
+type endKey struct {
+	library int
+	chrom   int
+	pos     int
+	reverse bool
+}
+
+// pairKey contains both ends of a read pair, with the left most end first.
+type pairKey struct {
+	first  endKey
+	second endKey
+}
+
+// pendingEnd is the first end of a pair seen while waiting for its mate.
+type pendingEnd struct {
+	key   endKey
+	read  int
+	score int
+	order int
+}
+
+// duplicateRead is a read or read pair that is part of a set of reads with the same ends.
+type duplicateRead struct {
+	name  string
+	read  int
+	score int
+	order int
+}
+
+// NewDuplicateMarker will allocate memory to mark duplicates of records described by header. Reads are assigned
+// to libraries using the LB field of their read group.
+func NewDuplicateMarker(header *Header, settings DuplicateSettings) *DuplicateMarker {
+	ans := &DuplicateMarker{
+		settings:   settings,
+		libraries:  make(map[string]int),
+		readGroups: make(map[string]string),
+		chroms:     newChromIndex(header),
+		pending:    make(map[string]*pendingEnd),
+		pairs:      make(map[pairKey][]*duplicateRead),
+		fragments:  make(map[endKey][]*duplicateRead),
+		pairEnds:   make(map[endKey]bool),
+		duplicates: make(map[string]bool),
+	}
+	for _, rg := range NewSamHeader(header).ReadGroups {
+		id, _ := rg.Get("ID")
+		if lb, ok := rg.Get("LB"); ok {
+			ans.readGroups[id] = lb
+		}
+	}
+	return ans
+}
+
+// MarkDuplicatesFile will read a sam or bam file twice, once to find duplicates and a second time to return the
+// marked records. Metrics are complete when the function returns.
+func MarkDuplicatesFile(filename string, settings DuplicateSettings) (*Header, <-chan Sam, []DuplicateMetrics) {
+	header, records := Read(filename)
+	marker := NewDuplicateMarker(header, settings)
+	for i := range records {
+		marker.Add(&i)
+	}
+	marker.Finish()
+	_, records = Read(filename)
+	ans := make(chan Sam, 1000)
+	go func() {
+		for i := range records {
+			if marker.Mark(&i) {
+				ans <- i
+			}
+		}
+		close(ans)
+	}()
+	return header, ans, marker.Metrics()
+}
+
+// MarkDuplicates will mark duplicates of records held in memory, returning the records that should be kept.
+func MarkDuplicates(header *Header, records []*Sam, settings DuplicateSettings) ([]*Sam, []DuplicateMetrics) {
+	marker := NewDuplicateMarker(header, settings)
+	for _, s := range records {
+		marker.Add(s)
+	}
+	marker.Finish()
+	ans := records[:0]
+	for _, s := range records {
+		if marker.Mark(s) {
+			ans = append(ans, s)
+		}
+	}
+	return ans, marker.Metrics()
+}
+
+// Add will record the fragment ends of a primary alignment. Secondary, supplementary and unmapped records are only counted.
+func (d *DuplicateMarker) Add(s *Sam) {
+	lib := d.library(s)
+	m := &d.metrics[lib]
+	switch {
+	case s.IsSecondary() || s.IsSupplementary():
+		m.SecondaryOrSupplementary++
+		return
+	case s.IsUnmapped():
+		m.UnmappedReads++
+		return
+	}
+	d.order++
+	key := endKey{library: lib, chrom: d.chroms.id(s.RName), pos: unclippedFivePrime(s), reverse: s.IsReverse()}
+	score := duplicateScore(s)
+	if !s.IsPaired() || s.IsMateUnmapped() {
+		m.UnpairedReads++
+		d.fragments[key] = append(d.fragments[key], &duplicateRead{name: s.QName, read: readNumber(s), score: score, order: d.order})
+		return
+	}
+	mate, ok := d.pending[s.QName]
+	if !ok {
+		d.pending[s.QName] = &pendingEnd{key: key, read: readNumber(s), score: score, order: d.order}
+		return
+	}
+	delete(d.pending, s.QName)
+	pair := pairKey{first: mate.key, second: key}
+	if endLess(key, mate.key) {
+		pair.first, pair.second = key, mate.key
+	}
+	m.ReadPairs++
+	d.pairs[pair] = append(d.pairs[pair], &duplicateRead{name: s.QName, score: score + mate.score, order: mate.order})
+	d.pairEnds[pair.first], d.pairEnds[pair.second] = true, true
+}
+
+// Finish will pick the read or pair with the highest sum of base qualities from each duplicate set and calculate
+// the metrics. Fragments that share an end with a read pair are always duplicates. Pairs missing a mate are
+// treated as fragments.
+func (d *DuplicateMarker) Finish() {
+	for name, end := range d.pending {
+		d.metrics[end.key.library].UnpairedReads++
+		d.fragments[end.key] = append(d.fragments[end.key], &duplicateRead{name: name, read: end.read, score: end.score, order: end.order})
+	}
+	d.pending = make(map[string]*pendingEnd)
+	var best *duplicateRead
+	for key, group := range d.pairs {
+		best = bestDuplicateRead(group)
+		for _, r := range group {
+			if r != best {
+				d.duplicates[r.name] = true
+			}
+		}
+		m := &d.metrics[key.first.library]
+		m.ReadPairDuplicates += len(group) - 1
+		if d.settings.OpticalDistance > 0 && len(group) > 1 {
+			m.ReadPairOpticalDuplicates += opticalDuplicates(group, d.settings.OpticalDistance)
+		}
+	}
+	for key, group := range d.fragments {
+		best = nil
+		if !d.pairEnds[key] {
+			best = bestDuplicateRead(group)
+		}
+		for _, r := range group {
+			if r != best {
+				d.duplicates[fragmentName(r.name, r.read)] = true
+				d.metrics[key.library].UnpairedDuplicates++
+			}
+		}
+	}
+	for i := range d.metrics {
+		m := &d.metrics[i]
+		if reads := m.UnpairedReads + 2*m.ReadPairs; reads > 0 {
+			m.PercentDuplication = float64(m.UnpairedDuplicates+2*m.ReadPairDuplicates) / float64(reads)
+		}
+		if m.ReadPairs > 0 {
+			m.EstimatedLibrarySize = estimateLibrarySize(m.ReadPairs-m.ReadPairOpticalDuplicates, m.ReadPairs-m.ReadPairDuplicates)
+		} else {
+			m.EstimatedLibrarySize = estimateLibrarySize(m.UnpairedReads, m.UnpairedReads-m.UnpairedDuplicates)
+		}
+	}
+}
+
+// Mark will set or clear the duplicate flag of a record after Finish has been called. Secondary and supplementary
+// alignments share the flag of their primary alignment. The return value is false if the record should be removed.
+func (d *DuplicateMarker) Mark(s *Sam) bool {
+	s.Flag &^= FlagDuplicate
+	if !s.IsUnmapped() && (d.duplicates[s.QName] || d.duplicates[fragmentName(s.QName, readNumber(s))]) {
+		if d.settings.Remove {
+			return false
+		}
+		s.Flag |= FlagDuplicate
+	}
+	return true
+}
+
+// Metrics returns the duplicate metrics of each library in the order they were found.
+func (d *DuplicateMarker) Metrics() []DuplicateMetrics {
+	return d.metrics
+}
+
+// library returns the index of the library of a read, adding it to the metrics the first time it is seen.
+func (d *DuplicateMarker) library(s *Sam) int {
+	name := unknownLibrary
+	if rg, ok := s.Tag("RG"); ok {
+		if id, isString := rg.Value().(string); isString && d.readGroups[id] != "" {
+			name = d.readGroups[id]
+		}
+	}
+	i, ok := d.libraries[name]
+	if !ok {
+		i = len(d.metrics)
+		d.libraries[name] = i
+		d.metrics = append(d.metrics, DuplicateMetrics{Library: name})
+	}
+	return i
+}
+
+// chromIndex numbers the reference names of a header, names missing from the header are added after the header
+// references.
+type chromIndex struct {
+	ids   map[string]int
+	names []string
+}
+
+// newChromIndex will number the references of header in the order they are listed.
+func newChromIndex(header *Header) *chromIndex {
+	ans := &chromIndex{ids: make(map[string]int)}
+	for _, c := range header.Chroms {
+		ans.id(c.Name)
+	}
+	return ans
+}
+
+// id returns the index of a reference name.
+func (c *chromIndex) id(name string) int {
+	i, ok := c.ids[name]
+	if !ok {
+		i = len(c.names)
+		c.ids[name] = i
+		c.names = append(c.names, name)
+	}
+	return i
+}
+
+// unclippedFivePrime returns the one-based reference position of the five prime end of a read, including clipped bases.
+func unclippedFivePrime(s *Sam) int {
+	if s.IsReverse() {
+		return s.UnclippedEnd()
+	}
+	return s.UnclippedStart()
+}
+
+// duplicateScore is the sum of base qualities of at least 15, the same score Picard uses to pick the best duplicate.
+func duplicateScore(s *Sam) int {
+	var ans int
+	for _, q := range s.Qual {
+		if int(q)-33 >= minDuplicateBaseQual {
+			ans += int(q) - 33
+		}
+	}
+	return ans
+}
+
+// readNumber returns 1 or 2 for the first and second read of a pair and 0 for single end reads.
+func readNumber(s *Sam) int {
+	switch {
+	case s.IsRead1():
+		return 1
+	case s.IsRead2():
+		return 2
+	default:
+		return 0
+	}
+}
+
+// fragmentName is the key of a single read of a pair in the set of duplicates, pairs are stored by read name.
+func fragmentName(name string, read int) string {
+	return name + "\t" + strconv.Itoa(read)
+}
+
+func endLess(a endKey, b endKey) bool {
+	if a.chrom != b.chrom {
+		return a.chrom < b.chrom
+	}
+	if a.pos != b.pos {
+		return a.pos < b.pos
+	}
+	return !a.reverse && b.reverse
+}
+
+// bestDuplicateRead returns the read with the highest score, ties are broken by the first read in the file.
+func bestDuplicateRead(group []*duplicateRead) *duplicateRead {
+	best := group[0]
+	for _, r := range group[1:] {
+		if r.score > best.score || r.score == best.score && r.order < best.order {
+			best = r
+		}
+	}
+	return best
+}
+
+// opticalDuplicates counts the reads in a duplicate set that are within distance pixels of an earlier read on the
+// same tile. Tile and coordinates are parsed from the last three fields of Illumina read names; reads with other
+// names are never optical duplicates.
+func opticalDuplicates(group []*duplicateRead, distance int) int {
+	type location struct {
+		tile  string
+		x, y  int
+		order int
+	}
+	var locations []location
+	for _, r := range group {
+		fields := strings.Split(r.name, ":")
+		if len(fields) < 5 {
+			continue
+		}
+		x, errX := strconv.Atoi(fields[len(fields)-2])
+		y, errY := strconv.Atoi(fields[len(fields)-1])
+		if errX != nil || errY != nil {
+			continue
+		}
+		locations = append(locations, location{tile: strings.Join(fields[:len(fields)-2], ":"), x: x, y: y, order: r.order})
+	}
+	var ans int
+	for i := range locations {
+		for j := range locations {
+			if locations[j].order < locations[i].order && locations[j].tile == locations[i].tile &&
+				absInt(locations[i].x-locations[j].x) <= distance && absInt(locations[i].y-locations[j].y) <= distance {
+				ans++
+				break
+			}
+		}
+	}
+	return ans
+}
+
+// estimateLibrarySize uses the Lander-Waterman equation to estimate the number of unique molecules in a library
+// from the number of reads and unique reads, solving unique/x = 1 - exp(-reads/x) with a bisection search.
+func estimateLibrarySize(reads int, unique int) int {
+	if reads <= 0 || unique <= 0 || unique >= reads {
+		return 0
+	}
+	c, n := float64(unique), float64(reads)
+	f := func(x float64) float64 {
+		return c/x - 1 + math.Exp(-n/x)
+	}
+	var low, high float64 = 1, 100
+	for f(high*c) > 0 {
+		high *= 10
+	}
+	for i := 0; i < 40; i++ {
+		mid := (low + high) / 2
+		u := f(mid * c)
+		if u == 0 {
+			low, high = mid, mid
+			break
+		} else if u > 0 {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return int(c * (low + high) / 2)
+}
+
+// WriteDuplicateMetrics will write one tab delimited line per library, using the column names of Picard MarkDuplicates.
+func WriteDuplicateMetrics(writer io.Writer, metrics []DuplicateMetrics) {
+	_, err := fmt.Fprintln(writer, "LIBRARY\tUNPAIRED_READS_EXAMINED\tREAD_PAIRS_EXAMINED\tSECONDARY_OR_SUPPLEMENTARY_RDS\tUNMAPPED_READS\t"+
+		"UNPAIRED_READ_DUPLICATES\tREAD_PAIR_DUPLICATES\tREAD_PAIR_OPTICAL_DUPLICATES\tPERCENT_DUPLICATION\tESTIMATED_LIBRARY_SIZE")
+	simpleio.StdError(err)
+	for _, m := range metrics {
+		_, err = fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.6f\t%d\n", m.Library, m.UnpairedReads, m.ReadPairs,
+			m.SecondaryOrSupplementary, m.UnmappedReads, m.UnpairedDuplicates, m.ReadPairDuplicates, m.ReadPairOpticalDuplicates,
+			m.PercentDuplication, m.EstimatedLibrarySize)
+		simpleio.StdError(err)
+	}
+}
@@ -0,0 +1,67 @@
+package bam
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkDuplicates(t *testing.T) {
+	header := &Header{Chroms: []ChromSize{{Name: "chr1", Size: 1000}}}
+	header.Text.WriteString("@HD\tVN:1.6\tSO:unsorted\n@SQ\tSN:chr1\tLN:1000\n@RG\tID:rg1\tLB:lib1\n")
+	records := []*Sam{
+		// pair a and b share both unclipped ends, b has higher base qualities
+		ParseSam("M:1:FC:1:1101:1000:1000\t97\tchr1\t100\t60\t10M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+		ParseSam("M:1:FC:1:1101:1000:1000\t145\tchr1\t200\t60\t10M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+		ParseSam("M:1:FC:1:1101:1050:1020\t97\tchr1\t102\t60\t2S8M\t=\t0\t0\tACGTACGTAC\tKKKKKKKKKK\tRG:Z:rg1"),
+		ParseSam("M:1:FC:1:1101:1050:1020\t145\tchr1\t200\t60\t8M2S\t=\t0\t0\tACGTACGTAC\tKKKKKKKKKK\tRG:Z:rg1"),
+		ParseSam("M:1:FC:1:1101:1050:1020\t2145\tchr1\t500\t60\t10M\t=\t0\t0\tACGTACGTAC\tKKKKKKKKKK\tRG:Z:rg1"),
+		// pair c has a different mate position
+		ParseSam("M:1:FC:1:1101:9000:9000\t97\tchr1\t100\t60\t10M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+		ParseSam("M:1:FC:1:1101:9000:9000\t145\tchr1\t300\t60\t10M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+		// fragment d shares an end with a pair, e and f are single end duplicates on the reverse strand
+		ParseSam("d\t73\tchr1\t100\t60\t10M\t=\t0\t0\tACGTACGTAC\tKKKKKKKKKK\tRG:Z:rg1"),
+		ParseSam("d\t133\tchr1\t100\t60\t*\t=\t0\t0\tACGTACGTAC\tKKKKKKKKKK\tRG:Z:rg1"),
+		ParseSam("e\t16\tchr1\t600\t60\t10M\t=\t0\t0\tACGTACGTAC\t5555555555\tRG:Z:rg1"),
+		ParseSam("f\t1040\tchr1\t601\t60\t9M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+		ParseSam("g\t0\tchr1\t600\t60\t10M\t=\t0\t0\tACGTACGTAC\tIIIIIIIIII\tRG:Z:rg1"),
+	}
+	kept, metrics := MarkDuplicates(header, records, DuplicateSettings{OpticalDistance: DefaultOpticalDistance})
+	expected := []bool{true, true, false, false, false, false, false, true, false, true, false, false}
+	if len(kept) != len(records) {
+		t.Fatalf("Error: expected all %d records to be kept, found %d...\n", len(records), len(kept))
+	}
+	for i, s := range kept {
+		if s.IsDuplicate() != expected[i] {
+			t.Errorf("Error: record %d %s duplicate flag is %v, expected %v...\n", i, s.QName, s.IsDuplicate(), expected[i])
+		}
+	}
+	m := metrics[0]
+	if len(metrics) != 1 || m.Library != "lib1" || m.ReadPairs != 3 || m.UnpairedReads != 4 || m.SecondaryOrSupplementary != 1 ||
+		m.UnmappedReads != 1 || m.ReadPairDuplicates != 1 || m.UnpairedDuplicates != 2 || m.ReadPairOpticalDuplicates != 1 {
+		t.Errorf("Error: unexpected metrics %+v...\n", metrics)
+	}
+	if m.PercentDuplication != 0.4 {
+		t.Errorf("Error: expected 40%% duplication, found %v...\n", m.PercentDuplication)
+	}
+
+	kept, _ = MarkDuplicates(header, records, DuplicateSettings{Remove: true})
+	if len(kept) != 8 {
+		t.Errorf("Error: expected 8 records after removing duplicates, found %d...\n", len(kept))
+	}
+	var out bytes.Buffer
+	WriteDuplicateMetrics(&out, metrics)
+	if lines := strings.Split(out.String(), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "lib1\t4\t3\t1\t1\t2\t1\t1\t0.400000\t") {
+		t.Errorf("Error: unexpected metrics output %s...\n", out.String())
+	}
+}
+
+func TestEstimateLibrarySize(t *testing.T) {
+	if size := estimateLibrarySize(1000, 1000); size != 0 {
+		t.Errorf("Error: library size without duplicates should not be estimated, found %d...\n", size)
+	}
+	// with a library of 1000 molecules, sampling 1000 reads is expected to find 1000 * (1 - e^-1) = 632 unique reads
+	if size := estimateLibrarySize(1000, 632); size < 995 || size > 1005 {
+		t.Errorf("Error: expected a library size near 1000, found %d...\n", size)
+	}
+}
@@ -0,0 +1,274 @@
+package bam
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+// Pileup is a single reference position along with every read base aligned to it.
+type Pileup struct {
+	RName string
+	Pos   int
+	Ref   code.Dna
+	Reads []PileupRead
+}
+
+// PileupRead is the base one read contributes to a pileup column. Deleted positions are reported with a Gap base,
+// and any insertion or deletion that immediately follows the base is attached as an indel event.
+type PileupRead struct {
+	QName     string
+	Base      code.Dna
+	Qual      uint8
+	MapQ      uint8
+	QueryPos  int
+	Reverse   bool
+	Start     bool
+	End       bool
+	Insertion []code.Dna
+	Deletion  []code.Dna
+}
+
+// PileupSettings contains the filters applied to reads and bases as they are added to the pileup. Reads must contain
+// every bit of RequireFlags and none of ExcludeFlags. Reference is optional and used to fill in the reference base.
+type PileupSettings struct {
+	MinMapQ      uint8
+	MinBaseQ     uint8
+	RequireFlags uint16
+	ExcludeFlags uint16
+	Reference    []fasta.Fasta
+}
+
+// DefaultPileupExclude will skip reads that are unmapped, secondary, fail quality checks or are pcr duplicates, similar to samtools.
+const DefaultPileupExclude uint16 = FlagUnmapped | FlagSecondary | FlagQcFail | FlagDuplicate
+
+// missingQual is the base quality of reads without a quality string.
+const missingQual uint8 = 0xff
+
+// IsDeletion returns true if the read has a deletion at this position of the reference.
+func (p *PileupRead) IsDeletion() bool {
+	return p.Base == code.Gap
+}
+
+// Depth returns the number of reads in a pileup column, including deletions.
+func (p *Pileup) Depth() int {
+	return len(p.Reads)
+}
+
+// pileupWindow holds the columns that can still receive reads, starting at the position of the most recent alignment.
+type pileupWindow struct {
+	rName   string
+	start   int
+	columns []*Pileup
+	ref     []code.Dna
+}
+
+// SamToPileup will stream coordinate sorted sam records and return a channel of pileup columns. Each column is
+// emitted once no later alignment can overlap it, so memory is limited to the columns spanned by overlapping reads.
+// Positions are one-based, and positions without any reads passing the filters are skipped.
+func SamToPileup(records <-chan Sam, settings PileupSettings) <-chan Pileup {
+	refs := make(map[string][]code.Dna)
+	for _, fa := range settings.Reference {
+		refs[fa.Name] = fa.Seq
+	}
+	ans := make(chan Pileup, 1000)
+	go func() {
+		window := &pileupWindow{}
+		for i := range records {
+			if !settings.keep(&i) {
+				continue
+			}
+			if i.RName != window.rName {
+				window.flush(ans, len(window.columns))
+				window.rName, window.start, window.columns, window.ref = i.RName, i.Pos, window.columns[:0], refs[i.RName]
+			} else if i.Pos < window.start {
+				log.Fatalf("Error: sam records must be sorted by coordinate to create a pileup, found %s:%d after %s:%d...\n", i.RName, i.Pos, window.rName, window.start)
+			}
+			window.flush(ans, i.Pos-window.start)
+			window.start = i.Pos
+			window.add(&i, settings)
+		}
+		window.flush(ans, len(window.columns))
+		close(ans)
+	}()
+	return ans
+}
+
+// PileupFile is a wrapper around SamToPileup that will read a coordinate sorted sam or bam file.
+func PileupFile(filename string, settings PileupSettings) (*Header, <-chan Pileup) {
+	header, records := Read(filename)
+	return header, SamToPileup(records, settings)
+}
+
+// keep returns true if a read passes the flag and mapping quality filters.
+func (settings *PileupSettings) keep(record *Sam) bool {
+	return record.RName != "*" && len(record.Cigar) > 0 && record.MapQ >= settings.MinMapQ &&
+		record.Flag&settings.RequireFlags == settings.RequireFlags && record.Flag&settings.ExcludeFlags == 0
+}
+
+// flush will send the first n columns of the window to the channel and remove them from the window.
+func (w *pileupWindow) flush(ans chan<- Pileup, n int) {
+	if n > len(w.columns) {
+		n = len(w.columns)
+	}
+	for _, col := range w.columns[:n] {
+		if col != nil && len(col.Reads) > 0 {
+			ans <- *col
+		}
+	}
+	w.columns = append(w.columns[:0], w.columns[n:]...)
+}
+
+// column returns the pileup column of a one-based position, allocating it if needed.
+func (w *pileupWindow) column(pos int) *Pileup {
+	for len(w.columns) <= pos-w.start {
+		w.columns = append(w.columns, nil)
+	}
+	if w.columns[pos-w.start] == nil {
+		w.columns[pos-w.start] = &Pileup{RName: w.rName, Pos: pos, Ref: w.refBase(pos)}
+	}
+	return w.columns[pos-w.start]
+}
+
+// refBase returns the reference base of a one-based position, or N if there is no reference.
+func (w *pileupWindow) refBase(pos int) code.Dna {
+	if pos-1 < len(w.ref) {
+		return w.ref[pos-1]
+	}
+	return code.N
+}
+
+// add will walk through the cigar of a read and add each aligned base to the pileup window.
+func (w *pileupWindow) add(record *Sam, settings PileupSettings) {
+	var refPos, queryPos, k int = record.Pos, 0, 0
+	var last *PileupRead
+	var curr *Pileup
+	first, final := alignedRange(record.Cigar)
+	reverse := record.IsReverse()
+	for j, c := range record.Cigar {
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			for k = 0; k < int(c.RunLen); k, refPos, queryPos = k+1, refPos+1, queryPos+1 {
+				last = nil
+				qual := baseQual(record, queryPos)
+				if qual < settings.MinBaseQ || queryPos >= len(record.Seq) {
+					continue
+				}
+				curr = w.column(refPos)
+				curr.Reads = append(curr.Reads, PileupRead{QName: record.QName, Base: record.Seq[queryPos], Qual: qual, MapQ: record.MapQ,
+					QueryPos: queryPos, Reverse: reverse, Start: j == first && k == 0, End: j == final && k == int(c.RunLen)-1})
+				last = &curr.Reads[len(curr.Reads)-1]
+			}
+		case Insertion:
+			if last != nil && queryPos+int(c.RunLen) <= len(record.Seq) {
+				last.Insertion = record.Seq[queryPos : queryPos+int(c.RunLen)]
+			}
+			queryPos += int(c.RunLen)
+		case Deletion:
+			if last != nil {
+				last.Deletion = make([]code.Dna, c.RunLen)
+				for k = range last.Deletion {
+					last.Deletion[k] = w.refBase(refPos + k)
+				}
+			}
+			for k = 0; k < int(c.RunLen); k, refPos = k+1, refPos+1 {
+				curr = w.column(refPos)
+				curr.Reads = append(curr.Reads, PileupRead{QName: record.QName, Base: code.Gap, Qual: 0, MapQ: record.MapQ, QueryPos: queryPos, Reverse: reverse})
+			}
+			last = nil
+		case N:
+			refPos += int(c.RunLen)
+			last = nil
+		case SoftClip:
+			queryPos += int(c.RunLen)
+		}
+	}
+}
+
+// alignedRange returns the index of the first and last cigar operations that align bases to the reference.
+func alignedRange(cigar []ByteCigar) (int, int) {
+	first, final := -1, -1
+	for j, c := range cigar {
+		if c.Op == Match || c.Op == EqualByte || c.Op == Mismatch {
+			if first < 0 {
+				first = j
+			}
+			final = j
+		}
+	}
+	return first, final
+}
+
+// baseQual returns the phred score of a base in the read, or missingQual if the read does not contain qualities.
+func baseQual(record *Sam, queryPos int) uint8 {
+	if len(record.Qual) != len(record.Seq) || queryPos >= len(record.Qual) {
+		return missingQual
+	}
+	return record.Qual[queryPos] - 33
+}
+
+// PileupToString will format a pileup column similar to samtools mpileup: chrom, position, reference base, depth,
+// read bases and base qualities. Bases matching the reference are printed as '.' or ',' for the forward and reverse strand.
+func PileupToString(p *Pileup) string {
+	var str strings.Builder
+	var err error
+	_, err = str.WriteString(p.RName + "\t" + strconv.Itoa(p.Pos) + "\t" + string(code.DnaToByte(p.Ref)) + "\t" + strconv.Itoa(len(p.Reads)) + "\t")
+	simpleio.StdError(err)
+	ref := code.ToUpper(p.Ref)
+	for _, r := range p.Reads {
+		if r.Start {
+			str.WriteByte('^')
+			str.WriteByte(minByte(r.MapQ, 93) + 33)
+		}
+		switch {
+		case r.IsDeletion():
+			str.WriteByte('*')
+		case ref != code.N && code.ToUpper(r.Base) == ref:
+			if r.Reverse {
+				str.WriteByte(',')
+			} else {
+				str.WriteByte('.')
+			}
+		default:
+			writePileupBases(&str, []code.Dna{r.Base}, r.Reverse)
+		}
+		if len(r.Insertion) > 0 {
+			str.WriteString("+" + strconv.Itoa(len(r.Insertion)))
+			writePileupBases(&str, r.Insertion, r.Reverse)
+		}
+		if len(r.Deletion) > 0 {
+			str.WriteString("-" + strconv.Itoa(len(r.Deletion)))
+			writePileupBases(&str, r.Deletion, r.Reverse)
+		}
+		if r.End {
+			str.WriteByte('$')
+		}
+	}
+	str.WriteByte('\t')
+	for _, r := range p.Reads {
+		str.WriteByte(minByte(r.Qual, 93) + 33)
+	}
+	return str.String()
+}
+
+// writePileupBases will write upper case bases for the forward strand and lower case bases for the reverse strand.
+func writePileupBases(str *strings.Builder, bases []code.Dna, reverse bool) {
+	for _, b := range bases {
+		if reverse {
+			str.WriteByte(code.DnaToByte(code.ToUpper(b)) | 0x20)
+		} else {
+			str.WriteByte(code.DnaToByte(code.ToUpper(b)))
+		}
+	}
+}
+
+func minByte(a uint8, b uint8) uint8 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,84 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+)
+
+var pileupReads = []Sam{
+	{QName: "read1", Flag: 0, RName: "chr1", Pos: 1, MapQ: 60, Cigar: ReadToBytesCigar([]byte("4M")), Seq: code.ToDna([]byte("ACGT")), Qual: []byte("IIII")},
+	{QName: "read2", Flag: 16, RName: "chr1", Pos: 2, MapQ: 60, Cigar: ReadToBytesCigar([]byte("2M1I1M2D2M")), Seq: code.ToDna([]byte("CGACGT")), Qual: []byte("IIIIII")},
+	{QName: "read3", Flag: 1024, RName: "chr1", Pos: 3, MapQ: 60, Cigar: ReadToBytesCigar([]byte("2M")), Seq: code.ToDna([]byte("GT")), Qual: []byte("II")},
+	{QName: "read4", Flag: 0, RName: "chr1", Pos: 3, MapQ: 60, Cigar: ReadToBytesCigar([]byte("2M")), Seq: code.ToDna([]byte("GT")), Qual: []byte("#I")},
+	{QName: "read5", Flag: 0, RName: "chr2", Pos: 1, MapQ: 5, Cigar: ReadToBytesCigar([]byte("2M")), Seq: code.ToDna([]byte("GT")), Qual: []byte("II")},
+}
+
+var pileupExpected = []string{
+	"chr1\t1\tA\t1\t^].\tI",
+	"chr1\t2\tC\t2\t.^],\tII",
+	"chr1\t3\tG\t2\t.,+1a\tII",
+	"chr1\t4\tT\t3\t.$c-2ac.$\tIII",
+	"chr1\t5\tA\t1\t*\t!",
+	"chr1\t6\tC\t1\t*\t!",
+	"chr1\t7\tG\t1\t,\tI",
+	"chr1\t8\tT\t1\t,$\tI",
+}
+
+func TestPileup(t *testing.T) {
+	records := make(chan Sam)
+	go func() {
+		for _, i := range pileupReads {
+			records <- i
+		}
+		close(records)
+	}()
+	settings := PileupSettings{MinMapQ: 10, MinBaseQ: 10, ExcludeFlags: DefaultPileupExclude, Reference: []fasta.Fasta{{Name: "chr1", Seq: code.ToDna([]byte("ACGTACGTACGT"))}}}
+	var i int
+	for p := range SamToPileup(records, settings) {
+		if i >= len(pileupExpected) {
+			t.Fatalf("Error: pileup returned more columns than expected: %s\n", PileupToString(&p))
+		}
+		if ans := PileupToString(&p); ans != pileupExpected[i] {
+			t.Errorf("Error: pileup column %s does not match expected %s...\n", ans, pileupExpected[i])
+		}
+		i++
+	}
+	if i != len(pileupExpected) {
+		t.Errorf("Error: pileup returned %d columns, expected %d...\n", i, len(pileupExpected))
+	}
+}
+
+// TestPileupFile will check every aligned base of the test file is reported exactly once in increasing order.
+func TestPileupFile(t *testing.T) {
+	for _, test := range readBamTests {
+		var expected int
+		settings := PileupSettings{ExcludeFlags: DefaultPileupExclude}
+		for _, s := range ReadSamRecord(test.sam) {
+			if settings.keep(s) {
+				for _, c := range s.Cigar {
+					if c.Op == Match || c.Op == EqualByte || c.Op == Mismatch {
+						expected += int(c.RunLen)
+					}
+				}
+			}
+		}
+		var bases, last int
+		_, columns := PileupFile(test.bam, settings)
+		for p := range columns {
+			if p.Pos <= last {
+				t.Fatalf("Error: pileup column %d was reported after %d...\n", p.Pos, last)
+			}
+			last = p.Pos
+			for _, r := range p.Reads {
+				if !r.IsDeletion() {
+					bases++
+				}
+			}
+		}
+		if bases != expected || bases == 0 {
+			t.Errorf("Error: pileup contains %d aligned bases, expected %d...\n", bases, expected)
+		}
+	}
+}
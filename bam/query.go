@@ -0,0 +1,128 @@
+package bam
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/edotau/goFish/simpleio"
+)
+
+// maxCoord is the largest coordinate that can be represented by the bai binning scheme.
+const maxCoord int = 1 << 29
+
+// Query uses a bai index to seek directly to the bgzf virtual offsets of a coordinate sorted bam file and returns
+// a channel containing only the alignments that overlap chrom:start-end. Start and end are zero-based, half-open
+// coordinates similar to bed, while the Pos of the returned sam records remains one-based.
+func Query(reader *BamReader, bai *Bai, chrom string, start int, end int) <-chan Sam {
+	if reader.header == nil {
+		ReadHeader(reader)
+	}
+	header := reader.header
+	refId := -1
+	for _, c := range header.Chroms {
+		if c.Name == chrom {
+			refId = c.Order
+			break
+		}
+	}
+	if refId < 0 {
+		log.Fatalf("Error: %s was not found in the bam header...\n", chrom)
+	}
+	chunks := bai.Chunks(refId, start, end)
+
+	// the sequential reader must be stopped before the file can be seeked
+	if closer, ok := reader.Gunzip.(io.Closer); ok {
+		simpleio.StdError(closer.Close())
+	}
+	source := reader.source
+	if source == nil {
+		source = reader.File
+	}
+	_, reader.error = source.Seek(0, io.SeekStart)
+	simpleio.StdError(reader.error)
+	bg, err := bgzf.NewReader(source, 1)
+	simpleio.StdError(err)
+	reader.Gunzip = bg
+
+	ans := make(chan Sam)
+	go func() {
+		var blockSize int32
+		var block *BinaryDecoder
+		var record *Sam
+		for _, chunk := range chunks {
+			reader.error = bg.Seek(chunk.Begin)
+			simpleio.StdError(reader.error)
+			for {
+				reader.error = binary.Read(bg, binary.LittleEndian, &blockSize)
+				if reader.error == io.EOF {
+					break
+				}
+				simpleio.StdError(reader.error)
+				if fromOffset(bg.LastChunk().Begin) >= fromOffset(chunk.End) {
+					break
+				}
+				block = decodeBlock(reader, blockSize)
+				// records are sorted, so once we are past the region the rest of the chunk can be skipped
+				if int(block.RefID) != refId || int(block.Pos) >= end {
+					break
+				}
+				record = BamBlockToSam(header, block)
+				if record.Pos-1+alignedLength(record.Cigar) > start {
+					ans <- *record
+				}
+			}
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// QueryFile is a wrapper around Query that will open a bam file along with its index,
+// which is expected to be found at the same path with a .bai suffix. Files may also be http or https urls, in
+// which case the index is downloaded and only the bgzf blocks overlapping the region are requested.
+func QueryFile(filename string, chrom string, start int, end int) (*Header, <-chan Sam) {
+	reader, header, bai := NewIndexedReader(filename)
+	return header, Query(reader, bai, chrom, start, end)
+}
+
+// NewIndexedReader will open a bam file, read its header and load the index found at the same path with a .bai
+// suffix, so the reader can be given to Query for any number of regions. Files may also be http or https urls.
+func NewIndexedReader(filename string) (*BamReader, *Header, *Bai) {
+	reader := NewBamReader(filename)
+	header := ReadHeader(reader)
+	return reader, header, IndexReader(filename + ".bai")
+}
+
+// ParseRegion will parse a samtools style region string (chr, chr:start or chr:start-end) using one-based,
+// closed coordinates and return the chromosome name along with zero-based, half-open start and end values.
+func ParseRegion(region string) (string, int, int) {
+	colon := strings.LastIndexByte(region, ':')
+	if colon < 0 {
+		return region, 0, maxCoord
+	}
+	chrom, coords := region[:colon], strings.ReplaceAll(region[colon+1:], ",", "")
+	if dash := strings.IndexByte(coords, '-'); dash >= 0 {
+		start, end := simpleio.StringToInt(coords[:dash]), simpleio.StringToInt(coords[dash+1:])
+		if start < 1 || end < start {
+			log.Fatalf("Error: invalid region %s...\n", region)
+		}
+		return chrom, start - 1, end
+	}
+	start := simpleio.StringToInt(coords)
+	if start < 1 {
+		log.Fatalf("Error: invalid region %s...\n", region)
+	}
+	return chrom, start - 1, maxCoord
+}
+
+// alignedLength returns the number of reference bases covered by an alignment. Records without
+// reference consuming operations are treated as a single base, which is how they are binned in bam indexes.
+func alignedLength(cigar []ByteCigar) int {
+	if length := ReferenceLength(cigar); length > 0 {
+		return length
+	}
+	return 1
+}
@@ -0,0 +1,41 @@
+package bam
+
+import (
+	"testing"
+)
+
+var queryTests = []struct {
+	region string
+}{
+	{"tig00000004:1000-2000"},
+	{"tig00000004:150-160"},
+	{"tig00000004:5600-5700"},
+	{"tig00000004"},
+}
+
+// TestQuery will compare alignments retrieved with the bai index to a full linear scan of the same sam file.
+func TestQuery(t *testing.T) {
+	for _, test := range readBamTests {
+		samFile := ReadSamRecord(test.sam)
+		for _, q := range queryTests {
+			chrom, start, end := ParseRegion(q.region)
+			var expected []*Sam
+			for _, s := range samFile {
+				if s.RName == chrom && s.Pos-1 < end && s.Pos-1+alignedLength(s.Cigar) > start {
+					expected = append(expected, s)
+				}
+			}
+			_, alignments := QueryFile(test.bam, chrom, start, end)
+			var i int
+			for each := range alignments {
+				if i >= len(expected) || !IsEqualDebug(&each, expected[i]) {
+					t.Fatalf("Error: query %s did not return the expected alignments...\n", q.region)
+				}
+				i++
+			}
+			if i != len(expected) || i == 0 {
+				t.Errorf("Error: query %s returned %d alignments, expected %d...\n", q.region, i, len(expected))
+			}
+		}
+	}
+}
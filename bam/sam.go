@@ -121,30 +121,14 @@ func processHeaderLine(header *Header, line string) {
 			}
 		}
 		header.ChromSize[currName] = currLen
+		header.Chroms = append(header.Chroms, ChromSize{Name: currName, Size: currLen, Order: len(header.Chroms)})
 	}
 }
 
 func UnmarshalSam(file *SamReader) (*Sam, bool) {
 	file.Reader.Buffer, file.done = simpleio.ReadLine(file.Reader)
 	if !file.done {
-		file.line = strings.SplitN(file.Reader.Buffer.String(), "\t", 12)
-		if len(file.line) < 11 {
-			log.Fatalf("Error: missing sam alignment fields, must contain at least 11...\n")
-		}
-		ans := &Sam{
-			QName:   file.line[0],
-			Flag:    simpleio.StringToUInt16(file.line[1]),
-			RName:   file.line[2],
-			Pos:     simpleio.StringToInt(file.line[3]),
-			MapQ:    uint8(simpleio.StringToInt(file.line[4])),
-			Cigar:   ReadToBytesCigar([]byte(file.line[5])),
-			MateRef: string(file.line[6]),
-			MatePos: simpleio.StringToInt(file.line[7]),
-			TmpLen:  simpleio.StringToInt(file.line[8]),
-			Seq:     code.ToDna([]byte(file.line[9])),
-			Qual:    []byte(file.line[10]),
-			Aux:     file.line[11],
-		}
+		ans := ParseSam(file.Reader.Buffer.String())
 		if len(ans.Seq) != len(ans.Qual) {
 			log.Fatalf("Error: seq and qual lengths should match...\n")
 		}
@@ -154,6 +138,31 @@ func UnmarshalSam(file *SamReader) (*Sam, bool) {
 	}
 }
 
+// ParseSam will parse a line of sam text into a record. The auxiliary tags are optional.
+func ParseSam(line string) *Sam {
+	words := strings.SplitN(line, "\t", 12)
+	if len(words) < 11 {
+		log.Fatalf("Error: missing sam alignment fields, must contain at least 11...\n")
+	}
+	ans := &Sam{
+		QName:   words[0],
+		Flag:    simpleio.StringToUInt16(words[1]),
+		RName:   words[2],
+		Pos:     simpleio.StringToInt(words[3]),
+		MapQ:    uint8(simpleio.StringToInt(words[4])),
+		Cigar:   ReadToBytesCigar([]byte(words[5])),
+		MateRef: words[6],
+		MatePos: simpleio.StringToInt(words[7]),
+		TmpLen:  simpleio.StringToInt(words[8]),
+		Seq:     code.ToDna([]byte(words[9])),
+		Qual:    []byte(words[10]),
+	}
+	if len(words) == 12 {
+		ans.Aux = words[11]
+	}
+	return ans
+}
+
 func ToString(record *Sam) string {
 	var str strings.Builder
 	var err error
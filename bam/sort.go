@@ -0,0 +1,280 @@
+package bam
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/simpleio"
+)
+
+// SortOrder defines how sam records are compared when sorting alignments.
+type SortOrder byte
+
+const (
+	// Coordinate sorts by the order of references in the @SQ header lines, then by position and strand.
+	Coordinate SortOrder = iota
+	// QueryName sorts by read name using a natural ordering of numbers, then by read one before read two.
+	QueryName
+)
+
+// String returns the value used in the SO: field of the @HD header line.
+func (order SortOrder) String() string {
+	switch order {
+	case QueryName:
+		return "queryname"
+	default:
+		return "coordinate"
+	}
+}
+
+// SortSettings contains the options used by Sort. MaxMemory is the approximate number of bytes of alignments
+// held in memory before a sorted run is written to a temporary bam file in TmpDir.
+type SortSettings struct {
+	Order     SortOrder
+	MaxMemory int
+	TmpDir    string
+}
+
+// DefaultSortMemory is the memory budget used when SortSettings.MaxMemory is not set.
+const DefaultSortMemory int = 768 * 1024 * 1024
+
+// Sort will sort a stream of sam records by coordinate or query name. Records are sorted in memory until the memory
+// budget is reached, then each sorted run is spilled to a temporary bgzf compressed bam file and the runs are merged
+// with a k-way heap merge, which allows files much larger than the available memory to be sorted. The sort order
+// is recorded in the SO: field of the header, which is updated in place and returned along with the sorted records.
+func Sort(header *Header, records <-chan Sam, settings SortSettings) (*Header, <-chan Sam) {
+	if settings.MaxMemory <= 0 {
+		settings.MaxMemory = DefaultSortMemory
+	}
+	SetSortOrder(header, settings.Order)
+	less := sortFunc(header, settings.Order)
+	ans := make(chan Sam, 1000)
+	go func() {
+		var runs []string
+		var tmpDir string
+		var err error
+		var memory int
+		var batch []Sam
+		for i := range records {
+			batch = append(batch, i)
+			memory += recordSize(&i)
+			if memory >= settings.MaxMemory {
+				if tmpDir == "" {
+					tmpDir, err = os.MkdirTemp(settings.TmpDir, "goFishSort")
+					simpleio.StdError(err)
+				}
+				runs = append(runs, writeRun(header, batch, less, filepath.Join(tmpDir, fmt.Sprintf("run%d.bam", len(runs)))))
+				batch, memory = batch[:0], 0
+			}
+		}
+		sort.SliceStable(batch, func(i, j int) bool { return less(&batch[i], &batch[j]) })
+		if len(runs) == 0 {
+			for _, i := range batch {
+				ans <- i
+			}
+		} else {
+			mergeRuns(runs, batch, less, ans)
+			simpleio.StdError(os.RemoveAll(tmpDir))
+		}
+		close(ans)
+	}()
+	return header, ans
+}
+
+// SortFile is a wrapper around Sort that will read a sam or bam file and write the sorted records to output.
+// If index is true and the output is a coordinate sorted bam, a bai index will also be written. When edit is not nil,
+// it is called on the input header before sorting, e.g. to add a @PG line with AddProgramLine.
+func SortFile(input string, output string, settings SortSettings, index bool, edit func(header *Header)) {
+	header, records := Read(input)
+	if edit != nil {
+		edit(header)
+	}
+	header, records = Sort(header, records, settings)
+	if strings.HasSuffix(output, ".bam") {
+		WriteBamFile(output, header, records, index && settings.Order == Coordinate)
+	} else {
+		Write(output, header, records)
+	}
+}
+
+// SetSortOrder will set the SO: field of the @HD line in the header text, adding an @HD line if one does not exist.
+func SetSortOrder(header *Header, order SortOrder) {
+	s := NewSamHeader(header)
+	s.SetSortOrder(order.String())
+	header.Text.Reset()
+	header.Text.WriteString(s.ToString())
+}
+
+// sortFunc returns the comparison used to sort records in the given order.
+func sortFunc(header *Header, order SortOrder) func(a *Sam, b *Sam) bool {
+	if order == QueryName {
+		return func(a *Sam, b *Sam) bool {
+			if c := NaturalCompare(a.QName, b.QName); c != 0 {
+				return c < 0
+			}
+			return a.Flag&(FlagRead1|FlagRead2) < b.Flag&(FlagRead1|FlagRead2)
+		}
+	}
+	refs := make(map[string]int)
+	for _, c := range header.Chroms {
+		refs[c.Name] = c.Order
+	}
+	refId := func(name string) int {
+		if id, ok := refs[name]; ok {
+			return id
+		}
+		// unmapped reads are placed after every reference
+		return len(refs)
+	}
+	return func(a *Sam, b *Sam) bool {
+		if idA, idB := refId(a.RName), refId(b.RName); idA != idB {
+			return idA < idB
+		}
+		if a.Pos != b.Pos {
+			return a.Pos < b.Pos
+		}
+		return a.Flag&FlagReverse < b.Flag&FlagReverse
+	}
+}
+
+// NaturalCompare will compare two read names such that runs of digits are compared by their numeric value,
+// similar to samtools, so read:2 is placed before read:10. Returns -1, 0 or 1.
+func NaturalCompare(a string, b string) int {
+	var i, j int
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && a[i] == '0' {
+				i++
+			}
+			for j < len(b) && b[j] == '0' {
+				j++
+			}
+			ni, nj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			if i-ni != j-nj {
+				return compareInt(i-ni, j-nj)
+			}
+			if c := strings.Compare(a[ni:i], b[nj:j]); c != 0 {
+				return c
+			}
+			if i-si != j-sj {
+				return compareInt(i-si, j-sj)
+			}
+		} else {
+			if a[i] != b[j] {
+				return compareInt(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+	}
+	return compareInt(len(a)-i, len(b)-j)
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func compareInt(a int, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// recordSize is a rough estimate of the number of bytes used to hold a sam record in memory.
+func recordSize(record *Sam) int {
+	return 128 + len(record.QName) + len(record.RName) + len(record.MateRef) + 4*len(record.Cigar) + len(record.Seq) + len(record.Qual) + len(record.Aux)
+}
+
+// writeRun will sort a batch of records and write them to a temporary bam file, returning the name of the file.
+func writeRun(header *Header, batch []Sam, less func(a *Sam, b *Sam) bool, filename string) string {
+	sort.SliceStable(batch, func(i, j int) bool { return less(&batch[i], &batch[j]) })
+	writer := NewBamWriter(filename)
+	WriteHeader(writer, header)
+	for i := range batch {
+		WriteBam(writer, &batch[i])
+	}
+	writer.Close()
+	return filename
+}
+
+// runHead is the next record from one of the sorted runs being merged.
+type runHead struct {
+	record Sam
+	run    int
+	next   <-chan Sam
+}
+
+// runHeap is a min heap of the next record of each sorted run. Ties are broken by run order to keep the sort stable.
+type runHeap struct {
+	heads []*runHead
+	less  func(a *Sam, b *Sam) bool
+}
+
+func (h *runHeap) Len() int { return len(h.heads) }
+
+func (h *runHeap) Less(i, j int) bool {
+	if h.less(&h.heads[i].record, &h.heads[j].record) {
+		return true
+	}
+	if h.less(&h.heads[j].record, &h.heads[i].record) {
+		return false
+	}
+	return h.heads[i].run < h.heads[j].run
+}
+
+func (h *runHeap) Swap(i, j int) { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+
+func (h *runHeap) Push(x interface{}) { h.heads = append(h.heads, x.(*runHead)) }
+
+func (h *runHeap) Pop() interface{} {
+	ans := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return ans
+}
+
+// mergeRuns will perform a k-way merge of the sorted bam files along with the final sorted batch still held in memory.
+func mergeRuns(runs []string, batch []Sam, less func(a *Sam, b *Sam) bool, ans chan<- Sam) {
+	h := &runHeap{less: less}
+	for i, filename := range runs {
+		_, records := Read(filename)
+		if record, ok := <-records; ok {
+			h.heads = append(h.heads, &runHead{record: record, run: i, next: records})
+		}
+	}
+	last := make(chan Sam, 1000)
+	go func() {
+		for _, i := range batch {
+			last <- i
+		}
+		close(last)
+	}()
+	if record, ok := <-last; ok {
+		h.heads = append(h.heads, &runHead{record: record, run: len(runs), next: last})
+	}
+	heap.Init(h)
+	var ok bool
+	for h.Len() > 0 {
+		curr := h.heads[0]
+		ans <- curr.record
+		if curr.record, ok = <-curr.next; ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+}
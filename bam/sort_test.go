@@ -0,0 +1,85 @@
+package bam
+
+import (
+	"strings"
+	"testing"
+)
+
+var naturalCompareTests = []struct {
+	a, b     string
+	expected int
+}{
+	{"read:2", "read:10", -1},
+	{"read:10", "read:2", 1},
+	{"read10a", "read10b", -1},
+	{"read007", "read7", 1},
+	{"read", "read1", -1},
+	{"same", "same", 0},
+}
+
+func TestNaturalCompare(t *testing.T) {
+	for _, test := range naturalCompareTests {
+		if ans := NaturalCompare(test.a, test.b); ans != test.expected {
+			t.Errorf("Error: comparing %s to %s returned %d, expected %d...\n", test.a, test.b, ans, test.expected)
+		}
+	}
+}
+
+// TestSort will sort the test alignments by query name with a tiny memory budget so several runs are spilled to disk
+// and merged, then sort the result by coordinate again which should reproduce the original coordinate sorted file.
+func TestSort(t *testing.T) {
+	for _, test := range readBamTests {
+		_, original := BasicRead(test.bam)
+		header, records := Read(test.bam)
+		header, sorted := Sort(header, records, SortSettings{Order: QueryName, MaxMemory: 64 * 1024, TmpDir: t.TempDir()})
+		if !strings.HasPrefix(header.Text.String(), "@HD\tVN:1.3\tSO:queryname\n") {
+			t.Errorf("Error: sort order was not updated in the header...\n")
+		}
+		var byName []Sam
+		for i := range sorted {
+			if n := len(byName); n > 0 && NaturalCompare(byName[n-1].QName, i.QName) > 0 {
+				t.Fatalf("Error: %s was sorted after %s...\n", i.QName, byName[n-1].QName)
+			}
+			byName = append(byName, i)
+		}
+		if len(byName) != len(original) {
+			t.Fatalf("Error: sorted %d records, expected %d...\n", len(byName), len(original))
+		}
+
+		filename := t.TempDir() + "/sorted.bam"
+		SortFile(writeTestSam(t, header, byName), filename, SortSettings{Order: Coordinate, MaxMemory: 64 * 1024, TmpDir: t.TempDir()}, true, nil)
+		h, results := BasicRead(filename)
+		if !strings.HasPrefix(h.Text.String(), "@HD\tVN:1.3\tSO:coordinate\n") {
+			t.Errorf("Error: sort order was not updated in the header...\n")
+		}
+		if len(results) != len(original) {
+			t.Fatalf("Error: sorted %d records, expected %d...\n", len(results), len(original))
+		}
+		for i := range results {
+			if results[i].RName != original[i].RName || results[i].Pos != original[i].Pos {
+				t.Fatalf("Error: coordinate sort did not restore the original order at record %d...\n", i)
+			}
+		}
+		var count int
+		for range Query(NewBamReader(filename), IndexReader(filename+".bai"), "tig00000004", 1000, 2000) {
+			count++
+		}
+		if count == 0 {
+			t.Errorf("Error: index built while sorting did not find any records...\n")
+		}
+	}
+}
+
+// writeTestSam will write records to a temporary sam file and return the file name.
+func writeTestSam(t *testing.T, header *Header, records []Sam) string {
+	filename := t.TempDir() + "/records.sam"
+	ans := make(chan Sam)
+	go func() {
+		for _, i := range records {
+			ans <- i
+		}
+		close(ans)
+	}()
+	Write(filename, header, ans)
+	return filename
+}
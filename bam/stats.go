@@ -0,0 +1,289 @@
+package bam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+// FlagStat contains the number of records in each flag category, similar to samtools flagstat.
+type FlagStat struct {
+	Total         int `json:"total"`
+	Primary       int `json:"primary"`
+	Secondary     int `json:"secondary"`
+	Supplementary int `json:"supplementary"`
+	Duplicates    int `json:"duplicates"`
+	QcFail        int `json:"qcFail"`
+	Mapped        int `json:"mapped"`
+	Paired        int `json:"paired"`
+	Read1         int `json:"read1"`
+	Read2         int `json:"read2"`
+	ProperPair    int `json:"properPair"`
+	BothMapped    int `json:"bothMapped"`
+	Singletons    int `json:"singletons"`
+	MateDiffChrom int `json:"mateDiffChrom"`
+	// MateDiffChromQ5 counts reads with a mate on a different chromosome with a mapping quality of at least 5.
+	MateDiffChromQ5 int `json:"mateDiffChromQ5"`
+}
+
+// CycleStat is the number of aligned bases and mismatches to the reference found at one sequencing cycle.
+type CycleStat struct {
+	Cycle      int     `json:"cycle"`
+	Bases      int     `json:"bases"`
+	Mismatches int     `json:"mismatches"`
+	Rate       float64 `json:"rate"`
+}
+
+// ChromCount is the number of primary alignments found on a reference sequence. Only references with reads are reported.
+type ChromCount struct {
+	Name  string `json:"name"`
+	Reads int    `json:"reads"`
+}
+
+// Bin is a single value of a histogram and the number of times it was observed.
+type Bin struct {
+	Value int `json:"value"`
+	Count int `json:"count"`
+}
+
+// Stats collects alignment quality control metrics in a single pass over sam records. Flag categories are counted
+// for every record, while the remaining metrics only use primary alignments. Per cycle mismatch rates require a reference.
+type Stats struct {
+	Flags            FlagStat     `json:"flags"`
+	MapQ             []Bin        `json:"mapq"`
+	InsertSize       []Bin        `json:"insertSize"`
+	Cycles           []CycleStat  `json:"cycles,omitempty"`
+	Bases            int          `json:"bases"`
+	SoftClippedReads int          `json:"softClippedReads"`
+	SoftClippedBases int          `json:"softClippedBases"`
+	SoftClipRate     float64      `json:"softClipRate"`
+	Chroms           []ChromCount `json:"chroms"`
+
+	mapQ       [256]int
+	insertSize map[int]int
+	cycles     []CycleStat
+	chroms     map[string]int
+	order      []string
+	ref        map[string][]code.Dna
+}
+
+// NewStats will allocate memory for alignment statistics. Chromosomes are reported in the order of the header,
+// and reference is optional, it is only used to count mismatches at each cycle.
+func NewStats(header *Header, reference []fasta.Fasta) *Stats {
+	ans := &Stats{insertSize: make(map[int]int), chroms: make(map[string]int)}
+	for _, c := range header.Chroms {
+		ans.order = append(ans.order, c.Name)
+		ans.chroms[c.Name] = 0
+	}
+	if len(reference) > 0 {
+		ans.ref = make(map[string][]code.Dna)
+		for _, fa := range reference {
+			ans.ref[fa.Name] = fa.Seq
+		}
+	}
+	return ans
+}
+
+// StatsFile will calculate the alignment statistics of a sam or bam file.
+func StatsFile(filename string, reference []fasta.Fasta) *Stats {
+	header, records := Read(filename)
+	ans := NewStats(header, reference)
+	for i := range records {
+		ans.Add(&i)
+	}
+	ans.Finish()
+	return ans
+}
+
+// Add will update the statistics with a single record.
+func (st *Stats) Add(s *Sam) {
+	f := &st.Flags
+	f.Total++
+	switch {
+	case s.IsSecondary():
+		f.Secondary++
+	case s.IsSupplementary():
+		f.Supplementary++
+	default:
+		f.Primary++
+	}
+	if s.IsDuplicate() {
+		f.Duplicates++
+	}
+	if s.IsQcFail() {
+		f.QcFail++
+	}
+	if !s.IsUnmapped() {
+		f.Mapped++
+	}
+	primary := !s.IsSecondary() && !s.IsSupplementary()
+	if s.IsPaired() && primary {
+		f.Paired++
+		if s.IsRead1() {
+			f.Read1++
+		}
+		if s.IsRead2() {
+			f.Read2++
+		}
+		if s.IsProperPair() && !s.IsUnmapped() {
+			f.ProperPair++
+		}
+		if !s.IsUnmapped() && !s.IsMateUnmapped() {
+			f.BothMapped++
+			if s.MateRef != "=" && s.MateRef != s.RName {
+				f.MateDiffChrom++
+				if s.MapQ >= 5 {
+					f.MateDiffChromQ5++
+				}
+			}
+		}
+		if !s.IsUnmapped() && s.IsMateUnmapped() {
+			f.Singletons++
+		}
+	}
+	if !primary || s.IsUnmapped() {
+		return
+	}
+	st.mapQ[s.MapQ]++
+	if _, ok := st.chroms[s.RName]; !ok {
+		st.order = append(st.order, s.RName)
+	}
+	st.chroms[s.RName]++
+	// each pair is counted once using the first read
+	if s.IsPaired() && s.IsRead1() && !s.IsMateUnmapped() && (s.MateRef == "=" || s.MateRef == s.RName) && s.TmpLen != 0 {
+		st.insertSize[absInt(s.TmpLen)]++
+	}
+	st.addBases(s)
+}
+
+// addBases will count soft clipped bases and compare each aligned base to the reference at each sequencing cycle.
+func (st *Stats) addBases(s *Sam) {
+	st.Bases += len(s.Seq)
+	ref := st.ref[s.RName]
+	var clipped bool
+	var refPos, queryPos, k int = s.Pos - 1, 0, 0
+	for _, c := range s.Cigar {
+		switch c.Op {
+		case SoftClip:
+			clipped = true
+			st.SoftClippedBases += int(c.RunLen)
+			queryPos += int(c.RunLen)
+		case Insertion:
+			queryPos += int(c.RunLen)
+		case Deletion, N:
+			refPos += int(c.RunLen)
+		case Match, EqualByte, Mismatch:
+			for k = 0; k < int(c.RunLen); k, refPos, queryPos = k+1, refPos+1, queryPos+1 {
+				if st.ref == nil || refPos >= len(ref) || queryPos >= len(s.Seq) {
+					continue
+				}
+				a, b := code.ToUpper(s.Seq[queryPos]), code.ToUpper(ref[refPos])
+				if a == code.N || b == code.N {
+					continue
+				}
+				cycle := queryPos
+				if s.IsReverse() {
+					cycle = len(s.Seq) - 1 - queryPos
+				}
+				for len(st.cycles) <= cycle {
+					st.cycles = append(st.cycles, CycleStat{Cycle: len(st.cycles) + 1})
+				}
+				st.cycles[cycle].Bases++
+				if a != b {
+					st.cycles[cycle].Mismatches++
+				}
+			}
+		}
+	}
+	if clipped {
+		st.SoftClippedReads++
+	}
+}
+
+// Finish will summarize the histograms and rates once every record has been added.
+func (st *Stats) Finish() {
+	st.MapQ = make([]Bin, 0, len(st.mapQ))
+	for q, count := range st.mapQ {
+		if count > 0 {
+			st.MapQ = append(st.MapQ, Bin{Value: q, Count: count})
+		}
+	}
+	st.InsertSize = make([]Bin, 0, len(st.insertSize))
+	for size, count := range st.insertSize {
+		st.InsertSize = append(st.InsertSize, Bin{Value: size, Count: count})
+	}
+	sort.Slice(st.InsertSize, func(i, j int) bool { return st.InsertSize[i].Value < st.InsertSize[j].Value })
+	st.Cycles = st.cycles
+	for i := range st.Cycles {
+		if st.Cycles[i].Bases > 0 {
+			st.Cycles[i].Rate = float64(st.Cycles[i].Mismatches) / float64(st.Cycles[i].Bases)
+		}
+	}
+	if st.Bases > 0 {
+		st.SoftClipRate = float64(st.SoftClippedBases) / float64(st.Bases)
+	}
+	st.Chroms = make([]ChromCount, 0, len(st.order))
+	for _, name := range st.order {
+		if st.chroms[name] > 0 {
+			st.Chroms = append(st.Chroms, ChromCount{Name: name, Reads: st.chroms[name]})
+		}
+	}
+}
+
+// WriteStatsTsv will write the statistics as tab delimited text. The first column of each line names the section:
+// SN for summary numbers, MAPQ and IS for the mapping quality and insert size histograms, MPC for mismatches per cycle
+// and CHR for the number of reads on each chromosome.
+func WriteStatsTsv(writer io.Writer, st *Stats) {
+	var err error
+	write := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(writer, format, args...)
+		}
+	}
+	f := st.Flags
+	for _, sn := range []struct {
+		name  string
+		value int
+	}{
+		{"total", f.Total}, {"primary", f.Primary}, {"secondary", f.Secondary}, {"supplementary", f.Supplementary},
+		{"duplicates", f.Duplicates}, {"qcFail", f.QcFail}, {"mapped", f.Mapped}, {"paired", f.Paired}, {"read1", f.Read1},
+		{"read2", f.Read2}, {"properPair", f.ProperPair}, {"bothMapped", f.BothMapped}, {"singletons", f.Singletons},
+		{"mateDiffChrom", f.MateDiffChrom}, {"mateDiffChromQ5", f.MateDiffChromQ5}, {"bases", st.Bases},
+		{"softClippedReads", st.SoftClippedReads}, {"softClippedBases", st.SoftClippedBases},
+	} {
+		write("SN\t%s\t%d\n", sn.name, sn.value)
+	}
+	write("SN\tsoftClipRate\t%g\n", st.SoftClipRate)
+	for _, b := range st.MapQ {
+		write("MAPQ\t%d\t%d\n", b.Value, b.Count)
+	}
+	for _, b := range st.InsertSize {
+		write("IS\t%d\t%d\n", b.Value, b.Count)
+	}
+	for _, c := range st.Cycles {
+		write("MPC\t%d\t%d\t%d\t%g\n", c.Cycle, c.Bases, c.Mismatches, c.Rate)
+	}
+	for _, c := range st.Chroms {
+		write("CHR\t%s\t%d\n", c.Name, c.Reads)
+	}
+	simpleio.StdError(err)
+}
+
+// WriteStatsJson will write the statistics as an indented json object.
+func WriteStatsJson(writer io.Writer, st *Stats) {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	simpleio.StdError(encoder.Encode(st))
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,83 @@
+package bam
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+)
+
+// TestStatsFile will compare the flag counts of the bam file to a linear scan of the matching sam file.
+func TestStatsFile(t *testing.T) {
+	for _, test := range readBamTests {
+		st := StatsFile(test.bam, nil)
+		var expected FlagStat
+		var primaryMapped int
+		for _, s := range ReadSamRecord(test.sam) {
+			expected.Total++
+			if s.Flag&FlagSecondary != 0 {
+				expected.Secondary++
+			} else if s.Flag&FlagSupplementary == 0 && s.Flag&FlagUnmapped == 0 {
+				primaryMapped++
+			}
+			if s.Flag&FlagDuplicate != 0 {
+				expected.Duplicates++
+			}
+			if s.Flag&FlagUnmapped == 0 {
+				expected.Mapped++
+			}
+		}
+		if st.Flags.Total != expected.Total || st.Flags.Secondary != expected.Secondary || st.Flags.Duplicates != expected.Duplicates || st.Flags.Mapped != expected.Mapped {
+			t.Errorf("Error: flag counts %+v do not match %+v...\n", st.Flags, expected)
+		}
+		if st.Flags.Primary+st.Flags.Secondary+st.Flags.Supplementary != st.Flags.Total {
+			t.Errorf("Error: primary, secondary and supplementary counts do not add up to %d...\n", st.Flags.Total)
+		}
+		var mapq, chroms int
+		for _, b := range st.MapQ {
+			mapq += b.Count
+		}
+		for _, c := range st.Chroms {
+			chroms += c.Reads
+		}
+		if mapq != primaryMapped || chroms != primaryMapped || len(st.Chroms) != 1 || st.Chroms[0].Name != "tig00000004" {
+			t.Errorf("Error: expected %d primary alignments on tig00000004, found %d in the mapq histogram and %v...\n", primaryMapped, mapq, st.Chroms)
+		}
+		var tsv bytes.Buffer
+		WriteStatsTsv(&tsv, st)
+		if !strings.HasPrefix(tsv.String(), "SN\ttotal\t1000\n") {
+			t.Errorf("Error: unexpected tsv output %s...\n", tsv.String()[:20])
+		}
+		var js bytes.Buffer
+		WriteStatsJson(&js, st)
+		var decoded Stats
+		if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || decoded.Flags != st.Flags || len(decoded.MapQ) != len(st.MapQ) {
+			t.Errorf("Error: json output could not be decoded: %v...\n", err)
+		}
+	}
+}
+
+func TestStatsCycles(t *testing.T) {
+	reference := []fasta.Fasta{{Name: "chr1", Seq: code.ToDna([]byte("ACGTACGTACGTACGTACGT"))}}
+	st := NewStats(&Header{}, reference)
+	st.Add(&Sam{QName: "a", RName: "chr1", Pos: 1, Cigar: ReadToBytesCigar([]byte("6M")), Seq: code.ToDna([]byte("ACGTAG"))})
+	st.Add(&Sam{QName: "b", Flag: FlagReverse, RName: "chr1", Pos: 3, Cigar: ReadToBytesCigar([]byte("2S4M")), Seq: code.ToDna([]byte("TTGTAC"))})
+	st.Add(&Sam{QName: "c", Flag: FlagSecondary, RName: "chr1", Pos: 1, Cigar: ReadToBytesCigar([]byte("6M")), Seq: code.ToDna([]byte("TTTTTT"))})
+	st.Finish()
+	if st.Flags.Total != 3 || st.Flags.Primary != 2 || st.Bases != 12 || st.SoftClippedReads != 1 || st.SoftClippedBases != 2 {
+		t.Errorf("Error: unexpected counts %+v bases=%d clipped=%d/%d...\n", st.Flags, st.Bases, st.SoftClippedReads, st.SoftClippedBases)
+	}
+	// read a mismatches at cycle 6, read b is reversed so its aligned bases are cycles 4 to 1
+	expected := []CycleStat{{1, 2, 0, 0}, {2, 2, 0, 0}, {3, 2, 0, 0}, {4, 2, 0, 0}, {5, 1, 0, 0}, {6, 1, 1, 1}}
+	if len(st.Cycles) != len(expected) {
+		t.Fatalf("Error: expected %d cycles, found %d...\n", len(expected), len(st.Cycles))
+	}
+	for i := range expected {
+		if st.Cycles[i] != expected[i] {
+			t.Errorf("Error: cycle %d is %+v, expected %+v...\n", i+1, st.Cycles[i], expected[i])
+		}
+	}
+}
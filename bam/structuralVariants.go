@@ -0,0 +1,546 @@
+package bam
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/bed"
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/simpleio"
+	"github.com/edotau/goFish/vcf"
+)
+
+// Structural variant types use the symbolic allele names of the vcf specification.
+const (
+	SvDeletion      string = "DEL"
+	SvDuplication   string = "DUP"
+	SvInversion     string = "INV"
+	SvTranslocation string = "BND"
+)
+
+// SvSettings controls which reads are used as evidence and how much evidence is needed to call a structural variant.
+// Pairs in forward-reverse orientation are discordant when their insert is longer than MaxInsert, which is estimated
+// from properly paired reads when it is zero. Evidence with breakpoints closer than Window is clustered into one call,
+// a Window of zero will use the insert size cutoff.
+type SvSettings struct {
+	MinMapQ    uint8
+	MinSupport int
+	MinSvLen   int
+	MinClip    int
+	MaxInsert  int
+	Window     int
+}
+
+// DefaultSvSettings requires three discordant pairs or split reads with a mapping quality of at least 20 to call
+// variants of 50 bases or more, and counts soft clips of at least 10 bases.
+var DefaultSvSettings SvSettings = SvSettings{MinMapQ: 20, MinSupport: 3, MinSvLen: 50, MinClip: 10}
+
+// defaultSvMaxInsert is the insert size cutoff used when there are no proper pairs to estimate it from.
+const defaultSvMaxInsert int = 1000
+
+// svInsertMads is the number of scaled median absolute deviations above the median insert size of a discordant pair.
+const svInsertMads float64 = 5
+
+// svClipSlop is the distance from a breakpoint where soft clipped reads are counted as support.
+const svClipSlop int = 5
+
+// StructuralVariant is a deletion, tandem duplication, inversion or translocation joining two breakpoints. Pos and
+// End are one-based positions of the last base before each junction, which are the vcf POS and END of symbolic
+// alleles. For translocations End is a position on MateChrom. Strands follows lumpy: + means the sequence at and
+// to the left of the breakpoint is kept and - means the sequence to the right is kept, so deletions are +- and
+// tandem duplications are -+. Support is the number of reads, counting each read name once.
+type StructuralVariant struct {
+	Type       string
+	Chrom      string
+	Pos        int
+	MateChrom  string
+	End        int
+	Strands    string
+	Discordant int
+	Split      int
+	Clipped    int
+	Precise    bool
+}
+
+// SvCaller finds structural variants from discordant read pairs, split reads with SA tags or long deletions in the
+// cigar, and clusters of soft clipped reads. Every record is given to Add and Finish returns the calls. Records can
+// be in any order, only the evidence of reads that are not aligned as expected is kept in memory.
+type SvCaller struct {
+	settings SvSettings
+	chroms   *chromIndex
+	inserts  map[int]int
+	clips    map[svBreakend]int
+	evidence []svEvidence
+}
+
+// svBreakend is one side of a junction. Pos is the last base before the junction and strand is + when the sequence
+// to the left is kept.
+type svBreakend struct {
+	chrom  int
+	pos    int
+	strand byte
+}
+
+// svEvidence joins two breakends, with the left most breakend first.
+type svEvidence struct {
+	first  svBreakend
+	second svBreakend
+	name   string
+	split  bool
+	insert int
+}
+
+// svKey groups evidence of the same kind of variant.
+type svKey struct {
+	first   int
+	second  int
+	strands [2]byte
+}
+
+// svSegment is a local alignment of part of a read. QueryStart is counted from the start of the read as sequenced.
+type svSegment struct {
+	chrom      int
+	start      int
+	end        int
+	reverse    bool
+	queryStart int
+}
+
+// NewSvCaller will allocate memory to call structural variants from records described by header.
+func NewSvCaller(header *Header, settings SvSettings) *SvCaller {
+	return &SvCaller{
+		settings: settings,
+		chroms:   newChromIndex(header),
+		inserts:  make(map[int]int),
+		clips:    make(map[svBreakend]int),
+	}
+}
+
+// CallSvsFile will read a sam or bam file and return its structural variants.
+func CallSvsFile(filename string, settings SvSettings) (*Header, []StructuralVariant) {
+	header, records := Read(filename)
+	caller := NewSvCaller(header, settings)
+	for i := range records {
+		caller.Add(&i)
+	}
+	return header, caller.Finish()
+}
+
+// Add will record the evidence of a single alignment. Unmapped, secondary, duplicate and low quality records are skipped.
+func (c *SvCaller) Add(s *Sam) {
+	if s.IsUnmapped() || s.IsSecondary() || s.IsDuplicate() || s.IsQcFail() || s.MapQ < c.settings.MinMapQ {
+		return
+	}
+	chrom := c.chroms.id(s.RName)
+	left, right := SoftClipLengths(s.Cigar)
+	if left > 0 && left >= c.settings.MinClip {
+		c.clips[svBreakend{chrom: chrom, pos: s.Pos - 1, strand: '-'}]++
+	}
+	if right > 0 && right >= c.settings.MinClip {
+		c.clips[svBreakend{chrom: chrom, pos: s.RefEnd(), strand: '+'}]++
+	}
+	if s.IsSupplementary() {
+		return
+	}
+	c.addDeletions(s, chrom)
+	c.addSplit(s, chrom)
+	c.addPair(s, chrom)
+}
+
+// addDeletions will record deletions in the cigar that are long enough to be called as structural variants.
+func (c *SvCaller) addDeletions(s *Sam, chrom int) {
+	refPos := s.Pos
+	for _, op := range s.Cigar {
+		if op.Op == Deletion && int(op.RunLen) >= c.settings.MinSvLen {
+			c.add(svBreakend{chrom: chrom, pos: refPos - 1, strand: '+'}, svBreakend{chrom: chrom, pos: refPos + int(op.RunLen) - 1, strand: '-'}, s.QName, true, 0)
+		}
+		if ConsumesReference(op.Op) {
+			refPos += int(op.RunLen)
+		}
+	}
+}
+
+// addSplit will join the neighboring local alignments of a read with supplementary alignments listed in the SA tag.
+func (c *SvCaller) addSplit(s *Sam, chrom int) {
+	sa, ok := s.Tag("SA")
+	if !ok {
+		return
+	}
+	text, _ := sa.Value().(string)
+	segments := []svSegment{newSvSegment(chrom, s.Pos, s.Cigar, s.IsReverse())}
+	for _, field := range strings.Split(strings.TrimSuffix(text, ";"), ";") {
+		words := strings.Split(field, ",")
+		if len(words) < 6 || simpleio.StringToInt(words[4]) < int(c.settings.MinMapQ) {
+			continue
+		}
+		segments = append(segments, newSvSegment(c.chroms.id(words[0]), simpleio.StringToInt(words[1]), ReadToBytesCigar([]byte(words[3])), words[2] == "-"))
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].queryStart < segments[j].queryStart })
+	for i := 1; i < len(segments); i++ {
+		a, b := segments[i-1], segments[i]
+		first, second := svBreakend{chrom: a.chrom, pos: a.end, strand: '+'}, svBreakend{chrom: b.chrom, pos: b.start - 1, strand: '-'}
+		if a.reverse {
+			first = svBreakend{chrom: a.chrom, pos: a.start - 1, strand: '-'}
+		}
+		if b.reverse {
+			second = svBreakend{chrom: b.chrom, pos: b.end, strand: '+'}
+		}
+		c.add(first, second, s.QName, true, 0)
+	}
+}
+
+func newSvSegment(chrom int, pos int, cigar []ByteCigar, reverse bool) svSegment {
+	left, right := ClipLengths(cigar)
+	ans := svSegment{chrom: chrom, start: pos, end: pos + alignedLength(cigar) - 1, reverse: reverse, queryStart: left}
+	if reverse {
+		ans.queryStart = right
+	}
+	return ans
+}
+
+// addPair will record read pairs that are not in forward-reverse orientation on the same reference, along with
+// forward-reverse pairs that may have a long insert. Only the left most read of a pair is used.
+func (c *SvCaller) addPair(s *Sam, chrom int) {
+	if !s.IsPaired() || s.IsMateUnmapped() || s.MateRef == "*" {
+		return
+	}
+	mateChrom := chrom
+	if s.MateRef != "=" {
+		mateChrom = c.chroms.id(s.MateRef)
+	}
+	if mateChrom < chrom || mateChrom == chrom && (s.MatePos < s.Pos || s.MatePos == s.Pos && !s.IsRead1()) {
+		return
+	}
+	first := svBreakend{chrom: chrom, pos: s.RefEnd(), strand: '+'}
+	if s.IsReverse() {
+		first = svBreakend{chrom: chrom, pos: s.Pos - 1, strand: '-'}
+	}
+	second := svBreakend{chrom: mateChrom, pos: s.MatePos - 1, strand: '-'}
+	if !s.IsMateReverse() {
+		mateLength := alignedLength(s.Cigar)
+		if mc, found := s.Tag("MC"); found {
+			if text, isString := mc.Value().(string); isString {
+				mateLength = alignedLength(ReadToBytesCigar([]byte(text)))
+			}
+		}
+		second = svBreakend{chrom: mateChrom, pos: s.MatePos + mateLength - 1, strand: '+'}
+	}
+	insert := absInt(s.TmpLen)
+	if chrom == mateChrom && first.strand == '+' && second.strand == '-' {
+		if s.IsProperPair() {
+			c.inserts[insert]++
+			if c.settings.MaxInsert == 0 || insert <= c.settings.MaxInsert {
+				return
+			}
+		}
+	}
+	c.add(first, second, s.QName, false, insert)
+}
+
+// add will record evidence joining two breakends.
+func (c *SvCaller) add(first svBreakend, second svBreakend, name string, split bool, insert int) {
+	if second.chrom < first.chrom || second.chrom == first.chrom && second.pos < first.pos {
+		first, second = second, first
+	}
+	c.evidence = append(c.evidence, svEvidence{first: first, second: second, name: name, split: split, insert: insert})
+}
+
+// InsertCutoff returns the longest insert of a concordant forward-reverse pair. Unless MaxInsert is set, this is
+// the median insert of proper pairs plus five scaled median absolute deviations.
+func (c *SvCaller) InsertCutoff() int {
+	if c.settings.MaxInsert > 0 {
+		return c.settings.MaxInsert
+	}
+	if len(c.inserts) == 0 {
+		return defaultSvMaxInsert
+	}
+	median := histogramMedian(c.inserts)
+	deviations := make(map[int]int)
+	for size, count := range c.inserts {
+		deviations[absInt(size-median)] += count
+	}
+	return median + int(math.Ceil(svInsertMads*1.4826*float64(histogramMedian(deviations))))
+}
+
+// histogramMedian returns the lower median of values counted in a histogram.
+func histogramMedian(counts map[int]int) int {
+	values := make([]int, 0, len(counts))
+	var total int
+	for v, n := range counts {
+		values = append(values, v)
+		total += n
+	}
+	sort.Ints(values)
+	var seen int
+	for _, v := range values {
+		seen += counts[v]
+		if 2*seen >= total {
+			return v
+		}
+	}
+	return 0
+}
+
+// Finish will cluster the evidence and return the variants with enough support sorted by position. Breakpoints are
+// the median of split reads when there are any, otherwise the inner most read ends of the discordant pairs are
+// moved to the best supported soft clip position within the window.
+func (c *SvCaller) Finish() []StructuralVariant {
+	cutoff := c.InsertCutoff()
+	window := c.settings.Window
+	if window == 0 {
+		window = cutoff
+	}
+	groups := make(map[svKey][]svEvidence)
+	for _, e := range c.evidence {
+		if !e.split && e.first.chrom == e.second.chrom && e.first.strand == '+' && e.second.strand == '-' && e.insert <= cutoff {
+			continue
+		}
+		key := svKey{first: e.first.chrom, second: e.second.chrom, strands: [2]byte{e.first.strand, e.second.strand}}
+		groups[key] = append(groups[key], e)
+	}
+	var ans []StructuralVariant
+	for key, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].first.pos != group[j].first.pos {
+				return group[i].first.pos < group[j].first.pos
+			}
+			return group[i].second.pos < group[j].second.pos
+		})
+		var clusters [][]svEvidence
+		var open int
+		for _, e := range group {
+			for open < len(clusters) && e.first.pos-clusters[open][0].first.pos > window {
+				open++
+			}
+			joined := false
+			for k := open; k < len(clusters) && !joined; k++ {
+				if absInt(e.second.pos-clusters[k][0].second.pos) <= window {
+					clusters[k] = append(clusters[k], e)
+					joined = true
+				}
+			}
+			if !joined {
+				clusters = append(clusters, []svEvidence{e})
+			}
+		}
+		for _, cluster := range clusters {
+			if sv, ok := c.call(key, cluster, window); ok {
+				ans = append(ans, sv)
+			}
+		}
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		a, b := c.chroms.ids[ans[i].Chrom], c.chroms.ids[ans[j].Chrom]
+		if a != b {
+			return a < b
+		}
+		if ans[i].Pos != ans[j].Pos {
+			return ans[i].Pos < ans[j].Pos
+		}
+		return ans[i].End < ans[j].End
+	})
+	return ans
+}
+
+// call will place the breakpoints of a cluster of evidence and count its support.
+func (c *SvCaller) call(key svKey, cluster []svEvidence, window int) (StructuralVariant, bool) {
+	split, discordant := make(map[string]bool), make(map[string]bool)
+	var firsts, seconds []int
+	for _, e := range cluster {
+		if e.split {
+			split[e.name] = true
+			firsts, seconds = append(firsts, e.first.pos), append(seconds, e.second.pos)
+		} else {
+			discordant[e.name] = true
+		}
+	}
+	if len(split)+len(discordant) < c.settings.MinSupport {
+		return StructuralVariant{}, false
+	}
+	first, second := cluster[0].first, cluster[0].second
+	if len(firsts) > 0 {
+		sort.Ints(firsts)
+		sort.Ints(seconds)
+		first.pos, second.pos = firsts[(len(firsts)-1)/2], seconds[(len(seconds)-1)/2]
+	} else {
+		for _, e := range cluster {
+			first.pos = innerBreakpoint(first, e.first.pos)
+			second.pos = innerBreakpoint(second, e.second.pos)
+		}
+		first.pos, second.pos = c.snapToClips(first, window), c.snapToClips(second, window)
+	}
+	ans := StructuralVariant{
+		Chrom:      c.chroms.names[first.chrom],
+		Pos:        first.pos,
+		MateChrom:  c.chroms.names[second.chrom],
+		End:        second.pos,
+		Strands:    string(key.strands[:]),
+		Discordant: len(discordant),
+		Split:      len(split),
+		Clipped:    c.clipped(first) + c.clipped(second),
+		Precise:    len(split) > 0,
+	}
+	switch {
+	case first.chrom != second.chrom:
+		ans.Type = SvTranslocation
+	case ans.Strands == "+-":
+		ans.Type = SvDeletion
+	case ans.Strands == "-+":
+		ans.Type = SvDuplication
+	default:
+		ans.Type = SvInversion
+	}
+	if ans.Type != SvTranslocation && ans.Len() < c.settings.MinSvLen {
+		return ans, false
+	}
+	return ans, true
+}
+
+// innerBreakpoint will update the position of a breakend from the end of a discordant read. The junction must be
+// to the right of every read keeping the left sequence and to the left of every read keeping the right sequence.
+func innerBreakpoint(b svBreakend, pos int) int {
+	if b.strand == '+' && pos > b.pos || b.strand == '-' && pos < b.pos {
+		return pos
+	}
+	return b.pos
+}
+
+// snapToClips returns the soft clip position with the most reads on the junction side of a breakend, or the
+// breakend position when there are no clipped reads within the window.
+func (c *SvCaller) snapToClips(b svBreakend, window int) int {
+	step := 1
+	if b.strand == '-' {
+		step = -1
+	}
+	best, bestCount := b.pos, 0
+	for k := 0; k <= window; k++ {
+		pos := b.pos + step*k
+		if n := c.clips[svBreakend{chrom: b.chrom, pos: pos, strand: b.strand}]; n > bestCount {
+			best, bestCount = pos, n
+		}
+	}
+	return best
+}
+
+// clipped returns the number of soft clipped reads ending near a breakend.
+func (c *SvCaller) clipped(b svBreakend) int {
+	var ans int
+	for pos := b.pos - svClipSlop; pos <= b.pos+svClipSlop; pos++ {
+		ans += c.clips[svBreakend{chrom: b.chrom, pos: pos, strand: b.strand}]
+	}
+	return ans
+}
+
+// Len returns the number of reference bases between the two breakpoints, or zero for translocations.
+func (sv *StructuralVariant) Len() int {
+	if sv.Chrom != sv.MateChrom {
+		return 0
+	}
+	return sv.End - sv.Pos
+}
+
+// Support returns the number of discordant pairs and split reads supporting a variant.
+func (sv *StructuralVariant) Support() int {
+	return sv.Discordant + sv.Split
+}
+
+// ToBed will convert a structural variant to a bed record with zero-based coordinates. Translocations use the
+// query fields for the breakpoint on the mate reference. The name lists the type and supporting read counts.
+func (sv *StructuralVariant) ToBed() bed.StructureVariance {
+	ans := bed.StructureVariance{
+		Name:   fmt.Sprintf("%s;PE=%d;SR=%d;SC=%d", sv.Type, sv.Discordant, sv.Split, sv.Clipped),
+		Len:    sv.Len(),
+		TName:  sv.Chrom,
+		TStart: sv.Pos,
+		TEnd:   sv.End,
+		QName:  sv.MateChrom,
+		QStart: sv.Pos,
+		QEnd:   sv.End,
+	}
+	if sv.Type == SvTranslocation {
+		ans.TEnd, ans.QStart, ans.QEnd = sv.Pos+1, sv.End, sv.End+1
+	}
+	return ans
+}
+
+// ToVcf will convert a structural variant to a vcf record with a symbolic allele, or a breakend allele for
+// translocations. REF is the base of ref at POS, or N when the reference does not include it, and the genotype is
+// left missing.
+func (sv *StructuralVariant) ToVcf(ref map[string][]code.Dna) vcf.Vcf {
+	ans := vcf.Vcf{Chr: sv.Chrom, Pos: sv.Pos, Id: ".", Alt: "<" + sv.Type + ">", NoQual: true, Filter: "PASS",
+		Format: []string{"GT", "PE", "SR", "SC"}, Genotypes: []string{fmt.Sprintf("./.:%d:%d:%d", sv.Discordant, sv.Split, sv.Clipped)}}
+	var info strings.Builder
+	if !sv.Precise {
+		info.WriteString("IMPRECISE;")
+	}
+	fmt.Fprintf(&info, "SVTYPE=%s;", sv.Type)
+	switch sv.Type {
+	case SvTranslocation:
+		fmt.Fprintf(&info, "CHR2=%s;", sv.MateChrom)
+		if sv.Strands[0] == '-' {
+			ans.Pos++
+		}
+		ans.Ref = refBase(ref, sv.Chrom, ans.Pos)
+		ans.Alt = breakendAllele(sv, ans.Ref)
+	case SvDeletion:
+		fmt.Fprintf(&info, "END=%d;SVLEN=%d;", sv.End, -sv.Len())
+	default:
+		fmt.Fprintf(&info, "END=%d;SVLEN=%d;", sv.End, sv.Len())
+	}
+	fmt.Fprintf(&info, "STRANDS=%s;SU=%d;PE=%d;SR=%d;SC=%d", sv.Strands, sv.Support(), sv.Discordant, sv.Split, sv.Clipped)
+	ans.Info = info.String()
+	if ans.Ref == "" {
+		ans.Ref = refBase(ref, sv.Chrom, ans.Pos)
+	}
+	return ans
+}
+
+// refBase returns the upper case base at a one-based position of a reference, or N if the position is not part of ref.
+func refBase(ref map[string][]code.Dna, chrom string, pos int) string {
+	if seq, found := ref[chrom]; found && pos >= 1 && pos <= len(seq) {
+		return code.ToUpperString(seq[pos-1 : pos])
+	}
+	return "N"
+}
+
+// breakendAllele returns the vcf bracket notation of a translocation, such as A[chr2:100[ for sequence to the left
+// of Pos joined to sequence to the right of End on the mate reference, where base is the reference base at Pos.
+func breakendAllele(sv *StructuralVariant, base string) string {
+	mate := fmt.Sprintf("%s:%d", sv.MateChrom, sv.End)
+	if sv.Strands[1] == '-' {
+		mate = fmt.Sprintf("[%s:%d[", sv.MateChrom, sv.End+1)
+	} else {
+		mate = fmt.Sprintf("]%s]", mate)
+	}
+	if sv.Strands[0] == '+' {
+		return base + mate
+	}
+	return mate + base
+}
+
+// SvVcfHeader returns the vcf header lines describing the fields written by ToVcf, with one sample column.
+func SvVcfHeader(header *Header, sample string) string {
+	var ans strings.Builder
+	writeVcfContigs(&ans, header)
+	ans.WriteString("##ALT=<ID=DEL,Description=\"Deletion\">\n" +
+		"##ALT=<ID=DUP,Description=\"Tandem duplication\">\n" +
+		"##ALT=<ID=INV,Description=\"Inversion\">\n" +
+		"##INFO=<ID=IMPRECISE,Number=0,Type=Flag,Description=\"Breakpoints were estimated from discordant pairs\">\n" +
+		"##INFO=<ID=SVTYPE,Number=1,Type=String,Description=\"Type of structural variant\">\n" +
+		"##INFO=<ID=END,Number=1,Type=Integer,Description=\"End position of the variant\">\n" +
+		"##INFO=<ID=SVLEN,Number=1,Type=Integer,Description=\"Difference in length between the reference and alternate alleles\">\n" +
+		"##INFO=<ID=CHR2,Number=1,Type=String,Description=\"Reference of the second breakpoint of a translocation\">\n" +
+		"##INFO=<ID=STRANDS,Number=1,Type=String,Description=\"Sides of the breakpoints joined by the variant\">\n" +
+		"##INFO=<ID=SU,Number=1,Type=Integer,Description=\"Number of discordant pairs and split reads supporting the variant\">\n" +
+		"##INFO=<ID=PE,Number=1,Type=Integer,Description=\"Number of discordant pairs supporting the variant\">\n" +
+		"##INFO=<ID=SR,Number=1,Type=Integer,Description=\"Number of split reads supporting the variant\">\n" +
+		"##INFO=<ID=SC,Number=1,Type=Integer,Description=\"Number of soft clipped reads at the breakpoints\">\n" +
+		"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n" +
+		"##FORMAT=<ID=PE,Number=1,Type=Integer,Description=\"Number of discordant pairs supporting the variant\">\n" +
+		"##FORMAT=<ID=SR,Number=1,Type=Integer,Description=\"Number of split reads supporting the variant\">\n" +
+		"##FORMAT=<ID=SC,Number=1,Type=Integer,Description=\"Number of soft clipped reads at the breakpoints\">\n")
+	fmt.Fprintf(&ans, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s\n", sample)
+	return ans.String()
+}
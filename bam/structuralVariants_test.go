@@ -0,0 +1,84 @@
+package bam
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/edotau/goFish/bed"
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/vcf"
+)
+
+// svTestReads has proper pairs with inserts of 280-320 and the evidence of a deletion, inversion, translocation,
+// tandem duplication and a deletion within the cigar.
+func svTestReads() []*Sam {
+	var ans []*Sam
+	for i := 0; i < 50; i++ {
+		pos, tlen := 1000+i*100, 280+(i%5)*10
+		ans = append(ans, ParseSam(fmt.Sprintf("proper%d\t99\tchr1\t%d\t60\t100M\t=\t%d\t%d\t*\t*", i, pos, pos+tlen-100, tlen)),
+			ParseSam(fmt.Sprintf("proper%d\t147\tchr1\t%d\t60\t100M\t=\t%d\t%d\t*\t*", i, pos+tlen-100, pos, -tlen)))
+	}
+	for i := 0; i < 4; i++ {
+		ans = append(ans, ParseSam(fmt.Sprintf("del%d\t97\tchr1\t%d\t60\t100M\t=\t%d\t1300\t*\t*", i, 9800+i*20, 11001+i*20)),
+			ParseSam(fmt.Sprintf("inv%d\t65\tchr1\t%d\t60\t100M\t=\t%d\t5100\t*\t*", i, 29800+i*20, 34800+i*20)),
+			ParseSam(fmt.Sprintf("tra%d\t97\tchr1\t%d\t60\t100M\tchr2\t%d\t0\t*\t*", i, 49800+i*20, 20001+i*20)),
+			ParseSam(fmt.Sprintf("dup%d\t81\tchr1\t%d\t60\t100M\t=\t%d\t900\t*\t*", i, 60000+i*20, 60800+i*20)))
+	}
+	for i := 0; i < 2; i++ {
+		ans = append(ans, ParseSam(fmt.Sprintf("split%d\t0\tchr1\t9951\t60\t50M50S\t*\t0\t0\t*\t*\tSA:Z:chr1,11001,+,50S50M,60,0;", i)),
+			ParseSam(fmt.Sprintf("split%d\t2048\tchr1\t11001\t60\t50H50M\t*\t0\t0\t*\t*\tSA:Z:chr1,9951,+,50M50S,60,0;", i)))
+	}
+	for i := 0; i < 3; i++ {
+		ans = append(ans, ParseSam(fmt.Sprintf("long%d\t0\tchr2\t40001\t60\t50M200D50M\t*\t0\t0\t*\t*", i)))
+	}
+	return append(ans,
+		// a soft clip near the inversion moves the imprecise breakpoint
+		ParseSam("clip\t0\tchr1\t29951\t60\t40M60S\t*\t0\t0\t*\t*"),
+		// a single discordant pair and a low quality read are not enough to call a variant
+		ParseSam("single\t65\tchr1\t80000\t60\t100M\t=\t85000\t5100\t*\t*"),
+		ParseSam("lowq\t65\tchr1\t80020\t5\t100M\t=\t85020\t0\t*\t*"))
+}
+
+func TestCallSvs(t *testing.T) {
+	header := &Header{Chroms: []ChromSize{{Name: "chr1", Size: 100000}, {Name: "chr2", Size: 100000, Order: 1}}}
+	caller := NewSvCaller(header, DefaultSvSettings)
+	for _, s := range svTestReads() {
+		caller.Add(s)
+	}
+	if cutoff := caller.InsertCutoff(); cutoff != 375 {
+		t.Errorf("Error: insert size cutoff is %d, expected 375...\n", cutoff)
+	}
+	expected := []StructuralVariant{
+		{Type: SvDeletion, Chrom: "chr1", Pos: 10000, MateChrom: "chr1", End: 11000, Strands: "+-", Discordant: 4, Split: 2, Clipped: 2, Precise: true},
+		{Type: SvInversion, Chrom: "chr1", Pos: 29990, MateChrom: "chr1", End: 34959, Strands: "++", Discordant: 4, Clipped: 1},
+		{Type: SvTranslocation, Chrom: "chr1", Pos: 49959, MateChrom: "chr2", End: 20000, Strands: "+-", Discordant: 4},
+		{Type: SvDuplication, Chrom: "chr1", Pos: 59999, MateChrom: "chr1", End: 60959, Strands: "-+", Discordant: 4},
+		{Type: SvDeletion, Chrom: "chr2", Pos: 40050, MateChrom: "chr2", End: 40250, Strands: "+-", Split: 3, Precise: true},
+	}
+	calls := caller.Finish()
+	if len(calls) != len(expected) {
+		t.Fatalf("Error: found %d structural variants, expected %d: %v\n", len(calls), len(expected), calls)
+	}
+	for i := range calls {
+		if calls[i] != expected[i] {
+			t.Errorf("Error: structural variant %d is\n%+v\nexpected\n%+v\n", i, calls[i], expected[i])
+		}
+	}
+
+	// bases of the reference repeat ACGT, so positions 10000 and 49959 are T and G
+	ref := map[string][]code.Dna{"chr1": code.ToDna([]byte(strings.Repeat("ACGT", 25000)))}
+	record := calls[0].ToVcf(ref)
+	if ans := vcf.ToString(&record); ans != "chr1\t10000\t.\tT\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=11000;SVLEN=-1000;STRANDS=+-;SU=6;PE=4;SR=2;SC=2\tGT:PE:SR:SC\t./.:4:2:2" {
+		t.Errorf("Error: unexpected vcf record %s\n", ans)
+	}
+	if record = calls[2].ToVcf(ref); record.Ref != "G" || record.Alt != "G[chr2:20001[" || record.Info != "IMPRECISE;SVTYPE=BND;CHR2=chr2;STRANDS=+-;SU=4;PE=4;SR=0;SC=0" {
+		t.Errorf("Error: unexpected translocation allele %s and info %s\n", record.Alt, record.Info)
+	}
+	if record = calls[4].ToVcf(ref); record.Ref != "N" {
+		t.Errorf("Error: REF of a variant on a chromosome missing from the reference is %s, expected N\n", record.Ref)
+	}
+	if ans := bed.SvToString(calls[3].ToBed()); ans != "chr1\t59999\t60959\tchr1\t59999\t60959\t960\tDUP;PE=4;SR=0;SC=0" {
+		t.Errorf("Error: unexpected bed record %s\n", ans)
+	}
+}
@@ -0,0 +1,116 @@
+package bam
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fastq"
+)
+
+// FastqSettings controls how read names are restored. Tags lists auxiliary fields, such as barcodes and UMIs, that
+// are appended to the read name as tab separated sam fields, the same comment format written by samtools fastq -T
+// and restored by bwa mem -C. ReadSuffix will add /1 and /2 to the names of paired reads.
+type FastqSettings struct {
+	Tags       []string
+	ReadSuffix bool
+}
+
+// missingFastqQual is used for every base of reads without base qualities, the same default as samtools fastq.
+const missingFastqQual byte = '"'
+
+// bufferedMate is a read waiting for its mate, order is used to report reads without mates in the order they were found.
+type bufferedMate struct {
+	fq    fastq.Fastq
+	read  int
+	order int
+}
+
+// SamToFastq will restore the original read of an alignment. Reads aligned to the reverse strand are reverse
+// complemented and their base qualities reversed, so the fastq matches the sequencer output.
+func SamToFastq(s *Sam, settings FastqSettings) fastq.Fastq {
+	ans := fastq.Fastq{Name: s.QName, Seq: make([]code.Dna, len(s.Seq)), Qual: make([]byte, len(s.Seq))}
+	copy(ans.Seq, s.Seq)
+	if len(s.Qual) == len(s.Seq) {
+		copy(ans.Qual, s.Qual)
+	} else {
+		for i := range ans.Qual {
+			ans.Qual[i] = missingFastqQual
+		}
+	}
+	if s.IsReverse() {
+		code.ReverseComplement(ans.Seq)
+		for i, j := 0, len(ans.Qual)-1; i < j; i, j = i+1, j-1 {
+			ans.Qual[i], ans.Qual[j] = ans.Qual[j], ans.Qual[i]
+		}
+	}
+	if settings.ReadSuffix && s.IsPaired() {
+		if s.IsRead1() {
+			ans.Name += "/1"
+		} else if s.IsRead2() {
+			ans.Name += "/2"
+		}
+	}
+	if len(settings.Tags) > 0 {
+		var name strings.Builder
+		name.WriteString(ans.Name)
+		for _, tag := range settings.Tags {
+			if aux, ok := s.Tag(tag); ok {
+				name.WriteByte('\t')
+				name.WriteString(aux.ToString())
+			}
+		}
+		ans.Name = name.String()
+	}
+	return ans
+}
+
+// ToFastq will convert alignments back to reads, skipping secondary and supplementary records. Pairs are sent once
+// both mates are found, so the first and second reads stay synchronized for any input order; mates are buffered in
+// memory until they are found, which only requires a few reads for name grouped input. Single end reads and reads
+// whose mate is missing from the input are sent with an empty ReadTwo.
+func ToFastq(records <-chan Sam, settings FastqSettings) <-chan fastq.PairedEnd {
+	ans := make(chan fastq.PairedEnd, 1000)
+	go func() {
+		mates := make(map[string]*bufferedMate)
+		var order int
+		for i := range records {
+			if i.IsSecondary() || i.IsSupplementary() {
+				continue
+			}
+			fq := SamToFastq(&i, settings)
+			read := readNumber(&i)
+			if !i.IsPaired() || read == 0 {
+				ans <- fastq.PairedEnd{ReadOne: fq}
+				continue
+			}
+			mate, ok := mates[i.QName]
+			if !ok || mate.read == read {
+				order++
+				mates[i.QName] = &bufferedMate{fq: fq, read: read, order: order}
+				continue
+			}
+			delete(mates, i.QName)
+			if read == 1 {
+				ans <- fastq.PairedEnd{ReadOne: fq, ReadTwo: mate.fq}
+			} else {
+				ans <- fastq.PairedEnd{ReadOne: mate.fq, ReadTwo: fq}
+			}
+		}
+		orphans := make([]*bufferedMate, 0, len(mates))
+		for _, mate := range mates {
+			orphans = append(orphans, mate)
+		}
+		sort.Slice(orphans, func(i, j int) bool { return orphans[i].order < orphans[j].order })
+		for _, mate := range orphans {
+			ans <- fastq.PairedEnd{ReadOne: mate.fq}
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// IsSingleton returns true if a read from ToFastq does not have a mate.
+func IsSingleton(pair *fastq.PairedEnd) bool {
+	return pair.ReadTwo.Seq == nil && pair.ReadTwo.Name == ""
+}
@@ -0,0 +1,67 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fastq"
+)
+
+func TestToFastq(t *testing.T) {
+	records := make(chan Sam, 10)
+	// coordinate sorted input: the second read of pair a is found before the first read
+	records <- Sam{QName: "a", Flag: FlagPaired | FlagRead2 | FlagReverse, RName: "chr1", Pos: 5, Seq: code.ToDna([]byte("AACGT")), Qual: []byte("ABCDE"), Aux: "BX:Z:ACGT-1\tUB:Z:TTT"}
+	records <- Sam{QName: "b", Flag: 0, RName: "chr1", Pos: 7, Seq: code.ToDna([]byte("GGC")), Qual: []byte("*")}
+	records <- Sam{QName: "a", Flag: FlagPaired | FlagRead2 | FlagReverse | FlagSupplementary, RName: "chr2", Pos: 1, Seq: code.ToDna([]byte("AA")), Qual: []byte("AB")}
+	records <- Sam{QName: "c", Flag: FlagPaired | FlagRead1, RName: "chr1", Pos: 9, Seq: code.ToDna([]byte("TTT")), Qual: []byte("FFF")}
+	records <- Sam{QName: "a", Flag: FlagPaired | FlagRead1 | FlagMateReverse, RName: "chr1", Pos: 10, Seq: code.ToDna([]byte("GATTACA")), Qual: []byte("IIIIIII"), Aux: "BX:Z:ACGT-1"}
+	records <- Sam{QName: "a", Flag: FlagPaired | FlagRead1 | FlagSecondary, RName: "chr3", Pos: 10, Seq: code.ToDna([]byte("GATTACA")), Qual: []byte("IIIIIII")}
+	close(records)
+
+	expected := []fastq.PairedEnd{
+		{ReadOne: fastq.Fastq{Name: "b", Seq: code.ToDna([]byte("GGC")), Qual: []byte(`"""`)}},
+		{ReadOne: fastq.Fastq{Name: "a/1\tBX:Z:ACGT-1", Seq: code.ToDna([]byte("GATTACA")), Qual: []byte("IIIIIII")},
+			ReadTwo: fastq.Fastq{Name: "a/2\tBX:Z:ACGT-1\tUB:Z:TTT", Seq: code.ToDna([]byte("ACGTT")), Qual: []byte("EDCBA")}},
+		{ReadOne: fastq.Fastq{Name: "c/1", Seq: code.ToDna([]byte("TTT")), Qual: []byte("FFF")}},
+	}
+	var i int
+	for pair := range ToFastq(records, FastqSettings{Tags: []string{"BX", "UB"}, ReadSuffix: true}) {
+		if i >= len(expected) {
+			t.Fatalf("Error: found more than %d reads...\n", len(expected))
+		}
+		if IsSingleton(&pair) != IsSingleton(&expected[i]) || !fastq.Equal(&pair.ReadOne, &expected[i].ReadOne) ||
+			!IsSingleton(&pair) && !fastq.Equal(&pair.ReadTwo, &expected[i].ReadTwo) {
+			t.Errorf("Error: read %d %s%s does not match %s%s...\n", i, pair.ReadOne.ToString(), pair.ReadTwo.ToString(),
+				expected[i].ReadOne.ToString(), expected[i].ReadTwo.ToString())
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Error: expected %d reads, found %d...\n", len(expected), i)
+	}
+}
+
+// TestToFastqFile checks that each primary alignment of the test file is written exactly once.
+func TestToFastqFile(t *testing.T) {
+	for _, test := range readBamTests {
+		var primary, reads int
+		for _, s := range ReadSamRecord(test.sam) {
+			if !s.IsSecondary() && !s.IsSupplementary() {
+				primary++
+			}
+		}
+		_, records := Read(test.bam)
+		for pair := range ToFastq(records, FastqSettings{}) {
+			reads++
+			if !IsSingleton(&pair) {
+				reads++
+				if pair.ReadOne.Name != pair.ReadTwo.Name {
+					t.Errorf("Error: %s was paired with %s...\n", pair.ReadOne.Name, pair.ReadTwo.Name)
+				}
+			}
+		}
+		if reads != primary {
+			t.Errorf("Error: expected %d reads from %s, found %d...\n", primary, test.bam, reads)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package bam
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+)
+
+// ViewSettings controls how RenderView draws alignments. Width is the number of text columns. Qual replaces each
+// aligned base with its quality divided by ten, Bases draws every base instead of using . and , for bases that match
+// the reference, and Color highlights mismatches and insertions with ansi escape codes.
+type ViewSettings struct {
+	Width int
+	Qual  bool
+	Bases bool
+	Color bool
+}
+
+// DefaultViewSettings draws 80 columns of plain text.
+var DefaultViewSettings ViewSettings = ViewSettings{Width: 80}
+
+// ViewHeaderLines is the number of lines drawn by RenderView before the first row of reads.
+const ViewHeaderLines int = 3
+
+const (
+	ansiMismatch  string = "\x1b[1;31m"
+	ansiInsertion string = "\x1b[35m"
+	ansiReset     string = "\x1b[0m"
+)
+
+// viewCell is a single character of the view and the ansi color used to draw it.
+type viewCell struct {
+	char  byte
+	color string
+}
+
+// RenderView will draw alignments starting at the zero-based position start in the style of samtools tview. The
+// first lines are the region, a ruler marking every tenth position and the reference, followed by the reads packed
+// into rows. Reads matching the reference are drawn as . on the forward strand and , on the reverse strand, while
+// mismatches are drawn as upper or lower case bases. Deletions are *, skipped regions are > or <, and columns are
+// added for insertions, which are drawn in the reads with an insertion and as * everywhere else. When ref is nil
+// the most common base of the reads at each position is used as the reference.
+func RenderView(chrom string, start int, ref []code.Dna, records []Sam, settings ViewSettings) []string {
+	if start < 0 {
+		start = 0
+	}
+	insertions := viewInsertions(records, start, start+settings.Width)
+	var columns []int
+	for p, col := start, 0; col < settings.Width; p++ {
+		columns = append(columns, col)
+		col += 1 + insertions[p]
+	}
+	end := start + len(columns)
+	column := func(refPos int) int {
+		if refPos < start || refPos >= end {
+			return -1
+		}
+		return columns[refPos-start]
+	}
+
+	var offset int
+	if ref == nil {
+		ref, offset = viewConsensus(records, start, end), start
+	}
+	refBase := func(refPos int) code.Dna {
+		if i := refPos - offset; i >= 0 && i < len(ref) {
+			return code.ToUpper(ref[i])
+		}
+		return code.N
+	}
+	ruler, reference := newViewLine(settings.Width), newViewLine(settings.Width)
+	for p := start; p < end; p++ {
+		col := columns[p-start]
+		if (p+1)%10 == 0 {
+			label := strconv.Itoa(p + 1)
+			if (col == 0 || ruler[col-1].char == ' ') && col+len(label) <= len(ruler) {
+				for k := 0; k < len(label); k++ {
+					ruler[col+k].char = label[k]
+				}
+			}
+		}
+		reference[col].char = byte(refBase(p))
+		for k := 1; k <= insertions[p] && col+k < len(reference); k++ {
+			reference[col+k].char = '*'
+		}
+	}
+
+	var rows [][]viewCell
+	var rowEnds []int
+	for i := range records {
+		s := &records[i]
+		first, last := s.Pos-1, s.RefEnd()
+		if s.IsUnmapped() || last <= start || first >= end {
+			continue
+		}
+		row := 0
+		for row < len(rowEnds) && rowEnds[row] >= first {
+			row++
+		}
+		if row == len(rows) {
+			rows, rowEnds = append(rows, newViewLine(settings.Width)), append(rowEnds, 0)
+		}
+		rowEnds[row] = last
+		drawRead(rows[row], s, refBase, column, insertions, settings)
+	}
+
+	ans := []string{fmt.Sprintf("%s:%d-%d", chrom, start+1, end), viewLineString(ruler, false), viewLineString(reference, false)}
+	for _, row := range rows {
+		ans = append(ans, viewLineString(row, settings.Color))
+	}
+	return ans
+}
+
+// ViewWidth returns the number of columns RenderView needs to draw every reference position from the zero-based
+// start to end, including the columns added for insertions.
+func ViewWidth(records []Sam, start int, end int) int {
+	ans := end - start
+	for _, n := range viewInsertions(records, start, end) {
+		ans += n
+	}
+	return ans
+}
+
+// viewInsertions returns the length of the longest insertion after each zero-based reference position from start to
+// end, which is the number of columns added after the column of that position.
+func viewInsertions(records []Sam, start int, end int) map[int]int {
+	ans := make(map[int]int)
+	for i := range records {
+		refPos := records[i].Pos - 1
+		for _, c := range records[i].Cigar {
+			if c.Op == Insertion && refPos > start && refPos <= end && int(c.RunLen) > ans[refPos-1] {
+				ans[refPos-1] = int(c.RunLen)
+			}
+			if ConsumesReference(c.Op) {
+				refPos += int(c.RunLen)
+			}
+		}
+	}
+	return ans
+}
+
+// drawRead will draw the aligned bases of a read into a row of the view. Insertion columns between two bases of
+// the read that are not filled by its own insertions are padded with *.
+func drawRead(line []viewCell, s *Sam, refBase func(int) code.Dna, column func(int) int, insertions map[int]int, settings ViewSettings) {
+	reverse := s.IsReverse()
+	strand := func(b byte) byte {
+		if reverse && b >= 'A' && b <= 'Z' {
+			return b + 0x20
+		}
+		return b
+	}
+	refPos, queryPos := s.Pos-1, 0
+	for _, c := range s.Cigar {
+		n := int(c.RunLen)
+		switch c.Op {
+		case Match, EqualByte, Mismatch:
+			for k := 0; k < n; k++ {
+				if col := column(refPos + k); col >= 0 {
+					line[col] = readCell(s, queryPos+k, refBase(refPos+k), strand, settings)
+				}
+			}
+			refPos, queryPos = refPos+n, queryPos+n
+		case Insertion:
+			if col := column(refPos - 1); col >= 0 {
+				for k := 0; k < n && col+1+k < len(line); k++ {
+					cell := viewCell{char: 'N', color: ansiInsertion}
+					if queryPos+k < len(s.Seq) {
+						cell.char = byte(code.ToUpper(s.Seq[queryPos+k]))
+					}
+					if settings.Qual && queryPos+k < len(s.Qual) && s.Qual[0] != '*' {
+						cell.char = qualDigit(s.Qual[queryPos+k])
+					}
+					cell.char = strand(cell.char)
+					line[col+1+k] = cell
+				}
+			}
+			queryPos += n
+		case Deletion, N:
+			char := byte('*')
+			if c.Op == N && reverse {
+				char = '<'
+			} else if c.Op == N {
+				char = '>'
+			}
+			for k := 0; k < n; k++ {
+				if col := column(refPos + k); col >= 0 {
+					line[col].char = char
+				}
+			}
+			refPos += n
+		case SoftClip:
+			queryPos += n
+		}
+	}
+	for p := s.Pos - 1; p < s.RefEnd()-1; p++ {
+		if col := column(p); col >= 0 {
+			for j := 1; j <= insertions[p] && col+j < len(line); j++ {
+				if line[col+j].char == ' ' {
+					line[col+j].char = '*'
+				}
+			}
+		}
+	}
+}
+
+// readCell returns the character drawn for an aligned base of a read.
+func readCell(s *Sam, queryPos int, ref code.Dna, strand func(byte) byte, settings ViewSettings) viewCell {
+	base := ref
+	if queryPos < len(s.Seq) {
+		base = code.ToUpper(s.Seq[queryPos])
+	}
+	cell := viewCell{char: byte(base)}
+	mismatch := base != ref || base == code.N
+	switch {
+	case settings.Qual && queryPos < len(s.Qual) && s.Qual[0] != '*':
+		cell.char = qualDigit(s.Qual[queryPos])
+	case !mismatch && !settings.Bases:
+		cell.char = '.'
+		if s.IsReverse() {
+			cell.char = ','
+		}
+		return cell
+	}
+	if mismatch {
+		cell.color = ansiMismatch
+	}
+	cell.char = strand(cell.char)
+	return cell
+}
+
+// qualDigit returns the phred+33 quality of a base divided by ten, capped at 9.
+func qualDigit(q byte) byte {
+	if q < 33 {
+		return '0'
+	}
+	if d := (q - 33) / 10; d < 9 {
+		return '0' + d
+	}
+	return '9'
+}
+
+// viewConsensus returns the most common base of the reads at each position from start to end, indexed from start.
+func viewConsensus(records []Sam, start int, end int) []code.Dna {
+	counts := make([][4]int, end-start)
+	for i := range records {
+		for _, block := range records[i].AlignedBlocks() {
+			for k := 0; k < block.Length; k++ {
+				p, q := block.RefStart-1+k, block.QueryStart+k
+				if p < start || p >= end || q >= len(records[i].Seq) {
+					continue
+				}
+				if j := strings.IndexByte("ACGT", byte(code.ToUpper(records[i].Seq[q]))); j >= 0 {
+					counts[p-start][j]++
+				}
+			}
+		}
+	}
+	ans := make([]code.Dna, end-start)
+	for p := range ans {
+		ans[p] = code.N
+		best := 0
+		for j, n := range counts[p] {
+			if n > best {
+				ans[p], best = code.Dna("ACGT"[j]), n
+			}
+		}
+	}
+	return ans
+}
+
+func newViewLine(width int) []viewCell {
+	ans := make([]viewCell, width)
+	for i := range ans {
+		ans[i].char = ' '
+	}
+	return ans
+}
+
+// viewLineString will convert cells to text, trimming trailing spaces and adding color codes to colored cells.
+func viewLineString(line []viewCell, color bool) string {
+	var ans strings.Builder
+	last := len(line)
+	for last > 0 && line[last-1].char == ' ' {
+		last--
+	}
+	for _, cell := range line[:last] {
+		if color && cell.color != "" {
+			ans.WriteString(cell.color)
+			ans.WriteByte(cell.char)
+			ans.WriteString(ansiReset)
+		} else {
+			ans.WriteByte(cell.char)
+		}
+	}
+	return ans.String()
+}
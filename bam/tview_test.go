@@ -0,0 +1,72 @@
+package bam
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+)
+
+func TestRenderView(t *testing.T) {
+	ref := code.ToDna([]byte("ACGTACGTACGTACGTACGT"))
+	records := []Sam{
+		*ParseSam("r1	0	chr1	1	60	5M2I5M	*	0	0	ACGTAGGCGTAC	IIIIIIIIIIII"),
+		*ParseSam("r2	16	chr1	3	60	4M1D6M	*	0	0	GTACTATGTA	IIIIIIIIII"),
+		*ParseSam("r3	0	chr1	14	60	6M	*	0	0	CGTACG	IIIIII"),
+	}
+	expected := []string{
+		"chr1:1-20",
+		"           10",
+		"ACGTA**CGTACGTACGTACGT",
+		".....GG.....   ......",
+		"  ,,,**,*,,t,,,",
+	}
+	settings := ViewSettings{Width: 22}
+	if ans := RenderView("chr1", 0, ref, records, settings); strings.Join(ans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Error: the view was drawn as\n%s\nexpected\n%s\n", strings.Join(ans, "\n"), strings.Join(expected, "\n"))
+	}
+	settings.Qual = true
+	if ans := RenderView("chr1", 0, ref, records, settings); ans[3] != "444444444444   444444" {
+		t.Errorf("Error: base qualities were drawn as %s\n", ans[3])
+	}
+	settings = ViewSettings{Width: 22, Bases: true, Color: true}
+	if ans := RenderView("chr1", 0, ref, records, settings); ans[4] != "  gta**c*ta"+ansiMismatch+"t"+ansiReset+"gta" {
+		t.Errorf("Error: bases were drawn as %q\n", ans[4])
+	}
+	// without a reference the reads are compared to their consensus, ties go to the first base and positions
+	// without reads are N
+	if ans := RenderView("chr1", 0, nil, records, ViewSettings{Width: 22}); ans[2] != "ACGTA**CGTACGTACGTACGN" || ans[4] != "  ,,,**,*,,t,,," {
+		t.Errorf("Error: the consensus view was drawn as\n%s\n", strings.Join(ans, "\n"))
+	}
+}
+
+// TestRenderViewFile draws reads aligned to the reference in the testdata directory. r2 has a two base insertion
+// after position 7 and a mismatch at 11, r3 is clipped with a deletion of 17-19, and r4 skips 27-31 with a
+// mismatch at 33.
+func TestRenderViewFile(t *testing.T) {
+	ref := fasta.Read("testdata/tview.fa")[0].Seq
+	_, reads := Read("testdata/tview.sam")
+	var records []Sam
+	for s := range reads {
+		records = append(records, s)
+	}
+	if width := ViewWidth(records, 0, 28); width != 30 {
+		t.Errorf("Error: positions 1-28 need %d columns, expected 30\n", width)
+	}
+	expected := []string{
+		"chr1:1-28",
+		"           10        20",
+		"ACGTTGC**AAGGCTTACCGATCGATTACA",
+		".......**.    ....***......",
+		"  ,,,,,tt,,,a,           ,,,<<",
+	}
+	if ans := RenderView("chr1", 0, ref, records, ViewSettings{Width: 30}); strings.Join(ans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Error: the view was drawn as\n%s\nexpected\n%s\n", strings.Join(ans, "\n"), strings.Join(expected, "\n"))
+	}
+	// the consensus of a view that does not start at the beginning of the reference
+	expected = []string{"chr1:21-30", "", "CGATTANNNN", ".....", "   ,,,<<<<"}
+	if ans := RenderView("chr1", 20, nil, records, ViewSettings{Width: 10}); strings.Join(ans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Error: the consensus view was drawn as\n%s\nexpected\n%s\n", strings.Join(ans, "\n"), strings.Join(expected, "\n"))
+	}
+}
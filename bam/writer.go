@@ -1,36 +1,54 @@
 package bam
 
 import (
-	"bufio"
 	"bytes"
-	"compress/gzip"
 	"encoding/binary"
 	"log"
-	"os"
 
+	"github.com/edotau/goFish/code"
 	"github.com/edotau/goFish/simpleio"
 )
 
 var bamMagic = [4]byte{'B', 'A', 'M', 0x1}
 
+// BamWriter compresses bam data into bgzf blocks. When Index is not nil, a bai index is built from the
+// virtual offsets of each record as it is written and will be saved to Filename.bai when the writer is closed.
 type BamWriter struct {
-	gzip.Writer
-	File   *os.File
-	Stream *bytes.Buffer
-	buf    [4]byte
+	*simpleio.BgzipWriter
+	Filename string
+	Index    *BaiBuilder
+	refs     map[string]int
+	numRefs  int
+	record   bytes.Buffer
+	buf      [4]byte
 }
 
+// NewBamWriter will create a bam file and return a BamWriter ready to write the binary header.
 func NewBamWriter(filename string) *BamWriter {
-	ans := &BamWriter{}
-	ans.File = simpleio.Vim(filename)
-	r := bufio.NewWriter(ans.File)
-	//common.ExitIfError(err)
-	ans.Writer = *gzip.NewWriter(r)
+	return &BamWriter{
+		BgzipWriter: simpleio.NewBgzipWriter(filename),
+		Filename:    filename,
+		refs:        make(map[string]int),
+	}
+}
+
+// NewIndexedBamWriter is similar to NewBamWriter, but will also build a bai index while records are
+// being written. Records must be written in coordinate sorted order.
+func NewIndexedBamWriter(filename string) *BamWriter {
+	ans := NewBamWriter(filename)
+	ans.Index = NewBaiBuilder()
 	return ans
 }
 
+// WriteBinaryHeader will create a new bam file and encode the header into binary.
 func WriteBinaryHeader(filename string, bh *Header) *BamWriter {
 	writer := NewBamWriter(filename)
+	WriteHeader(writer, bh)
+	return writer
+}
+
+// WriteHeader will encode the header text and reference dictionary of a bam file into binary.
+func WriteHeader(writer *BamWriter, bh *Header) {
 	binary.Write(writer, binary.LittleEndian, bamMagic)
 	text := MarshalText(bh)
 	binary.Write(writer, binary.LittleEndian, int32(len(text)))
@@ -38,6 +56,7 @@ func WriteBinaryHeader(filename string, bh *Header) *BamWriter {
 	binary.Write(writer, binary.LittleEndian, int32(len(bh.Chroms)))
 	var name []byte
 	for _, i := range bh.Chroms {
+		writer.refs[i.Name] = len(writer.refs)
 		name = append(name, []byte(i.Name)...)
 		name = append(name, 0)
 		binary.Write(writer, binary.LittleEndian, int32(len(name)))
@@ -45,39 +64,146 @@ func WriteBinaryHeader(filename string, bh *Header) *BamWriter {
 		name = name[:0]
 		binary.Write(writer, binary.LittleEndian, int32(i.Size))
 	}
+	writer.numRefs = len(bh.Chroms)
 	writer.Flush()
-	return writer
 }
 
-var (
-	lenFieldSize      = binary.Size(BinaryDecoder{}.BlockSize)
-	bamFixedRemainder = binary.Size(BinaryDecoder{}) - lenFieldSize
-)
-
-func WriteBam(writer *BamWriter, record *Sam) {
-	if len(record.RName) > 254 {
-		log.Fatalf("Error: length of reference name is too long...\n")
+// Close will flush any remaining records, write the bgzf end of file marker and save the bai index if one was built.
+func (writer *BamWriter) Close() {
+	writer.BgzipWriter.Close()
+	if writer.Index != nil {
+		IndexWriter(writer.Filename+".bai", writer.Index.Bai(writer.numRefs))
 	}
-	recLen := bamFixedRemainder + len(record.RName) + 1 + len(record.Cigar)<<2 + len(record.Seq)
-	WriteInt32(int32(recLen), writer)
+}
 
+// bamFixedLength is the number of bytes used by the fixed length fields of a bam record, not including the block size.
+const bamFixedLength int = 32
+
+// bamFixedFields contains the fixed length fields at the start of every bam record in the order they are encoded.
+type bamFixedFields struct {
+	BlockSize int32
+	RefID     int32
+	Pos       int32
+	BinMqNl   uint32
+	FlagNc    uint32
+	LSeq      int32
+	NextRefID int32
+	NextPos   int32
+	TLength   int32
 }
 
-/*
+// seqNibble is used to encode bases into the 4-bit representation used by bam files: =ACMGRSVTWYHKDBN -> 0-15.
+var seqNibble [256]byte
 
-func TestWriter(t *testing.T) {
-	for _, test := range readBamTests {
-		bamFile := NewBamReader(test.bam)
-		header := ReadHeader(bamFile)
-		WriteBinaryHeader("testdata/writer.bam", header)
-		wBamFile := NewBamReader("testdata/writer.bam")
-		readWriteHeader := ReadHeader(wBamFile)
-		fmt.Printf("%s", readWriteHeader.Txt)
+func init() {
+	for i := range seqNibble {
+		seqNibble[i] = 15
+	}
+	for i, b := range []byte("=ACMGRSVTWYHKDBN") {
+		seqNibble[b] = byte(i)
+		seqNibble[b|0x20] = byte(i)
 	}
+}
 
+// WriteBam will encode a sam record into binary and write the record to the bam file. The header
+// must be written first, so reference names can be converted into reference ids.
+func WriteBam(writer *BamWriter, record *Sam) {
+	begin := writer.Offset()
+	refId := writer.refId(record.RName)
+	mateId := refId
+	if record.MateRef != "=" {
+		mateId = writer.refId(record.MateRef)
+	}
+	cigar := ByteCigarToUint32(record.Cigar)
+	seq := record.Seq
+	if len(seq) == 1 && seq[0] == code.Gap {
+		seq = nil
+	}
+	end := record.Pos - 1 + alignedLength(record.Cigar)
+	if record.Flag&0x4 != 0 {
+		end = record.Pos
+	}
+	var aux [][]byte
+	var auxLen int
+	for _, field := range ParseAux(record.Aux) {
+		aux = append(aux, MarshalAux(field))
+		auxLen += len(aux[len(aux)-1])
+	}
+	if len(record.QName) > 254 {
+		log.Fatalf("Error: length of read name is too long...\n")
+	}
+	writer.record.Reset()
+	fixed := bamFixedFields{
+		BlockSize: int32(bamFixedLength + len(record.QName) + 1 + len(cigar)<<2 + (len(seq)+1)/2 + len(seq) + auxLen),
+		RefID:     int32(refId),
+		Pos:       int32(record.Pos - 1),
+		BinMqNl:   reg2bin(record.Pos-1, end)<<16 | uint32(record.MapQ)<<8 | uint32(len(record.QName)+1),
+		FlagNc:    uint32(record.Flag)<<16 | uint32(len(cigar)),
+		LSeq:      int32(len(seq)),
+		NextRefID: int32(mateId),
+		NextPos:   int32(record.MatePos - 1),
+		TLength:   int32(record.TmpLen),
+	}
+	simpleio.StdError(binary.Write(&writer.record, binary.LittleEndian, fixed))
+	writer.record.WriteString(record.QName)
+	writer.record.WriteByte(0)
+	simpleio.StdError(binary.Write(&writer.record, binary.LittleEndian, cigar))
+	for i := 0; i < len(seq); i += 2 {
+		if i+1 < len(seq) {
+			writer.record.WriteByte(seqNibble[seq[i]]<<4 | seqNibble[seq[i+1]])
+		} else {
+			writer.record.WriteByte(seqNibble[seq[i]] << 4)
+		}
+	}
+	if len(record.Qual) == 1 && record.Qual[0] == '*' {
+		for range seq {
+			writer.record.WriteByte(0xff)
+		}
+	} else {
+		if len(record.Qual) != len(seq) {
+			log.Fatalf("Error: %s has %d bases but %d quality scores...\n", record.QName, len(seq), len(record.Qual))
+		}
+		for i := range seq {
+			writer.record.WriteByte(record.Qual[i] - 33)
+		}
+	}
+	for _, field := range aux {
+		writer.record.Write(field)
+	}
+	_, err := writer.Write(writer.record.Bytes())
+	simpleio.StdError(err)
+	if writer.Index != nil {
+		writer.Index.Add(refId, record.Pos-1, end, record.Flag, Chunk{Begin: toOffset(begin), End: toOffset(writer.Offset())})
+	}
+}
 
+// refId will look up the index of a reference name in the bam header, or return -1 for unmapped records.
+func (writer *BamWriter) refId(name string) int {
+	if name == "*" {
+		return -1
+	}
+	refId, ok := writer.refs[name]
+	if !ok {
+		log.Fatalf("Error: reference %s was not found in the bam header...\n", name)
+	}
+	return refId
+}
+
+// WriteBamFile is a wrapper that will write a bam header followed by every record received from a channel,
+// then close the file. Setting index to true will also create a bai index for coordinate sorted input.
+func WriteBamFile(filename string, header *Header, records <-chan Sam, index bool) {
+	var writer *BamWriter
+	if index {
+		writer = NewIndexedBamWriter(filename)
+	} else {
+		writer = NewBamWriter(filename)
+	}
+	WriteHeader(writer, header)
+	for i := range records {
+		WriteBam(writer, &i)
+	}
+	writer.Close()
 }
-*/
 
 func WriteUint8(v uint8, writer *BamWriter) {
 	writer.buf[0] = v
@@ -102,104 +228,3 @@ func WriteUint32(v uint32, writer *BamWriter) {
 func MarshalText(bh *Header) []byte {
 	return bh.Text.Bytes()
 }
-
-/*
-type BAMWriters struct {
-	BarcodeSortedBam     *BAMWriter
-	PositionBucketedBams map[string][]*BAMWriter
-	positionChunkSize    int
-	debugTags            bool
-	channel              chan *Data
-	// This mutex is Rlocked by the worker thread. When we close, we wait
-	 //for the mutex to be unlocked to ensure data is flushed before continueing
-
-	done sync.RWMutex
-}
-
-type BAMWriter struct {
-	Writer  *bam.Writer
-	Contigs map[string]*bam.Reference
-	Record  bam.Record
-}
-
-func CreateBAM(ref *gobwa.GoBwaReference, path, read_groups, sample_id string, firstChunk bool) (*BAMWriter, error) {
-	bw := &BAMWriter{}
-	bw.Contigs = make(map[string]*bam.Reference)
-
-	references := make([]*bam.Reference, 0, 0)
-
-	gobwa.EnumerateContigs(ref, func(name string, length int) {
-		r, err := bam.NewReference(name, name, "human", length, nil, nil)
-		if err != nil {
-			panic(err)
-		}
-		references = append(references, r)
-		bw.Contigs[name] = r
-	})
-
-	// Only include the CO headers on the first chunk: avoid having them duplicated during samtools merge
-	comments := []byte("")
-	if firstChunk {
-		comments = []byte("@CO\t10x_bam_to_fastq:R1(RX:QX,TR:TQ,SEQ:QUAL)\n@CO\t10x_bam_to_fastq:R2(SEQ:QUAL)\n@CO\t10x_bam_to_fastq:I1(BC:QT)")
-	}
-	h, err := bam.NewHeader(comments, references)
-
-	if err != nil {
-		panic(err)
-	}
-
-	// NewReadGroup(name, center, desc, lib, prog, plat, unit, sample string, date time.Time, size int, flow, key []byte)
-	for _, rg_id := range strings.Split(read_groups, ",") {
-		// currently, the ID is composed of:
-		// sample:library:gem_group:flowcell:lane
-		rg_fields := strings.Split(rg_id, ":")
-		if len(rg_fields) == 0 {
-			log.Printf("Empty RG was specified, skipping")
-		} else if len(rg_fields) < 5 {
-			log.Printf("RG is not fully specified, skipping: %s", rg_id)
-		} else {
-			rg, err := bam.NewReadGroup(
-				rg_id, //ID
-				"",    //CN
-				"",    //DS
-				rg_fields[1]+"."+rg_fields[2], //LB = (input library).(gem group)
-				"",           //PG
-				"ILLUMINA",   //PL
-				rg_id,        //PU: just make same as ID?
-				rg_fields[0], //SM
-				time.Now(),
-				0,
-				nil,
-				nil)
-			if err != nil {
-				panic(err)
-			}
-			h.AddReadGroup(rg)
-		}
-	}
-
-	// Add a program line for lariat
-	prog := bam.NewProgram(
-		"lariat",                   // ID
-		"longranger.lariat",        // PN
-		strings.Join(os.Args, " "), // CL
-		"",          // PP - no need to indicate previous, since Lariat produces the initial BAM
-		__VERSION__) // VN
-	h.AddProgram(prog)
-
-	file, err := os.Create(path)
-
-	if err != nil {
-		return nil, err
-	}
-
-	w, err := bam.NewWriter(file, h, 2)
-
-	if err != nil {
-		panic(err)
-	}
-	bw.Writer = w
-	return bw, nil
-}
-
-*/
@@ -0,0 +1,80 @@
+package bam
+
+import (
+	"testing"
+
+	"github.com/edotau/goFish/code"
+)
+
+// TestBamWriter will perform a round trip by writing the records of the sam and bam test files
+// into a new bam file and reading them back in, which should produce identical records.
+func TestBamWriter(t *testing.T) {
+	for _, test := range readBamTests {
+		for _, input := range []string{test.bam, test.sam} {
+			header, records := Read(input)
+			var expected []Sam
+			filename := t.TempDir() + "/writer.bam"
+			writer := NewIndexedBamWriter(filename)
+			WriteHeader(writer, header)
+			for i := range records {
+				expected = append(expected, i)
+				WriteBam(writer, &i)
+			}
+			writer.Close()
+
+			h, results := BasicRead(filename)
+			if h.Text.String() != header.Text.String() || len(h.Chroms) != len(header.Chroms) {
+				t.Errorf("Error: bam header did not round trip from %s...\n", input)
+			}
+			if len(results) != len(expected) {
+				t.Fatalf("Error: wrote %d records from %s, but read back %d...\n", len(expected), input, len(results))
+			}
+			for i := range results {
+				if !IsEqualDebug(results[i], &expected[i]) {
+					t.Fatalf("Error: bam record did not round trip from %s...\n", input)
+				}
+			}
+			chrom, start, end := ParseRegion("tig00000004:1000-2000")
+			var count int
+			for range Query(NewBamReader(filename), IndexReader(filename+".bai"), chrom, start, end) {
+				count++
+			}
+			if count == 0 {
+				t.Errorf("Error: index built by the bam writer did not find any records...\n")
+			}
+		}
+	}
+}
+
+// TestWriteAuxTypes will check every auxiliary type along with unmapped records round trip through the bam writer.
+func TestWriteAuxTypes(t *testing.T) {
+	header := &Header{ChromSize: map[string]int{"chrI": 1000}, Chroms: []ChromSize{{Name: "chrI", Size: 1000}}}
+	header.Text.WriteString("@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chrI\tLN:1000\n")
+	expected := []Sam{
+		{QName: "read1", Flag: 99, RName: "chrI", Pos: 10, MapQ: 60, Cigar: ReadToBytesCigar([]byte("2S3M1I2M")), MateRef: "=", MatePos: 100, TmpLen: 98,
+			Seq: code.ToDna([]byte("ACGTACGT")), Qual: []byte("ABCDEFGH"), Aux: "NM:i:1\tXA:A:x\tXN:i:-200\tXL:i:70000\tXF:f:1.5\tRG:Z:group one\tXH:H:1AE301\tXB:B:c,-1,2,3\tXS:B:S,1,65000\tXI:B:i,-5,100000\tXG:B:f,0.5,2"},
+		{QName: "read2", Flag: 4, RName: "*", Pos: 0, MapQ: 0, Cigar: nil, MateRef: "*", MatePos: 0, TmpLen: 0,
+			Seq: code.ToDna([]byte("ACGTN")), Qual: []byte("*"), Aux: ""},
+		{QName: "read3", Flag: 4, RName: "*", Pos: 0, MapQ: 0, Cigar: nil, MateRef: "*", MatePos: 0, TmpLen: 0,
+			Seq: code.ToDna([]byte("A")), Qual: []byte("*"), Aux: ""},
+	}
+	filename := t.TempDir() + "/aux.bam"
+	writer := WriteBinaryHeader(filename, header)
+	for i := range expected {
+		WriteBam(writer, &expected[i])
+	}
+	writer.Close()
+	_, results := BasicRead(filename)
+	if len(results) != len(expected) {
+		t.Fatalf("Error: expected %d records, but found %d...\n", len(expected), len(results))
+	}
+	if !IsEqualDebug(results[0], &expected[0]) {
+		t.Errorf("Error: auxiliary tags did not round trip through the bam writer...\n")
+	}
+	if results[1].RName != "*" || results[1].MateRef != "*" || code.ToString(results[1].Seq) != "ACGTN" || string(results[1].Qual) != "*" {
+		t.Errorf("Error: unmapped record did not round trip through the bam writer: %s\n", ToString(results[1]))
+	}
+	if code.ToString(results[2].Seq) != "A" || string(results[2].Qual) != "*" {
+		t.Errorf("Error: a single base without qualities did not round trip through the bam writer: %s\n", ToString(results[2]))
+	}
+}
@@ -41,16 +41,22 @@ func main() {
 			}
 		} else if *counts {
 			buf := &strings.Builder{}
-			//buf.WriteString("Chrom\tPos\tRef,Alt")
-			for key := range header.Samples {
-				buf.WriteByte('\t')
-				buf.WriteString(key)
+			names := make([]string, len(header.Samples))
+			for key, idx := range header.Samples {
+				names[idx] = key
 			}
+			buf.WriteString("Chrom\tPos\tRef\tAlt\t")
+			buf.WriteString(strings.Join(names, "\t"))
 			fmt.Printf("%s\n", buf.String())
 			for i, done := vcf.UnmarshalVcf(file); !done; i, done = vcf.UnmarshalVcf(file) {
 				buf.Reset()
 				writeLine(i, buf)
-				buf.WriteString(strings.Join(vcf.GetAllAlleleDepth(i), "\t"))
+				for s := range i.Genotypes {
+					if s > 0 {
+						buf.WriteByte('\t')
+					}
+					writeDepth(i.SampleInts(s, "AD"), buf)
+				}
 				fmt.Printf("%s\n", buf.String())
 			}
 		} else {
@@ -71,22 +77,22 @@ func main() {
 		//fmt.Printf("hybrid:%s=%d\n", flag.Arg(2), header.Samples[flag.Arg(2)])
 
 		results := stats.Results{}
-		var sampleOne, sampleTwo alleleDepth
+		var sampleOne, sampleTwo vcf.Genotype
 		var significant []stats.DiffPeak
 
 		output := simpleio.NewWriter(flag.Arg(3))
 		defer output.Close()
 		for v, done := vcf.UnmarshalVcf(file); !done; v, done = vcf.UnmarshalVcf(file) {
-			sampleOne, sampleTwo = getGtTwo(v, wgs, hybrid)
-			if vcf.IsHeterozygous(sampleOne.Gt) {
+			sampleOne, sampleTwo = v.Genotype(wgs), v.Genotype(hybrid)
+			if sampleOne.Ploidy() == 2 && sampleOne.IsHet() {
 
 				fishingTest := stats.FisherExact{
-					A: sampleOne.Depth[sampleOne.Gt.AlleleOne],
-					B: sampleOne.Depth[sampleOne.Gt.AlleleTwo],
-					C: sampleTwo.Depth[sampleOne.Gt.AlleleOne],
-					D: sampleTwo.Depth[sampleOne.Gt.AlleleTwo],
+					A: sampleOne.Depth(sampleOne.Alleles[0]),
+					B: sampleOne.Depth(sampleOne.Alleles[1]),
+					C: sampleTwo.Depth(sampleOne.Alleles[0]),
+					D: sampleTwo.Depth(sampleOne.Alleles[1]),
 				}
-				if equalWithin(fishingTest.A, fishingTest.B) && (fishingTest.C > -1 && fishingTest.D > -1) {
+				if fishingTest.A > -1 && fishingTest.B > -1 && equalWithin(fishingTest.A, fishingTest.B) && (fishingTest.C > -1 && fishingTest.D > -1) {
 					results = stats.RunFishersExactTest(fishingTest)
 					if results.Pval < 0.001 {
 						diff := stats.DiffPeak{
@@ -150,70 +156,16 @@ func GoFishingString(v *vcf.Vcf, results stats.Results) string {
 	return buf.String()
 }
 
-type alleleDepth struct {
-	Gt    vcf.Genotype
-	Depth []int
-}
-
-func getAdGtAll(v *vcf.Vcf) []alleleDepth {
-	format := vcf.MkFormatMap(v)
-	ans := make([]alleleDepth, len(v.Genotypes))
-	var line []string = make([]string, len(v.Format))
-	for i := 0; i < len(ans); i++ {
-		line = strings.Split(v.Genotypes[i], ":")
-		ans[i].Gt = vcf.ParseGt(line[0])
-		ans[i].Depth = simpleio.StringToIntSlice(vcf.FindAlleleDepth(format, line))
-	}
-	return ans
-}
-
-func getGtTwo(v *vcf.Vcf, sampleOne int, sampleTwo int) (alleleDepth, alleleDepth) {
-	format := vcf.MkFormatMap(v)
-	var line []string = make([]string, len(v.Format))
-
-	wgs := alleleDepth{}
-	line = strings.Split(v.Genotypes[sampleOne], ":")
-	wgs.Gt = vcf.ParseGt(line[0])
-	wgs.Depth = stringToIntSlice(vcf.FindAlleleDepth(format, line))
-
-	hybrid := alleleDepth{}
-	line = strings.Split(v.Genotypes[sampleTwo], ":")
-	hybrid.Gt = vcf.ParseGt(line[0])
-	hybrid.Depth = stringToIntSlice(vcf.FindAlleleDepth(format, line))
-	return wgs, hybrid
-}
-
-// StringToInts will process strings (usually from column data) and return a slice of []int
-func stringToIntSlice(column string) []int {
-	work := strings.Split(column, ",")
-	var answer []int = make([]int, len(work))
-	for i := 0; i < len(work); i++ {
-		answer[i] = simpleio.StringToInt(work[i])
-	}
-	return answer
-}
-
-func addTwoGt(v *vcf.Vcf, sampleOne int, sampleTwo int, sampleThree int) (alleleDepth, alleleDepth) {
-	format := vcf.MkFormatMap(v)
-	var line []string = make([]string, len(v.Format))
-
-	wgs := alleleDepth{}
-	line = strings.Split(v.Genotypes[sampleOne], ":")
-	wgs.Gt = vcf.ParseGt(line[0])
-	wgs.Depth = simpleio.StringToIntSlice(vcf.FindAlleleDepth(format, line))
-
-	hybrid := alleleDepth{}
-	line = strings.Split(v.Genotypes[sampleTwo], ":")
-	hybrid.Gt = vcf.ParseGt(line[0])
-	hybrid.Depth = simpleio.StringToIntSlice(vcf.FindAlleleDepth(format, line))
-
-	hybridTwo := alleleDepth{}
-	line = strings.Split(v.Genotypes[sampleThree], ":")
-	hybridTwo.Gt = vcf.ParseGt(line[0])
-	hybridTwo.Depth = simpleio.StringToIntSlice(vcf.FindAlleleDepth(format, line))
-
-	for i := 0; i < len(hybridTwo.Depth); i++ {
-		hybrid.Depth[i] += hybridTwo.Depth[i]
+// addTwoGt will return the genotype of sampleOne along with the genotype of sampleTwo, where the allele depths
+// of sampleThree have been added to the allele depths of sampleTwo.
+func addTwoGt(v *vcf.Vcf, sampleOne int, sampleTwo int, sampleThree int) (vcf.Genotype, vcf.Genotype) {
+	wgs, hybrid, hybridTwo := v.Genotype(sampleOne), v.Genotype(sampleTwo), v.Genotype(sampleThree)
+	for i := 0; i < len(hybrid.AD) && i < len(hybridTwo.AD); i++ {
+		if hybrid.AD[i] == vcf.MissingInt || hybridTwo.AD[i] == vcf.MissingInt {
+			hybrid.AD[i] = vcf.MissingInt
+		} else {
+			hybrid.AD[i] += hybridTwo.AD[i]
+		}
 	}
 	return wgs, hybrid
 }
@@ -228,3 +180,20 @@ func writeLine(v *vcf.Vcf, buf *strings.Builder) {
 	buf.WriteString(v.Alt)
 	buf.WriteByte('\t')
 }
+
+// writeDepth will write the allele depths of a sample separated by commas, using '.' for missing values.
+func writeDepth(depth []int, buf *strings.Builder) {
+	if len(depth) == 0 {
+		buf.WriteByte('.')
+	}
+	for j, d := range depth {
+		if j > 0 {
+			buf.WriteByte(',')
+		}
+		if d == vcf.MissingInt {
+			buf.WriteByte('.')
+		} else {
+			buf.WriteString(simpleio.IntToString(d))
+		}
+	}
+}
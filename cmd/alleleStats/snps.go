@@ -6,6 +6,7 @@ import (
 
 	"github.com/edotau/goFish/bam"
 	"github.com/edotau/goFish/code"
+	_ "github.com/edotau/goFish/cram"
 	"github.com/edotau/goFish/simpleio"
 	"github.com/edotau/goFish/vcf"
 )
@@ -38,21 +39,21 @@ type family struct {
 	Seq [][]code.Dna
 }
 
-func buildGenotypeMap(v *vcf.Vcf, vcfHeader *vcf.Header, famIdx index, mapToVcf map[uint64]family) map[uint64]family {
+func buildGenotypeMap(v *vcf.Vcf, vcfHeader *vcf.Header, data family, mapToVcf map[uint64]family) map[uint64]family {
 	code := vcf.GenomeKeyUint64(vcfHeader.Ref[v.Chr], v.Pos-1)
 	_, ok := mapToVcf[code]
 	if !ok {
-		mapToVcf[code] = getGenotypeData(v, famIdx)
+		data.Seq = getVcfSeq(v)
+		mapToVcf[code] = data
 	}
 	return mapToVcf
 }
 
 func getGenotypeData(v *vcf.Vcf, famIdx index) family {
 	ans := family{}
-	ans.One = vcf.ParseGt(v.Genotypes[famIdx.p1])
-	ans.Two = vcf.ParseGt(v.Genotypes[famIdx.p2])
-	ans.F1 = vcf.ParseGt(v.Genotypes[famIdx.f1])
-	ans.Seq = getVcfSeq(v)
+	ans.One = v.Genotype(famIdx.p1)
+	ans.Two = v.Genotype(famIdx.p2)
+	ans.F1 = v.Genotype(famIdx.f1)
 	return ans
 }
 
@@ -69,8 +70,9 @@ func SnpSearch(samfile string, genotypeVcf string, fOne string, parentOne string
 
 	famIdx := index{p1: vcfHeader.Samples[parentOne], p2: vcfHeader.Samples[parentTwo], f1: vcfHeader.Samples[fOne]}
 	for genotype := range vcfs {
-		if vcf.ASFilter(&genotype, famIdx.p1, famIdx.p2, famIdx.f1) {
-			buildGenotypeMap(&genotype, vcfHeader, famIdx, snpDb)
+		data := getGenotypeData(&genotype, famIdx)
+		if vcf.ASFilter(data.One, data.Two, data.F1) {
+			buildGenotypeMap(&genotype, vcfHeader, data, snpDb)
 		}
 	}
 
@@ -105,10 +107,10 @@ func SnpSearch(samfile string, genotypeVcf string, fOne string, parentOne string
 				//_, ok = snpDb[code]
 				//if ok {
 				//	gV = snpDb[code]
-				//	if dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentOne]].AlleleOne]) == 0 && dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentOne]].AlleleTwo]) == 0 {
+				//	if dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentOne]].Alleles[0]]) == 0 && dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentOne]].Alleles[1]]) == 0 {
 				//		parentAllele1++
 				//	}
-				//	if dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentTwo]].AlleleOne]) == 0 && dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentTwo]].AlleleTwo]) == 0 {
+				//	if dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentTwo]].Alleles[0]]) == 0 && dna.CompareSeqsIgnoreCase(read.Seq[query:query+read.Cigar[i].RunLen], gV.Alleles[vcf.GetGenotypes(gV.Genotypes)[vcfHeader.Samples[parentTwo]].Alleles[1]]) == 0 {
 				//		parentAllele2++
 				//	}
 				//}
@@ -118,10 +120,10 @@ func SnpSearch(samfile string, genotypeVcf string, fOne string, parentOne string
 				gV, ok = snpDb[hashKey]
 				if ok {
 
-					if code.CountDnaBytes(gV.Seq[gV.One.AlleleOne], code.Gap) == int(read.Cigar[i].RunLen) && code.CountDnaBytes(gV.Seq[gV.One.AlleleTwo], code.Gap) == int(read.Cigar[i].RunLen) {
+					if code.CountDnaBytes(gV.Seq[gV.One.Alleles[0]], code.Gap) == int(read.Cigar[i].RunLen) && code.CountDnaBytes(gV.Seq[gV.One.Alleles[1]], code.Gap) == int(read.Cigar[i].RunLen) {
 						parentAllele1++
 					}
-					if code.CountDnaBytes(gV.Seq[gV.Two.AlleleOne], code.Gap) == int(read.Cigar[i].RunLen) && code.CountDnaBytes(gV.Seq[gV.Two.AlleleTwo], code.Gap) == int(read.Cigar[i].RunLen) {
+					if code.CountDnaBytes(gV.Seq[gV.Two.Alleles[0]], code.Gap) == int(read.Cigar[i].RunLen) && code.CountDnaBytes(gV.Seq[gV.Two.Alleles[1]], code.Gap) == int(read.Cigar[i].RunLen) {
 						parentAllele1++
 					}
 				}
@@ -131,10 +133,10 @@ func SnpSearch(samfile string, genotypeVcf string, fOne string, parentOne string
 					hashKey = vcf.GenomeKeyUint64(int(vcfHeader.Ref[read.RName]), int(target+j))
 					gV, ok = snpDb[hashKey]
 					if ok {
-						if read.Seq[query+j] == gV.Seq[gV.One.AlleleOne][0] && read.Seq[query+j] == gV.Seq[gV.One.AlleleTwo][0] {
+						if read.Seq[query+j] == gV.Seq[gV.One.Alleles[0]][0] && read.Seq[query+j] == gV.Seq[gV.One.Alleles[1]][0] {
 							parentAllele1++
 						}
-						if read.Seq[query+j] != gV.Seq[gV.Two.AlleleOne][0] && read.Seq[query+j] == gV.Seq[gV.Two.AlleleTwo][0] {
+						if read.Seq[query+j] != gV.Seq[gV.Two.Alleles[0]][0] && read.Seq[query+j] == gV.Seq[gV.Two.Alleles[1]][0] {
 							parentAllele2++
 						}
 					}
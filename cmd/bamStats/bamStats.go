@@ -0,0 +1,60 @@
+// bamStats reports alignment quality control metrics, similar to samtools flagstat and stats
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/edotau/goFish/bam"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+func usage() {
+	fmt.Print(
+		"bamStats - alignment quality control metrics: flag categories, mapping quality, insert size, mismatches per cycle, soft clipping and reads per chromosome\n" +
+			"  Usage:\n" +
+			"    ./bamStats [options] align.bam\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 1
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var ref *string = flag.String("ref", "", "Reference ``fasta`` used to calculate mismatch rates at each sequencing cycle")
+	var json *bool = flag.Bool("json", false, "Write the statistics as json instead of tab delimited text")
+	var output *string = flag.String("o", "", "Write the statistics to a ``file`` instead of stdout")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	var reference []fasta.Fasta
+	if *ref != "" {
+		reference = fasta.Read(*ref)
+	}
+	stats := bam.StatsFile(flag.Arg(0), reference)
+
+	var writer *simpleio.SimpleWriter
+	if *output != "" {
+		writer = simpleio.NewWriter(*output)
+		defer writer.Close()
+	}
+	switch {
+	case writer != nil && *json:
+		bam.WriteStatsJson(writer, stats)
+	case writer != nil:
+		bam.WriteStatsTsv(writer, stats)
+	case *json:
+		bam.WriteStatsJson(os.Stdout, stats)
+	default:
+		bam.WriteStatsTsv(os.Stdout, stats)
+	}
+}
@@ -0,0 +1,62 @@
+// bamToFastq will restore the original paired and single end reads of sam/bam alignments
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/fastq"
+	"github.com/edotau/goFish/simpleio"
+)
+
+func usage() {
+	fmt.Print(
+		"bamToFastq - convert alignments back to fastq, writing prefix_R1.fastq.gz, prefix_R2.fastq.gz and prefix_singleton.fastq.gz\n" +
+			"  Usage:\n" +
+			"    ./bamToFastq [options] input.bam prefix\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 2
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var tags *string = flag.String("tags", "", "Comma separated ``list of auxiliary tags, such as CB,UB or BX, appended to read names")
+	var suffix *bool = flag.Bool("suffix", false, "Add /1 and /2 to the names of paired reads")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	settings := bam.FastqSettings{ReadSuffix: *suffix}
+	if *tags != "" {
+		settings.Tags = strings.Split(*tags, ",")
+	}
+	readOne := simpleio.NewWriter(fmt.Sprintf("%s_R1.fastq.gz", flag.Arg(1)))
+	readTwo := simpleio.NewWriter(fmt.Sprintf("%s_R2.fastq.gz", flag.Arg(1)))
+	singleton := simpleio.NewWriter(fmt.Sprintf("%s_singleton.fastq.gz", flag.Arg(1)))
+	defer readOne.Close()
+	defer readTwo.Close()
+	defer singleton.Close()
+
+	_, records := bam.Read(flag.Arg(0))
+	var pairs, singletons int
+	for i := range bam.ToFastq(records, settings) {
+		if bam.IsSingleton(&i) {
+			singleton.Write(fastq.ToBytes(&i.ReadOne))
+			singletons++
+		} else {
+			readOne.Write(fastq.ToBytes(&i.ReadOne))
+			readTwo.Write(fastq.ToBytes(&i.ReadTwo))
+			pairs++
+		}
+	}
+	log.Printf("Wrote %d read pairs and %d singletons\n", pairs, singletons)
+}
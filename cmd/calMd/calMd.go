@@ -0,0 +1,109 @@
+// calMd will recalculate the MD and NM tags of alignments from a reference and report records with tags that disagree
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+func usage() {
+	fmt.Print(
+		"calMd - recalculate MD and NM tags by comparing each alignment to the reference\n" +
+			"  Usage:\n" +
+			"    ./calMd [options] ref.fa input.bam output.bam\n" +
+			"    ./calMd -check [options] ref.fa input.bam\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var check *bool = flag.Bool("check", false, "Only report records whose MD or NM tags disagree with the reference, without writing alignments")
+	var mismatches *string = flag.String("mismatches", "", "Write the reference position, read position and bases of every mismatch to a tab delimited ``file")
+	var index *bool = flag.Bool("index", false, "Build a bai index for coordinate sorted bam output and write it to output.bam.bai")
+	flag.Parse()
+
+	var expectedNumArgs int = 3
+	if *check {
+		expectedNumArgs = 2
+	}
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	refs := make(map[string][]code.Dna)
+	for _, fa := range fasta.Read(flag.Arg(0)) {
+		refs[fa.Name] = fa.Seq
+	}
+	var report *simpleio.SimpleWriter
+	if *mismatches != "" {
+		report = simpleio.NewWriter(*mismatches)
+		defer report.Close()
+		fmt.Fprintf(report, "#qname\tflag\trname\trefPos\tqueryPos\tref\tbase\n")
+	}
+	header, records := bam.Read(flag.Arg(1))
+	output := make(chan bam.Sam, 1000)
+	var disagree int
+	go func() {
+		for i := range records {
+			result, ok := bam.CheckMd(&i, refs[i.RName])
+			if ok && !result.Agrees() {
+				disagree++
+				if *check {
+					fmt.Printf("%s\t%d\t%s\t%d\tMD:%s\tNM:%s\texpected MD:%s\tNM:%d\n", i.QName, i.Flag, i.RName, i.Pos, missingTag(result.OldMd), missingTag(result.OldNm), result.Md, result.Nm)
+				}
+			}
+			for j := 0; report != nil && j < len(result.Mismatches); j++ {
+				m := result.Mismatches[j]
+				fmt.Fprintf(report, "%s\t%d\t%s\t%d\t%d\t%c\t%c\n", i.QName, i.Flag, i.RName, m.RefPos, m.QueryPos, code.DnaToByte(m.Ref), code.DnaToByte(m.Base))
+			}
+			if ok && !*check {
+				i.SetTag("MD", result.Md)
+				i.SetTag("NM", result.Nm)
+			}
+			output <- i
+		}
+		close(output)
+	}()
+	if *check {
+		for range output {
+		}
+	} else {
+		bam.AddProgramLine(header, "calMd", os.Args)
+		if strings.HasSuffix(flag.Arg(2), ".bam") {
+			bam.WriteBamFile(flag.Arg(2), header, output, *index)
+		} else {
+			bam.Write(flag.Arg(2), header, output)
+		}
+	}
+	log.Printf("Found %d records with MD or NM tags that disagree with the reference\n", disagree)
+}
+
+// missingTag will print a * for tags that were not found in the record.
+func missingTag(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "*"
+		}
+		return v
+	case int:
+		if v < 0 {
+			return "*"
+		}
+		return fmt.Sprint(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,83 @@
+// callSvs will call deletions, duplications, inversions and translocations from discordant pairs, split reads and soft clips
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/bed"
+	"github.com/edotau/goFish/code"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+	"github.com/edotau/goFish/vcf"
+)
+
+func usage() {
+	fmt.Print(
+		"callSvs - call structural variants from discordant read pairs, SA tag split reads and soft clipped reads\n" +
+			"  Usage:\n" +
+			"    ./callSvs [options] ref.fa input.bam output.vcf\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 3
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var mapq *int = flag.Int("mapq", int(bam.DefaultSvSettings.MinMapQ), "Minimum mapping ``quality of reads used as evidence")
+	var support *int = flag.Int("support", bam.DefaultSvSettings.MinSupport, "Minimum ``number of discordant pairs and split reads needed to call a variant")
+	var minLen *int = flag.Int("minLen", bam.DefaultSvSettings.MinSvLen, "Minimum ``length of deletions, duplications and inversions")
+	var clip *int = flag.Int("clip", bam.DefaultSvSettings.MinClip, "Minimum ``length of soft clips counted at breakpoints")
+	var insert *int = flag.Int("insert", 0, "Longest insert ``size of a concordant pair, 0 estimates the cutoff from proper pairs")
+	var window *int = flag.Int("window", 0, "Cluster evidence with breakpoints within this ``distance, 0 uses the insert size cutoff")
+	var sample *string = flag.String("sample", "", "Sample ``name of the vcf genotype column, defaults to the SM of the first read group or the file name")
+	var bedFile *string = flag.String("bed", "", "Also write calls as structure variance bed records to a ``file")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	refs := make(map[string][]code.Dna)
+	for _, fa := range fasta.Read(flag.Arg(0)) {
+		refs[fa.Name] = fa.Seq
+	}
+	settings := bam.SvSettings{MinMapQ: uint8(*mapq), MinSupport: *support, MinSvLen: *minLen, MinClip: *clip, MaxInsert: *insert, Window: *window}
+	header, calls := bam.CallSvsFile(flag.Arg(1), settings)
+	if *sample == "" {
+		*sample = sampleName(header, flag.Arg(1))
+	}
+
+	output := simpleio.NewWriter(flag.Arg(2))
+	defer output.Close()
+	fmt.Fprint(output, bam.SvVcfHeader(header, *sample))
+	for _, sv := range calls {
+		record := sv.ToVcf(refs)
+		fmt.Fprintf(output, "%s\n", vcf.ToString(&record))
+	}
+	if *bedFile != "" {
+		writer := simpleio.NewWriter(*bedFile)
+		defer writer.Close()
+		for _, sv := range calls {
+			fmt.Fprintf(writer, "%s\n", bed.SvToString(sv.ToBed()))
+		}
+	}
+	log.Printf("Called %d structural variants\n", len(calls))
+}
+
+// sampleName returns the sample of the first read group, or the name of the alignment file without its extension.
+func sampleName(header *bam.Header, filename string) string {
+	for _, rg := range bam.NewSamHeader(header).ReadGroups {
+		if sm, ok := rg.Get("SM"); ok {
+			return sm
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+}
@@ -4,20 +4,32 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/edotau/goFish/bam"
 	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+	"github.com/edotau/goFish/vcf"
 )
 
 func usage() {
 	fmt.Print(
-		"goIndels - software to get indels in sam/bam alignment\nUsage:\n  ./goIndels .sam/.bam\n\n")
+		"goIndels - software to get left aligned indels in sam/bam alignment with read support as vcf\nUsage:\n  ./goIndels [options] ref.fa .sam/.bam output.vcf\n\n")
 	flag.PrintDefaults()
 }
 
 func main() {
-	var expectedNumArgs int = 1
+	var expectedNumArgs int = 3
 	flag.Usage = usage
 	log.SetFlags(log.Ldate | log.Ltime)
+	var filter *string = flag.String("filter", "", "Only report indels of alignments matching an ``expression``, e.g. 'mapq >= 30 && !secondary'")
+	var mapq *int = flag.Int("mapq", int(bam.DefaultIndelSettings.MinMapQ), "Minimum mapping ``quality of reads counted at each locus")
+	var support *int = flag.Int("support", bam.DefaultIndelSettings.MinSupport, "Minimum ``number of reads with an indel to report it")
+	var sample *string = flag.String("sample", "", "Sample ``name of the vcf genotype column, defaults to the file name")
 	flag.Parse()
 
 	if len(flag.Args()) != expectedNumArgs {
@@ -25,18 +37,22 @@ func main() {
 		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
 	}
 
-	_, reader := bam.Read(flag.Arg(0))
-	var currPos int
-	var j int
-	for i := range reader {
-		currPos = i.Pos
-		for j = 0; j < len(i.Cigar); j++ {
-			if bam.ConsumesReference(i.Cigar[j].Op) {
-				currPos += int(i.Cigar[j].RunLen)
-			}
-			if i.Cigar[j].Op == bam.Insertion || i.Cigar[j].Op == bam.Deletion {
-				fmt.Printf("%s\t%d\t%s\n", i.RName, currPos, string(i.Cigar[j].Op))
-			}
-		}
+	refs := make(map[string][]code.Dna)
+	for _, fa := range fasta.Read(flag.Arg(0)) {
+		refs[fa.Name] = fa.Seq
+	}
+	filters, err := bam.NewFilter(*filter)
+	simpleio.StdError(err)
+	header, indels := bam.CallIndelsFile(flag.Arg(1), refs, bam.IndelSettings{MinMapQ: uint8(*mapq), MinSupport: *support}, filters)
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(flag.Arg(1)), filepath.Ext(flag.Arg(1)))
+	}
+	output := simpleio.NewWriter(flag.Arg(2))
+	defer output.Close()
+	fmt.Fprint(output, bam.IndelVcfHeader(header, *sample))
+	for _, record := range bam.IndelsToVcf(indels) {
+		fmt.Fprintf(output, "%s\n", vcf.ToString(&record))
 	}
+	log.Printf("Found %d indels\n", len(indels))
 }
@@ -0,0 +1,69 @@
+// goTabix - index bgzf compressed vcf, bed and gff files and query the lines overlapping a region
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/tabix"
+)
+
+func usage() {
+	fmt.Print(
+		"goTabix - build tabix or csi indexes of sorted, bgzf compressed text files and query regions\n" +
+			"  Usage:\n" +
+			"    ./goTabix [options] file.vcf.gz\n" +
+			"    ./goTabix [options] file.vcf.gz chr:start-end...\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 1
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var preset *string = flag.String("p", "", "Column ``preset`` of the file, one of vcf, bed or gff, guessed from the file extension by default")
+	var csi *bool = flag.Bool("csi", false, "Build a csi index instead of a tbi index, needed for chromosomes longer than 512Mb")
+	var minShift *int = flag.Int("m", 14, "Minimum ``shift`` of the bins of a csi index")
+	var depth *int = flag.Int("depth", 5, "Number of ``levels`` of the bins of a csi index")
+	var header *bool = flag.Bool("header", false, "Print the header lines before the lines of each region")
+	flag.Parse()
+
+	if len(flag.Args()) < expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting at least %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+	filename := flag.Arg(0)
+
+	if len(flag.Args()) > 1 {
+		reader := tabix.NewReader(filename)
+		defer reader.Close()
+		if *header {
+			fmt.Print(tabix.Header(reader))
+		}
+		for _, region := range flag.Args()[1:] {
+			chrom, start, end := bam.ParseRegion(region)
+			for line := range tabix.Query(reader, chrom, start, end) {
+				fmt.Println(line)
+			}
+		}
+		return
+	}
+
+	columns, ok := tabix.Presets[*preset]
+	if *preset == "" {
+		columns, ok = tabix.GuessPreset(filename)
+	}
+	if !ok {
+		log.Fatalf("Error: could not choose the columns of %s, use -p vcf, bed or gff...\n", filename)
+	}
+	var idx *tabix.Index
+	if *csi {
+		idx = tabix.BuildCsi(filename, columns, *minShift, *depth)
+	} else {
+		idx = tabix.Build(filename, columns)
+	}
+	tabix.Write(tabix.IndexFilename(filename, idx), idx)
+}
@@ -0,0 +1,55 @@
+// markDuplicates will flag or remove pcr and optical duplicates of paired and single end alignments
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/simpleio"
+)
+
+func usage() {
+	fmt.Print(
+		"markDuplicates - flag or remove duplicate reads sharing the same unclipped 5' ends, library and orientation\n" +
+			"  Usage:\n" +
+			"    ./markDuplicates [options] input.bam output.bam\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 2
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var remove *bool = flag.Bool("remove", false, "Remove duplicates from the output instead of setting the 0x400 flag")
+	var metrics *string = flag.String("metrics", "", "Write duplication metrics for each library to a ``file`` instead of stderr")
+	var optical *int = flag.Int("optical", bam.DefaultOpticalDistance, "Maximum ``pixel distance between read pairs on the same tile counted as optical duplicates, 0 to disable")
+	var index *bool = flag.Bool("index", false, "Build a bai index for coordinate sorted bam output and write it to output.bam.bai")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	header, records, stats := bam.MarkDuplicatesFile(flag.Arg(0), bam.DuplicateSettings{Remove: *remove, OpticalDistance: *optical})
+	bam.AddProgramLine(header, "markDuplicates", os.Args)
+	if strings.HasSuffix(flag.Arg(1), ".bam") {
+		bam.WriteBamFile(flag.Arg(1), header, records, *index)
+	} else {
+		bam.Write(flag.Arg(1), header, records)
+	}
+
+	if *metrics != "" {
+		writer := simpleio.NewWriter(*metrics)
+		bam.WriteDuplicateMetrics(writer, stats)
+		writer.Close()
+	} else {
+		bam.WriteDuplicateMetrics(os.Stderr, stats)
+	}
+}
@@ -0,0 +1,55 @@
+// normalizeVcf - split multi-allelic records and left align indels of a vcf against a reference
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/vcf"
+)
+
+func usage() {
+	fmt.Print(
+		"normalizeVcf - split multi-allelic records, trim shared bases and left align indels of a sorted vcf so variants from different callers can be compared\n" +
+			"  Usage:\n" +
+			"    ./normalizeVcf [options] ref.fa input.vcf output.vcf\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 3
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var multi *bool = flag.Bool("multi", false, "Keep multi-allelic records instead of splitting them into one record per alternate allele")
+	var checkRef *string = flag.String("check", vcf.DefaultNormalizeSettings.CheckRef, "What to do when REF does not match the reference, e to exit, w to warn, x to exclude the record or s to set REF from the reference")
+	var window *int = flag.Int("window", vcf.DefaultNormalizeSettings.Window, "Number of ``bases`` records are buffered for so left aligned records are written in order")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+	switch *checkRef {
+	case "e", "w", "x", "s":
+	default:
+		log.Fatalf("Error: -check must be one of e, w, x or s, but got %s\n", *checkRef)
+	}
+
+	refs := make(map[string][]code.Dna)
+	for _, fa := range fasta.Read(flag.Arg(0)) {
+		refs[fa.Name] = fa.Seq
+	}
+	reader := vcf.NewReader(flag.Arg(1))
+	header := vcf.ReadHeader(reader)
+	vcf.AddSourceLine(header, "normalizeVcf", os.Args)
+
+	records := make(chan vcf.Vcf, 1000)
+	go vcf.ReadToChan(reader, records)
+	settings := vcf.NormalizeSettings{Split: !*multi, CheckRef: *checkRef, Window: *window}
+	vcf.Write(flag.Arg(2), header, vcf.NormalizeAll(records, refs, settings))
+}
@@ -0,0 +1,45 @@
+// sortBam will sort sam/bam alignments by coordinate or query name using a fixed amount of memory
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/edotau/goFish/bam"
+	_ "github.com/edotau/goFish/cram"
+)
+
+func usage() {
+	fmt.Print(
+		"sortBam - sort sam/bam alignments by coordinate or read name, spilling sorted runs to disk when memory is full\n" +
+			"  Usage:\n" +
+			"    ./sortBam [options] input.bam output.bam\n\n" +
+			"options:\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var expectedNumArgs int = 2
+	flag.Usage = usage
+	log.SetFlags(log.Ldate | log.Ltime)
+	var queryName *bool = flag.Bool("n", false, "Sort by read name instead of coordinate")
+	var memory *int = flag.Int("m", 768, "Maximum ``MB of alignments held in memory before writing a sorted run to a temporary file")
+	var tmpDir *string = flag.String("T", "", "Write temporary files to this ``directory (default system temp directory)")
+	var index *bool = flag.Bool("index", false, "Build a bai index for coordinate sorted bam output and write it to output.bam.bai")
+	flag.Parse()
+
+	if len(flag.Args()) != expectedNumArgs {
+		flag.Usage()
+		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
+	}
+
+	settings := bam.SortSettings{Order: bam.Coordinate, MaxMemory: *memory * 1024 * 1024, TmpDir: *tmpDir}
+	if *queryName {
+		settings.Order = bam.QueryName
+	}
+	bam.SortFile(flag.Arg(0), flag.Arg(1), settings, *index, func(header *bam.Header) {
+		bam.AddProgramLine(header, "sortBam", os.Args)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+const viewHelp string = "h/l,arrows: 10 bases  H/L,space,backspace: page  j/k: rows  g: go to region  b: base quality  .: bases  q: quit"
+
+// viewer is the state of the alignment viewer, a window of the reference starting at the zero-based position
+// start with the reads scrolled down by row.
+type viewer struct {
+	reader   *bam.BamReader
+	bai      *bam.Bai
+	header   *bam.Header
+	refs     map[string][]code.Dna
+	filters  *bam.Filter
+	chrom    string
+	start    int
+	row      int
+	settings bam.ViewSettings
+}
+
+// newViewer will open a coordinate sorted bam file or url along with its .bai index and read the reference, if one is given.
+func newViewer(filename string, reference string, filters *bam.Filter, settings bam.ViewSettings) *viewer {
+	v := &viewer{refs: make(map[string][]code.Dna), filters: filters, settings: settings}
+	v.reader, v.header, v.bai = bam.NewIndexedReader(filename)
+	if reference != "" {
+		for _, fa := range fasta.Read(reference) {
+			v.refs[fa.Name] = fa.Seq
+		}
+	}
+	if len(v.header.Chroms) > 0 {
+		v.chrom = v.header.Chroms[0].Name
+	}
+	return v
+}
+
+// setRegion will move the view to the start of a samtools style region and return false if the chromosome is not
+// in the bam header.
+func (v *viewer) setRegion(region string) bool {
+	chrom, start, _ := bam.ParseRegion(region)
+	for _, c := range v.header.Chroms {
+		if c.Name == chrom {
+			v.chrom, v.start, v.row = chrom, start, 0
+			v.move(0)
+			return true
+		}
+	}
+	return false
+}
+
+// move will scroll the view by a number of bases without leaving the chromosome.
+func (v *viewer) move(bases int) {
+	v.start += bases
+	for _, c := range v.header.Chroms {
+		if c.Name == v.chrom && v.start >= c.Size {
+			v.start = c.Size - 1
+		}
+	}
+	if v.start < 0 {
+		v.start = 0
+	}
+}
+
+// render will query the alignments in view and draw them.
+func (v *viewer) render() []string {
+	return bam.RenderView(v.chrom, v.start, v.refs[v.chrom], v.records(v.start+v.settings.Width), v.settings)
+}
+
+// records returns the alignments that pass the filters and overlap the view from its start to the zero-based end.
+func (v *viewer) records(end int) []bam.Sam {
+	var ans []bam.Sam
+	for s := range bam.Query(v.reader, v.bai, v.chrom, v.start, end) {
+		if v.filters.Keep(&s) {
+			ans = append(ans, s)
+		}
+	}
+	return ans
+}
+
+// renderRegion will print a region as plain text. When the region has an end, the view is wide enough for every
+// base of the region along with the columns of its insertions.
+func renderRegion(v *viewer, region string) {
+	if !v.setRegion(region) {
+		log.Fatalf("Error: %s was not found in the bam header...\n", region)
+	}
+	if _, start, end := bam.ParseRegion(region); strings.Contains(region, "-") {
+		v.settings.Width = bam.ViewWidth(v.records(end), start, end)
+	}
+	for _, line := range v.render() {
+		fmt.Println(line)
+	}
+}
+
+// interactive will draw the view on the terminal and scroll it with the keyboard until q is pressed.
+func interactive(v *viewer) {
+	tty, err := os.Open("/dev/tty")
+	simpleio.StdError(err)
+	defer tty.Close()
+	saved := stty(tty, "-g")
+	stty(tty, "raw", "-echo")
+	defer stty(tty, saved)
+	defer fmt.Print("\x1b[H\x1b[2J")
+
+	v.settings.Color = true
+	var status string
+	key := make([]byte, 8)
+	for {
+		height, width := terminalSize(tty)
+		v.settings.Width = width
+		lines := v.render()
+		rows := height - bam.ViewHeaderLines - 1
+		if last := len(lines) - bam.ViewHeaderLines - rows; v.row > last {
+			v.row = last
+		}
+		if v.row < 0 {
+			v.row = 0
+		}
+		if status == "" {
+			status = fmt.Sprintf("%s:%d  rows %d of %d  ? for help", v.chrom, v.start+1, v.row+1, len(lines)-bam.ViewHeaderLines)
+		}
+		drawScreen(lines, v.row, rows, height, status)
+		status = ""
+
+		n, err := tty.Read(key)
+		simpleio.StdError(err)
+		command := key[0]
+		if n == 3 && key[0] == 0x1b && key[1] == '[' {
+			command = map[byte]byte{'A': 'k', 'B': 'j', 'C': 'l', 'D': 'h'}[key[2]]
+		}
+		switch command {
+		case 'q', 3, 4:
+			return
+		case 'h':
+			v.move(-10)
+		case 'l':
+			v.move(10)
+		case 'H', 127:
+			v.move(-width)
+		case 'L', ' ':
+			v.move(width)
+		case 'j':
+			v.row++
+		case 'k':
+			v.row--
+		case 'J':
+			v.row += rows
+		case 'K':
+			v.row -= rows
+		case 'b':
+			v.settings.Qual = !v.settings.Qual
+		case '.':
+			v.settings.Bases = !v.settings.Bases
+		case '?':
+			status = viewHelp
+		case 'g':
+			stty(tty, saved)
+			fmt.Printf("\x1b[%d;1H\x1b[2Kregion: ", height)
+			region, _ := bufio.NewReader(tty).ReadString('\n')
+			stty(tty, "raw", "-echo")
+			if region = strings.TrimSpace(region); region != "" && !v.setRegion(region) {
+				status = fmt.Sprintf("%s was not found in the bam header", region)
+			}
+		}
+	}
+}
+
+// drawScreen will clear the terminal and draw the header lines of the view, the rows of reads that fit on the
+// screen and a status line at the bottom.
+func drawScreen(lines []string, row int, rows int, height int, status string) {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H\x1b[2J")
+	for i := 0; i < bam.ViewHeaderLines && i < len(lines); i++ {
+		screen.WriteString(lines[i])
+		screen.WriteString("\r\n")
+	}
+	for i := bam.ViewHeaderLines + row; i < len(lines) && i < bam.ViewHeaderLines+row+rows; i++ {
+		screen.WriteString(lines[i])
+		screen.WriteString("\r\n")
+	}
+	fmt.Fprintf(&screen, "\x1b[%d;1H\x1b[7m%s\x1b[0m", height, status)
+	fmt.Print(screen.String())
+}
+
+// stty will run stty on the terminal and return its output.
+func stty(tty *os.File, args ...string) string {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	simpleio.StdError(err)
+	return strings.TrimSpace(string(out))
+}
+
+// terminalSize returns the number of rows and columns of the terminal, or 24 by 80 if stty can not report them.
+func terminalSize(tty *os.File) (int, int) {
+	var height, width int
+	if _, err := fmt.Sscan(stty(tty, "size"), &height, &width); err != nil || height <= bam.ViewHeaderLines+1 || width <= 0 {
+		return 24, 80
+	}
+	return height, width
+}
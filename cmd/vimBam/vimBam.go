@@ -5,8 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/edotau/goFish/bam"
+	_ "github.com/edotau/goFish/cram"
+	"github.com/edotau/goFish/simpleio"
 	//"github.com/edotau/goFish/"
 )
 
@@ -14,7 +17,10 @@ func usage() {
 	fmt.Print(
 		"vimBam - view option of samtools integrated with other features from goFish\n" +
 			"  Usage:\n" +
-			"    ./vimBam [options] align.bam\n\n" +
+			"    ./vimBam [options] align.bam\n" +
+			"    ./vimBam -region chr:start-end https://host/align.bam\n" +
+			"    ./vimBam -tview [-ref ref.fa] [-region chr:start] align.bam|https://host/align.bam\n" +
+			"    ./vimBam -render -region chr:start-end [-ref ref.fa] align.bam|https://host/align.bam\n\n" +
 			"options:\n\n")
 	flag.PrintDefaults()
 }
@@ -24,6 +30,20 @@ func main() {
 	var expectedNumArgs int = 1
 	flag.Usage = usage
 	log.SetFlags(log.Ldate | log.Ltime)
+	var region *string = flag.String("region", "", "Only view alignments overlapping chr:start-end, uses the .bai index of a coordinate sorted bam if one exists``")
+	var index *bool = flag.Bool("index", false, "Build a bai index for a coordinate sorted bam and write it to align.bam.bai")
+	var filter *string = flag.String("filter", "", "Only keep alignments matching an ``expression``, e.g. 'mapq >= 30 && !secondary && [NM] < 4'")
+	var require *int = flag.Int("f", 0, "Only keep alignments with all bits of this ``flag`` set")
+	var exclude *int = flag.Int("F", 0, "Remove alignments with any bits of this ``flag`` set")
+	var mapQ *int = flag.Int("q", 0, "Remove alignments with a mapping quality below this ``value``")
+	var count *bool = flag.Bool("count", false, "Print the number of alignments that pass the filters instead of the alignments")
+	var output *string = flag.String("o", "", "Write alignments to a sam or bam ``file`` instead of printing sam to stdout")
+	var tview *bool = flag.Bool("tview", false, "View alignments in the terminal, similar to samtools tview, press ? for the keys to scroll the view")
+	var render *bool = flag.Bool("render", false, "Print the alignment view of -region as plain text instead of viewing it interactively")
+	var ref *string = flag.String("ref", "", "Reference ``fasta`` drawn above the alignment view, otherwise the consensus of the reads is used")
+	var width *int = flag.Int("width", bam.DefaultViewSettings.Width, "Number of ``columns`` rendered for a region without an end")
+	var qual *bool = flag.Bool("qual", false, "Render base qualities divided by ten instead of bases")
+	var bases *bool = flag.Bool("bases", false, "Render every base instead of . and , for bases matching the reference")
 	flag.Parse()
 
 	if len(flag.Args()) != expectedNumArgs {
@@ -31,8 +51,48 @@ func main() {
 		log.Fatalf("Error: expecting %d arguments, but got %d\n", expectedNumArgs, len(flag.Args()))
 	}
 
-	header, alignments := bam.Read(flag.Arg(0))
-	fmt.Printf("%s\n", header.Text.String())
+	if *index {
+		bam.IndexWriter(flag.Arg(0)+".bai", bam.IndexBam(flag.Arg(0)))
+		return
+	}
+
+	filters, err := bam.NewFilter(*filter)
+	simpleio.StdError(err)
+	filters.RequireFlags, filters.ExcludeFlags, filters.MinMapQ = uint16(*require), uint16(*exclude), uint8(*mapQ)
+	if *tview || *render {
+		v := newViewer(flag.Arg(0), *ref, filters, bam.ViewSettings{Width: *width, Qual: *qual, Bases: *bases})
+		switch {
+		case *render && *region == "":
+			log.Fatalf("Error: -render requires a -region to view...\n")
+		case *render:
+			renderRegion(v, *region)
+		default:
+			if *region != "" && !v.setRegion(*region) {
+				log.Fatalf("Error: %s was not found in the bam header...\n", *region)
+			}
+			interactive(v)
+		}
+		return
+	}
+	if *region != "" {
+		filters.SetRegion(*region)
+	}
+	header, alignments := bam.FilterFile(flag.Arg(0), filters)
+
+	if *count {
+		var total int
+		for range alignments {
+			total++
+		}
+		fmt.Printf("%d\n", total)
+		return
+	}
+	bam.AddProgramLine(header, "vimBam", os.Args)
+	if *output != "" {
+		bam.Write(*output, header, alignments)
+		return
+	}
+	fmt.Printf("%s", header.Text.String())
 	for i := range alignments {
 		fmt.Printf("%s\n", bam.ToString(&i))
 	}
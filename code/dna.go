@@ -214,6 +214,23 @@ func ToString(bases []Dna) string {
 	return buffer.String()
 }
 
+// ToUpper will remove the soft mask from a base.
+func ToUpper(b Dna) Dna {
+	if b >= 'a' && b <= 'z' {
+		return b - 0x20
+	}
+	return b
+}
+
+// ToUpperString will convert bases to upper case text, removing the soft mask.
+func ToUpperString(bases []Dna) string {
+	ans := make([]byte, len(bases))
+	for i, b := range bases {
+		ans[i] = byte(ToUpper(b))
+	}
+	return string(ans)
+}
+
 // CountBase returns the number of the designated base present in the input sequence.
 func CountDnaBytes(seq []Dna, b Dna) int {
 	return CountInterval(seq, b, 0, len(seq))
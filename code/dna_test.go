@@ -20,3 +20,9 @@ func TestDnaToFromString(t *testing.T) {
 		}
 	}
 }
+
+func TestToUpperString(t *testing.T) {
+	if answer := ToUpperString(ToDna([]byte("ACGTacgtNn-"))); answer != "ACGTACGTNN-" {
+		t.Errorf("Converting to upper case gave %s", answer)
+	}
+}
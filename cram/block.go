@@ -0,0 +1,169 @@
+package cram
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Block compression methods.
+const (
+	methodRaw   byte = 0
+	methodGzip  byte = 1
+	methodBzip2 byte = 2
+	methodLzma  byte = 3
+	methodRans  byte = 4
+)
+
+// Block content types.
+const (
+	contentFileHeader        byte = 0
+	contentCompressionHeader byte = 1
+	contentSliceHeader       byte = 2
+	contentExternal          byte = 4
+	contentCore              byte = 5
+)
+
+// block is a single decompressed block of a container.
+type block struct {
+	method      byte
+	contentType byte
+	contentId   int32
+	data        []byte
+}
+
+// crcReader keeps a copy of every byte read, so the crc32 stored at the end of headers and blocks can be checked.
+type crcReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+func (c *crcReader) readByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(c, b[:])
+	return b[0], err
+}
+
+// itf8 will read an itf8 value one byte at a time from a stream.
+func (c *crcReader) itf8() (int32, error) {
+	b, err := c.readByte()
+	if err != nil {
+		return 0, err
+	}
+	raw := []byte{b}
+	for n := 0; n < 4 && b&(0x80>>uint(n)) != 0; n++ {
+		next, err := c.readByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		raw = append(raw, next)
+	}
+	return (&byteReader{data: raw}).itf8()
+}
+
+func (c *crcReader) ltf8() (int64, error) {
+	b, err := c.readByte()
+	if err != nil {
+		return 0, err
+	}
+	raw := []byte{b}
+	for n := 0; n < 8 && b&(0x80>>uint(n)) != 0; n++ {
+		next, err := c.readByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		raw = append(raw, next)
+	}
+	return (&byteReader{data: raw}).ltf8()
+}
+
+// checkCrc will read the crc32 that follows a header or block and compare it to the bytes read so far.
+func (c *crcReader) checkCrc(what string) error {
+	expected := crc32.ChecksumIEEE(c.buf.Bytes())
+	var b [4]byte
+	if _, err := io.ReadFull(c.r, b[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if found := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24; found != expected {
+		return fmt.Errorf("cram: %s crc32 %08x does not match %08x", what, found, expected)
+	}
+	return nil
+}
+
+// readBlock will read and decompress a block, checking its crc32.
+func readBlock(r io.Reader) (*block, error) {
+	c := &crcReader{r: r}
+	var ans block
+	var err error
+	if ans.method, err = c.readByte(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if ans.contentType, err = c.readByte(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if ans.contentId, err = c.itf8(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	compressed, err := c.itf8()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	raw, err := c.itf8()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if compressed < 0 || raw < 0 {
+		return nil, fmt.Errorf("cram: invalid block sizes %d and %d", compressed, raw)
+	}
+	data := make([]byte, compressed)
+	if _, err = io.ReadFull(c, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if err = c.checkCrc("block"); err != nil {
+		return nil, err
+	}
+	if ans.data, err = decompress(ans.method, data); err != nil {
+		return nil, err
+	}
+	if len(ans.data) != int(raw) {
+		return nil, fmt.Errorf("cram: block decompressed to %d bytes, expected %d", len(ans.data), raw)
+	}
+	return &ans, nil
+}
+
+// decompress will expand the data of a block. Lzma compressed blocks are not supported.
+func decompress(method byte, data []byte) ([]byte, error) {
+	switch method {
+	case methodRaw:
+		return data, nil
+	case methodGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case methodBzip2:
+		return io.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+	case methodRans:
+		return ransDecode(data)
+	default:
+		return nil, fmt.Errorf("cram: block compression method %d is not supported", method)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
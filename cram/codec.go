@@ -0,0 +1,514 @@
+package cram
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Encoding ids used by the data series and tag encoding maps of the compression header.
+const (
+	encodingNull          = 0
+	encodingExternal      = 1
+	encodingGolomb        = 2
+	encodingHuffman       = 3
+	encodingByteArrayLen  = 4
+	encodingByteArrayStop = 5
+	encodingBeta          = 6
+	encodingSubexp        = 7
+	encodingGolombRice    = 8
+	encodingGamma         = 9
+)
+
+var errShortData = errors.New("cram: unexpected end of data")
+
+// byteReader reads the little endian integers, itf8 and ltf8 values used throughout cram from a slice of bytes.
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *byteReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errShortData
+	}
+	r.pos++
+	return r.data[r.pos-1], nil
+}
+
+func (r *byteReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errShortData
+	}
+	r.pos += n
+	return r.data[r.pos-n : r.pos], nil
+}
+
+// itf8 reads a 32 bit integer where the number of leading 1 bits of the first byte give the number of bytes that follow.
+func (r *byteReader) itf8() (int32, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	switch {
+	case b < 0x80:
+		return int32(b), nil
+	case b < 0xc0:
+		n = 1
+	case b < 0xe0:
+		n = 2
+	case b < 0xf0:
+		n = 3
+	default:
+		n = 4
+	}
+	rest, err := r.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	ans := uint32(b) & (0xff >> uint(n+1))
+	if n == 4 {
+		ans = uint32(b) & 0x0f
+		for _, c := range rest[:3] {
+			ans = ans<<8 | uint32(c)
+		}
+		return int32(ans<<4 | uint32(rest[3]&0x0f)), nil
+	}
+	for _, c := range rest {
+		ans = ans<<8 | uint32(c)
+	}
+	return int32(ans), nil
+}
+
+// ltf8 reads a 64 bit integer in the same style as itf8, using up to nine bytes.
+func (r *byteReader) ltf8() (int64, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for n = 0; n < 8 && b&(0x80>>uint(n)) != 0; n++ {
+	}
+	rest, err := r.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	var ans uint64
+	if n < 8 {
+		ans = uint64(b) & (0xff >> uint(n+1))
+	}
+	for _, c := range rest {
+		ans = ans<<8 | uint64(c)
+	}
+	return int64(ans), nil
+}
+
+func (r *byteReader) int32() (int32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24), nil
+}
+
+// itf8Array reads an itf8 count followed by that many itf8 values.
+func (r *byteReader) itf8Array() ([]int32, error) {
+	n, err := r.itf8()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || int(n) > len(r.data)-r.pos {
+		return nil, errShortData
+	}
+	ans := make([]int32, n)
+	for i := range ans {
+		if ans[i], err = r.itf8(); err != nil {
+			return nil, err
+		}
+	}
+	return ans, nil
+}
+
+// bitReader reads the most significant bits of each byte first, the order used by the core data block.
+type bitReader struct {
+	data []byte
+	pos  int
+	bit  uint
+}
+
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.data) {
+		return 0, errShortData
+	}
+	ans := uint32(r.data[r.pos]>>(7-r.bit)) & 1
+	if r.bit++; r.bit == 8 {
+		r.bit, r.pos = 0, r.pos+1
+	}
+	return ans, nil
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	var ans uint32
+	for i := 0; i < n; i++ {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		ans = ans<<1 | b
+	}
+	return ans, nil
+}
+
+// sliceData holds the core and external blocks of the slice being decoded.
+type sliceData struct {
+	core     bitReader
+	external map[int32]*byteReader
+}
+
+func (d *sliceData) block(id int32) (*byteReader, error) {
+	if b, ok := d.external[id]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("cram: external block %d is missing from the slice", id)
+}
+
+// encoding decodes one data series. Integer codecs return bytes by truncating each value, and Bytes returns n values,
+// or a complete array for the byte array codecs which store their own length.
+type encoding interface {
+	Int(d *sliceData) (int32, error)
+	Byte(d *sliceData) (byte, error)
+	Bytes(d *sliceData, n int) ([]byte, error)
+}
+
+// readEncoding will parse an encoding id and its parameters from the compression header.
+func readEncoding(r *byteReader) (encoding, error) {
+	id, err := r.itf8()
+	if err != nil {
+		return nil, err
+	}
+	size, err := r.itf8()
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.bytes(int(size))
+	if err != nil {
+		return nil, err
+	}
+	params := &byteReader{data: data}
+	switch id {
+	case encodingNull:
+		return nullEncoding{}, nil
+	case encodingExternal:
+		block, err := params.itf8()
+		return externalEncoding{block: block}, err
+	case encodingHuffman:
+		return newHuffman(params)
+	case encodingByteArrayLen:
+		var ans byteArrayLenEncoding
+		if ans.length, err = readEncoding(params); err != nil {
+			return nil, err
+		}
+		ans.value, err = readEncoding(params)
+		return ans, err
+	case encodingByteArrayStop:
+		var ans byteArrayStopEncoding
+		if ans.stop, err = params.byte(); err != nil {
+			return nil, err
+		}
+		ans.block, err = params.itf8()
+		return ans, err
+	case encodingBeta:
+		var ans betaEncoding
+		if ans.offset, err = params.itf8(); err != nil {
+			return nil, err
+		}
+		ans.bits, err = params.itf8()
+		return ans, err
+	case encodingSubexp:
+		var ans subexpEncoding
+		if ans.offset, err = params.itf8(); err != nil {
+			return nil, err
+		}
+		ans.k, err = params.itf8()
+		return ans, err
+	case encodingGamma:
+		var ans gammaEncoding
+		ans.offset, err = params.itf8()
+		return ans, err
+	default:
+		return nil, fmt.Errorf("cram: encoding %d is not supported", id)
+	}
+}
+
+// intBytes implements Bytes for codecs that only decode integers.
+func intBytes(e encoding, d *sliceData, n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("cram: byte arrays require a byte array encoding")
+	}
+	ans := make([]byte, n)
+	var err error
+	for i := range ans {
+		if ans[i], err = e.Byte(d); err != nil {
+			return nil, err
+		}
+	}
+	return ans, nil
+}
+
+type nullEncoding struct{}
+
+func (nullEncoding) Int(d *sliceData) (int32, error) { return 0, nil }
+
+func (nullEncoding) Byte(d *sliceData) (byte, error) { return 0, nil }
+
+func (nullEncoding) Bytes(d *sliceData, n int) ([]byte, error) {
+	if n < 0 {
+		return nil, nil
+	}
+	return make([]byte, n), nil
+}
+
+// externalEncoding stores integers as itf8 and bytes as they are in an external block.
+type externalEncoding struct {
+	block int32
+}
+
+func (e externalEncoding) Int(d *sliceData) (int32, error) {
+	b, err := d.block(e.block)
+	if err != nil {
+		return 0, err
+	}
+	return b.itf8()
+}
+
+func (e externalEncoding) Byte(d *sliceData) (byte, error) {
+	b, err := d.block(e.block)
+	if err != nil {
+		return 0, err
+	}
+	return b.byte()
+}
+
+func (e externalEncoding) Bytes(d *sliceData, n int) ([]byte, error) {
+	b, err := d.block(e.block)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, errors.New("cram: byte arrays require a byte array encoding")
+	}
+	return b.bytes(n)
+}
+
+// huffmanEncoding reads canonical huffman codes from the core block. A single symbol is stored with zero bits.
+type huffmanEncoding struct {
+	symbols []int32
+	codes   []uint32
+	lengths []int
+}
+
+// newHuffman builds canonical codes: symbols are sorted by code length and then value, and each code is one more
+// than the previous code shifted left by the change in length.
+func newHuffman(params *byteReader) (encoding, error) {
+	symbols, err := params.itf8Array()
+	if err != nil {
+		return nil, err
+	}
+	lengths, err := params.itf8Array()
+	if err != nil {
+		return nil, err
+	}
+	if len(symbols) != len(lengths) || len(symbols) == 0 {
+		return nil, fmt.Errorf("cram: huffman encoding has %d symbols and %d code lengths", len(symbols), len(lengths))
+	}
+	order := make([]int, len(symbols))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		return lengths[a] < lengths[b] || lengths[a] == lengths[b] && symbols[a] < symbols[b]
+	})
+	ans := &huffmanEncoding{}
+	var code uint32
+	var prev int
+	for i, k := range order {
+		length := int(lengths[k])
+		if length < 0 || length > 31 {
+			return nil, fmt.Errorf("cram: invalid huffman code length %d", length)
+		}
+		if i > 0 {
+			code++
+		}
+		code <<= uint(length - prev)
+		prev = length
+		ans.symbols = append(ans.symbols, symbols[k])
+		ans.codes = append(ans.codes, code)
+		ans.lengths = append(ans.lengths, length)
+	}
+	return ans, nil
+}
+
+func (e *huffmanEncoding) Int(d *sliceData) (int32, error) {
+	if len(e.symbols) == 1 && e.lengths[0] == 0 {
+		return e.symbols[0], nil
+	}
+	var code uint32
+	var length int
+	for i := range e.symbols {
+		for length < e.lengths[i] {
+			b, err := d.core.readBit()
+			if err != nil {
+				return 0, err
+			}
+			code, length = code<<1|b, length+1
+		}
+		if code == e.codes[i] {
+			return e.symbols[i], nil
+		}
+	}
+	return 0, errors.New("cram: invalid huffman code")
+}
+
+func (e *huffmanEncoding) Byte(d *sliceData) (byte, error) {
+	n, err := e.Int(d)
+	return byte(n), err
+}
+
+func (e *huffmanEncoding) Bytes(d *sliceData, n int) ([]byte, error) { return intBytes(e, d, n) }
+
+// byteArrayLenEncoding decodes the length of an array followed by its values.
+type byteArrayLenEncoding struct {
+	length encoding
+	value  encoding
+}
+
+func (e byteArrayLenEncoding) Int(d *sliceData) (int32, error) {
+	return 0, errors.New("cram: byte array encoding used for an integer data series")
+}
+
+func (e byteArrayLenEncoding) Byte(d *sliceData) (byte, error) {
+	return 0, errors.New("cram: byte array encoding used for a byte data series")
+}
+
+func (e byteArrayLenEncoding) Bytes(d *sliceData, n int) ([]byte, error) {
+	length, err := e.length.Int(d)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("cram: negative byte array length %d", length)
+	}
+	return e.value.Bytes(d, int(length))
+}
+
+// byteArrayStopEncoding reads bytes from an external block up to a stop byte, which is not included in the array.
+type byteArrayStopEncoding struct {
+	stop  byte
+	block int32
+}
+
+func (e byteArrayStopEncoding) Int(d *sliceData) (int32, error) {
+	return 0, errors.New("cram: byte array encoding used for an integer data series")
+}
+
+func (e byteArrayStopEncoding) Byte(d *sliceData) (byte, error) {
+	return 0, errors.New("cram: byte array encoding used for a byte data series")
+}
+
+func (e byteArrayStopEncoding) Bytes(d *sliceData, n int) ([]byte, error) {
+	b, err := d.block(e.block)
+	if err != nil {
+		return nil, err
+	}
+	for i := b.pos; i < len(b.data); i++ {
+		if b.data[i] == e.stop {
+			ans := b.data[b.pos:i]
+			b.pos = i + 1
+			return ans, nil
+		}
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// betaEncoding stores value+offset as a fixed number of bits.
+type betaEncoding struct {
+	offset int32
+	bits   int32
+}
+
+func (e betaEncoding) Int(d *sliceData) (int32, error) {
+	n, err := d.core.readBits(int(e.bits))
+	return int32(n) - e.offset, err
+}
+
+func (e betaEncoding) Byte(d *sliceData) (byte, error) {
+	n, err := e.Int(d)
+	return byte(n), err
+}
+
+func (e betaEncoding) Bytes(d *sliceData, n int) ([]byte, error) { return intBytes(e, d, n) }
+
+// gammaEncoding stores value+offset as the number of bits minus one in unary followed by the binary value.
+type gammaEncoding struct {
+	offset int32
+}
+
+func (e gammaEncoding) Int(d *sliceData) (int32, error) {
+	var zeros int
+	for {
+		b, err := d.core.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		zeros++
+	}
+	n, err := d.core.readBits(zeros)
+	return int32(1<<uint(zeros)|n) - e.offset, err
+}
+
+func (e gammaEncoding) Byte(d *sliceData) (byte, error) {
+	n, err := e.Int(d)
+	return byte(n), err
+}
+
+func (e gammaEncoding) Bytes(d *sliceData, n int) ([]byte, error) { return intBytes(e, d, n) }
+
+// subexpEncoding stores small values with k bits and larger values with a unary prefix giving the extra bits.
+type subexpEncoding struct {
+	offset int32
+	k      int32
+}
+
+func (e subexpEncoding) Int(d *sliceData) (int32, error) {
+	var ones int
+	for {
+		b, err := d.core.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 0 {
+			break
+		}
+		ones++
+	}
+	if ones == 0 {
+		n, err := d.core.readBits(int(e.k))
+		return int32(n) - e.offset, err
+	}
+	bits := ones + int(e.k) - 1
+	n, err := d.core.readBits(bits)
+	return int32(1<<uint(bits)|n) - e.offset, err
+}
+
+func (e subexpEncoding) Byte(d *sliceData) (byte, error) {
+	n, err := e.Int(d)
+	return byte(n), err
+}
+
+func (e subexpEncoding) Bytes(d *sliceData, n int) ([]byte, error) { return intBytes(e, d, n) }
@@ -0,0 +1,323 @@
+// Package cram decodes CRAM 3.0 files into the same sam records produced by the bam package. Blocks may be raw or
+// compressed with gzip, bzip2 or rANS 4x8, and data series may use the external, huffman, beta, gamma, subexp and
+// byte array encodings. Reads are restored from a fasta reference, or from a reference embedded in each slice.
+// Importing this package lets bam.Read open .cram files, using the fasta named by the CRAM_REFERENCE environment
+// variable or the local files listed in the UR fields of the @SQ header lines.
+package cram
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+	"github.com/edotau/goFish/simpleio"
+)
+
+// ReferenceEnv is the environment variable holding the path of the fasta used when bam.Read opens a cram file.
+const ReferenceEnv string = "CRAM_REFERENCE"
+
+var (
+	// ErrNotCram is returned when a file does not start with the cram magic number.
+	ErrNotCram = errors.New("cram: file does not start with CRAM")
+	// ErrVersion is returned for cram files other than version 3.
+	ErrVersion = errors.New("cram: only cram version 3 is supported")
+)
+
+// Reader decodes the records of a cram file one container at a time.
+type Reader struct {
+	Header     *bam.Header
+	r          io.Reader
+	readGroups []string
+	refs       map[string][]code.Dna
+	refFiles   []string
+	records    []bam.Sam
+	next       int
+	file       *os.File
+}
+
+// containerHeader is the header of a container, which holds a compression header followed by one or more slices.
+type containerHeader struct {
+	length  int32
+	refId   int32
+	records int32
+	blocks  int32
+}
+
+func init() {
+	bam.RegisterCramReader(func(filename string) (*bam.Header, <-chan bam.Sam) {
+		return Read(filename, nil)
+	})
+}
+
+// NewReader will read the file definition and sam header of a cram stream. reference is only required for slices
+// that were compressed against a reference; when it is nil the fasta is loaded from ReferenceEnv or @SQ UR fields
+// the first time a reference sequence is needed.
+func NewReader(r io.Reader, reference []fasta.Fasta) (*Reader, error) {
+	definition := make([]byte, 26)
+	if _, err := io.ReadFull(r, definition); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if string(definition[:4]) != "CRAM" {
+		return nil, ErrNotCram
+	}
+	if definition[4] != 3 {
+		return nil, ErrVersion
+	}
+	ans := &Reader{r: r, refs: make(map[string][]code.Dna)}
+	for _, fa := range reference {
+		ans.refs[fa.Name] = fa.Seq
+	}
+	container, data, err := ans.readContainer()
+	if err != nil {
+		return nil, err
+	}
+	if container == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b, err := readBlock(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if b.contentType != contentFileHeader || len(b.data) < 4 {
+		return nil, errors.New("cram: the first container does not contain the sam header")
+	}
+	headerReader := &byteReader{data: b.data}
+	length, _ := headerReader.int32()
+	text, err := headerReader.bytes(int(length))
+	if err != nil {
+		return nil, err
+	}
+	h := &bam.Header{}
+	h.Text.Write(bytes.TrimRight(text, "\x00"))
+	samHeader := bam.NewSamHeader(h)
+	ans.Header = samHeader.ToHeader()
+	ans.Header.Text.Reset()
+	ans.Header.Text.Write(bytes.TrimRight(text, "\x00"))
+	for _, rg := range samHeader.ReadGroups {
+		id, _ := rg.Get("ID")
+		ans.readGroups = append(ans.readGroups, id)
+	}
+	if len(reference) == 0 {
+		if path := os.Getenv(ReferenceEnv); path != "" {
+			ans.refFiles = append(ans.refFiles, path)
+		}
+		for _, sq := range samHeader.Refs {
+			if ur, ok := sq.Get("UR"); ok && !strings.Contains(strings.TrimPrefix(ur, "file://"), "://") {
+				ans.refFiles = append(ans.refFiles, strings.TrimPrefix(ur, "file://"))
+			}
+		}
+	}
+	return ans, nil
+}
+
+// NewFileReader will open a cram file for reading.
+func NewFileReader(filename string, reference []fasta.Fasta) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	ans, err := NewReader(bufio.NewReader(file), reference)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ans.file = file
+	return ans, nil
+}
+
+// Read will decode a cram file and send each record to a channel, similar to bam.Read. reference may be nil when
+// the reference can be found from the environment or header.
+func Read(filename string, reference []fasta.Fasta) (*bam.Header, <-chan bam.Sam) {
+	reader, err := NewFileReader(filename, reference)
+	simpleio.StdError(err)
+	ans := make(chan bam.Sam, 1000)
+	go func() {
+		for {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			simpleio.StdError(err)
+			ans <- *record
+		}
+		simpleio.StdError(reader.Close())
+		close(ans)
+	}()
+	return reader.Header, ans
+}
+
+// Next returns the next record, or io.EOF once every container has been read.
+func (r *Reader) Next() (*bam.Sam, error) {
+	for r.next >= len(r.records) {
+		container, data, err := r.readContainer()
+		if err != nil {
+			return nil, err
+		}
+		if container == nil {
+			return nil, io.EOF
+		}
+		if r.records, err = r.decodeContainer(container, data); err != nil {
+			return nil, err
+		}
+		r.next = 0
+	}
+	r.next++
+	return &r.records[r.next-1], nil
+}
+
+// Close will close the file opened by NewFileReader.
+func (r *Reader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
+
+// readContainer will read a container header and the blocks that follow. A nil header is returned at the end of the stream.
+func (r *Reader) readContainer() (*containerHeader, []byte, error) {
+	c := &crcReader{r: r.r}
+	var ans containerHeader
+	var raw [4]byte
+	if n, err := io.ReadFull(c, raw[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, unexpectedEOF(err)
+	}
+	ans.length = int32(uint32(raw[0]) | uint32(raw[1])<<8 | uint32(raw[2])<<16 | uint32(raw[3])<<24)
+	var err error
+	var start, span int32
+	for _, v := range []*int32{&ans.refId, &start, &span, &ans.records} {
+		if *v, err = c.itf8(); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = c.ltf8(); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+	}
+	if ans.blocks, err = c.itf8(); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	landmarks, err := c.itf8()
+	if err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	for i := int32(0); i < landmarks; i++ {
+		if _, err = c.itf8(); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+	}
+	if err = c.checkCrc("container header"); err != nil {
+		return nil, nil, err
+	}
+	if ans.length < 0 {
+		return nil, nil, fmt.Errorf("cram: invalid container length %d", ans.length)
+	}
+	data := make([]byte, ans.length)
+	if _, err = io.ReadFull(r.r, data); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	return &ans, data, nil
+}
+
+// decodeContainer will decode the records of every slice in a container.
+func (r *Reader) decodeContainer(container *containerHeader, data []byte) ([]bam.Sam, error) {
+	if container.records == 0 {
+		return nil, nil
+	}
+	blocks := bytes.NewReader(data)
+	b, err := readBlock(blocks)
+	if err != nil {
+		return nil, err
+	}
+	if b.contentType != contentCompressionHeader {
+		return nil, fmt.Errorf("cram: expected a compression header block, found content type %d", b.contentType)
+	}
+	h, err := readCompressionHeader(b.data)
+	if err != nil {
+		return nil, err
+	}
+	var ans []bam.Sam
+	for read := int32(1); read < container.blocks; {
+		if b, err = readBlock(blocks); err != nil {
+			return nil, err
+		}
+		read++
+		if b.contentType != contentSliceHeader {
+			continue
+		}
+		s, err := readSliceHeader(b.data)
+		if err != nil {
+			return nil, err
+		}
+		d := &sliceData{external: make(map[int32]*byteReader)}
+		for i := int32(0); i < s.blocks; i++ {
+			if b, err = readBlock(blocks); err != nil {
+				return nil, err
+			}
+			read++
+			switch b.contentType {
+			case contentCore:
+				d.core = bitReader{data: b.data}
+			case contentExternal:
+				d.external[b.contentId] = &byteReader{data: b.data}
+			}
+		}
+		var embedded []code.Dna
+		if s.embeddedRef >= 0 {
+			ref, err := d.block(s.embeddedRef)
+			if err != nil {
+				return nil, err
+			}
+			embedded = code.ToDna(ref.data)
+		}
+		records, err := r.decodeSlice(h, s, d, embedded)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, records...)
+	}
+	return ans, nil
+}
+
+// refName returns the name of a reference id, or * for unmapped reads.
+func (r *Reader) refName(refId int32) string {
+	if refId < 0 || int(refId) >= len(r.Header.Chroms) {
+		return "*"
+	}
+	return r.Header.Chroms[refId].Name
+}
+
+// referenceSeq returns the reference sequence of a mapped read, loading the reference fasta the first time it is needed.
+// Reads in containers that do not require a reference are restored from their features and N bases.
+func (r *Reader) referenceSeq(refId int32, required bool) ([]code.Dna, error) {
+	name := r.refName(refId)
+	if seq, ok := r.refs[name]; ok || !required {
+		return seq, nil
+	}
+	for len(r.refFiles) > 0 {
+		path := r.refFiles[0]
+		r.refFiles = r.refFiles[1:]
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		for _, fa := range fasta.Read(path) {
+			if _, ok := r.refs[fa.Name]; !ok {
+				r.refs[fa.Name] = fa.Seq
+			}
+		}
+		if seq, ok := r.refs[name]; ok {
+			return seq, nil
+		}
+	}
+	return nil, fmt.Errorf("cram: reference sequence %s is required to decode the file, set %s to a fasta file", name, ReferenceEnv)
+}
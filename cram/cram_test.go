@@ -0,0 +1,323 @@
+package cram
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+	"github.com/edotau/goFish/fasta"
+)
+
+var update = flag.Bool("update", false, "rewrite the cram test files from the bam test data")
+
+const (
+	testBam   = "../bam/testdata/tenXbarcodeTest.bam"
+	testCram  = "testdata/tenXbarcodeTest.cram"
+	testFasta = "testdata/tenXbarcodeTest.fa"
+
+	samtoolsSam   = "testdata/samtools.sam"
+	samtoolsCram  = "testdata/samtools.cram"
+	samtoolsFasta = "testdata/samtools.fa"
+)
+
+// testMethods compresses quality scores with order-1 rANS, bases and names with gzip or order-0 rANS, and leaves
+// the remaining external blocks raw.
+var testMethods = map[int32]byte{1: methodRans, 2: methodRans + 1, 4: methodGzip, 12: methodRans, 13: methodRans + 1,
+	14: methodGzip, 15: methodRans, 30: methodGzip}
+
+func TestItf8(t *testing.T) {
+	for _, v := range []int32{0, 1, 127, 128, 16383, 16384, 2097151, 2097152, 268435455, 268435456, 2147483647, -1, -2147483648} {
+		var buf bytes.Buffer
+		putItf8(&buf, v)
+		if ans, err := (&byteReader{data: buf.Bytes()}).itf8(); err != nil || ans != v {
+			t.Errorf("Error: itf8 value %d was read as %d, %v...\n", v, ans, err)
+		}
+		if ans, err := (&crcReader{r: bytes.NewReader(buf.Bytes())}).itf8(); err != nil || ans != v {
+			t.Errorf("Error: streamed itf8 value %d was read as %d, %v...\n", v, ans, err)
+		}
+	}
+	for _, v := range []int64{0, 127, 128, 1 << 14, 1 << 21, 1 << 28, 1 << 35, 1 << 42, 1 << 49, 1 << 56, 1<<63 - 1, -1} {
+		var buf bytes.Buffer
+		putLtf8(&buf, v)
+		if ans, err := (&byteReader{data: buf.Bytes()}).ltf8(); err != nil || ans != v {
+			t.Errorf("Error: ltf8 value %d was read as %d, %v...\n", v, ans, err)
+		}
+	}
+}
+
+func TestRans(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	for _, size := range []int{1, 3, 4, 7, 100, 1001, 70000} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = "AACCGGTTNNN@#!"[random.Intn(14)]
+		}
+		data[size/2] = 0
+		for order := 0; order < 2; order++ {
+			ans, err := ransDecode(ransEncode(data, order))
+			if err != nil || !bytes.Equal(ans, data) {
+				t.Errorf("Error: order-%d rans did not restore %d bytes, %v...\n", order, size, err)
+			}
+		}
+	}
+	if _, err := ransDecode([]byte{2, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Errorf("Error: expected an error for rans order 2...\n")
+	}
+}
+
+// readSam will collect the header and records of a sam file.
+func readSam(filename string) (*bam.Header, []*bam.Sam) {
+	header, records := bam.Read(filename)
+	var ans []*bam.Sam
+	for s := range records {
+		s := s
+		ans = append(ans, &s)
+	}
+	return header, ans
+}
+
+// TestReadSamtools compares a cram file written by htslib against the sam records it was made from. htslib stores
+// read groups, MD and NM as markers in the tag dictionary, which the reader has to restore. The file is made with:
+//
+//	samtools view -C -T testdata/samtools.fa -o testdata/samtools.cram testdata/samtools.sam
+func TestReadSamtools(t *testing.T) {
+	if _, err := os.Stat(samtoolsCram); err != nil {
+		t.Skipf("%s was not found, it is written by samtools from %s...\n", samtoolsCram, samtoolsSam)
+	}
+	_, expected := readSam(samtoolsSam)
+	_, records := Read(samtoolsCram, fasta.Read(samtoolsFasta))
+	var i int
+	for s := range records {
+		if i < len(expected) && bam.ToString(&s) != bam.ToString(expected[i]) {
+			t.Errorf("Error: samtools cram record %d\n%s\ndoes not match sam record\n%s\n", i, bam.ToString(&s), bam.ToString(expected[i]))
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Error: read %d samtools cram records, expected %d...\n", i, len(expected))
+	}
+}
+
+// TestTagMarkers encodes the samtools test records with the markers htslib writes for RG, MD and NM.
+func TestTagMarkers(t *testing.T) {
+	header, expected := readSam(samtoolsSam)
+	reference := fasta.Read(samtoolsFasta)
+	refs := map[string][]code.Dna{}
+	for _, f := range reference {
+		refs[f.Name] = f.Seq
+	}
+	for _, opt := range []testWriterOptions{
+		{sliceSize: 10, slicesPerContainer: 1, readNames: true, tagMarkers: true},
+		{sliceSize: 2, slicesPerContainer: 2, readNames: true, embedReference: true, tagMarkers: true},
+	} {
+		data := writeTestCram(header, expected, refs, opt)
+		if bytes.Contains(data, []byte("4^CGA6")) || !bytes.Contains(data, []byte("0C5")) {
+			t.Errorf("Error: expected matching MD tags to be left out and others to be stored with options %+v...\n", opt)
+		}
+		reader, err := NewReader(bytes.NewReader(data), reference)
+		if err != nil {
+			t.Fatalf("Error: %v\n", err)
+		}
+		for i := 0; ; i++ {
+			s, err := reader.Next()
+			if err == io.EOF {
+				if i != len(expected) {
+					t.Errorf("Error: decoded %d records, expected %d...\n", i, len(expected))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("Error: %v with options %+v\n", err, opt)
+			}
+			if bam.ToString(s) != bam.ToString(expected[i]) {
+				t.Errorf("Error: with options %+v decoded\n%s\nexpected\n%s\n", opt, bam.ToString(s), bam.ToString(expected[i]))
+			}
+		}
+	}
+}
+
+// TestRead decodes the bam test file written by the test encoder.
+func TestRead(t *testing.T) {
+	if *update {
+		writeTestData(t)
+	}
+	_, expected := bam.BasicRead(testBam)
+	header, records := Read(testCram, fasta.Read(testFasta))
+	if len(header.Chroms) != 2687 || header.Chroms[0].Name != "tig00000004" || header.Chroms[0].Size != 74365 {
+		t.Errorf("Error: unexpected reference dictionary in the cram header...\n")
+	}
+	var i int
+	for s := range records {
+		if i < len(expected) && bam.ToString(&s) != bam.ToString(expected[i]) {
+			t.Errorf("Error: cram record %d\n%s\ndoes not match bam record\n%s\n", i, bam.ToString(&s), bam.ToString(expected[i]))
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Error: read %d cram records, expected %d...\n", i, len(expected))
+	}
+}
+
+func TestBamReadCram(t *testing.T) {
+	os.Setenv(ReferenceEnv, testFasta)
+	_, records := bam.Read(testCram)
+	var count, paired int
+	for s := range records {
+		count++
+		if s.IsPaired() && s.MateRef == "=" {
+			paired++
+		}
+	}
+	if count != 1000 || paired == 0 {
+		t.Errorf("Error: bam.Read returned %d cram records with %d mates on the same reference...\n", count, paired)
+	}
+	os.Unsetenv(ReferenceEnv)
+	reader, err := NewFileReader(testCram, nil)
+	if err != nil {
+		t.Fatalf("Error: %v\n", err)
+	}
+	defer reader.Close()
+	if _, err = reader.Next(); err == nil {
+		t.Errorf("Error: expected an error when the reference cannot be found...\n")
+	}
+}
+
+// testHeader has two references and two read groups.
+func testHeader() *bam.Header {
+	h := &bam.Header{}
+	h.Text.WriteString("@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:40\n@SQ\tSN:chr2\tLN:40\n@RG\tID:a\tSM:x\n@RG\tID:b\tSM:x\n")
+	return bam.NewSamHeader(h).ToHeader()
+}
+
+var testReference = map[string][]code.Dna{
+	"chr1": code.ToDna([]byte("ACGTACGTTTGGCCAAACGTNNACGTACGTACGTAAACCC")),
+	"chr2": code.ToDna([]byte("GGGGCCCCAAAATTTTGGGGCCCCAAAATTTTGGGGCCCC")),
+}
+
+var testRecords = []string{
+	"p1\t99\tchr1\t3\t60\t2S4M1I3M2D4M\t=\t20\t25\tTTGTTCGAATTGGC\tABCDEFGHIJKLMN\tNM:i:4\tRG:Z:a",
+	"p1\t147\tchr1\t20\t50\t3M2N3M1H\t=\t3\t-25\tNTAGTA\t######\tAS:i:-3\tXS:Z:text",
+	"s1\t0\tchr1\t5\t0\t5M\t*\t0\t0\tAcGTA\t*\tRG:Z:b",
+	"d1\t65\tchr1\t30\t60\t6M\tchr2\t5\t0\tCGTAAA\tIIIIII\tRG:Z:b\tNM:i:0",
+	"c1\t97\tchr1\t36\t60\t5M\tchr2\t1\t0\tACCCG\tIIIII",
+	"c1\t145\tchr2\t1\t60\t4M\tchr1\t36\t0\tGGGG\tIIII",
+	"u1\t77\t*\t0\t0\t*\t*\t0\t0\tACGTN\t+++++",
+	"u1\t141\t*\t0\t0\t*\t*\t0\t0\tNNNN\t*",
+}
+
+func TestWriteOptions(t *testing.T) {
+	var records []*bam.Sam
+	for _, line := range testRecords {
+		records = append(records, bam.ParseSam(line))
+	}
+	for _, opt := range []testWriterOptions{
+		{sliceSize: 8, slicesPerContainer: 1, readNames: true},
+		{sliceSize: 3, slicesPerContainer: 2, readNames: true, methods: testMethods, coreMethod: methodGzip},
+		{sliceSize: 4, slicesPerContainer: 1, readNames: false, embedReference: true, tagMarkers: true, methods: testMethods},
+	} {
+		data := writeTestCram(testHeader(), records, testReference, opt)
+		reader, err := NewReader(bytes.NewReader(data), []fasta.Fasta{{Name: "chr1", Seq: testReference["chr1"]}, {Name: "chr2", Seq: testReference["chr2"]}})
+		if err != nil {
+			t.Fatalf("Error: %v\n", err)
+		}
+		for i := 0; ; i++ {
+			s, err := reader.Next()
+			if err == io.EOF {
+				if i != len(records) {
+					t.Errorf("Error: decoded %d records, expected %d...\n", i, len(records))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("Error: %v with options %+v\n", err, opt)
+			}
+			expected := *records[i]
+			if !opt.readNames && s.QName != expected.QName {
+				// names are only stored for records without a mate in the same slice
+				expected.QName = s.QName
+			}
+			if bam.ToString(s) != bam.ToString(&expected) {
+				t.Errorf("Error: with options %+v decoded\n%s\nexpected\n%s\n", opt, bam.ToString(s), bam.ToString(&expected))
+			}
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("BAM\x01")), nil); err == nil {
+		t.Errorf("Error: expected an error for a short file...\n")
+	}
+	data := writeTestCram(testHeader(), []*bam.Sam{bam.ParseSam(testRecords[0])}, testReference, testWriterOptions{sliceSize: 1, slicesPerContainer: 1, readNames: true})
+	if _, err := NewReader(bytes.NewReader(append([]byte("BAM\x01"), data[4:]...)), nil); err != ErrNotCram {
+		t.Errorf("Error: expected %v, found %v...\n", ErrNotCram, err)
+	}
+	version := append([]byte{}, data...)
+	version[4] = 2
+	if _, err := NewReader(bytes.NewReader(version), nil); err != ErrVersion {
+		t.Errorf("Error: expected %v, found %v...\n", ErrVersion, err)
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-len(eofContainer)-10] ^= 0xff
+	reader, err := NewReader(bytes.NewReader(corrupt), nil)
+	if err != nil {
+		t.Fatalf("Error: %v\n", err)
+	}
+	if _, err = reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("Error: expected a crc32 error for a corrupt block, found %v...\n", err)
+	}
+}
+
+// writeTestData will encode the bam test file as cram against a consensus of its aligned bases.
+func writeTestData(t *testing.T) {
+	header, records := bam.BasicRead(testBam)
+	name := records[0].RName
+	var length int
+	for _, s := range records {
+		if end := s.Pos + bam.ReferenceLength(s.Cigar); s.RName == name && end > length {
+			length = end
+		}
+	}
+	counts := make([][4]int, length)
+	for _, s := range records {
+		refPos, readPos := s.Pos-1, 0
+		for _, c := range s.Cigar {
+			for k := 0; k < int(c.RunLen); k++ {
+				switch query := c.Op != bam.Deletion && c.Op != bam.N && c.Op != bam.HardClip && c.Op != bam.Padded; {
+				case bam.ConsumesReference(c.Op) && query:
+					if i := bytes.IndexByte([]byte("ACGT"), code.DnaToByteNoMask(s.Seq[readPos])); i >= 0 {
+						counts[refPos][i]++
+					}
+					refPos, readPos = refPos+1, readPos+1
+				case bam.ConsumesReference(c.Op):
+					refPos++
+				case query:
+					readPos++
+				}
+			}
+		}
+	}
+	seq := make([]byte, length)
+	for i := range seq {
+		seq[i] = "ACGT"[i%4]
+		best := 0
+		for k, n := range counts[i] {
+			if n > best {
+				seq[i], best = "ACGT"[k], n
+			}
+		}
+	}
+	reference := fasta.Fasta{Name: name, Seq: code.ToDna(seq)}
+	if err := os.WriteFile(testFasta, []byte(reference.ToString()), 0644); err != nil {
+		t.Fatalf("Error: %v\n", err)
+	}
+	opt := testWriterOptions{sliceSize: 300, slicesPerContainer: 2, readNames: true, methods: testMethods, defaultMethod: methodGzip, coreMethod: methodRaw}
+	data := writeTestCram(header, records, map[string][]code.Dna{name: reference.Seq}, opt)
+	if err := os.WriteFile(testCram, data, 0644); err != nil {
+		t.Fatalf("Error: %v\n", err)
+	}
+}
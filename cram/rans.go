@@ -0,0 +1,228 @@
+package cram
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rANS 4x8 uses frequencies that sum to 4096 and four interleaved states renormalized one byte at a time.
+const (
+	ransFreqBits   = 12
+	ransFreqTotal  = 1 << ransFreqBits
+	ransLowerBound = 1 << 23
+)
+
+var errRans = errors.New("cram: invalid rans data")
+
+// ransSymbol is the cumulative start and frequency of one symbol.
+type ransSymbol struct {
+	start uint32
+	freq  uint32
+}
+
+// ransTable maps each slot of the cumulative frequency range back to a symbol.
+type ransTable struct {
+	symbols [256]ransSymbol
+	lookup  [ransFreqTotal]byte
+}
+
+// ransDecode will decompress a rANS 4x8 block: an order byte, the compressed and uncompressed sizes as little endian
+// uint32 values, the frequency tables and the interleaved states.
+func ransDecode(data []byte) ([]byte, error) {
+	if len(data) < 9 {
+		return nil, errRans
+	}
+	order := data[0]
+	size := binary.LittleEndian.Uint32(data[5:9])
+	if int(binary.LittleEndian.Uint32(data[1:5])) != len(data)-9 {
+		return nil, fmt.Errorf("cram: rans block contains %d bytes, expected %d", len(data)-9, binary.LittleEndian.Uint32(data[1:5]))
+	}
+	r := &byteReader{data: data[9:]}
+	switch order {
+	case 0:
+		return ransDecode0(r, int(size))
+	case 1:
+		return ransDecode1(r, int(size))
+	default:
+		return nil, fmt.Errorf("cram: rans order %d is not supported", order)
+	}
+}
+
+// readRansFrequencies will read a run length encoded frequency table. Each symbol is followed by its frequency,
+// stored in one byte or two when the high bit is set. When a symbol is followed by the next symbol in order, the
+// next byte gives the number of additional consecutive symbols whose frequencies follow without their symbol.
+// A zero symbol ends the table.
+func readRansFrequencies(r *byteReader, table *ransTable) error {
+	sym, err := r.byte()
+	if err != nil {
+		return err
+	}
+	var total uint32
+	var run int
+	for {
+		f, err := r.byte()
+		if err != nil {
+			return err
+		}
+		freq := uint32(f)
+		if f >= 0x80 {
+			low, err := r.byte()
+			if err != nil {
+				return err
+			}
+			freq = uint32(f&0x7f)<<8 | uint32(low)
+		}
+		if total+freq > ransFreqTotal {
+			return errRans
+		}
+		table.symbols[sym] = ransSymbol{start: total, freq: freq}
+		for i := total; i < total+freq; i++ {
+			table.lookup[i] = sym
+		}
+		total += freq
+		if run > 0 {
+			run--
+			sym++
+		} else {
+			next, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if next == sym+1 && next != 0 {
+				b, err := r.byte()
+				if err != nil {
+					return err
+				}
+				run = int(b)
+			}
+			sym = next
+		}
+		if sym == 0 {
+			break
+		}
+	}
+	if total == 0 {
+		return errRans
+	}
+	return nil
+}
+
+// readRansStates will read the four initial states.
+func readRansStates(r *byteReader) ([4]uint32, error) {
+	var states [4]uint32
+	for i := range states {
+		b, err := r.bytes(4)
+		if err != nil {
+			return states, err
+		}
+		states[i] = binary.LittleEndian.Uint32(b)
+	}
+	return states, nil
+}
+
+// ransAdvance will decode one symbol from a state and renormalize the state.
+func ransAdvance(r *byteReader, state *uint32, table *ransTable) (byte, error) {
+	slot := *state & (ransFreqTotal - 1)
+	sym := table.lookup[slot]
+	s := table.symbols[sym]
+	if s.freq == 0 {
+		return 0, errRans
+	}
+	*state = s.freq*(*state>>ransFreqBits) + slot - s.start
+	for *state < ransLowerBound {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		*state = *state<<8 | uint32(b)
+	}
+	return sym, nil
+}
+
+// ransDecode0 decodes symbols independently, with output byte i decoded by state i%4.
+func ransDecode0(r *byteReader, size int) ([]byte, error) {
+	table := &ransTable{}
+	if err := readRansFrequencies(r, table); err != nil {
+		return nil, err
+	}
+	states, err := readRansStates(r)
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]byte, size)
+	for i := range ans {
+		if ans[i], err = ransAdvance(r, &states[i%4], table); err != nil {
+			return nil, err
+		}
+	}
+	return ans, nil
+}
+
+// ransDecode1 uses the previous byte as context. The output is split into four parts decoded by one state each,
+// with the remainder of the division decoded by the last state.
+func ransDecode1(r *byteReader, size int) ([]byte, error) {
+	tables := make(map[byte]*ransTable)
+	ctx, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	var run int
+	for {
+		table := &ransTable{}
+		if err = readRansFrequencies(r, table); err != nil {
+			return nil, err
+		}
+		tables[ctx] = table
+		if run > 0 {
+			run--
+			ctx++
+		} else {
+			next, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			if next == ctx+1 && next != 0 {
+				b, err := r.byte()
+				if err != nil {
+					return nil, err
+				}
+				run = int(b)
+			}
+			ctx = next
+		}
+		if ctx == 0 {
+			break
+		}
+	}
+	states, err := readRansStates(r)
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]byte, size)
+	quarter := size / 4
+	var last [4]byte
+	for i := 0; i < quarter; i++ {
+		for j := 0; j < 4; j++ {
+			table, ok := tables[last[j]]
+			if !ok {
+				return nil, errRans
+			}
+			if last[j], err = ransAdvance(r, &states[j], table); err != nil {
+				return nil, err
+			}
+			ans[j*quarter+i] = last[j]
+		}
+	}
+	for i := 4 * quarter; i < size; i++ {
+		table, ok := tables[last[3]]
+		if !ok {
+			return nil, errRans
+		}
+		if last[3], err = ransAdvance(r, &states[3], table); err != nil {
+			return nil, err
+		}
+		ans[i] = last[3]
+	}
+	return ans, nil
+}
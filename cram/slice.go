@@ -0,0 +1,724 @@
+package cram
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+)
+
+// Cram record flags stored in the CF data series.
+const (
+	cramQualArray      = 0x1
+	cramDetached       = 0x2
+	cramMateDownstream = 0x4
+	cramUnknownBases   = 0x8
+)
+
+// Mate flags stored in the MF data series of detached records.
+const (
+	mateReverse  = 0x1
+	mateUnmapped = 0x2
+)
+
+// tagId is a tag name and bam value type from the tag dictionary.
+type tagId [3]byte
+
+// compressionHeader describes how the records of every slice in a container are encoded.
+type compressionHeader struct {
+	readNames    bool
+	apDelta      bool
+	refRequired  bool
+	substitution [5][4]byte
+	tagLines     [][]tagId
+	series       map[string]encoding
+	tags         map[int32]encoding
+}
+
+// sliceHeader describes the records and blocks of one slice.
+type sliceHeader struct {
+	refId       int32
+	start       int32
+	span        int32
+	records     int32
+	counter     int64
+	blocks      int32
+	embeddedRef int32
+}
+
+// substitutionBases is the order of reference bases in the substitution matrix.
+var substitutionBases = [5]byte{'A', 'C', 'G', 'T', 'N'}
+
+// readCompressionHeader will parse the preservation map, data series encodings and tag encodings.
+func readCompressionHeader(data []byte) (*compressionHeader, error) {
+	ans := &compressionHeader{readNames: true, apDelta: true, refRequired: true, series: make(map[string]encoding), tags: make(map[int32]encoding)}
+	for i, ref := range substitutionBases {
+		var k int
+		for _, alt := range substitutionBases {
+			if alt != ref {
+				ans.substitution[i][k] = alt
+				k++
+			}
+		}
+	}
+	r := &byteReader{data: data}
+	entries, err := readMap(r)
+	if err != nil {
+		return nil, err
+	}
+	for entries.pos < len(entries.data) {
+		key, err := entries.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		switch string(key) {
+		case "RN", "AP", "RR":
+			b, err := entries.byte()
+			if err != nil {
+				return nil, err
+			}
+			switch string(key) {
+			case "RN":
+				ans.readNames = b != 0
+			case "AP":
+				ans.apDelta = b != 0
+			default:
+				ans.refRequired = b != 0
+			}
+		case "SM":
+			matrix, err := entries.bytes(5)
+			if err != nil {
+				return nil, err
+			}
+			for i, ref := range substitutionBases {
+				var k int
+				for _, alt := range substitutionBases {
+					if alt == ref {
+						continue
+					}
+					ans.substitution[i][(matrix[i]>>uint(6-2*k))&3] = alt
+					k++
+				}
+			}
+		case "TD":
+			size, err := entries.itf8()
+			if err != nil {
+				return nil, err
+			}
+			dictionary, err := entries.bytes(int(size))
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range bytes.Split(bytes.TrimSuffix(dictionary, []byte{0}), []byte{0}) {
+				if len(line)%3 != 0 {
+					return nil, fmt.Errorf("cram: invalid tag dictionary entry %q", line)
+				}
+				var ids []tagId
+				for i := 0; i < len(line); i += 3 {
+					ids = append(ids, tagId{line[i], line[i+1], line[i+2]})
+				}
+				ans.tagLines = append(ans.tagLines, ids)
+			}
+		default:
+			return nil, fmt.Errorf("cram: unknown preservation map key %s", key)
+		}
+	}
+	if entries, err = readMap(r); err != nil {
+		return nil, err
+	}
+	for entries.pos < len(entries.data) {
+		key, err := entries.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		if ans.series[string(key)], err = readEncoding(entries); err != nil {
+			return nil, err
+		}
+	}
+	if entries, err = readMap(r); err != nil {
+		return nil, err
+	}
+	for entries.pos < len(entries.data) {
+		key, err := entries.itf8()
+		if err != nil {
+			return nil, err
+		}
+		if ans.tags[key], err = readEncoding(entries); err != nil {
+			return nil, err
+		}
+	}
+	return ans, nil
+}
+
+// readMap returns the entries of a map stored as its size in bytes, the number of entries and the entries.
+func readMap(r *byteReader) (*byteReader, error) {
+	size, err := r.itf8()
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.bytes(int(size))
+	if err != nil {
+		return nil, err
+	}
+	entries := &byteReader{data: data}
+	_, err = entries.itf8()
+	return entries, err
+}
+
+// readSliceHeader will parse the header block of a slice.
+func readSliceHeader(data []byte) (*sliceHeader, error) {
+	r := &byteReader{data: data}
+	var ans sliceHeader
+	var err error
+	for _, v := range []*int32{&ans.refId, &ans.start, &ans.span, &ans.records} {
+		if *v, err = r.itf8(); err != nil {
+			return nil, err
+		}
+	}
+	if ans.counter, err = r.ltf8(); err != nil {
+		return nil, err
+	}
+	if ans.blocks, err = r.itf8(); err != nil {
+		return nil, err
+	}
+	if _, err = r.itf8Array(); err != nil {
+		return nil, err
+	}
+	if ans.embeddedRef, err = r.itf8(); err != nil {
+		return nil, err
+	}
+	return &ans, nil
+}
+
+// record is a decoded cram record along with the information needed to link it to its mates.
+type record struct {
+	sam       bam.Sam
+	refId     int32
+	end       int
+	mateLine  int
+	upstream  bool
+	nameKnown bool
+}
+
+// feature is a difference between a read and the reference.
+type feature struct {
+	code  byte
+	pos   int
+	base  byte
+	qual  byte
+	bases []byte
+	n     int
+}
+
+// seriesInt will decode an integer from a data series.
+func (h *compressionHeader) seriesInt(key string, d *sliceData) (int32, error) {
+	e, ok := h.series[key]
+	if !ok {
+		return 0, fmt.Errorf("cram: data series %s is missing from the compression header", key)
+	}
+	return e.Int(d)
+}
+
+func (h *compressionHeader) seriesByte(key string, d *sliceData) (byte, error) {
+	e, ok := h.series[key]
+	if !ok {
+		return 0, fmt.Errorf("cram: data series %s is missing from the compression header", key)
+	}
+	return e.Byte(d)
+}
+
+func (h *compressionHeader) seriesBytes(key string, d *sliceData, n int) ([]byte, error) {
+	e, ok := h.series[key]
+	if !ok {
+		return nil, fmt.Errorf("cram: data series %s is missing from the compression header", key)
+	}
+	return e.Bytes(d, n)
+}
+
+// decodeSlice will decode every record of a slice and link mates stored in the same slice.
+func (r *Reader) decodeSlice(h *compressionHeader, s *sliceHeader, d *sliceData, embedded []code.Dna) ([]bam.Sam, error) {
+	records := make([]record, s.records)
+	prevPos := int(s.start)
+	var err error
+	for i := range records {
+		if err = r.decodeRecord(h, s, d, embedded, i, &records[i], &prevPos); err != nil {
+			return nil, fmt.Errorf("%v in record %d of slice at %d", err, s.counter+int64(i)+1, s.start)
+		}
+	}
+	for i := range records {
+		if m := records[i].mateLine; m >= 0 {
+			if m >= len(records) {
+				return nil, fmt.Errorf("cram: mate of record %d is past the end of the slice", s.counter+int64(i)+1)
+			}
+			records[m].upstream = true
+		}
+	}
+	for i := range records {
+		if !records[i].nameKnown && !records[i].upstream {
+			records[i].sam.QName, records[i].nameKnown = "cram:"+strconv.FormatInt(s.counter+int64(i)+1, 10), true
+		}
+		if records[i].mateLine >= 0 && !records[i].upstream {
+			r.linkMates(records, i)
+		}
+	}
+	ans := make([]bam.Sam, len(records))
+	for i := range records {
+		ans[i] = records[i].sam
+	}
+	return ans, nil
+}
+
+// linkMates will fill in the mate fields of a chain of records starting at first. Each record points to the next
+// segment and the last segment points back to the first. The template length spans the left most start to the right
+// most end, and is positive for the first left most segment. Records without a stored name share the name of the first.
+func (r *Reader) linkMates(records []record, first int) {
+	var chain []int
+	for i := first; i >= 0 && len(chain) <= len(records); i = records[i].mateLine {
+		chain = append(chain, i)
+	}
+	sameRef, left, right := true, 0, 0
+	for k, i := range chain {
+		rec := &records[i]
+		if rec.sam.IsUnmapped() || rec.refId != records[first].refId {
+			sameRef = false
+		}
+		if k == 0 || rec.sam.Pos < left {
+			left = rec.sam.Pos
+		}
+		if k == 0 || rec.end > right {
+			right = rec.end
+		}
+	}
+	var leftSet bool
+	for k, i := range chain {
+		rec, mate := &records[i], &records[chain[(k+1)%len(chain)]]
+		if !rec.nameKnown {
+			rec.sam.QName, rec.nameKnown = records[first].sam.QName, true
+		}
+		if mate.sam.IsReverse() {
+			rec.sam.Flag |= bam.FlagMateReverse
+		}
+		if mate.sam.IsUnmapped() {
+			rec.sam.Flag |= bam.FlagMateUnmapped
+		}
+		rec.sam.MateRef, rec.sam.MatePos = r.mateRef(rec.refId, mate.refId), mate.sam.Pos
+		switch {
+		case !sameRef:
+			rec.sam.TmpLen = 0
+		case rec.sam.Pos == left && !leftSet:
+			rec.sam.TmpLen, leftSet = right-left+1, true
+		default:
+			rec.sam.TmpLen = -(right - left + 1)
+		}
+	}
+}
+
+// mateRef returns the sam RNEXT field: = for mates on the same reference and * for unplaced mates.
+func (r *Reader) mateRef(refId int32, mateRefId int32) string {
+	switch {
+	case mateRefId < 0:
+		return "*"
+	case mateRefId == refId:
+		return "="
+	default:
+		return r.refName(mateRefId)
+	}
+}
+
+// decodeRecord will decode the data series of a single record in the order defined by the cram specification.
+func (r *Reader) decodeRecord(h *compressionHeader, s *sliceHeader, d *sliceData, embedded []code.Dna, index int, rec *record, prevPos *int) error {
+	bf, err := h.seriesInt("BF", d)
+	if err != nil {
+		return err
+	}
+	cf, err := h.seriesInt("CF", d)
+	if err != nil {
+		return err
+	}
+	rec.refId = s.refId
+	if s.refId == -2 {
+		if rec.refId, err = h.seriesInt("RI", d); err != nil {
+			return err
+		}
+	}
+	length, err := h.seriesInt("RL", d)
+	if err != nil {
+		return err
+	}
+	ap, err := h.seriesInt("AP", d)
+	if err != nil {
+		return err
+	}
+	if h.apDelta {
+		*prevPos += int(ap)
+		rec.sam.Pos = *prevPos
+	} else {
+		rec.sam.Pos = int(ap)
+	}
+	rg, err := h.seriesInt("RG", d)
+	if err != nil {
+		return err
+	}
+	if h.readNames {
+		name, err := h.seriesBytes("RN", d, -1)
+		if err != nil {
+			return err
+		}
+		rec.sam.QName, rec.nameKnown = string(bytes.TrimRight(name, "\x00")), true
+	}
+	rec.sam.Flag = uint16(bf)
+	rec.sam.RName = r.refName(rec.refId)
+	rec.sam.MateRef, rec.mateLine = "*", -1
+	switch {
+	case cf&cramDetached != 0:
+		mf, err := h.seriesInt("MF", d)
+		if err != nil {
+			return err
+		}
+		if mf&mateReverse != 0 {
+			rec.sam.Flag |= bam.FlagMateReverse
+		}
+		if mf&mateUnmapped != 0 {
+			rec.sam.Flag |= bam.FlagMateUnmapped
+		}
+		if !h.readNames {
+			name, err := h.seriesBytes("RN", d, -1)
+			if err != nil {
+				return err
+			}
+			rec.sam.QName, rec.nameKnown = string(bytes.TrimRight(name, "\x00")), true
+		}
+		ns, err := h.seriesInt("NS", d)
+		if err != nil {
+			return err
+		}
+		np, err := h.seriesInt("NP", d)
+		if err != nil {
+			return err
+		}
+		ts, err := h.seriesInt("TS", d)
+		if err != nil {
+			return err
+		}
+		rec.sam.MateRef, rec.sam.MatePos, rec.sam.TmpLen = r.mateRef(rec.refId, ns), int(np), int(ts)
+	case cf&cramMateDownstream != 0:
+		nf, err := h.seriesInt("NF", d)
+		if err != nil {
+			return err
+		}
+		rec.mateLine = index + int(nf) + 1
+	}
+	tags, err := r.decodeTags(h, d)
+	if err != nil {
+		return err
+	}
+	if int(rg) >= len(r.readGroups) {
+		return fmt.Errorf("cram: read group %d is not in the header", rg)
+	}
+	seq := make([]byte, length)
+	qual := make([]byte, length)
+	for i := range qual {
+		qual[i] = 0xff
+	}
+	var ref []code.Dna
+	refStart := 1
+	if bf&int32(bam.FlagUnmapped) == 0 {
+		ref, refStart = embedded, int(s.start)
+		if ref == nil {
+			refStart = 1
+			if ref, err = r.referenceSeq(rec.refId, h.refRequired); err != nil {
+				return err
+			}
+		}
+		features, err := decodeFeatures(h, d)
+		if err != nil {
+			return err
+		}
+		if rec.sam.Cigar, err = applyFeatures(h, features, seq, qual, ref, rec.sam.Pos-refStart); err != nil {
+			return err
+		}
+		rec.end = rec.sam.Pos + bam.ReferenceLength(rec.sam.Cigar) - 1
+		mapq, err := h.seriesInt("MQ", d)
+		if err != nil {
+			return err
+		}
+		rec.sam.MapQ = uint8(mapq)
+		if cf&cramQualArray != 0 {
+			q, err := h.seriesBytes("QS", d, int(length))
+			if err != nil {
+				return err
+			}
+			copy(qual, q)
+		}
+	} else {
+		rec.end = rec.sam.Pos
+		if cf&cramUnknownBases == 0 {
+			bases, err := h.seriesBytes("BA", d, int(length))
+			if err != nil {
+				return err
+			}
+			copy(seq, bases)
+		}
+		if cf&cramQualArray != 0 {
+			q, err := h.seriesBytes("QS", d, int(length))
+			if err != nil {
+				return err
+			}
+			copy(qual, q)
+		}
+	}
+	if cf&cramUnknownBases != 0 {
+		seq, qual = nil, nil
+	}
+	rec.sam.Seq = code.ToDna(seq)
+	rec.sam.Qual = formatQual(qual)
+	rec.sam.Aux = r.restoreTags(tags, rg, &rec.sam, ref, refStart)
+	return nil
+}
+
+// decodeTags will decode the tags listed by the tag line of a record into sam text. Tags of type '*' have no data
+// series: they mark the position of a tag that was left out by the encoder, and are returned as is for restoreTags.
+func (r *Reader) decodeTags(h *compressionHeader, d *sliceData) ([]string, error) {
+	line, err := h.seriesInt("TL", d)
+	if err != nil {
+		return nil, err
+	}
+	if line < 0 || int(line) >= len(h.tagLines) {
+		return nil, fmt.Errorf("cram: tag line %d is not in the tag dictionary", line)
+	}
+	var fields []string
+	for _, id := range h.tagLines[line] {
+		if id[2] == '*' {
+			fields = append(fields, string(id[:]))
+			continue
+		}
+		key := int32(id[0])<<16 | int32(id[1])<<8 | int32(id[2])
+		e, ok := h.tags[key]
+		if !ok {
+			return nil, fmt.Errorf("cram: tag %c%c:%c is missing from the compression header", id[0], id[1], id[2])
+		}
+		value, err := e.Bytes(d, -1)
+		if err != nil {
+			return nil, err
+		}
+		if id[2] == 'Z' || id[2] == 'H' {
+			value = bytes.TrimRight(value, "\x00")
+		}
+		fields = append(fields, bam.Aux(append([]byte{id[0], id[1], id[2]}, value...)).ToString())
+	}
+	return fields, nil
+}
+
+// restoreTags will put back the tags that htslib leaves out when encoding. RG:Z comes from the read group series and
+// MD:Z and NM:i are calculated against the reference, each at the position of its marker in the tag line. Files
+// written without an RG marker get the read group after the other tags, and MD and NM are dropped when the read
+// cannot be compared to the reference.
+func (r *Reader) restoreTags(fields []string, rg int32, s *bam.Sam, ref []code.Dna, refStart int) string {
+	var md string
+	var nm int
+	var calculated, ok, placed bool
+	ans := make([]string, 0, len(fields)+1)
+	for _, f := range fields {
+		switch f {
+		case "RG*":
+			if rg >= 0 {
+				ans = append(ans, "RG:Z:"+r.readGroups[rg])
+			}
+			placed = true
+		case "MD*", "NM*":
+			if !calculated {
+				shifted := *s
+				shifted.Pos = s.Pos - refStart + 1
+				md, nm, _, ok = bam.CalcMd(&shifted, ref)
+				calculated = true
+			}
+			switch {
+			case !ok:
+			case f == "MD*":
+				ans = append(ans, "MD:Z:"+md)
+			default:
+				ans = append(ans, "NM:i:"+strconv.Itoa(nm))
+			}
+		default:
+			ans = append(ans, f)
+		}
+	}
+	if rg >= 0 && !placed {
+		ans = append(ans, "RG:Z:"+r.readGroups[rg])
+	}
+	return strings.Join(ans, "\t")
+}
+
+// decodeFeatures will read the differences between a mapped read and the reference. Feature positions are one-based
+// and stored as the distance from the previous feature.
+func decodeFeatures(h *compressionHeader, d *sliceData) ([]feature, error) {
+	n, err := h.seriesInt("FN", d)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("cram: invalid number of read features %d", n)
+	}
+	ans := make([]feature, n)
+	var pos int
+	for i := range ans {
+		f := &ans[i]
+		if f.code, err = h.seriesByte("FC", d); err != nil {
+			return nil, err
+		}
+		delta, err := h.seriesInt("FP", d)
+		if err != nil {
+			return nil, err
+		}
+		pos += int(delta)
+		f.pos = pos
+		var count int32
+		switch f.code {
+		case 'B':
+			if f.base, err = h.seriesByte("BA", d); err == nil {
+				f.qual, err = h.seriesByte("QS", d)
+			}
+		case 'X':
+			f.base, err = h.seriesByte("BS", d)
+		case 'I':
+			f.bases, err = h.seriesBytes("IN", d, -1)
+		case 'i':
+			f.base, err = h.seriesByte("BA", d)
+		case 'S':
+			f.bases, err = h.seriesBytes("SC", d, -1)
+		case 'b':
+			f.bases, err = h.seriesBytes("BB", d, -1)
+		case 'q':
+			f.bases, err = h.seriesBytes("QQ", d, -1)
+		case 'Q':
+			f.qual, err = h.seriesByte("QS", d)
+		case 'D':
+			count, err = h.seriesInt("DL", d)
+		case 'N':
+			count, err = h.seriesInt("RS", d)
+		case 'H':
+			count, err = h.seriesInt("HC", d)
+		case 'P':
+			count, err = h.seriesInt("PD", d)
+		default:
+			return nil, fmt.Errorf("cram: unknown read feature %c", f.code)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.n = int(count)
+	}
+	return ans, nil
+}
+
+// applyFeatures will rebuild the read bases, qualities and cigar by copying reference bases between features.
+// offset is the zero-based index of the alignment start in ref.
+func applyFeatures(h *compressionHeader, features []feature, seq []byte, qual []byte, ref []code.Dna, offset int) ([]bam.ByteCigar, error) {
+	var cigar []bam.ByteCigar
+	var readPos, refPos int = 0, offset
+	var matches int
+	refBase := func() byte {
+		if refPos < 0 || refPos >= len(ref) {
+			return 'N'
+		}
+		return code.DnaToByteNoMask(ref[refPos])
+	}
+	addOp := func(op byte, n int) {
+		if matches > 0 {
+			cigar = bam.AddCigarByte(cigar, bam.ByteCigar{RunLen: uint16(matches), Op: bam.Match})
+			matches = 0
+		}
+		if n > 0 {
+			cigar = bam.AddCigarByte(cigar, bam.ByteCigar{RunLen: uint16(n), Op: op})
+		}
+	}
+	for _, f := range features {
+		if f.pos < 1 || f.pos > len(seq)+1 {
+			return nil, fmt.Errorf("cram: read feature %c at %d is outside the read of length %d", f.code, f.pos, len(seq))
+		}
+		for ; readPos < f.pos-1; readPos, refPos, matches = readPos+1, refPos+1, matches+1 {
+			seq[readPos] = refBase()
+		}
+		var length int
+		switch f.code {
+		case 'X', 'B', 'i':
+			length = 1
+		case 'I', 'S', 'b':
+			length = len(f.bases)
+		}
+		if readPos+length > len(seq) {
+			return nil, fmt.Errorf("cram: read feature %c at %d is outside the read of length %d", f.code, f.pos, len(seq))
+		}
+		switch f.code {
+		case 'X':
+			seq[readPos] = h.substitution[substitutionIndex(refBase())][f.base&3]
+			readPos, refPos, matches = readPos+1, refPos+1, matches+1
+		case 'B':
+			seq[readPos], qual[readPos] = f.base, f.qual
+			readPos, refPos, matches = readPos+1, refPos+1, matches+1
+		case 'b':
+			copy(seq[readPos:], f.bases)
+			readPos, refPos, matches = readPos+length, refPos+length, matches+length
+		case 'I':
+			copy(seq[readPos:], f.bases)
+			addOp(bam.Insertion, length)
+			readPos += length
+		case 'S':
+			copy(seq[readPos:], f.bases)
+			addOp(bam.SoftClip, length)
+			readPos += length
+		case 'i':
+			seq[readPos] = f.base
+			addOp(bam.Insertion, 1)
+			readPos++
+		case 'D':
+			addOp(bam.Deletion, f.n)
+			refPos += f.n
+		case 'N':
+			addOp(bam.N, f.n)
+			refPos += f.n
+		case 'H':
+			addOp(bam.HardClip, f.n)
+		case 'P':
+			addOp(bam.Padded, f.n)
+		case 'Q':
+			if readPos < len(qual) {
+				qual[readPos] = f.qual
+			}
+		case 'q':
+			copy(qual[readPos:], f.bases)
+		}
+	}
+	for ; readPos < len(seq); readPos, refPos, matches = readPos+1, refPos+1, matches+1 {
+		seq[readPos] = refBase()
+	}
+	addOp(bam.Match, 0)
+	return cigar, nil
+}
+
+// substitutionIndex returns the row of the substitution matrix for a reference base.
+func substitutionIndex(b byte) int {
+	for i, base := range substitutionBases[:4] {
+		if b == base {
+			return i
+		}
+	}
+	return 4
+}
+
+// formatQual will add the 33 offset to each phred score, or return * if the read has no qualities, the same as the bam reader.
+func formatQual(q []byte) []byte {
+	for _, v := range q {
+		if v != 0xff {
+			ans := make([]byte, len(q))
+			for i, p := range q {
+				ans[i] = p + 33
+			}
+			return ans
+		}
+	}
+	return []byte{'*'}
+}
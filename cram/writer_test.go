@@ -0,0 +1,1037 @@
+package cram
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/code"
+)
+
+// This file contains a small cram encoder used to create test files. It writes every data series the reader
+// supports, using a mix of encodings and block compression methods.
+
+// eofContainer is the end of file container defined by the cram 3.0 specification.
+var eofContainer = []byte{0x0f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x0f, 0xe0, 0x45, 0x4f, 0x46, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x05, 0xbd, 0xd9, 0x4f, 0x00, 0x01, 0x00, 0x06, 0x06, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0xee, 0x63, 0x01, 0x4b}
+
+// testWriterOptions controls the layout of the encoded file.
+type testWriterOptions struct {
+	sliceSize          int
+	slicesPerContainer int
+	readNames          bool
+	embedReference     bool
+	tagMarkers         bool
+	methods            map[int32]byte
+	defaultMethod      byte
+	coreMethod         byte
+}
+
+// Content ids of the external blocks holding each data series.
+var testSeriesBlocks = map[string]int32{"BF": 1, "AP": 2, "RG": 3, "RN": 4, "MF": 5, "NS": 6, "NP": 7, "TS": 8, "NF": 9, "TL": 10,
+	"FN": 11, "BA": 12, "QS": 13, "IN": 14, "SC": 15, "BB": 16, "QQ": 17, "RI": 18}
+
+const testEmbeddedBlock int32 = 30
+
+func putItf8(buf *bytes.Buffer, v int32) {
+	u := uint32(v)
+	switch {
+	case u < 0x80:
+		buf.WriteByte(byte(u))
+	case u < 0x4000:
+		buf.Write([]byte{0x80 | byte(u>>8), byte(u)})
+	case u < 0x200000:
+		buf.Write([]byte{0xc0 | byte(u>>16), byte(u >> 8), byte(u)})
+	case u < 0x10000000:
+		buf.Write([]byte{0xe0 | byte(u>>24), byte(u >> 16), byte(u >> 8), byte(u)})
+	default:
+		buf.Write([]byte{0xf0 | byte(u>>28)&0x0f, byte(u >> 20), byte(u >> 12), byte(u >> 4), byte(u) & 0x0f})
+	}
+}
+
+func putLtf8(buf *bytes.Buffer, v int64) {
+	u := uint64(v)
+	n := 1
+	for n < 9 && u >= uint64(1)<<uint(7*n) {
+		n++
+	}
+	if n == 9 {
+		buf.WriteByte(0xff)
+		for i := 7; i >= 0; i-- {
+			buf.WriteByte(byte(u >> uint(8*i)))
+		}
+		return
+	}
+	prefix := byte(0xff << uint(9-n))
+	if n == 8 {
+		prefix = 0xfe
+		buf.WriteByte(prefix)
+	} else {
+		buf.WriteByte(prefix | byte(u>>uint(8*(n-1))))
+	}
+	for i := n - 2; i >= 0; i-- {
+		buf.WriteByte(byte(u >> uint(8*i)))
+	}
+}
+
+func putInt32(buf *bytes.Buffer, v int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	buf.Write(b[:])
+}
+
+// testBitWriter writes the most significant bit of each byte first.
+type testBitWriter struct {
+	data []byte
+	bits uint
+}
+
+func (w *testBitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte((v>>uint(i))&1) << (7 - w.bits%8)
+		w.bits++
+	}
+}
+
+// testEncoder writes the values of one data series.
+type testEncoder interface {
+	params() []byte
+	putInt(s *testSlice, v int32)
+}
+
+type testExternal struct{ block int32 }
+
+func (e testExternal) params() []byte {
+	var buf, p bytes.Buffer
+	putItf8(&p, e.block)
+	putItf8(&buf, encodingExternal)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+func (e testExternal) putInt(s *testSlice, v int32) { putItf8(s.block(e.block), v) }
+
+type testBeta struct{ offset, bits int32 }
+
+func (e testBeta) params() []byte {
+	var buf, p bytes.Buffer
+	putItf8(&p, e.offset)
+	putItf8(&p, e.bits)
+	putItf8(&buf, encodingBeta)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+func (e testBeta) putInt(s *testSlice, v int32) { s.core.writeBits(uint32(v+e.offset), int(e.bits)) }
+
+type testGamma struct{ offset int32 }
+
+func (e testGamma) params() []byte {
+	var buf, p bytes.Buffer
+	putItf8(&p, e.offset)
+	putItf8(&buf, encodingGamma)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+func (e testGamma) putInt(s *testSlice, v int32) {
+	n := uint32(v + e.offset)
+	var bits int
+	for n>>uint(bits+1) != 0 {
+		bits++
+	}
+	s.core.writeBits(0, bits)
+	s.core.writeBits(n, bits+1)
+}
+
+type testSubexp struct{ offset, k int32 }
+
+func (e testSubexp) params() []byte {
+	var buf, p bytes.Buffer
+	putItf8(&p, e.offset)
+	putItf8(&p, e.k)
+	putItf8(&buf, encodingSubexp)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+func (e testSubexp) putInt(s *testSlice, v int32) {
+	n := uint32(v + e.offset)
+	if n < 1<<uint(e.k) {
+		s.core.writeBits(0, 1)
+		s.core.writeBits(n, int(e.k))
+		return
+	}
+	var b int
+	for n>>uint(b+1) != 0 {
+		b++
+	}
+	for i := 0; i < b-int(e.k)+1; i++ {
+		s.core.writeBits(1, 1)
+	}
+	s.core.writeBits(0, 1)
+	s.core.writeBits(n, b)
+}
+
+// testHuffman builds code lengths by repeatedly merging the two least frequent subtrees.
+type testHuffman struct {
+	symbols []int32
+	lengths []int32
+	codes   map[int32][2]uint32
+}
+
+func newTestHuffman(counts map[int32]int) *testHuffman {
+	ans := &testHuffman{codes: make(map[int32][2]uint32)}
+	type node struct {
+		weight  int
+		symbols []int
+	}
+	var nodes []node
+	for sym := range counts {
+		ans.symbols = append(ans.symbols, sym)
+	}
+	sort.Slice(ans.symbols, func(i, j int) bool { return ans.symbols[i] < ans.symbols[j] })
+	ans.lengths = make([]int32, len(ans.symbols))
+	for i, sym := range ans.symbols {
+		nodes = append(nodes, node{weight: counts[sym], symbols: []int{i}})
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		merged := node{weight: nodes[0].weight + nodes[1].weight, symbols: append(append([]int{}, nodes[0].symbols...), nodes[1].symbols...)}
+		for _, i := range merged.symbols {
+			ans.lengths[i]++
+		}
+		nodes = append([]node{merged}, nodes[2:]...)
+	}
+	var p bytes.Buffer
+	ans.writeParams(&p)
+	decoder, err := newHuffman(&byteReader{data: p.Bytes()})
+	if err != nil {
+		panic(err)
+	}
+	h := decoder.(*huffmanEncoding)
+	for i, sym := range h.symbols {
+		ans.codes[sym] = [2]uint32{h.codes[i], uint32(h.lengths[i])}
+	}
+	return ans
+}
+
+func (e *testHuffman) writeParams(p *bytes.Buffer) {
+	putItf8(p, int32(len(e.symbols)))
+	for _, sym := range e.symbols {
+		putItf8(p, sym)
+	}
+	putItf8(p, int32(len(e.lengths)))
+	for _, length := range e.lengths {
+		putItf8(p, length)
+	}
+}
+
+func (e *testHuffman) params() []byte {
+	var buf, p bytes.Buffer
+	e.writeParams(&p)
+	putItf8(&buf, encodingHuffman)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+func (e *testHuffman) putInt(s *testSlice, v int32) {
+	code, ok := e.codes[v]
+	if !ok {
+		panic("huffman symbol is missing from the alphabet")
+	}
+	s.core.writeBits(code[0], int(code[1]))
+}
+
+// byteArrayLenParams encodes arrays as an external length followed by external values.
+func byteArrayLenParams(block int32) []byte {
+	var buf, p bytes.Buffer
+	p.Write(testExternal{block}.params())
+	p.Write(testExternal{block}.params())
+	putItf8(&buf, encodingByteArrayLen)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+// byteArrayStopParams encodes arrays as external bytes ending with a zero.
+func byteArrayStopParams(block int32) []byte {
+	var buf, p bytes.Buffer
+	p.WriteByte(0)
+	putItf8(&p, block)
+	putItf8(&buf, encodingByteArrayStop)
+	putItf8(&buf, int32(p.Len()))
+	buf.Write(p.Bytes())
+	return buf.Bytes()
+}
+
+// testSlice collects the core and external data of one slice.
+type testSlice struct {
+	core     testBitWriter
+	external map[int32]*bytes.Buffer
+}
+
+func (s *testSlice) block(id int32) *bytes.Buffer {
+	if s.external[id] == nil {
+		s.external[id] = &bytes.Buffer{}
+	}
+	return s.external[id]
+}
+
+// testRecord is a sam record prepared for encoding.
+type testRecord struct {
+	sam      *bam.Sam
+	refId    int32
+	mateRef  int32
+	cf       int32
+	flag     uint16
+	mateNext int
+	tags     []bam.Aux
+	rg       int32
+	tagLine  int32
+}
+
+// testContainerEncoders holds the encodings shared by every slice of a container.
+type testContainerEncoders struct {
+	cf, rl, fc *testHuffman
+	mq, bs     testBeta
+	fp         testGamma
+	dl         testSubexp
+}
+
+// writeTestCram will encode the header and records as a cram file.
+func writeTestCram(header *bam.Header, records []*bam.Sam, reference map[string][]code.Dna, opt testWriterOptions) []byte {
+	var out bytes.Buffer
+	out.WriteString("CRAM")
+	out.Write([]byte{3, 0})
+	out.Write(make([]byte, 20))
+
+	var text bytes.Buffer
+	putInt32(&text, int32(header.Text.Len()))
+	text.Write(header.Text.Bytes())
+	headerBlock := encodeTestBlock(methodGzip, contentFileHeader, 0, text.Bytes())
+	writeTestContainer(&out, -1, 0, 0, 0, 0, 1, nil, headerBlock)
+
+	refIds := make(map[string]int32)
+	for i, c := range header.Chroms {
+		refIds[c.Name] = int32(i)
+	}
+	readGroups := make(map[string]int32)
+	for i, rg := range bam.NewSamHeader(header).ReadGroups {
+		id, _ := rg.Get("ID")
+		readGroups[id] = int32(i)
+	}
+	var counter int64
+	perContainer := opt.sliceSize * opt.slicesPerContainer
+	for start := 0; start < len(records); start += perContainer {
+		end := start + perContainer
+		if end > len(records) {
+			end = len(records)
+		}
+		counter = writeTestDataContainer(&out, records[start:end], refIds, readGroups, reference, opt, counter)
+	}
+	out.Write(eofContainer)
+	return out.Bytes()
+}
+
+// writeTestContainer will write a container header followed by its blocks.
+func writeTestContainer(out *bytes.Buffer, refId, start, span, records int32, counter int64, blocks int32, landmarks []int32, data []byte) {
+	var h bytes.Buffer
+	putInt32(&h, int32(len(data)))
+	putItf8(&h, refId)
+	putItf8(&h, start)
+	putItf8(&h, span)
+	putItf8(&h, records)
+	putLtf8(&h, counter)
+	putLtf8(&h, 0)
+	putItf8(&h, blocks)
+	putItf8(&h, int32(len(landmarks)))
+	for _, l := range landmarks {
+		putItf8(&h, l)
+	}
+	putInt32(&h, int32(crc32.ChecksumIEEE(h.Bytes())))
+	out.Write(h.Bytes())
+	out.Write(data)
+}
+
+// encodeTestBlock will compress the data of a block and add the block header and crc32.
+func encodeTestBlock(method byte, contentType byte, contentId int32, data []byte) []byte {
+	if len(data) == 0 {
+		method = methodRaw
+	}
+	compressed := data
+	switch method {
+	case methodGzip:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		compressed = buf.Bytes()
+	case methodRans:
+		compressed = ransEncode(data, 0)
+	case methodRans + 1:
+		method, compressed = methodRans, ransEncode(data, 1)
+	}
+	var b bytes.Buffer
+	b.WriteByte(method)
+	b.WriteByte(contentType)
+	putItf8(&b, contentId)
+	putItf8(&b, int32(len(compressed)))
+	putItf8(&b, int32(len(data)))
+	b.Write(compressed)
+	putInt32(&b, int32(crc32.ChecksumIEEE(b.Bytes())))
+	return b.Bytes()
+}
+
+// prepareTestRecords will find mates that can be restored from the slice, and the tags and read group of each record.
+// With tag markers the read group is replaced by an RG* marker, and MD and NM by MD* and NM* when the reader will
+// calculate the same values, as htslib does. Otherwise only a read group after the other tags is left out.
+func prepareTestRecords(records []*bam.Sam, refIds map[string]int32, readGroups map[string]int32, reference map[string][]code.Dna, markers bool) []testRecord {
+	ans := make([]testRecord, len(records))
+	names := make(map[string][]int)
+	for i, s := range records {
+		ans[i] = testRecord{sam: s, refId: -1, mateRef: -1, flag: s.Flag, mateNext: -1, rg: -1, tags: bam.ParseAux(s.Aux)}
+		if id, ok := refIds[s.RName]; ok {
+			ans[i].refId = id
+		}
+		if id, ok := refIds[s.MateRef]; ok {
+			ans[i].mateRef = id
+		} else if s.MateRef == "=" {
+			ans[i].mateRef = ans[i].refId
+		}
+		if markers {
+			md, nm, _, ok := bam.CalcMd(s, reference[s.RName])
+			for k, a := range ans[i].tags {
+				switch {
+				case a.Name() == "RG" && ans[i].rg < 0:
+					if rg, found := readGroups[string(a[3:])]; found {
+						ans[i].rg, ans[i].tags[k] = rg, bam.Aux("RG*")
+					}
+				case a.Name() == "MD" && ok && string(a[3:]) == md:
+					ans[i].tags[k] = bam.Aux("MD*")
+				case a.Name() == "NM" && ok:
+					if n, isInt := a.Int(); isInt && n == nm {
+						ans[i].tags[k] = bam.Aux("NM*")
+					}
+				}
+			}
+		} else if n := len(ans[i].tags); n > 0 && ans[i].tags[n-1].Name() == "RG" {
+			if rg, ok := readGroups[string(ans[i].tags[n-1][3:])]; ok {
+				ans[i].rg, ans[i].tags = rg, ans[i].tags[:n-1]
+			}
+		}
+		if s.IsPaired() && !s.IsSecondary() && !s.IsSupplementary() {
+			names[s.QName] = append(names[s.QName], i)
+		}
+	}
+	reader := &Reader{Header: &bam.Header{}}
+	for i := range ans {
+		lines := names[records[i].QName]
+		if len(lines) != 2 || lines[0] != i {
+			continue
+		}
+		j := lines[1]
+		if canAttach(reader, refIds, records[i], records[j], ans[i].refId, ans[j].refId) {
+			ans[i].mateNext = j
+			ans[i].cf |= cramMateDownstream
+			ans[i].flag &^= bam.FlagMateReverse | bam.FlagMateUnmapped
+			ans[j].flag &^= bam.FlagMateReverse | bam.FlagMateUnmapped
+			ans[j].cf = -1
+		}
+	}
+	for i := range ans {
+		s := records[i]
+		switch {
+		case ans[i].cf == -1:
+			ans[i].cf = 0
+		case ans[i].cf&cramMateDownstream != 0:
+		case s.MateRef != "*" || s.MatePos != 0 || s.TmpLen != 0 || s.Flag&(bam.FlagMateReverse|bam.FlagMateUnmapped) != 0:
+			ans[i].cf |= cramDetached
+		}
+		if len(s.Qual) == len(s.Seq) && len(s.Seq) > 0 {
+			ans[i].cf |= cramQualArray
+		}
+		if len(s.Seq) == 0 {
+			ans[i].cf |= cramUnknownBases
+		}
+	}
+	return ans
+}
+
+// canAttach returns true if the reader will restore the mate fields of both reads exactly.
+func canAttach(reader *Reader, refIds map[string]int32, a *bam.Sam, b *bam.Sam, refA int32, refB int32) bool {
+	names := make([]bam.ChromSize, len(refIds))
+	for name, id := range refIds {
+		names[id] = bam.ChromSize{Name: name}
+	}
+	reader.Header.Chroms = names
+	linked := []record{{sam: *a, refId: refA, mateLine: 1, nameKnown: true}, {sam: *b, refId: refB, mateLine: -1, nameKnown: true}}
+	for k := range linked {
+		linked[k].sam.Flag &^= bam.FlagMateReverse | bam.FlagMateUnmapped
+		linked[k].sam.MateRef, linked[k].sam.MatePos, linked[k].sam.TmpLen = "", 0, 0
+		linked[k].end = linked[k].sam.Pos
+		if !linked[k].sam.IsUnmapped() {
+			linked[k].end = linked[k].sam.Pos + bam.ReferenceLength(linked[k].sam.Cigar) - 1
+		}
+	}
+	reader.linkMates(linked, 0)
+	for k, s := range []*bam.Sam{a, b} {
+		l := linked[k].sam
+		if l.Flag != s.Flag || l.MateRef != s.MateRef || l.MatePos != s.MatePos || l.TmpLen != s.TmpLen {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTestDataContainer will encode a container of records and return the updated record counter.
+func writeTestDataContainer(out *bytes.Buffer, records []*bam.Sam, refIds map[string]int32, readGroups map[string]int32,
+	reference map[string][]code.Dna, opt testWriterOptions, counter int64) int64 {
+	var slices [][]testRecord
+	var tagLines [][]tagId
+	lineIndex := make(map[string]int32)
+	enc := testContainerEncoders{mq: testBeta{0, 8}, bs: testBeta{0, 2}, fp: testGamma{1}, dl: testSubexp{0, 2}}
+	cfCounts, rlCounts, fcCounts := make(map[int32]int), make(map[int32]int), map[int32]int{'X': 1}
+	tagIds := make(map[int32]bool)
+	for start := 0; start < len(records); start += opt.sliceSize {
+		end := start + opt.sliceSize
+		if end > len(records) {
+			end = len(records)
+		}
+		prepared := prepareTestRecords(records[start:end], refIds, readGroups, reference, opt.tagMarkers)
+		for i := range prepared {
+			var line []tagId
+			for _, a := range prepared[i].tags {
+				id := tagId{a[0], a[1], a[2]}
+				line = append(line, id)
+				if id[2] == '*' {
+					continue
+				}
+				tagIds[int32(id[0])<<16|int32(id[1])<<8|int32(id[2])] = true
+			}
+			key := string(flattenTagIds(line))
+			if _, ok := lineIndex[key]; !ok {
+				lineIndex[key] = int32(len(tagLines))
+				tagLines = append(tagLines, line)
+			}
+			prepared[i].tagLine = lineIndex[key]
+			cfCounts[prepared[i].cf]++
+			rlCounts[int32(len(prepared[i].sam.Seq))]++
+			if len(prepared[i].sam.Seq) == 0 {
+				rlCounts[int32(bam.QueryRunLen(prepared[i].sam.Cigar))]++
+			}
+			for _, c := range prepared[i].sam.Cigar {
+				fcCounts[int32(c.Op)]++
+			}
+		}
+		slices = append(slices, prepared)
+	}
+	for _, op := range []int32{'I', 'i', 'B', 'Q'} {
+		fcCounts[op]++
+	}
+	enc.cf, enc.rl, enc.fc = newTestHuffman(cfCounts), newTestHuffman(rlCounts), newTestHuffman(fcCounts)
+
+	var data bytes.Buffer
+	data.Write(encodeTestBlock(methodGzip, contentCompressionHeader, 0, testCompressionHeader(opt, tagLines, tagIds, enc)))
+	var landmarks []int32
+	blocks := int32(1)
+	containerRef := int32(-3)
+	for _, prepared := range slices {
+		landmarks = append(landmarks, int32(data.Len()))
+		sliceBlocks, n := encodeTestSlice(prepared, reference, opt, enc, counter)
+		data.Write(sliceBlocks)
+		blocks += n
+		counter += int64(len(prepared))
+		for _, r := range prepared {
+			if containerRef == -3 {
+				containerRef = r.refId
+			} else if containerRef != r.refId {
+				containerRef = -2
+			}
+		}
+	}
+	writeTestContainer(out, containerRef, 0, 0, int32(len(records)), counter-int64(len(records)), blocks, landmarks, data.Bytes())
+	return counter
+}
+
+func flattenTagIds(ids []tagId) []byte {
+	var ans []byte
+	for _, id := range ids {
+		ans = append(ans, id[:]...)
+	}
+	return ans
+}
+
+// testCompressionHeader will write the preservation map and the encodings of every data series and tag.
+func testCompressionHeader(opt testWriterOptions, tagLines [][]tagId, tagIds map[int32]bool, enc testContainerEncoders) []byte {
+	var preservation, entries bytes.Buffer
+	entries.WriteString("RN")
+	if opt.readNames {
+		entries.WriteByte(1)
+	} else {
+		entries.WriteByte(0)
+	}
+	entries.WriteString("AP")
+	entries.WriteByte(1)
+	entries.WriteString("RR")
+	entries.WriteByte(1)
+	// the default substitution matrix assigns codes 0 to 3 to the other bases in ACGTN order
+	entries.WriteString("SM")
+	entries.Write([]byte{0x1b, 0x1b, 0x1b, 0x1b, 0x1b})
+	var dictionary bytes.Buffer
+	for _, line := range tagLines {
+		dictionary.Write(flattenTagIds(line))
+		dictionary.WriteByte(0)
+	}
+	entries.WriteString("TD")
+	putItf8(&entries, int32(dictionary.Len()))
+	entries.Write(dictionary.Bytes())
+	writeTestMap(&preservation, 5, entries.Bytes())
+
+	var series bytes.Buffer
+	var count int32
+	add := func(key string, params []byte) {
+		series.WriteString(key)
+		series.Write(params)
+		count++
+	}
+	for _, key := range []string{"BF", "AP", "RG", "MF", "NS", "NP", "TS", "NF", "TL", "FN", "BA", "QS", "RI"} {
+		add(key, testExternal{testSeriesBlocks[key]}.params())
+	}
+	for _, key := range []string{"RN", "IN", "SC"} {
+		add(key, byteArrayStopParams(testSeriesBlocks[key]))
+	}
+	for _, key := range []string{"BB", "QQ"} {
+		add(key, byteArrayLenParams(testSeriesBlocks[key]))
+	}
+	add("CF", enc.cf.params())
+	add("RL", enc.rl.params())
+	add("FC", enc.fc.params())
+	add("MQ", enc.mq.params())
+	add("BS", enc.bs.params())
+	add("FP", enc.fp.params())
+	for _, key := range []string{"DL", "RS", "HC", "PD"} {
+		add(key, enc.dl.params())
+	}
+	writeTestMap(&preservation, count, series.Bytes())
+
+	var tags bytes.Buffer
+	keys := make([]int32, 0, len(tagIds))
+	for key := range tagIds {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		putItf8(&tags, key)
+		tags.Write(byteArrayLenParams(key))
+	}
+	writeTestMap(&preservation, int32(len(keys)), tags.Bytes())
+	return preservation.Bytes()
+}
+
+func writeTestMap(out *bytes.Buffer, count int32, entries []byte) {
+	var m bytes.Buffer
+	putItf8(&m, count)
+	m.Write(entries)
+	putItf8(out, int32(m.Len()))
+	out.Write(m.Bytes())
+}
+
+// encodeTestSlice will encode the records of a slice and return its blocks and the number of blocks written.
+func encodeTestSlice(records []testRecord, reference map[string][]code.Dna, opt testWriterOptions, enc testContainerEncoders, counter int64) ([]byte, int32) {
+	s := &testSlice{external: make(map[int32]*bytes.Buffer)}
+	refId, start, end := records[0].refId, 0, 0
+	for k, r := range records {
+		if r.refId != refId {
+			refId = -2
+		}
+		if k == 0 || r.sam.Pos < start {
+			start = r.sam.Pos
+		}
+		if e := r.sam.Pos + bam.ReferenceLength(r.sam.Cigar); e > end {
+			end = e
+		}
+	}
+	var ref []code.Dna
+	refStart := start
+	embedded := opt.embedReference && refId >= 0
+	if embedded {
+		ref = reference[records[0].sam.RName]
+		var seq []byte
+		for pos := start; pos < end; pos++ {
+			if pos-1 < len(ref) {
+				seq = append(seq, code.DnaToByteNoMask(ref[pos-1]))
+			} else {
+				seq = append(seq, 'N')
+			}
+		}
+		s.block(testEmbeddedBlock).Write(seq)
+		ref = code.ToDna(seq)
+	}
+	prevPos := start
+	for i, r := range records {
+		sam := r.sam
+		putItf8(s.block(testSeriesBlocks["BF"]), int32(r.flag))
+		enc.cf.putInt(s, r.cf)
+		if refId == -2 {
+			putItf8(s.block(testSeriesBlocks["RI"]), r.refId)
+		}
+		length := len(sam.Seq)
+		if length == 0 {
+			length = bam.QueryRunLen(sam.Cigar)
+		}
+		enc.rl.putInt(s, int32(length))
+		putItf8(s.block(testSeriesBlocks["AP"]), int32(sam.Pos-prevPos))
+		prevPos = sam.Pos
+		putItf8(s.block(testSeriesBlocks["RG"]), r.rg)
+		if opt.readNames {
+			s.block(testSeriesBlocks["RN"]).Write(append([]byte(sam.QName), 0))
+		}
+		switch {
+		case r.cf&cramDetached != 0:
+			var mf int32
+			if sam.Flag&bam.FlagMateReverse != 0 {
+				mf |= mateReverse
+			}
+			if sam.Flag&bam.FlagMateUnmapped != 0 {
+				mf |= mateUnmapped
+			}
+			putItf8(s.block(testSeriesBlocks["MF"]), mf)
+			if !opt.readNames {
+				s.block(testSeriesBlocks["RN"]).Write(append([]byte(sam.QName), 0))
+			}
+			putItf8(s.block(testSeriesBlocks["NS"]), r.mateRef)
+			putItf8(s.block(testSeriesBlocks["NP"]), int32(sam.MatePos))
+			putItf8(s.block(testSeriesBlocks["TS"]), int32(sam.TmpLen))
+		case r.cf&cramMateDownstream != 0:
+			putItf8(s.block(testSeriesBlocks["NF"]), int32(r.mateNext-i-1))
+		}
+		putItf8(s.block(testSeriesBlocks["TL"]), r.tagLine)
+		for _, a := range r.tags {
+			if a[2] == '*' {
+				continue
+			}
+			key := int32(a[0])<<16 | int32(a[1])<<8 | int32(a[2])
+			value := []byte(a[3:])
+			if a[2] == 'Z' || a[2] == 'H' {
+				value = append(append([]byte{}, value...), 0)
+			}
+			putItf8(s.block(key), int32(len(value)))
+			s.block(key).Write(value)
+		}
+		qual := make([]byte, length)
+		for k := range qual {
+			qual[k] = 0xff
+			if r.cf&cramQualArray != 0 {
+				qual[k] = sam.Qual[k] - 33
+			}
+		}
+		if !sam.IsUnmapped() {
+			if embedded {
+				encodeTestFeatures(s, sam, qual, ref, sam.Pos-refStart, enc)
+			} else {
+				encodeTestFeatures(s, sam, qual, reference[sam.RName], sam.Pos-1, enc)
+			}
+			enc.mq.putInt(s, int32(sam.MapQ))
+			if r.cf&cramQualArray != 0 {
+				s.block(testSeriesBlocks["QS"]).Write(qual)
+			}
+		} else {
+			if r.cf&cramUnknownBases == 0 {
+				s.block(testSeriesBlocks["BA"]).Write(code.ToBytes(sam.Seq))
+			}
+			if r.cf&cramQualArray != 0 {
+				s.block(testSeriesBlocks["QS"]).Write(qual)
+			}
+		}
+	}
+
+	ids := make([]int32, 0, len(s.external))
+	for id := range s.external {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var h bytes.Buffer
+	startField := int32(start)
+	if refId == -1 {
+		startField = 0
+	}
+	putItf8(&h, refId)
+	putItf8(&h, startField)
+	putItf8(&h, int32(end-start))
+	putItf8(&h, int32(len(records)))
+	putLtf8(&h, counter)
+	putItf8(&h, int32(len(ids)+1))
+	putItf8(&h, int32(len(ids)+1))
+	putItf8(&h, 0)
+	for _, id := range ids {
+		putItf8(&h, id)
+	}
+	if embedded {
+		putItf8(&h, testEmbeddedBlock)
+	} else {
+		putItf8(&h, -1)
+	}
+	h.Write(make([]byte, 16))
+
+	var ans bytes.Buffer
+	ans.Write(encodeTestBlock(methodRaw, contentSliceHeader, 0, h.Bytes()))
+	ans.Write(encodeTestBlock(opt.coreMethod, contentCore, 0, s.core.data))
+	for _, id := range ids {
+		method, ok := opt.methods[id]
+		if !ok {
+			method = opt.defaultMethod
+		}
+		ans.Write(encodeTestBlock(method, contentExternal, id, s.external[id].Bytes()))
+	}
+	return ans.Bytes(), int32(len(ids) + 2)
+}
+
+// encodeTestFeatures will write the differences between a read and the reference, using X for substitutions of
+// A, C, G, T and N, B for other bases, i for single base insertions and Q for one changed base quality.
+func encodeTestFeatures(s *testSlice, sam *bam.Sam, qual []byte, ref []code.Dna, offset int, enc testContainerEncoders) {
+	type testFeature struct {
+		code  byte
+		pos   int
+		value int32
+		bases []byte
+	}
+	var features []testFeature
+	seq := code.ToBytes(sam.Seq)
+	if len(seq) == 0 {
+		seq = bytes.Repeat([]byte{'N'}, bam.QueryRunLen(sam.Cigar))
+	}
+	refBase := func(pos int) byte {
+		if pos < 0 || pos >= len(ref) {
+			return 'N'
+		}
+		return code.DnaToByteNoMask(ref[pos])
+	}
+	var readPos, refPos int = 0, offset
+	for _, c := range sam.Cigar {
+		n := int(c.RunLen)
+		switch c.Op {
+		case bam.Match, bam.EqualByte, bam.Mismatch:
+			for k := 0; k < n; k, readPos, refPos = k+1, readPos+1, refPos+1 {
+				b, rb := seq[readPos], refBase(refPos)
+				if b == rb {
+					continue
+				}
+				if row := substitutionIndex(rb); bytes.IndexByte([]byte("ACGTN"), b) >= 0 {
+					var bs int32
+					for code := int32(0); code < 4; code++ {
+						if defaultSubstitution[row][code] == b {
+							bs = code
+						}
+					}
+					features = append(features, testFeature{code: 'X', pos: readPos + 1, value: bs})
+				} else {
+					features = append(features, testFeature{code: 'B', pos: readPos + 1, bases: []byte{b, qual[readPos]}})
+				}
+			}
+		case bam.Insertion:
+			if n == 1 {
+				features = append(features, testFeature{code: 'i', pos: readPos + 1, bases: seq[readPos : readPos+1]})
+			} else {
+				features = append(features, testFeature{code: 'I', pos: readPos + 1, bases: seq[readPos : readPos+n]})
+			}
+			readPos += n
+		case bam.SoftClip:
+			features = append(features, testFeature{code: 'S', pos: readPos + 1, bases: seq[readPos : readPos+n]})
+			readPos += n
+		case bam.Deletion, bam.N:
+			features = append(features, testFeature{code: c.Op, pos: readPos + 1, value: int32(n)})
+			refPos += n
+		case bam.HardClip, bam.Padded:
+			features = append(features, testFeature{code: c.Op, pos: readPos + 1, value: int32(n)})
+		}
+	}
+	putItf8(s.block(testSeriesBlocks["FN"]), int32(len(features)))
+	var prev int
+	for _, f := range features {
+		enc.fc.putInt(s, int32(f.code))
+		enc.fp.putInt(s, int32(f.pos-prev))
+		prev = f.pos
+		switch f.code {
+		case 'X':
+			enc.bs.putInt(s, f.value)
+		case 'B':
+			s.block(testSeriesBlocks["BA"]).WriteByte(f.bases[0])
+			s.block(testSeriesBlocks["QS"]).WriteByte(f.bases[1])
+		case 'i':
+			s.block(testSeriesBlocks["BA"]).WriteByte(f.bases[0])
+		case 'I':
+			s.block(testSeriesBlocks["IN"]).Write(append(append([]byte{}, f.bases...), 0))
+		case 'S':
+			s.block(testSeriesBlocks["SC"]).Write(append(append([]byte{}, f.bases...), 0))
+		default:
+			enc.dl.putInt(s, f.value)
+		}
+	}
+}
+
+// defaultSubstitution is the substitution matrix written by testCompressionHeader.
+var defaultSubstitution = func() [5][4]byte {
+	var ans [5][4]byte
+	for i, ref := range substitutionBases {
+		var k int
+		for _, alt := range substitutionBases {
+			if alt != ref {
+				ans[i][k] = alt
+				k++
+			}
+		}
+	}
+	return ans
+}()
+
+// ransEncode is the inverse of ransDecode. Symbols are encoded in the reverse of the order they are decoded, and
+// the output bytes are collected backwards.
+func ransEncode(data []byte, order int) []byte {
+	type event struct {
+		state int
+		ctx   byte
+		sym   byte
+	}
+	var events []event
+	if order == 0 {
+		for i, b := range data {
+			events = append(events, event{state: i % 4, sym: b})
+		}
+	} else {
+		quarter := len(data) / 4
+		var last [4]byte
+		for i := 0; i < quarter; i++ {
+			for j := 0; j < 4; j++ {
+				events = append(events, event{state: j, ctx: last[j], sym: data[j*quarter+i]})
+				last[j] = data[j*quarter+i]
+			}
+		}
+		for i := 4 * quarter; i < len(data); i++ {
+			events = append(events, event{state: 3, ctx: last[3], sym: data[i]})
+			last[3] = data[i]
+		}
+	}
+	counts := make(map[byte]map[byte]int)
+	for _, e := range events {
+		if counts[e.ctx] == nil {
+			counts[e.ctx] = make(map[byte]int)
+		}
+		counts[e.ctx][e.sym]++
+	}
+	tables := make(map[byte]map[byte]ransSymbol)
+	var header bytes.Buffer
+	contexts := sortedKeys(counts)
+	if order == 0 {
+		tables[0] = writeTestRansTable(&header, counts[0])
+	} else {
+		writeTestRansRuns(&header, contexts, func(ctx byte) { tables[ctx] = writeTestRansTable(&header, counts[ctx]) })
+	}
+	states := [4]uint32{ransLowerBound, ransLowerBound, ransLowerBound, ransLowerBound}
+	var reversed []byte
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		s := tables[e.ctx][e.sym]
+		x := states[e.state]
+		max := ((ransLowerBound >> ransFreqBits) << 8) * s.freq
+		for x >= max {
+			reversed = append(reversed, byte(x))
+			x >>= 8
+		}
+		states[e.state] = (x/s.freq)<<ransFreqBits + x%s.freq + s.start
+	}
+	for i := 3; i >= 0; i-- {
+		x := states[i]
+		reversed = append(reversed, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+	}
+	var ans bytes.Buffer
+	ans.WriteByte(byte(order))
+	body := header.Bytes()
+	for i := len(reversed) - 1; i >= 0; i-- {
+		body = append(body, reversed[i])
+	}
+	putInt32(&ans, int32(len(body)))
+	putInt32(&ans, int32(len(data)))
+	ans.Write(body)
+	return ans.Bytes()
+}
+
+func sortedKeys(counts map[byte]map[byte]int) []byte {
+	var ans []byte
+	for k := range counts {
+		ans = append(ans, k)
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i] < ans[j] })
+	return ans
+}
+
+// writeTestRansRuns writes symbols in the run length format read by readRansFrequencies, calling body after each symbol.
+func writeTestRansRuns(out *bytes.Buffer, symbols []byte, body func(byte)) {
+	out.WriteByte(symbols[0])
+	var run int
+	for k, sym := range symbols {
+		body(sym)
+		if run > 0 {
+			run--
+			continue
+		}
+		var next byte
+		if k+1 < len(symbols) {
+			next = symbols[k+1]
+		}
+		out.WriteByte(next)
+		if next != 0 && next == sym+1 {
+			for k+2+run < len(symbols) && symbols[k+2+run] == symbols[k+1+run]+1 {
+				run++
+			}
+			out.WriteByte(byte(run))
+		}
+	}
+}
+
+// writeTestRansTable will scale symbol counts to sum to 4096 and write the frequency table.
+func writeTestRansTable(out *bytes.Buffer, counts map[byte]int) map[byte]ransSymbol {
+	var total, sum int
+	var symbols []byte
+	for sym, n := range counts {
+		symbols = append(symbols, sym)
+		total += n
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+	freqs := make(map[byte]int)
+	largest := symbols[0]
+	for _, sym := range symbols {
+		freqs[sym] = counts[sym] * ransFreqTotal / total
+		if freqs[sym] == 0 {
+			freqs[sym] = 1
+		}
+		sum += freqs[sym]
+		if counts[sym] > counts[largest] {
+			largest = sym
+		}
+	}
+	freqs[largest] += ransFreqTotal - sum
+	ans := make(map[byte]ransSymbol)
+	var start uint32
+	writeTestRansRuns(out, symbols, func(sym byte) {
+		f := freqs[sym]
+		if f >= 0x80 {
+			out.WriteByte(byte(0x80 | f>>8))
+		}
+		out.WriteByte(byte(f))
+		ans[sym] = ransSymbol{start: start, freq: uint32(f)}
+		start += uint32(f)
+	})
+	return ans
+}
@@ -66,7 +66,9 @@ func GunzipFastq(reader *simpleio.SimpleReader) (*Fastq, bool) {
 func (fq *Fastq) ToString() string {
 	var buffer strings.Builder
 
-	_, err := buffer.WriteString(fq.Name)
+	err := buffer.WriteByte('@')
+	simpleio.StdError(err)
+	_, err = buffer.WriteString(fq.Name)
 	simpleio.StdError(err)
 	err = buffer.WriteByte('\n')
 	simpleio.StdError(err)
@@ -92,7 +94,9 @@ func (fq *Fastq) ToString() string {
 func ToBytes(fq *Fastq) []byte {
 	var buffer bytes.Buffer
 
-	_, err := buffer.WriteString(fq.Name)
+	err := buffer.WriteByte('@')
+	simpleio.StdError(err)
+	_, err = buffer.WriteString(fq.Name)
 	simpleio.StdError(err)
 	err = buffer.WriteByte('\n')
 	simpleio.StdError(err)
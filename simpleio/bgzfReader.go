@@ -0,0 +1,220 @@
+package simpleio
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ErrNotBgzf is returned when a block of the input does not contain the BC extra field used by bgzf files.
+var ErrNotBgzf = errors.New("bgzf: file is not bgzf compressed")
+
+// ErrBgzfBlockSize is returned when the footer of a block gives an inflated size larger than the 64 KiB allowed by bgzf.
+var ErrBgzfBlockSize = errors.New("bgzf: inflated block size is larger than 65536 bytes")
+
+// ErrBgzfChecksum is returned when the crc32 or size of an inflated block does not match the block footer.
+var ErrBgzfChecksum = errors.New("bgzf: checksum does not match inflated data")
+
+// DefaultThreads is the number of goroutines used to inflate bgzf blocks when the number of threads is not specified.
+var DefaultThreads int = runtime.GOMAXPROCS(0)
+
+// BgzfReader is an io.Reader that will inflate the blocks of a bgzf file concurrently. Compressed blocks are read
+// sequentially and handed to a pool of workers, while the inflated blocks are returned in the same order as the file.
+// The goroutines of a BgzfReader exit once the end of the input or an error is reached, or when Close is called, so a
+// reader that is dropped before it is read to the end must be closed, otherwise its goroutines are blocked forever.
+type BgzfReader struct {
+	order chan chan bgzfBlock
+	jobs  chan bgzfJob
+	curr  []byte
+	err   error
+	quit  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// bgzfJob is a single compressed block waiting to be inflated by a worker.
+type bgzfJob struct {
+	raw    []byte
+	xlen   int
+	result chan bgzfBlock
+}
+
+// bgzfBlock contains the inflated data of one block, or an error if the block could not be inflated.
+type bgzfBlock struct {
+	data []byte
+	err  error
+}
+
+// NewBgzfReader will start a bgzf reader using a number of threads to inflate blocks. Threads less than 1 will use DefaultThreads.
+// Close must be called if the reader is not read until it returns an error or io.EOF.
+func NewBgzfReader(r io.Reader, threads int) *BgzfReader {
+	if threads < 1 {
+		threads = DefaultThreads
+	}
+	ans := &BgzfReader{
+		order: make(chan chan bgzfBlock, threads*4),
+		jobs:  make(chan bgzfJob, threads*4),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < threads; i++ {
+		go inflateBlocks(ans.jobs)
+	}
+	go ans.readBlocks(r)
+	return ans
+}
+
+// Read will copy inflated data into p, waiting for the next block in the file if needed.
+func (r *BgzfReader) Read(p []byte) (int, error) {
+	for len(r.curr) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		result, ok := <-r.order
+		if !ok {
+			r.err = io.EOF
+			continue
+		}
+		block := <-result
+		r.curr, r.err = block.data, block.err
+	}
+	n := copy(p, r.curr)
+	r.curr = r.curr[n:]
+	return n, nil
+}
+
+// Close will stop reading blocks from the underlying reader and wait for the reading goroutine to exit, which allows
+// the file to be closed or seeked safely. Blocks that have already been read are discarded.
+func (r *BgzfReader) Close() error {
+	r.once.Do(func() {
+		close(r.quit)
+		<-r.done
+	})
+	return nil
+}
+
+// readBlocks will read the compressed blocks of the bgzf file in order, queue a place holder for each result so the
+// blocks can be returned in order, then send the block to the workers.
+func (r *BgzfReader) readBlocks(input io.Reader) {
+	defer close(r.done)
+	defer close(r.order)
+	defer close(r.jobs)
+	var header [12]byte
+	for {
+		_, err := io.ReadFull(input, header[:])
+		if err == io.EOF {
+			return
+		}
+		job := bgzfJob{result: make(chan bgzfBlock, 1)}
+		if err == nil {
+			job.raw, job.xlen, err = readRawBlock(input, header[:])
+		}
+		if err != nil {
+			job.result <- bgzfBlock{err: err}
+			select {
+			case r.order <- job.result:
+			case <-r.quit:
+			}
+			return
+		}
+		select {
+		case r.order <- job.result:
+		case <-r.quit:
+			return
+		}
+		select {
+		case r.jobs <- job:
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// readRawBlock will read the remainder of a compressed block after the first 12 bytes of the gzip header, returning the
+// full block along with the length of the extra field.
+func readRawBlock(input io.Reader, header []byte) ([]byte, int, error) {
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&4 == 0 {
+		return nil, 0, ErrNotBgzf
+	}
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(input, extra); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	size := -1
+	for i := 0; i+4 <= len(extra); {
+		length := int(binary.LittleEndian.Uint16(extra[i+2 : i+4]))
+		if extra[i] == 'B' && extra[i+1] == 'C' && length == 2 && i+6 <= len(extra) {
+			size = int(binary.LittleEndian.Uint16(extra[i+4:i+6])) + 1
+		}
+		i += 4 + length
+	}
+	if size < len(header)+xlen+8 {
+		return nil, 0, ErrNotBgzf
+	}
+	raw := make([]byte, size)
+	copy(raw, header)
+	copy(raw[len(header):], extra)
+	if _, err := io.ReadFull(input, raw[len(header)+xlen:]); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(raw[len(raw)-4:]) > 65536 {
+		return nil, 0, ErrBgzfBlockSize
+	}
+	return raw, xlen, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadBgzfBlock will read and inflate the next block of a bgzf file, returning the inflated data along with the
+// number of compressed bytes that were read, which is needed to build the virtual offsets of an index.
+func ReadBgzfBlock(input io.Reader) ([]byte, int, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(input, header[:]); err != nil {
+		return nil, 0, err
+	}
+	raw, xlen, err := readRawBlock(input, header[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := inflateBlock(flate.NewReader(nil), raw, xlen)
+	return data, len(raw), err
+}
+
+// inflateBlocks is a worker that will inflate the blocks sent by readBlocks.
+func inflateBlocks(jobs <-chan bgzfJob) {
+	inflater := flate.NewReader(nil)
+	for job := range jobs {
+		data, err := inflateBlock(inflater, job.raw, job.xlen)
+		job.result <- bgzfBlock{data: data, err: err}
+	}
+}
+
+// inflateBlock will inflate a compressed block and verify the crc32 and size stored in its footer.
+func inflateBlock(inflater io.ReadCloser, raw []byte, xlen int) ([]byte, error) {
+	var compressed bytes.Reader
+	footer := raw[len(raw)-8:]
+	data := make([]byte, binary.LittleEndian.Uint32(footer[4:]))
+	compressed.Reset(raw[12+xlen : len(raw)-8])
+	err := inflater.(flate.Resetter).Reset(&compressed, nil)
+	if err == nil {
+		_, err = io.ReadFull(inflater, data)
+	}
+	if err == nil && crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(footer[:4]) {
+		err = ErrBgzfChecksum
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
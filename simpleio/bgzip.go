@@ -3,30 +3,39 @@ package simpleio
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
-
-	"github.com/biogo/hts/bgzf"
 )
 
 type BgzipReader struct {
 	*bufio.Reader
-	file   *os.File
-	line   []byte
-	Buffer *bytes.Buffer
+	file    *os.File
+	bgzf    *BgzfReader
+	threads int
+	line    []byte
+	closed  bool
+	Buffer  *bytes.Buffer
 }
 
+// NewBgzipReader will open a bgzip file for reading lines, using DefaultThreads to inflate blocks concurrently.
 func NewBgzipReader(filename string) *BgzipReader {
+	return NewBgzipReaderThreads(filename, DefaultThreads)
+}
+
+// NewBgzipReaderThreads will open a bgzip file for reading lines, using a number of threads to inflate blocks concurrently.
+func NewBgzipReaderThreads(filename string, threads int) *BgzipReader {
 	var answer BgzipReader = BgzipReader{
-		file:   Vim(filename),
-		line:   make([]byte, defaultBufSize),
-		Buffer: &bytes.Buffer{},
+		file:    Vim(filename),
+		threads: threads,
+		line:    make([]byte, defaultBufSize),
+		Buffer:  &bytes.Buffer{},
 	}
-
-	reader, err := bgzf.NewReader(answer.file, 1)
-	answer.Reader = bufio.NewReader(reader)
-	StdError(err)
+	answer.bgzf = NewBgzfReader(answer.file, threads)
+	answer.Reader = bufio.NewReader(answer.bgzf)
 	return &answer
 }
 
@@ -63,13 +72,29 @@ func ReadLineBgzip(reader *BgzipReader) (*bytes.Buffer, bool) {
 	return nil, true
 }
 
+// Close will stop inflating blocks and close the file. ReadLineBgzip closes the reader at the end of the file, so
+// calling Close again after reading every line does nothing.
 func (reader *BgzipReader) Close() {
-	if reader != nil {
+	if reader != nil && !reader.closed {
+		reader.closed = true
+		reader.bgzf.Close()
 		err := reader.file.Close()
 		StdError(err)
 	}
 }
 
+// Seek will move the reader to a bgzf virtual offset, which holds the file offset of a compressed block in the upper
+// 48 bits and the offset into the inflated block in the lower 16 bits.
+func (reader *BgzipReader) Seek(voffset uint64) {
+	StdError(reader.bgzf.Close())
+	_, err := reader.file.Seek(int64(voffset>>16), io.SeekStart)
+	StdError(err)
+	reader.bgzf = NewBgzfReader(reader.file, reader.threads)
+	reader.Reader.Reset(reader.bgzf)
+	_, err = reader.Discard(int(voffset & 0xffff))
+	StdError(err)
+}
+
 func readMoreBgzip(reader *BgzipReader) []byte {
 	var err error
 	reader.line, err = reader.ReadBytes('\n')
@@ -87,3 +112,113 @@ func ReadBgzipFile(filename string) *bytes.Buffer {
 	}
 	return &ans
 }
+
+// bgzfBlockSize is the maximum number of uncompressed bytes stored in one bgzf block.
+const bgzfBlockSize int = 0xff00
+
+// bgzfEOF is the empty block appended to the end of every bgzf file as an end of file marker.
+var bgzfEOF = []byte{0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+// BgzipWriter compresses data into independent bgzf blocks and keeps track of how many compressed bytes
+// have been written, which is needed to calculate the virtual file offsets used by bai and tabix indexes.
+type BgzipWriter struct {
+	writer  *bufio.Writer
+	file    *os.File
+	block   []byte
+	offset  int64
+	deflate *flate.Writer
+	buf     bytes.Buffer
+}
+
+// NewBgzipWriter will create a file and return a BgzipWriter ready to compress data into bgzf blocks.
+func NewBgzipWriter(filename string) *BgzipWriter {
+	file := Touch(filename)
+	ans := NewBgzipStream(file)
+	ans.file = file
+	return ans
+}
+
+// NewBgzipStream will return a BgzipWriter that writes bgzf blocks to any io.Writer.
+func NewBgzipStream(w io.Writer) *BgzipWriter {
+	deflate, err := flate.NewWriter(nil, flate.DefaultCompression)
+	StdError(err)
+	return &BgzipWriter{
+		writer:  bufio.NewWriterSize(w, BufferSize),
+		block:   make([]byte, 0, bgzfBlockSize),
+		deflate: deflate,
+	}
+}
+
+// Write will buffer uncompressed bytes and compress a new bgzf block each time the buffer is full.
+func (bg *BgzipWriter) Write(p []byte) (int, error) {
+	var n, space int
+	for len(p) > 0 {
+		space = bgzfBlockSize - len(bg.block)
+		if space > len(p) {
+			space = len(p)
+		}
+		bg.block = append(bg.block, p[:space]...)
+		p = p[space:]
+		n += space
+		if len(bg.block) == bgzfBlockSize {
+			if err := bg.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush will compress any buffered bytes into a complete bgzf block, which starts a new block for the next write.
+func (bg *BgzipWriter) Flush() error {
+	if len(bg.block) == 0 {
+		return nil
+	}
+	bg.buf.Reset()
+	bg.deflate.Reset(&bg.buf)
+	if _, err := bg.deflate.Write(bg.block); err != nil {
+		return err
+	}
+	if err := bg.deflate.Close(); err != nil {
+		return err
+	}
+	if err := bg.writeBlock(bg.buf.Bytes(), crc32.ChecksumIEEE(bg.block), len(bg.block)); err != nil {
+		return err
+	}
+	bg.block = bg.block[:0]
+	return nil
+}
+
+// writeBlock will write a gzip member with the bgzf extra subfield containing the total block size.
+func (bg *BgzipWriter) writeBlock(data []byte, crc uint32, size int) error {
+	var header [18]byte = [18]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0x00, 'B', 'C', 0x02, 0x00}
+	binary.LittleEndian.PutUint16(header[16:], uint16(len(header)+len(data)+8-1))
+	var footer [8]byte
+	binary.LittleEndian.PutUint32(footer[:4], crc)
+	binary.LittleEndian.PutUint32(footer[4:], uint32(size))
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, err := bg.writer.Write(b); err != nil {
+			return err
+		}
+	}
+	bg.offset += int64(len(header) + len(data) + len(footer))
+	return nil
+}
+
+// Offset returns the bgzf virtual file offset of the next byte written: the file offset of the
+// current compressed block shifted 16 bits to the left, combined with the offset within the uncompressed block.
+func (bg *BgzipWriter) Offset() uint64 {
+	return uint64(bg.offset)<<16 | uint64(len(bg.block))
+}
+
+// Close will flush the remaining data, write the bgzf end of file marker and close the underlying file if there is one.
+func (bg *BgzipWriter) Close() {
+	StdError(bg.Flush())
+	_, err := bg.writer.Write(bgzfEOF)
+	StdError(err)
+	bg.offset += int64(len(bgzfEOF))
+	StdError(bg.writer.Flush())
+	if bg.file != nil {
+		StdError(bg.file.Close())
+	}
+}
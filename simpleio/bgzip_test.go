@@ -2,8 +2,13 @@ package simpleio
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"os"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func BenchmarkBgzipReader(b *testing.B) {
@@ -34,3 +39,77 @@ func SimpleReaderBench(filename string) *bytes.Buffer {
 	}
 	return &ans
 }
+
+// TestBgzfReader will compare the concurrent bgzf reader to the standard library gzip reader using a range of thread counts.
+func TestBgzfReader(t *testing.T) {
+	filename := "testdata/atacseq_simple_pool.vcf.gz"
+	file := Vim(filename)
+	gz, err := gzip.NewReader(file)
+	StdError(err)
+	expected, err := io.ReadAll(gz)
+	StdError(err)
+	file.Close()
+	for _, threads := range []int{1, 2, 8} {
+		file = Vim(filename)
+		reader := NewBgzfReader(file, threads)
+		data, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("Error: bgzf reader with %d threads did not match gzip reader: %v\n", threads, err)
+		}
+		reader.Close()
+		file.Close()
+	}
+
+	var plain bytes.Buffer
+	writer := gzip.NewWriter(&plain)
+	writer.Write(expected[:1000])
+	writer.Close()
+	if _, err = io.ReadAll(NewBgzfReader(&plain, 2)); err != ErrNotBgzf {
+		t.Errorf("Error: expected an error reading a gzip file that is not bgzf, found %v\n", err)
+	}
+
+	compressed, err := os.ReadFile(filename)
+	StdError(err)
+	compressed[len(compressed)/2] ^= 0xff
+	if _, err = io.ReadAll(NewBgzfReader(bytes.NewReader(compressed), 2)); err == nil {
+		t.Errorf("Error: expected an error reading a corrupted bgzf file...\n")
+	}
+	if _, err = io.ReadAll(NewBgzfReader(bytes.NewReader(compressed[:len(compressed)-100]), 2)); err == nil {
+		t.Errorf("Error: expected an error reading a truncated bgzf file...\n")
+	}
+	compressed[len(compressed)/2] ^= 0xff
+	size := int(compressed[16]) | int(compressed[17])<<8 + 1
+	compressed[size-1] = 0x01
+	if _, err = io.ReadAll(NewBgzfReader(bytes.NewReader(compressed), 2)); err != ErrBgzfBlockSize {
+		t.Errorf("Error: expected an error reading a block larger than 64 KiB, found %v\n", err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	reader := NewBgzfReader(bytes.NewReader(compressed[size:]), 4)
+	reader.Read(make([]byte, 10))
+	reader.Close()
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > goroutines {
+		t.Errorf("Error: goroutines of a closed bgzf reader did not exit...\n")
+	}
+}
+
+// BenchmarkBgzipReaderThreads will measure the throughput of reading lines from a bgzip file using an increasing number of threads.
+func BenchmarkBgzipReaderThreads(b *testing.B) {
+	filename := "testdata/atacseq_simple_pool.vcf.gz"
+	size := len(ReadBgzipFile(filename).Bytes())
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				reader := NewBgzipReaderThreads(filename, threads)
+				for _, done := ReadLineBgzip(reader); !done; _, done = ReadLineBgzip(reader) {
+				}
+				reader.Close()
+			}
+		})
+	}
+}
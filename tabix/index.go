@@ -0,0 +1,445 @@
+package tabix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/simpleio"
+)
+
+// unsetOffset marks windows of the linear index without lines while an index is being built.
+const unsetOffset uint64 = 1<<64 - 1
+
+var (
+	tbiMagic [4]byte = [4]byte{'T', 'B', 'I', 0x1}
+	csiMagic [4]byte = [4]byte{'C', 'S', 'I', 0x1}
+)
+
+// Index holds the bins and linear index of each reference in a bgzf compressed text file. Tabix indexes use a
+// minimum shift of 14 and a depth of 5, while CSI indexes can change both to cover longer chromosomes.
+type Index struct {
+	Preset
+	Csi      bool
+	MinShift int
+	Depth    int
+	Names    []string
+	Refs     []Reference
+	NoCoor   *uint64
+}
+
+// Reference contains the index data of a single chromosome. Intervals is the linear index of tabix files, which
+// stores the virtual offset of the first line overlapping each window of 1<<MinShift bases.
+type Reference struct {
+	Bins      []Bin
+	Intervals []uint64
+	Meta      Metadata
+}
+
+// Bin holds the chunks of lines assigned to a bin. Loffset is only used by CSI indexes, where it replaces the
+// linear index with the virtual offset of the first line overlapping the start of the bin.
+type Bin struct {
+	Num     uint32
+	Loffset uint64
+	Chunks  []Chunk
+}
+
+// Chunk is a range of bgzf virtual offsets, where the upper 48 bits are the file offset of a compressed block and
+// the lower 16 bits are the offset of a line in the inflated block.
+type Chunk struct {
+	Begin uint64
+	End   uint64
+}
+
+// Metadata is stored in a pseudo-bin by htslib and contains the offsets and the number of lines of a reference.
+type Metadata struct {
+	Begin    uint64
+	End      uint64
+	Records  uint64
+	Unplaced uint64
+}
+
+// indexBuilder accumulates the bins and linear index of a sorted text file as lines are added in order.
+type indexBuilder struct {
+	idx      *Index
+	bins     []map[uint32]*Bin
+	refs     map[string]int
+	lastRef  int
+	lastPos  int
+	maxCoord int
+}
+
+// Build will read a bgzf compressed, coordinate sorted text file and return a tabix index.
+func Build(filename string, preset Preset) *Index {
+	return buildIndex(filename, &Index{Preset: preset, MinShift: 14, Depth: 5})
+}
+
+// BuildCsi will read a bgzf compressed, coordinate sorted text file and return a CSI index. Positions below
+// 1<<(minShift+3*depth) can be indexed, so chromosomes longer than the 512Mb limit of tabix indexes need a larger
+// depth.
+func BuildCsi(filename string, preset Preset, minShift int, depth int) *Index {
+	return buildIndex(filename, &Index{Preset: preset, Csi: true, MinShift: minShift, Depth: depth})
+}
+
+// buildIndex will walk through the compressed blocks of a file, tracking the virtual offset where each line
+// begins and ends so the lines can be added to the index.
+func buildIndex(filename string, idx *Index) *Index {
+	file := simpleio.Vim(filename)
+	defer file.Close()
+	b := &indexBuilder{idx: idx, refs: make(map[string]int), lastRef: -1, maxCoord: 1 << (idx.MinShift + 3*idx.Depth)}
+	var line []byte
+	var lineBegin, blockOffset uint64
+	var lines int32
+	for {
+		data, size, err := simpleio.ReadBgzfBlock(file)
+		if err == io.EOF {
+			break
+		}
+		simpleio.StdError(err)
+		for u := 0; u < len(data); {
+			if len(line) == 0 {
+				lineBegin = blockOffset<<16 | uint64(u)
+			}
+			i := bytes.IndexByte(data[u:], '\n')
+			if i < 0 {
+				line = append(line, data[u:]...)
+				break
+			}
+			line, u = append(line, data[u:u+i]...), u+i+1
+			lineEnd := blockOffset<<16 | uint64(u)
+			if u == len(data) {
+				lineEnd = (blockOffset + uint64(size)) << 16
+			}
+			if lines++; lines > idx.Skip {
+				b.add(string(line), lineBegin, lineEnd)
+			}
+			line = line[:0]
+		}
+		blockOffset += uint64(size)
+	}
+	if len(line) > 0 && lines >= idx.Skip {
+		b.add(string(line), lineBegin, blockOffset<<16)
+	}
+	return b.finish()
+}
+
+// add will record a single line in the index.
+func (b *indexBuilder) add(line string, begin uint64, end uint64) {
+	chrom, start, stop, ok := b.idx.Interval(strings.TrimSuffix(line, "\r"))
+	if !ok {
+		return
+	}
+	if stop > b.maxCoord {
+		log.Fatalf("Error: %s:%d is too large for a tabix index, build a csi index with a larger minimum shift or depth...\n", chrom, stop)
+	}
+	refId, found := b.refs[chrom]
+	if !found {
+		refId = len(b.idx.Names)
+		b.refs[chrom] = refId
+		b.idx.Names = append(b.idx.Names, chrom)
+		b.idx.Refs = append(b.idx.Refs, Reference{Meta: Metadata{Begin: begin}})
+		b.bins = append(b.bins, make(map[uint32]*Bin))
+	} else if refId != b.lastRef || start < b.lastPos {
+		log.Fatalf("Error: lines must be sorted by coordinate to build an index, found %s:%d after %s:%d...\n", chrom, start+1, b.idx.Names[b.lastRef], b.lastPos+1)
+	}
+	b.lastRef, b.lastPos = refId, start
+	ref := &b.idx.Refs[refId]
+	ref.Meta.End = end
+	ref.Meta.Records++
+	binNum := reg2bin(start, stop, b.idx.MinShift, b.idx.Depth)
+	curr, ok := b.bins[refId][binNum]
+	if !ok {
+		curr = &Bin{Num: binNum}
+		b.bins[refId][binNum] = curr
+	}
+	if n := len(curr.Chunks); n > 0 && curr.Chunks[n-1].End == begin {
+		curr.Chunks[n-1].End = end
+	} else {
+		curr.Chunks = append(curr.Chunks, Chunk{Begin: begin, End: end})
+	}
+	for window := start >> b.idx.MinShift; window <= (stop-1)>>b.idx.MinShift; window++ {
+		for len(ref.Intervals) <= window {
+			ref.Intervals = append(ref.Intervals, unsetOffset)
+		}
+		if ref.Intervals[window] == unsetOffset {
+			ref.Intervals[window] = begin
+		}
+	}
+}
+
+// finish will sort the bins of each reference and fill empty windows of the linear index with the previous offset.
+// CSI indexes do not store a linear index, so the offset of the window at the start of each bin is kept instead.
+func (b *indexBuilder) finish() *Index {
+	for refId := range b.idx.Refs {
+		ref := &b.idx.Refs[refId]
+		offset := ref.Meta.Begin
+		for i := range ref.Intervals {
+			if ref.Intervals[i] == unsetOffset {
+				ref.Intervals[i] = offset
+			}
+			offset = ref.Intervals[i]
+		}
+		for _, curr := range b.bins[refId] {
+			if window := binStart(curr.Num, b.idx.MinShift, b.idx.Depth) >> b.idx.MinShift; b.idx.Csi && window < len(ref.Intervals) {
+				curr.Loffset = ref.Intervals[window]
+			} else if b.idx.Csi {
+				curr.Loffset = offset
+			}
+			ref.Bins = append(ref.Bins, *curr)
+		}
+		sort.Slice(ref.Bins, func(i, j int) bool { return ref.Bins[i].Num < ref.Bins[j].Num })
+		if b.idx.Csi {
+			ref.Intervals = nil
+		}
+	}
+	return b.idx
+}
+
+// Chunks will use the bins and linear index of a chromosome to collect the bgzf chunks that may contain lines
+// overlapping the zero-based, half-open interval start-end. Chunks are sorted and merged so each block of the
+// file only needs to be read once.
+func (idx *Index) Chunks(chrom string, start int, end int) []Chunk {
+	refId := -1
+	for i, name := range idx.Names {
+		if name == chrom {
+			refId = i
+		}
+	}
+	if refId < 0 || start >= end {
+		return nil
+	}
+	if start < 0 {
+		start = 0
+	}
+	if maxCoord := 1 << (idx.MinShift + 3*idx.Depth); end > maxCoord {
+		end = maxCoord
+	}
+	ref := idx.Refs[refId]
+	minOffset := idx.minOffset(ref, start)
+	overlap := make(map[uint32]bool)
+	for _, b := range reg2bins(start, end, idx.MinShift, idx.Depth) {
+		overlap[b] = true
+	}
+	var chunks []Chunk
+	for _, b := range ref.Bins {
+		if !overlap[b.Num] {
+			continue
+		}
+		for _, c := range b.Chunks {
+			if c.End > minOffset {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	sort.Slice(chunks, func(a, b int) bool { return chunks[a].Begin < chunks[b].Begin })
+	var ans []Chunk
+	for _, c := range chunks {
+		if n := len(ans); n > 0 && c.Begin <= ans[n-1].End {
+			if c.End > ans[n-1].End {
+				ans[n-1].End = c.End
+			}
+		} else {
+			ans = append(ans, c)
+		}
+	}
+	return ans
+}
+
+// minOffset returns the smallest virtual offset of a line that may overlap start. Tabix indexes use the linear
+// index, while CSI indexes use the offset of the smallest bin containing start, or its closest parent.
+func (idx *Index) minOffset(ref Reference, start int) uint64 {
+	if !idx.Csi {
+		switch {
+		case len(ref.Intervals) == 0:
+			return 0
+		case start>>idx.MinShift < len(ref.Intervals):
+			return ref.Intervals[start>>idx.MinShift]
+		default:
+			return ref.Intervals[len(ref.Intervals)-1]
+		}
+	}
+	loffset := make(map[uint32]uint64)
+	for _, b := range ref.Bins {
+		loffset[b.Num] = b.Loffset
+	}
+	bin := reg2bin(start, start+1, idx.MinShift, idx.Depth)
+	for {
+		if ans, ok := loffset[bin]; ok {
+			return ans
+		}
+		if bin == 0 {
+			return 0
+		}
+		bin = (bin - 1) >> 3
+	}
+}
+
+// IndexFilename returns the file name of an index next to the text file, .csi or .tbi.
+func IndexFilename(filename string, idx *Index) string {
+	if idx.Csi {
+		return filename + ".csi"
+	}
+	return filename + ".tbi"
+}
+
+// Write will compress an index with bgzf and save it to a file.
+func Write(filename string, idx *Index) {
+	writer := simpleio.NewBgzipWriter(filename)
+	WriteIndex(writer, idx)
+	writer.Close()
+}
+
+// WriteIndex will encode a tabix or CSI index into binary following the htslib specs, including the metadata
+// pseudo-bins of each reference.
+func WriteIndex(writer io.Writer, idx *Index) {
+	var err error
+	writeLE := func(data interface{}) {
+		if err == nil {
+			err = binary.Write(writer, binary.LittleEndian, data)
+		}
+	}
+	var names bytes.Buffer
+	for _, name := range idx.Names {
+		names.WriteString(name)
+		names.WriteByte(0)
+	}
+	format := idx.Format
+	if idx.ZeroBased {
+		format |= formatZeroBased
+	}
+	header := []int32{format, idx.SeqCol, idx.BegCol, idx.EndCol, int32(idx.Meta), idx.Skip, int32(names.Len())}
+	if idx.Csi {
+		writeLE(csiMagic)
+		writeLE([]int32{int32(idx.MinShift), int32(idx.Depth), int32(4*len(header) + names.Len())})
+		writeLE(header)
+		writeLE(names.Bytes())
+		writeLE(int32(len(idx.Refs)))
+	} else {
+		writeLE(tbiMagic)
+		writeLE(int32(len(idx.Refs)))
+		writeLE(header)
+		writeLE(names.Bytes())
+	}
+	for _, ref := range idx.Refs {
+		writeLE(int32(len(ref.Bins) + 1))
+		for _, b := range ref.Bins {
+			writeLE(b.Num)
+			if idx.Csi {
+				writeLE(b.Loffset)
+			}
+			writeLE(int32(len(b.Chunks)))
+			for _, c := range b.Chunks {
+				writeLE([]uint64{c.Begin, c.End})
+			}
+		}
+		writeLE(metaBin(idx.Depth))
+		if idx.Csi {
+			writeLE(uint64(0))
+		}
+		writeLE(int32(2))
+		writeLE([]uint64{ref.Meta.Begin, ref.Meta.End, ref.Meta.Records, ref.Meta.Unplaced})
+		if !idx.Csi {
+			writeLE(int32(len(ref.Intervals)))
+			writeLE(ref.Intervals)
+		}
+	}
+	if idx.NoCoor != nil {
+		writeLE(*idx.NoCoor)
+	}
+	simpleio.StdError(err)
+}
+
+// Read will open a bgzf compressed .tbi or .csi file and decode the index.
+func Read(filename string) *Index {
+	reader := simpleio.NewBgzipReader(filename)
+	defer reader.Close()
+	return ReadIndex(reader)
+}
+
+// ReadIndex will decode an uncompressed tabix or CSI index.
+func ReadIndex(reader io.Reader) *Index {
+	var err error
+	readLE := func(data interface{}) {
+		if err == nil {
+			err = binary.Read(reader, binary.LittleEndian, data)
+		}
+	}
+	idx := &Index{MinShift: 14, Depth: 5}
+	var magic [4]byte
+	readLE(&magic)
+	var numRefs, minShift, depth, auxLen int32
+	switch magic {
+	case tbiMagic:
+		readLE(&numRefs)
+	case csiMagic:
+		idx.Csi = true
+		readLE(&minShift)
+		readLE(&depth)
+		readLE(&auxLen)
+		idx.MinShift, idx.Depth = int(minShift), int(depth)
+	default:
+		simpleio.StdError(fmt.Errorf("tabix: %q is not the magic number of a tbi or csi index", magic[:]))
+	}
+	if !idx.Csi || auxLen >= 28 {
+		header := make([]int32, 7)
+		readLE(header)
+		idx.Format, idx.ZeroBased = header[0]&^formatZeroBased, header[0]&formatZeroBased != 0
+		idx.SeqCol, idx.BegCol, idx.EndCol, idx.Meta, idx.Skip = header[1], header[2], header[3], byte(header[4]), header[5]
+		names := make([]byte, header[6])
+		readLE(names)
+		idx.Names = strings.Split(strings.TrimSuffix(string(names), "\x00"), "\x00")
+		if header[6] == 0 {
+			idx.Names = nil
+		}
+		auxLen -= 28 + header[6]
+	}
+	if idx.Csi {
+		if auxLen > 0 {
+			readLE(make([]byte, auxLen))
+		}
+		readLE(&numRefs)
+	}
+	simpleio.StdError(err)
+	idx.Refs = make([]Reference, numRefs)
+	meta := metaBin(idx.Depth)
+	for refId := range idx.Refs {
+		ref := &idx.Refs[refId]
+		var numBins, numChunks, numIntervals int32
+		readLE(&numBins)
+		for i := 0; i < int(numBins); i++ {
+			var b Bin
+			readLE(&b.Num)
+			if idx.Csi {
+				readLE(&b.Loffset)
+			}
+			readLE(&numChunks)
+			chunks := make([]uint64, 2*numChunks)
+			readLE(chunks)
+			if b.Num == meta && numChunks == 2 {
+				ref.Meta = Metadata{Begin: chunks[0], End: chunks[1], Records: chunks[2], Unplaced: chunks[3]}
+				continue
+			}
+			for c := 0; c < len(chunks); c += 2 {
+				b.Chunks = append(b.Chunks, Chunk{Begin: chunks[c], End: chunks[c+1]})
+			}
+			ref.Bins = append(ref.Bins, b)
+		}
+		if !idx.Csi {
+			readLE(&numIntervals)
+			ref.Intervals = make([]uint64, numIntervals)
+			readLE(ref.Intervals)
+		}
+		simpleio.StdError(err)
+	}
+	var noCoor uint64
+	if err = binary.Read(reader, binary.LittleEndian, &noCoor); err == nil {
+		idx.NoCoor = &noCoor
+	} else if err != io.EOF {
+		simpleio.StdError(err)
+	}
+	return idx
+}
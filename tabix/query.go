@@ -0,0 +1,122 @@
+package tabix
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/edotau/goFish/bam"
+	"github.com/edotau/goFish/bed"
+	"github.com/edotau/goFish/simpleio"
+	"github.com/edotau/goFish/vcf"
+)
+
+// Reader is a bgzf compressed text file opened along with its index, which can be queried any number of times.
+type Reader struct {
+	*simpleio.BgzipReader
+	Index *Index
+}
+
+// NewReader will open a bgzf compressed text file and the index found next to it, preferring a .tbi index
+// over a .csi index.
+func NewReader(filename string) *Reader {
+	index := filename + ".tbi"
+	if _, err := os.Stat(index); err != nil {
+		index = filename + ".csi"
+	}
+	return &Reader{BgzipReader: simpleio.NewBgzipReader(filename), Index: Read(index)}
+}
+
+// Header returns the lines at the start of the file that begin with the meta character of the index, such as the
+// ## and #CHROM lines of a vcf file.
+func Header(reader *Reader) string {
+	var ans strings.Builder
+	reader.Seek(0)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) == 0 || line[0] != reader.Index.Meta {
+			break
+		}
+		ans.WriteString(line)
+		if err != nil {
+			break
+		}
+	}
+	return ans.String()
+}
+
+// Query will seek to the first chunk of the file that may overlap chrom:start-end and return a channel of the
+// lines that overlap the region. Start and end are zero-based, half-open coordinates similar to bed, and lines are
+// returned without the end of line character. Lines are sorted, so reading stops at the first line past the region.
+func Query(reader *Reader, chrom string, start int, end int) <-chan string {
+	chunks := reader.Index.Chunks(chrom, start, end)
+	ans := make(chan string, 1000)
+	go func() {
+		defer close(ans)
+		if len(chunks) == 0 {
+			return
+		}
+		reader.Seek(chunks[0].Begin)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				simpleio.StdError(err)
+			}
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if chr, beg, stop, ok := reader.Index.Interval(line); ok {
+				if chr != chrom || beg >= end {
+					return
+				}
+				if stop > start {
+					ans <- line
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+		}
+	}()
+	return ans
+}
+
+// QueryVcf is similar to Query, but will parse each line into a Vcf struct.
+func QueryVcf(reader *Reader, chrom string, start int, end int) <-chan vcf.Vcf {
+	ans := make(chan vcf.Vcf, 1000)
+	go func() {
+		for line := range Query(reader, chrom, start, end) {
+			ans <- *vcf.ParseVcf(line)
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// QueryBed is similar to Query, but will return the zero-based, half-open interval of each line as a bed.Simple
+// using the columns of the index, so regions of vcf and gff files can also be returned as beds.
+func QueryBed(reader *Reader, chrom string, start int, end int) <-chan bed.Bed {
+	ans := make(chan bed.Bed, 1000)
+	go func() {
+		for line := range Query(reader, chrom, start, end) {
+			chr, beg, stop, _ := reader.Index.Interval(line)
+			ans <- &bed.Simple{Chr: chr, Start: beg, End: stop}
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// QueryFile is a wrapper around Query that will open a file along with its index and return the overlapping lines
+// of a samtools style region, chr:start-end, using one-based closed coordinates.
+func QueryFile(filename string, region string) <-chan string {
+	reader := NewReader(filename)
+	chrom, start, end := bam.ParseRegion(region)
+	ans := make(chan string, 1000)
+	go func() {
+		for line := range Query(reader, chrom, start, end) {
+			ans <- line
+		}
+		reader.Close()
+		close(ans)
+	}()
+	return ans
+}
@@ -0,0 +1,153 @@
+// Package tabix builds and reads tabix (.tbi) and CSI indexes of bgzf compressed, coordinate sorted text files
+// such as vcf.gz, bed.gz and gff.gz, and uses them to query the lines overlapping a region.
+package tabix
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Formats stored in the index header, which tell other tools how to interpret the columns of the indexed file.
+const (
+	FormatGeneric int32 = 0
+	FormatSam     int32 = 1
+	FormatVcf     int32 = 2
+	// formatZeroBased is set when the start column uses zero-based coordinates, such as bed files.
+	formatZeroBased int32 = 0x10000
+)
+
+// Preset describes the columns of a text file used to build an index. Columns are one-based and EndCol is 0
+// when lines only contain a start position. Lines starting with Meta and the first Skip lines are not indexed.
+type Preset struct {
+	Format    int32
+	SeqCol    int32
+	BegCol    int32
+	EndCol    int32
+	ZeroBased bool
+	Meta      byte
+	Skip      int32
+}
+
+// Column presets for common file types, which follow the presets of the tabix command line tool.
+var (
+	VcfPreset Preset = Preset{Format: FormatVcf, SeqCol: 1, BegCol: 2, EndCol: 0, Meta: '#'}
+	BedPreset Preset = Preset{Format: FormatGeneric, SeqCol: 1, BegCol: 2, EndCol: 3, ZeroBased: true, Meta: '#'}
+	GffPreset Preset = Preset{Format: FormatGeneric, SeqCol: 1, BegCol: 4, EndCol: 5, Meta: '#'}
+)
+
+// Presets maps the names used on the command line to column presets.
+var Presets map[string]Preset = map[string]Preset{"vcf": VcfPreset, "bed": BedPreset, "gff": GffPreset}
+
+// GuessPreset will choose a preset from the file extension, ignoring the .gz suffix.
+func GuessPreset(filename string) (Preset, bool) {
+	name := strings.TrimSuffix(filename, ".gz")
+	switch {
+	case strings.HasSuffix(name, ".vcf"):
+		return VcfPreset, true
+	case strings.HasSuffix(name, ".bed"):
+		return BedPreset, true
+	case strings.HasSuffix(name, ".gff"), strings.HasSuffix(name, ".gff3"), strings.HasSuffix(name, ".gtf"):
+		return GffPreset, true
+	}
+	return Preset{}, false
+}
+
+// Interval will return the chromosome along with the zero-based, half-open interval covered by a line of text.
+// The last value is false for header lines. For vcf records the end is taken from the END info field when it
+// exists, otherwise from the length of the reference allele.
+func (p Preset) Interval(line string) (string, int, int, bool) {
+	if len(line) == 0 || line[0] == p.Meta {
+		return "", 0, 0, false
+	}
+	columns := strings.Split(line, "\t")
+	if int(p.SeqCol) > len(columns) || int(p.BegCol) > len(columns) || int(p.EndCol) > len(columns) {
+		log.Fatalf("Error: expecting at least %d columns in this line:\n%s\n", maxInt32(p.SeqCol, p.BegCol, p.EndCol), line)
+	}
+	start := columnInt(columns[p.BegCol-1], line)
+	if !p.ZeroBased {
+		start--
+	}
+	end := start + 1
+	switch {
+	case p.EndCol > 0:
+		end = columnInt(columns[p.EndCol-1], line)
+	case p.Format == FormatVcf && len(columns) > 7:
+		end = start + len(columns[3])
+		for _, field := range strings.Split(columns[7], ";") {
+			if strings.HasPrefix(field, "END=") {
+				end = columnInt(field[4:], line)
+			}
+		}
+	}
+	if end <= start {
+		end = start + 1
+	}
+	return columns[p.SeqCol-1], start, end, true
+}
+
+func columnInt(text string, line string) int {
+	ans, err := strconv.Atoi(text)
+	if err != nil {
+		log.Fatalf("Error: could not read the coordinate %s in this line:\n%s\n", text, line)
+	}
+	return ans
+}
+
+func maxInt32(values ...int32) int32 {
+	var ans int32
+	for _, v := range values {
+		if v > ans {
+			ans = v
+		}
+	}
+	return ans
+}
+
+// reg2bin will calculate the bin of a zero-based, half-open interval using the generalized binning scheme of the
+// CSI specs, where tabix indexes use a minimum shift of 14 and a depth of 5.
+func reg2bin(beg int, end int, minShift int, depth int) uint32 {
+	end--
+	s, t := minShift, ((1<<(depth*3))-1)/7
+	for l := depth; l > 0; l-- {
+		if beg>>s == end>>s {
+			return uint32(t + beg>>s)
+		}
+		s += 3
+		t -= 1 << ((l - 1) * 3)
+	}
+	return 0
+}
+
+// reg2bins will calculate the list of bins that may overlap with the zero-based, half-open interval beg-end.
+func reg2bins(beg int, end int, minShift int, depth int) []uint32 {
+	var ans []uint32
+	end--
+	s, t := minShift+depth*3, 0
+	for l := 0; l <= depth; l++ {
+		for b := t + beg>>s; b <= t+end>>s; b++ {
+			ans = append(ans, uint32(b))
+		}
+		s -= 3
+		t += 1 << (l * 3)
+	}
+	return ans
+}
+
+// binStart returns the first position covered by a bin.
+func binStart(bin uint32, minShift int, depth int) int {
+	s, t := minShift+depth*3, 0
+	for l := 0; l <= depth; l++ {
+		if next := t + 1<<(l*3); int(bin) < next {
+			return (int(bin) - t) << s
+		}
+		s -= 3
+		t += 1 << (l * 3)
+	}
+	return 0
+}
+
+// metaBin returns the pseudo-bin used to store the number of records and the offsets of each reference.
+func metaBin(depth int) uint32 {
+	return uint32(((1<<(depth*3+3))-1)/7 + 1)
+}
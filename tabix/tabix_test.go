@@ -0,0 +1,184 @@
+package tabix
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bgzf"
+	htscsi "github.com/biogo/hts/csi"
+	htstabix "github.com/biogo/hts/tabix"
+	"github.com/edotau/goFish/simpleio"
+)
+
+const testVcf string = "../vcf/testdata/small.vcf.gz"
+
+func TestBinning(t *testing.T) {
+	var tests = []struct {
+		beg, end int
+		bin      uint32
+	}{
+		{0, 1, 4681},
+		{16384, 16385, 4682},
+		{0, 16385, 585},
+		{0, 1 << 26, 1},
+		{0, 1<<26 + 1, 0},
+	}
+	for _, test := range tests {
+		if bin := reg2bin(test.beg, test.end, 14, 5); bin != test.bin {
+			t.Errorf("Error: %d-%d was placed in bin %d, expected %d\n", test.beg, test.end, bin, test.bin)
+		}
+		if start := binStart(test.bin, 14, 5); start > test.beg {
+			t.Errorf("Error: bin %d starts at %d, after %d\n", test.bin, start, test.beg)
+		}
+	}
+	if bins := reg2bins(16384, 16385, 14, 5); !reflect.DeepEqual(bins, []uint32{0, 1, 9, 73, 585, 4682}) {
+		t.Errorf("Error: unexpected bins %v\n", bins)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	var tests = []struct {
+		preset     Preset
+		line       string
+		chrom      string
+		start, end int
+		ok         bool
+	}{
+		{VcfPreset, "chr1\t100\t.\tACG\tA\t.\tPASS\tDP=3\tGT\t0/1", "chr1", 99, 102, true},
+		{VcfPreset, "chr1\t100\t.\tN\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=500", "chr1", 99, 500, true},
+		{VcfPreset, "#CHROM\tPOS", "", 0, 0, false},
+		{BedPreset, "chr2\t10\t20\tpeak", "chr2", 10, 20, true},
+		{GffPreset, "chr3\tsrc\tgene\t5\t9\t.\t+\t.\tID=a", "chr3", 4, 9, true},
+	}
+	for _, test := range tests {
+		if chrom, start, end, ok := test.preset.Interval(test.line); chrom != test.chrom || start != test.start || end != test.end || ok != test.ok {
+			t.Errorf("Error: %q was read as %s:%d-%d %t\n", test.line, chrom, start, end, ok)
+		}
+	}
+}
+
+// bruteForce returns the lines of a file that overlap a region by reading every line.
+func bruteForce(filename string, preset Preset, chrom string, start int, end int) []string {
+	var ans []string
+	for _, line := range strings.Split(simpleio.ReadBgzipFile(filename).String(), "\n") {
+		if chr, beg, stop, ok := preset.Interval(line); ok && chr == chrom && beg < end && stop > start {
+			ans = append(ans, line)
+		}
+	}
+	return ans
+}
+
+func collect(lines <-chan string) []string {
+	var ans []string
+	for line := range lines {
+		ans = append(ans, line)
+	}
+	return ans
+}
+
+// checkQueries compares queries of random regions to the lines found by reading the whole file.
+func checkQueries(t *testing.T, filename string, idx *Index, chroms []string, size int) {
+	reader := &Reader{BgzipReader: simpleio.NewBgzipReader(filename), Index: idx}
+	defer reader.Close()
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		chrom := chroms[random.Intn(len(chroms))]
+		start := random.Intn(size)
+		end := start + 1 + random.Intn(size/10)
+		if ans, expected := collect(Query(reader, chrom, start, end)), bruteForce(filename, idx.Preset, chrom, start, end); !reflect.DeepEqual(ans, expected) {
+			t.Errorf("Error: query of %s:%d-%d found %d lines, expected %d\n", chrom, start, end, len(ans), len(expected))
+		}
+	}
+}
+
+func TestVcfIndex(t *testing.T) {
+	idx := Build(testVcf, VcfPreset)
+	output := filepath.Join(t.TempDir(), "small.vcf.gz.tbi")
+	Write(output, idx)
+	if ans := Read(output); !reflect.DeepEqual(ans, idx) {
+		t.Errorf("Error: the index read from %s does not match the index that was written\n", output)
+	}
+	// the index should also be readable by htslib compatible tools
+	hts, err := htstabix.ReadFrom(openBgzf(t, output))
+	if err != nil {
+		t.Fatalf("Error: %s could not be read by biogo: %v\n", output, err)
+	}
+	if !reflect.DeepEqual(hts.Names(), idx.Names) {
+		t.Errorf("Error: biogo found the chromosomes %v, expected %v\n", hts.Names(), idx.Names)
+	}
+	checkQueries(t, testVcf, idx, idx.Names, 1100000)
+
+	reader := &Reader{BgzipReader: simpleio.NewBgzipReader(testVcf), Index: idx}
+	defer reader.Close()
+	if header := Header(reader); !strings.HasPrefix(header, "##fileformat=VCFv4.2\n") || !strings.Contains(header, "\n#CHROM\tPOS") {
+		t.Errorf("Error: unexpected vcf header\n%s\n", header)
+	}
+	for v := range QueryVcf(reader, "chr01", 133, 134) {
+		if v.Pos != 134 || v.Ref != "T" || v.Alt != "G,*" {
+			t.Errorf("Error: unexpected vcf record at chr01:134 %v\n", v)
+		}
+	}
+}
+
+func openBgzf(t *testing.T, filename string) *bgzf.Reader {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	bg, err := bgzf.NewReader(file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bg
+}
+
+// writeTestBed will write a sorted, bgzf compressed bed file spanning several blocks, including long intervals.
+func writeTestBed(filename string, chroms []string, size int) {
+	writer := simpleio.NewBgzipWriter(filename)
+	random := rand.New(rand.NewSource(2))
+	fmt.Fprintf(writer, "#chrom\tstart\tend\tname\n")
+	for _, chrom := range chroms {
+		for pos := 0; pos < size; pos += random.Intn(200) {
+			length := 1 + random.Intn(100)
+			if random.Intn(100) == 0 {
+				length = random.Intn(200000)
+			}
+			fmt.Fprintf(writer, "%s\t%d\t%d\tpeak_%d\n", chrom, pos, pos+length, pos)
+		}
+	}
+	writer.Close()
+}
+
+func TestBedIndex(t *testing.T) {
+	chroms, size := []string{"chrI", "chrII", "chrIII"}, 1000000
+	filename := filepath.Join(t.TempDir(), "test.bed.gz")
+	writeTestBed(filename, chroms, size)
+	preset, ok := GuessPreset(filename)
+	if !ok || preset != BedPreset {
+		t.Errorf("Error: expected the bed preset for %s\n", filename)
+	}
+	checkQueries(t, filename, Build(filename, preset), chroms, size)
+
+	csi := BuildCsi(filename, preset, 12, 6)
+	Write(filename+".csi", csi)
+	reader := NewReader(filename)
+	defer reader.Close()
+	if !reflect.DeepEqual(reader.Index, csi) {
+		t.Errorf("Error: the csi index read from %s.csi does not match the index that was written\n", filename)
+	}
+	checkQueries(t, filename, reader.Index, chroms, size)
+	if hts, err := htscsi.ReadFrom(openBgzf(t, filename+".csi")); err != nil || hts.NumRefs() != len(chroms) {
+		t.Errorf("Error: %s.csi could not be read by biogo: %v\n", filename, err)
+	}
+	for b := range QueryBed(reader, "chrII", 5000, 5001) {
+		if b.Chrom() != "chrII" || b.ChrStart() > 5000 || b.ChrEnd() <= 5000 {
+			t.Errorf("Error: %s:%d-%d does not overlap chrII:5000-5001\n", b.Chrom(), b.ChrStart(), b.ChrEnd())
+		}
+	}
+}
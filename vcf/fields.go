@@ -0,0 +1,289 @@
+package vcf
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MissingInt is returned by the integer accessors for values written as '.' in a vcf record.
+const MissingInt int = math.MinInt32
+
+// Field is the definition of an INFO or FORMAT field declared in the vcf header. Number is kept as written, so it
+// is either a fixed count or one of A (one value per alternate allele), R (one value per allele), G (one value per
+// genotype) or '.' when the number of values varies.
+type Field struct {
+	Id          string
+	Number      string
+	Type        string
+	Description string
+}
+
+// IsMissing returns true for float values that were written as '.' in a vcf record.
+func IsMissing(f float64) bool {
+	return math.IsNaN(f)
+}
+
+// SetHeader will attach a header to a record so the typed accessors can use the Number of each field to expand
+// missing values. Records read with UnmarshalVcf after ReadHeader already have their header set.
+func (v *Vcf) SetHeader(header *Header) {
+	v.header = header
+}
+
+// InfoValue returns the raw text of an INFO field and true if the key was found. Flags have an empty value.
+func (v *Vcf) InfoValue(key string) (string, bool) {
+	for _, field := range strings.Split(v.Info, ";") {
+		if field == key {
+			return "", true
+		}
+		if strings.HasPrefix(field, key) && len(field) > len(key) && field[len(key)] == '=' {
+			return field[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// InfoFlag returns true when an INFO flag, or any other INFO key, is present in the record.
+func (v *Vcf) InfoFlag(key string) bool {
+	_, ok := v.InfoValue(key)
+	return ok
+}
+
+// InfoStrings returns the comma separated values of an INFO field, or nil if the key was not found.
+func (v *Vcf) InfoStrings(key string) []string {
+	text, ok := v.InfoValue(key)
+	if !ok {
+		return nil
+	}
+	f, declared := v.infoField(key)
+	return v.expand(text, f, declared, 2)
+}
+
+// InfoInts returns the values of an INFO field as integers, using MissingInt for missing values.
+func (v *Vcf) InfoInts(key string) []int {
+	return toInts(v.InfoStrings(key))
+}
+
+// InfoFloats returns the values of an INFO field as floats, using NaN for missing values.
+func (v *Vcf) InfoFloats(key string) []float64 {
+	return toFloats(v.InfoStrings(key))
+}
+
+// InfoInt returns the first value of an INFO field and false if the key was not found or the value is missing.
+func (v *Vcf) InfoInt(key string) (int, bool) {
+	values := v.InfoInts(key)
+	if len(values) == 0 || values[0] == MissingInt {
+		return 0, false
+	}
+	return values[0], true
+}
+
+// InfoFloat returns the first value of an INFO field and false if the key was not found or the value is missing.
+func (v *Vcf) InfoFloat(key string) (float64, bool) {
+	values := v.InfoFloats(key)
+	if len(values) == 0 || IsMissing(values[0]) {
+		return 0, false
+	}
+	return values[0], true
+}
+
+// SampleValue returns the raw text of a FORMAT field for the sample in genotype column sample, and false if the key
+// is not part of the FORMAT column. Trailing fields dropped from a sample are returned as '.'.
+func (v *Vcf) SampleValue(sample int, key string) (string, bool) {
+	col := -1
+	for i, f := range v.Format {
+		if f == key {
+			col = i
+		}
+	}
+	if col < 0 || sample < 0 || sample >= len(v.Genotypes) {
+		return "", false
+	}
+	values := strings.Split(v.Genotypes[sample], ":")
+	if col >= len(values) {
+		return ".", true
+	}
+	return values[col], true
+}
+
+// SampleStrings returns the comma separated values of a FORMAT field for a sample, or nil if the key was not found.
+func (v *Vcf) SampleStrings(sample int, key string) []string {
+	text, ok := v.SampleValue(sample, key)
+	if !ok {
+		return nil
+	}
+	f, declared := v.formatField(key)
+	return v.expand(text, f, declared, v.ploidy(sample))
+}
+
+// SampleInts returns the values of a FORMAT field for a sample as integers, using MissingInt for missing values.
+func (v *Vcf) SampleInts(sample int, key string) []int {
+	return toInts(v.SampleStrings(sample, key))
+}
+
+// SampleFloats returns the values of a FORMAT field for a sample as floats, using NaN for missing values.
+func (v *Vcf) SampleFloats(sample int, key string) []float64 {
+	return toFloats(v.SampleStrings(sample, key))
+}
+
+// SampleInt returns the first value of a FORMAT field for a sample and false if the key was not found or the value
+// is missing.
+func (v *Vcf) SampleInt(sample int, key string) (int, bool) {
+	values := v.SampleInts(sample, key)
+	if len(values) == 0 || values[0] == MissingInt {
+		return 0, false
+	}
+	return values[0], true
+}
+
+// AltCount returns the number of alternate alleles of a record.
+func (v *Vcf) AltCount() int {
+	if v.Alt == "" || v.Alt == "." {
+		return 0
+	}
+	return strings.Count(v.Alt, ",") + 1
+}
+
+// Count returns the number of values expected for a field in a record with a number of alternate alleles and a
+// sample ploidy, or -1 if the number of values is not fixed.
+func (f Field) Count(alts int, ploidy int) int {
+	switch f.Number {
+	case "A":
+		return alts
+	case "R":
+		return alts + 1
+	case "G":
+		return genotypeCount(alts+1, ploidy)
+	}
+	n, err := strconv.Atoi(f.Number)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// genotypeCount returns the number of unordered genotypes of a ploidy that can be made from a number of alleles.
+func genotypeCount(alleles int, ploidy int) int {
+	ans := 1
+	for i := 1; i <= ploidy; i++ {
+		ans = ans * (alleles + i - 1) / i
+	}
+	return ans
+}
+
+func (v *Vcf) infoField(key string) (Field, bool) {
+	if v.header == nil {
+		return Field{}, false
+	}
+	f, ok := v.header.Info[key]
+	return f, ok
+}
+
+func (v *Vcf) formatField(key string) (Field, bool) {
+	if v.header == nil {
+		return Field{}, false
+	}
+	f, ok := v.header.Format[key]
+	return f, ok
+}
+
+// ploidy returns the number of alleles in the genotype of a sample, which is 2 when there is no genotype.
+func (v *Vcf) ploidy(sample int) int {
+	if gt, ok := v.SampleValue(sample, "GT"); ok && gt != "." {
+		return strings.Count(gt, "/") + strings.Count(gt, "|") + 1
+	}
+	return 2
+}
+
+// expand will split the values of a field, and when the field is declared in the header a single missing value
+// is expanded to the number of values given by its Number.
+func (v *Vcf) expand(text string, f Field, declared bool, ploidy int) []string {
+	values := strings.Split(text, ",")
+	if declared && text == "." {
+		if n := f.Count(v.AltCount(), ploidy); n > 1 {
+			values = make([]string, n)
+			for i := range values {
+				values[i] = "."
+			}
+		}
+	}
+	return values
+}
+
+// toInts will convert the values of a field to integers. Values that are missing or can not be read as numbers,
+// such as the nul written by some callers, are returned as MissingInt.
+func toInts(values []string) []int {
+	if values == nil {
+		return nil
+	}
+	ans := make([]int, len(values))
+	for i, text := range values {
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			n = MissingInt
+		}
+		ans[i] = n
+	}
+	return ans
+}
+
+// toFloats will convert the values of a field to floats, returning NaN for missing values.
+func toFloats(values []string) []float64 {
+	if values == nil {
+		return nil
+	}
+	ans := make([]float64, len(values))
+	for i, text := range values {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil || text == "." {
+			f = math.NaN()
+		}
+		ans[i] = f
+	}
+	return ans
+}
+
+// parseField will read the ID, Number, Type and Description of a ##INFO=<...> or ##FORMAT=<...> header line.
+// Values may be quoted, in which case they can contain commas.
+func parseField(line string) Field {
+	var ans Field
+	start, end := strings.IndexByte(line, '<'), strings.LastIndexByte(line, '>')
+	if start < 0 || end < start {
+		return ans
+	}
+	text := line[start+1 : end]
+	for len(text) > 0 {
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			break
+		}
+		key, value := text[:eq], ""
+		text = text[eq+1:]
+		if strings.HasPrefix(text, "\"") {
+			closing := 1
+			for closing < len(text) && (text[closing] != '"' || text[closing-1] == '\\') {
+				closing++
+			}
+			value = strings.ReplaceAll(text[1:closing], "\\\"", "\"")
+			if closing < len(text) {
+				closing++
+			}
+			text = strings.TrimPrefix(text[closing:], ",")
+		} else if comma := strings.IndexByte(text, ','); comma >= 0 {
+			value, text = text[:comma], text[comma+1:]
+		} else {
+			value, text = text, ""
+		}
+		switch key {
+		case "ID":
+			ans.Id = value
+		case "Number":
+			ans.Number = value
+		case "Type":
+			ans.Type = value
+		case "Description":
+			ans.Description = value
+		}
+	}
+	return ans
+}
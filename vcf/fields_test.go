@@ -0,0 +1,85 @@
+package vcf
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderFields(t *testing.T) {
+	reader := NewReader(testVcf)
+	header := ReadHeader(reader)
+	if f := header.Info["AF"]; f.Number != "A" || f.Type != "Float" || f.Description != "Allele Frequency, for each ALT allele, in the same order as listed" {
+		t.Errorf("Error: unexpected INFO definition of AF %v\n", f)
+	}
+	if f := header.Format["AD"]; f.Number != "R" || f.Type != "Integer" {
+		t.Errorf("Error: unexpected FORMAT definition of AD %v\n", f)
+	}
+	v, done := UnmarshalVcf(reader)
+	if done {
+		t.Fatalf("Error: expected a record in %s\n", testVcf)
+	}
+	if ac := v.InfoInts("AC"); !reflect.DeepEqual(ac, []int{2, 2}) {
+		t.Errorf("Error: AC was read as %v\n", ac)
+	}
+	if af := v.InfoFloats("AF"); len(af) != 2 || math.Abs(af[0]-0.1) > 1e-9 || math.Abs(af[1]-0.1) > 1e-9 {
+		t.Errorf("Error: AF was read as %v\n", af)
+	}
+	if dp, ok := v.InfoInt("DP"); !ok || dp != 214 {
+		t.Errorf("Error: DP was read as %d %t\n", dp, ok)
+	}
+	if rank := v.InfoFloats("AS_BaseQRankSum"); len(rank) != 2 || rank[0] != 1.5 || !IsMissing(rank[1]) {
+		t.Errorf("Error: AS_BaseQRankSum was read as %v\n", rank)
+	}
+	if _, ok := v.InfoInt("END"); ok {
+		t.Errorf("Error: END is not part of the first record\n")
+	}
+	if ad := v.SampleInts(0, "AD"); !reflect.DeepEqual(ad, []int{41, 0, 0}) {
+		t.Errorf("Error: AD of the first sample was read as %v\n", ad)
+	}
+	if gq, ok := v.SampleInt(0, "GQ"); !ok || gq != 99 {
+		t.Errorf("Error: GQ of the first sample was read as %d %t\n", gq, ok)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	header := NewHeader()
+	for _, line := range []string{
+		"##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Allelic depths, for the ref and alt alleles\">",
+		"##FORMAT=<ID=PL,Number=G,Type=Integer,Description=\"Phred-scaled \\\"likelihoods\\\"\">",
+		"##INFO=<ID=AF,Number=A,Type=Float,Description=\"Allele Frequency\">",
+	} {
+		f := parseField(line)
+		if line[2] == 'I' {
+			header.Info[f.Id] = f
+		} else {
+			header.Format[f.Id] = f
+		}
+	}
+	if f := header.Format["AD"]; f.Description != "Allelic depths, for the ref and alt alleles" {
+		t.Errorf("Error: unexpected description %q\n", f.Description)
+	}
+	if f := header.Format["PL"]; f.Description != "Phred-scaled \"likelihoods\"" {
+		t.Errorf("Error: unexpected description %q\n", f.Description)
+	}
+	v := ParseVcf("chr1\t100\t.\tA\tC,G\t.\tPASS\tAF=.;DB\tGT:AD:PL\t./.:.:.\t0/1:3,4,0")
+	if ad := v.SampleInts(0, "AD"); !reflect.DeepEqual(ad, []int{MissingInt}) {
+		t.Errorf("Error: AD without a header was read as %v\n", ad)
+	}
+	v.SetHeader(&header)
+	if ad := v.SampleInts(0, "AD"); !reflect.DeepEqual(ad, []int{MissingInt, MissingInt, MissingInt}) {
+		t.Errorf("Error: missing AD was expanded to %v\n", ad)
+	}
+	if pl := v.SampleInts(0, "PL"); len(pl) != 6 {
+		t.Errorf("Error: missing PL was expanded to %v\n", pl)
+	}
+	if pl := v.SampleInts(1, "PL"); len(pl) != 6 || pl[0] != MissingInt {
+		t.Errorf("Error: PL dropped from the end of a sample was read as %v\n", pl)
+	}
+	if af := v.InfoFloats("AF"); len(af) != 2 || !IsMissing(af[0]) || !IsMissing(af[1]) {
+		t.Errorf("Error: missing AF was expanded to %v\n", af)
+	}
+	if !v.InfoFlag("DB") || v.InfoFlag("D") {
+		t.Errorf("Error: unexpected INFO flags in %s\n", v.Info)
+	}
+}
@@ -1,38 +1,18 @@
 package vcf
 
-func ASFilter(v *Vcf, parentOne int, parentTwo int, F1 int) bool {
-	gtOne := ParseGt(v.Genotypes[parentOne])
-	gtTwo := ParseGt(v.Genotypes[parentTwo])
-	f1Hybrid := ParseGt(v.Genotypes[F1])
-	if IsHomozygous(gtOne) && IsHomozygous(gtTwo) && IsHeterozygous(f1Hybrid) && gtOne.AlleleOne != gtTwo.AlleleOne {
-		return true
-	} else {
+// ASFilter returns true when both parents are homozygous for different alleles and the F1 hybrid is heterozygous,
+// so reads of the hybrid can be assigned to a parent. All three genotypes must be diploid.
+func ASFilter(gtOne Genotype, gtTwo Genotype, f1Hybrid Genotype) bool {
+	if gtOne.Ploidy() != 2 || gtTwo.Ploidy() != 2 || f1Hybrid.Ploidy() != 2 {
 		return false
 	}
+	return IsHomozygous(gtOne) && IsHomozygous(gtTwo) && IsHeterozygous(f1Hybrid) && gtOne.Alleles[0] != gtTwo.Alleles[0]
 }
 
 func IsHeterozygous(genome Genotype) bool {
-
-	if genome.AlleleOne < 0 || genome.AlleleTwo < 0 {
-		return false
-	} else if genome.AlleleOne != genome.AlleleTwo {
-		return true
-	} else if genome.AlleleOne == genome.AlleleTwo {
-		return false
-	} else {
-		return false
-	}
+	return genome.IsHet()
 }
 
 func IsHomozygous(genome Genotype) bool {
-	if genome.AlleleOne < 0 || genome.AlleleTwo < 0 {
-		return false
-	}
-	if genome.AlleleOne == genome.AlleleTwo {
-		return true
-	}
-	if genome.AlleleOne != genome.AlleleTwo {
-		return false
-	}
-	return false
+	return genome.IsHomRef() || genome.IsHomAlt()
 }
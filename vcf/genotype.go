@@ -0,0 +1,197 @@
+package vcf
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Genotype is the call of a single sample in a vcf record. Alleles holds one allele index per chromosome copy, so
+// a diploid call has two alleles and a haploid call has one, where 0 is the reference, 1 is the first alternate
+// allele and -1 is a missing allele. GQ and DP are set to MissingInt and AD and PL are nil when they are not part
+// of the record.
+type Genotype struct {
+	Alleles []int16
+	Phased  bool
+	GQ      int
+	DP      int
+	AD      []int
+	PL      []int
+}
+
+// ParseGenotype will parse the text of a GT field, such as 0/1, 1|2, 0/0/1, 1 or ./., into a Genotype. A genotype
+// is phased when every allele is separated by '|'.
+func ParseGenotype(gt string) Genotype {
+	ans := Genotype{GQ: MissingInt, DP: MissingInt}
+	if gt == "" {
+		return ans
+	}
+	ans.Alleles = make([]int16, 0, 2)
+	ans.Phased = strings.IndexByte(gt, '|') >= 0 && strings.IndexByte(gt, '/') < 0
+	for start, i := 0, 0; i <= len(gt); i++ {
+		if i < len(gt) && gt[i] != '/' && gt[i] != '|' {
+			continue
+		}
+		ans.Alleles = append(ans.Alleles, parseAllele(gt[start:i], gt))
+		start = i + 1
+	}
+	return ans
+}
+
+// parseAllele is a helper function that converts one allele of a GT field into its index.
+func parseAllele(text string, gt string) int16 {
+	if text == "." {
+		return -1
+	}
+	n, err := strconv.ParseInt(text, 10, 16)
+	if err != nil || n < 0 {
+		log.Fatalf("Error: could not parse the allele %q of the genotype %s\n", text, gt)
+	}
+	return int16(n)
+}
+
+// ParseGt will parse the genotype of a sample column, which starts with the GT field, e.g. 0/1:3,4:7.
+func ParseGt(data string) Genotype {
+	if colon := strings.IndexByte(data, ':'); colon >= 0 {
+		data = data[:colon]
+	}
+	return ParseGenotype(data)
+}
+
+// GetGenotypes will parse the genotype of every sample in a tab separated string of sample columns.
+func GetGenotypes(samples string) []Genotype {
+	text := strings.Split(samples, "\t")
+	answer := make([]Genotype, len(text))
+	for i := 0; i < len(text); i++ {
+		answer[i] = ParseGt(text[i])
+	}
+	return answer
+}
+
+// Genotype returns the call of the sample in genotype column sample, along with its GQ, DP, AD and PL values.
+func (v *Vcf) Genotype(sample int) Genotype {
+	var ans Genotype
+	if gt, ok := v.SampleValue(sample, "GT"); ok {
+		ans = ParseGenotype(gt)
+	} else {
+		ans = Genotype{GQ: MissingInt, DP: MissingInt}
+	}
+	ans.GQ = firstInt(v.SampleInts(sample, "GQ"))
+	ans.DP = firstInt(v.SampleInts(sample, "DP"))
+	ans.AD = v.SampleInts(sample, "AD")
+	ans.PL = v.SampleInts(sample, "PL")
+	return ans
+}
+
+// AllGenotypes returns the calls of every sample in the record.
+func (v *Vcf) AllGenotypes() []Genotype {
+	ans := make([]Genotype, len(v.Genotypes))
+	for i := range ans {
+		ans[i] = v.Genotype(i)
+	}
+	return ans
+}
+
+// firstInt returns the first value of a field, or MissingInt when the field is empty.
+func firstInt(values []int) int {
+	if len(values) == 0 {
+		return MissingInt
+	}
+	return values[0]
+}
+
+// Ploidy returns the number of alleles in the genotype.
+func (g Genotype) Ploidy() int {
+	return len(g.Alleles)
+}
+
+// IsMissing returns true when none of the alleles of the genotype were called.
+func (g Genotype) IsMissing() bool {
+	for _, a := range g.Alleles {
+		if a >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCalled returns true when every allele of the genotype was called.
+func (g Genotype) IsCalled() bool {
+	for _, a := range g.Alleles {
+		if a < 0 {
+			return false
+		}
+	}
+	return len(g.Alleles) > 0
+}
+
+// IsHomRef returns true when every allele of a called genotype is the reference allele.
+func (g Genotype) IsHomRef() bool {
+	return g.IsCalled() && g.isHom() && g.Alleles[0] == 0
+}
+
+// IsHomAlt returns true when every allele of a called genotype is the same alternate allele.
+func (g Genotype) IsHomAlt() bool {
+	return g.IsCalled() && g.isHom() && g.Alleles[0] > 0
+}
+
+// IsHet returns true when a called genotype has at least two different alleles.
+func (g Genotype) IsHet() bool {
+	return g.IsCalled() && !g.isHom()
+}
+
+func (g Genotype) isHom() bool {
+	for _, a := range g.Alleles[1:] {
+		if a != g.Alleles[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// AltDosage returns the number of called alleles that are not the reference allele, e.g. 1 for 0/1 and 2 for 1/2.
+func (g Genotype) AltDosage() int {
+	var ans int
+	for _, a := range g.Alleles {
+		if a > 0 {
+			ans++
+		}
+	}
+	return ans
+}
+
+// Depth returns the AD value of an allele, or MissingInt when the depth of the allele is not known.
+func (g Genotype) Depth(allele int16) int {
+	if allele < 0 || int(allele) >= len(g.AD) {
+		return MissingInt
+	}
+	return g.AD[allele]
+}
+
+// String will format the alleles of the genotype as they are written in a GT field.
+func (g Genotype) String() string {
+	if len(g.Alleles) == 0 {
+		return "."
+	}
+	sep := byte('/')
+	if g.Phased {
+		sep = '|'
+	}
+	buffer := strings.Builder{}
+	for i, a := range g.Alleles {
+		if i > 0 {
+			buffer.WriteByte(sep)
+		}
+		if a < 0 {
+			buffer.WriteByte('.')
+		} else {
+			buffer.WriteString(strconv.Itoa(int(a)))
+		}
+	}
+	return buffer.String()
+}
+
+// GtToString will format a genotype as it is written in a GT field.
+func GtToString(gt Genotype) string {
+	return gt.String()
+}
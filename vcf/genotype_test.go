@@ -0,0 +1,61 @@
+package vcf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGenotype(t *testing.T) {
+	var tests = []struct {
+		gt                       string
+		alleles                  []int16
+		phased                   bool
+		het, homRef, homAlt, mis bool
+		dosage                   int
+	}{
+		{"0/1", []int16{0, 1}, false, true, false, false, false, 1},
+		{"1|0", []int16{1, 0}, true, true, false, false, false, 1},
+		{"0/0", []int16{0, 0}, false, false, true, false, false, 0},
+		{"2/2", []int16{2, 2}, false, false, false, true, false, 2},
+		{"1/2", []int16{1, 2}, false, true, false, false, false, 2},
+		{"./.", []int16{-1, -1}, false, false, false, false, true, 0},
+		{".|.", []int16{-1, -1}, true, false, false, false, true, 0},
+		{"0/.", []int16{0, -1}, false, false, false, false, false, 0},
+		{"1", []int16{1}, false, false, false, true, false, 1},
+		{".", []int16{-1}, false, false, false, false, true, 0},
+		{"0/0/1", []int16{0, 0, 1}, false, true, false, false, false, 1},
+		{"0|1/2", []int16{0, 1, 2}, false, true, false, false, false, 2},
+		{"10|12", []int16{10, 12}, true, true, false, false, false, 2},
+	}
+	for _, test := range tests {
+		g := ParseGenotype(test.gt)
+		if !reflect.DeepEqual(g.Alleles, test.alleles) || g.Phased != test.phased {
+			t.Errorf("Error: %s was parsed as %v phased=%t\n", test.gt, g.Alleles, g.Phased)
+		}
+		if g.IsHet() != test.het || g.IsHomRef() != test.homRef || g.IsHomAlt() != test.homAlt || g.IsMissing() != test.mis || g.AltDosage() != test.dosage {
+			t.Errorf("Error: unexpected calls for %s: het=%t homRef=%t homAlt=%t missing=%t dosage=%d\n", test.gt, g.IsHet(), g.IsHomRef(), g.IsHomAlt(), g.IsMissing(), g.AltDosage())
+		}
+		if test.gt != "0|1/2" && g.String() != test.gt {
+			t.Errorf("Error: %s was written as %s\n", test.gt, g.String())
+		}
+	}
+}
+
+func TestSampleGenotype(t *testing.T) {
+	reader := NewReader(testVcf)
+	ReadHeader(reader)
+	v, _ := UnmarshalVcf(reader)
+	g := v.Genotype(0)
+	if !g.IsHomRef() || g.GQ != 99 || g.DP != 41 || !reflect.DeepEqual(g.AD, []int{41, 0, 0}) || !reflect.DeepEqual(g.PL, []int{0, 99, 1017, 99, 1017, 1017}) {
+		t.Errorf("Error: unexpected genotype of the first sample %v\n", g)
+	}
+	if g = v.Genotype(1); !g.IsMissing() || g.GQ != MissingInt || g.Depth(0) != 3 || g.Depth(3) != MissingInt {
+		t.Errorf("Error: unexpected genotype of the second sample %v\n", g)
+	}
+	if all := v.AllGenotypes(); len(all) != len(v.Genotypes) || all[0].String() != "0/0" {
+		t.Errorf("Error: unexpected genotypes %v\n", all)
+	}
+	if g = ParseGt("1|1:0,5:5"); !g.IsHomAlt() || !g.Phased || g.AD != nil {
+		t.Errorf("Error: unexpected genotype %v\n", g)
+	}
+}
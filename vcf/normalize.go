@@ -0,0 +1,305 @@
+package vcf
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/code"
+)
+
+// NormalizeSettings controls how records are normalized against a reference.
+type NormalizeSettings struct {
+	Split    bool   // split multi-allelic records into one record per alternate allele
+	CheckRef string // when REF does not match the reference, e to exit, w to warn and keep the record, x to exclude it or s to set REF from the reference
+	Window   int    // number of bases records are buffered by NormalizeAll so left aligned records are written in order
+}
+
+// DefaultNormalizeSettings splits multi-allelic records and exits when REF does not match the reference,
+// similar to bcftools norm -m-any --check-ref e.
+var DefaultNormalizeSettings = NormalizeSettings{Split: true, CheckRef: "e", Window: 1000}
+
+// Normalize will check REF against the reference, split a multi-allelic record into biallelic records when
+// settings.Split is set, then trim the bases shared by the alleles and left align indels in repeats, so the same
+// variant is represented the same way by every caller. Records with a REF that does not match are handled as given by
+// settings.CheckRef, and records with symbolic, breakend or * alleles are split but otherwise left unchanged.
+func Normalize(v *Vcf, ref map[string][]code.Dna, settings NormalizeSettings) []Vcf {
+	record := *v
+	seq, found := ref[v.Chr]
+	align := true
+	if !found || !refMatches(&record, seq) {
+		switch settings.CheckRef {
+		case "w":
+			log.Printf("Warning: REF %s at %s:%d does not match the reference\n", v.Ref, v.Chr, v.Pos)
+			align = false
+		case "x":
+			return nil
+		case "s":
+			if !found || v.Pos < 1 || v.Pos-1+len(v.Ref) > len(seq) {
+				log.Fatalf("Error: %s:%d is not part of the reference\n", v.Chr, v.Pos)
+			}
+			record.Ref = code.ToUpperString(seq[v.Pos-1 : v.Pos-1+len(v.Ref)])
+		default:
+			log.Fatalf("Error: REF %s at %s:%d does not match the reference\n", v.Ref, v.Chr, v.Pos)
+		}
+	}
+	ans := []Vcf{record}
+	if settings.Split {
+		ans = splitRecord(&record)
+	}
+	if align {
+		for i := range ans {
+			leftAlignRecord(&ans[i], seq)
+		}
+	}
+	return ans
+}
+
+// NormalizeAll will normalize every record of a sorted channel. Records are held until the input has moved
+// settings.Window bases past them, so records that were shifted to the left are still returned in sorted order.
+func NormalizeAll(records <-chan Vcf, ref map[string][]code.Dna, settings NormalizeSettings) <-chan Vcf {
+	ans := make(chan Vcf, 1000)
+	go func() {
+		var buffer []Vcf
+		for v := range records {
+			for len(buffer) > 0 && (buffer[0].Chr != v.Chr || buffer[0].Pos < v.Pos-settings.Window) {
+				ans <- buffer[0]
+				buffer = buffer[1:]
+			}
+			for _, record := range Normalize(&v, ref, settings) {
+				idx := sort.Search(len(buffer), func(j int) bool { return buffer[j].Pos > record.Pos })
+				buffer = append(buffer, Vcf{})
+				copy(buffer[idx+1:], buffer[idx:])
+				buffer[idx] = record
+			}
+		}
+		for _, record := range buffer {
+			ans <- record
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// LeftAlign will trim the bases shared by every allele and shift the alleles to the left most position with the same
+// sequences, keeping a single shared base when the alleles differ in length. Pos is the one-based position of the
+// first base of the alleles in ref, and the new position and alleles are returned in upper case.
+func LeftAlign(ref []code.Dna, pos int, alleles []string) (int, []string) {
+	seqs := make([][]byte, len(alleles))
+	for i := range alleles {
+		seqs[i] = []byte(strings.ToUpper(alleles[i]))
+	}
+	if len(seqs) < 2 || allEqual(seqs) {
+		return pos, toStrings(seqs)
+	}
+	for {
+		changed := false
+		if minLen(seqs) > 0 && sameBase(seqs, func(s []byte) byte { return s[len(s)-1] }) {
+			for i := range seqs {
+				seqs[i] = seqs[i][:len(seqs[i])-1]
+			}
+			changed = true
+		}
+		if minLen(seqs) == 0 && pos > 1 {
+			pos--
+			base := byte(code.ToUpper(ref[pos-1]))
+			for i := range seqs {
+				seqs[i] = append([]byte{base}, seqs[i]...)
+			}
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	for minLen(seqs) > 1 && sameBase(seqs, func(s []byte) byte { return s[0] }) {
+		for i := range seqs {
+			seqs[i] = seqs[i][1:]
+		}
+		pos++
+	}
+	if minLen(seqs) == 0 && pos-1+len(seqs[0]) < len(ref) {
+		// events at the first base of a reference are anchored to the base after them
+		base := byte(code.ToUpper(ref[pos-1+len(seqs[0])]))
+		for i := range seqs {
+			seqs[i] = append(seqs[i], base)
+		}
+	}
+	return pos, toStrings(seqs)
+}
+
+// leftAlignRecord will trim and left align the alleles of a record, leaving the record unchanged when it has an
+// allele that is not made of bases or when the alleles are already normalized.
+func leftAlignRecord(v *Vcf, seq []code.Dna) {
+	alleles := append([]string{v.Ref}, strings.Split(v.Alt, ",")...)
+	for _, allele := range alleles {
+		if !isBases(allele) {
+			return
+		}
+	}
+	pos, ans := LeftAlign(seq, v.Pos, alleles)
+	if pos == v.Pos && strings.EqualFold(strings.Join(ans, ","), strings.Join(alleles, ",")) {
+		return
+	}
+	v.Pos, v.Ref, v.Alt = pos, ans[0], strings.Join(ans[1:], ",")
+}
+
+// splitRecord will split a record with several alternate alleles into one record per alternate allele. Number=A, R
+// and G values of INFO and FORMAT fields declared in the header are subset to the alleles of each record, and
+// genotypes are re-indexed so the alternate allele of the record is 1 and other alternate alleles become 0.
+func splitRecord(v *Vcf) []Vcf {
+	alts := strings.Split(v.Alt, ",")
+	if len(alts) < 2 {
+		return []Vcf{*v}
+	}
+	ans := make([]Vcf, len(alts))
+	for i := range alts {
+		ans[i] = *v
+		ans[i].Alt = alts[i]
+		ans[i].Info = splitInfo(v, i+1, len(alts))
+		ans[i].Genotypes = make([]string, len(v.Genotypes))
+		for s := range v.Genotypes {
+			ans[i].Genotypes[s] = splitSample(v, s, i+1, len(alts))
+		}
+	}
+	return ans
+}
+
+func splitInfo(v *Vcf, alt int, alts int) string {
+	fields := strings.Split(v.Info, ";")
+	for i, field := range fields {
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			continue
+		}
+		if f, ok := v.infoField(field[:eq]); ok {
+			fields[i] = field[:eq+1] + splitValues(field[eq+1:], f, alt, alts, 2)
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+func splitSample(v *Vcf, sample int, alt int, alts int) string {
+	values := strings.Split(v.Genotypes[sample], ":")
+	ploidy := v.ploidy(sample)
+	for c, key := range v.Format {
+		if c >= len(values) {
+			break
+		}
+		if key == "GT" {
+			values[c] = splitGt(values[c], alt)
+		} else if f, ok := v.formatField(key); ok {
+			values[c] = splitValues(values[c], f, alt, alts, ploidy)
+		}
+	}
+	return strings.Join(values, ":")
+}
+
+// splitGt will re-index a genotype to the reference and a single alternate allele.
+func splitGt(gt string, alt int) string {
+	g := ParseGenotype(gt)
+	for i, a := range g.Alleles {
+		switch {
+		case int(a) == alt:
+			g.Alleles[i] = 1
+		case a > 0:
+			g.Alleles[i] = 0
+		}
+	}
+	return g.String()
+}
+
+// splitValues will keep the values of a field that belong to the reference and one alternate allele. Values that
+// do not have the number of values expected from the header are returned unchanged.
+func splitValues(text string, f Field, alt int, alts int, ploidy int) string {
+	if text == "." {
+		return text
+	}
+	values := strings.Split(text, ",")
+	switch {
+	case f.Number == "A" && len(values) == alts:
+		return values[alt-1]
+	case f.Number == "R" && len(values) == alts+1:
+		return values[0] + "," + values[alt]
+	case f.Number == "G" && len(values) == genotypeCount(alts+1, ploidy):
+		ans := make([]string, ploidy+1)
+		for k := range ans {
+			ans[k] = values[genotypeIndex(alt, k, ploidy)]
+		}
+		return strings.Join(ans, ",")
+	}
+	return text
+}
+
+// genotypeIndex returns the position, in the order of Number=G fields, of the genotype made of copies copies of an
+// alternate allele and reference alleles for the rest of the ploidy.
+func genotypeIndex(alt int, copies int, ploidy int) int {
+	var ans int
+	for m := ploidy - copies + 1; m <= ploidy; m++ {
+		ans += binomial(alt+m-1, m)
+	}
+	return ans
+}
+
+func binomial(n int, k int) int {
+	ans := 1
+	for i := 1; i <= k; i++ {
+		ans = ans * (n - k + i) / i
+	}
+	return ans
+}
+
+// refMatches returns true when the REF of a record matches the reference, ignoring case.
+func refMatches(v *Vcf, seq []code.Dna) bool {
+	if v.Pos < 1 || v.Pos-1+len(v.Ref) > len(seq) {
+		return false
+	}
+	return strings.EqualFold(v.Ref, code.ToUpperString(seq[v.Pos-1:v.Pos-1+len(v.Ref)]))
+}
+
+func isBases(allele string) bool {
+	for i := 0; i < len(allele); i++ {
+		switch allele[i] {
+		case 'A', 'C', 'G', 'T', 'N', 'a', 'c', 'g', 't', 'n':
+		default:
+			return false
+		}
+	}
+	return len(allele) > 0
+}
+
+func allEqual(seqs [][]byte) bool {
+	for _, s := range seqs[1:] {
+		if string(s) != string(seqs[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func minLen(seqs [][]byte) int {
+	ans := len(seqs[0])
+	for _, s := range seqs[1:] {
+		if len(s) < ans {
+			ans = len(s)
+		}
+	}
+	return ans
+}
+
+func sameBase(seqs [][]byte, base func([]byte) byte) bool {
+	for _, s := range seqs[1:] {
+		if base(s) != base(seqs[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func toStrings(seqs [][]byte) []string {
+	ans := make([]string, len(seqs))
+	for i := range seqs {
+		ans[i] = string(seqs[i])
+	}
+	return ans
+}
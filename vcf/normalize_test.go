@@ -0,0 +1,116 @@
+package vcf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edotau/goFish/code"
+)
+
+// testRef has a CA repeat from 4 to 9: TTGCACACAGGGTACGT
+var testRef = map[string][]code.Dna{"chr1": code.ToDna([]byte("TTGCACACAGGGTACGT"))}
+
+func testHeader() *Header {
+	header := NewHeader()
+	for _, line := range []string{
+		"##INFO=<ID=AC,Number=A,Type=Integer,Description=\"Allele count\">",
+		"##INFO=<ID=AF,Number=A,Type=Float,Description=\"Allele frequency\">",
+		"##INFO=<ID=DP,Number=1,Type=Integer,Description=\"Depth\">",
+		"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">",
+		"##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Allelic depths\">",
+		"##FORMAT=<ID=PL,Number=G,Type=Integer,Description=\"Phred-scaled likelihoods\">",
+	} {
+		f := parseField(line)
+		if line[2] == 'I' {
+			header.Info[f.Id] = f
+		} else {
+			header.Format[f.Id] = f
+		}
+	}
+	return &header
+}
+
+func normalizeLine(line string, settings NormalizeSettings) []string {
+	v := ParseVcf(line)
+	v.SetHeader(testHeader())
+	var ans []string
+	for _, record := range Normalize(v, testRef, settings) {
+		ans = append(ans, ToString(&record))
+	}
+	return ans
+}
+
+func TestLeftAlign(t *testing.T) {
+	var tests = []struct {
+		pos        int
+		alleles    []string
+		ansPos     int
+		ansAlleles []string
+	}{
+		{7, []string{"ACA", "A"}, 3, []string{"GCA", "G"}},
+		{9, []string{"A", "ACA"}, 3, []string{"G", "GCA"}},
+		{4, []string{"CAC", "CTC"}, 5, []string{"A", "T"}},
+		{4, []string{"cac", "cac"}, 4, []string{"CAC", "CAC"}},
+		{7, []string{"ACA", "A", "ACACA"}, 3, []string{"GCA", "G", "GCACA"}},
+		{1, []string{"TT", "T"}, 1, []string{"TT", "T"}},
+		{2, []string{"T", "TT"}, 1, []string{"T", "TT"}},
+	}
+	for _, test := range tests {
+		if pos, alleles := LeftAlign(testRef["chr1"], test.pos, test.alleles); pos != test.ansPos || !reflect.DeepEqual(alleles, test.ansAlleles) {
+			t.Errorf("Error: %d %v was left aligned to %d %v, expected %d %v\n", test.pos, test.alleles, pos, alleles, test.ansPos, test.ansAlleles)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	var tests = []struct {
+		line     string
+		settings NormalizeSettings
+		expected []string
+	}{
+		{"chr1\t5\t.\tA\tC,T\t50\tPASS\tAC=1,2;AF=0.25,0.5;DP=10\tGT:AD:PL\t1/2:1,4,5:10,20,30,40,50,60\t0|0:3,.,.:.",
+			DefaultNormalizeSettings,
+			[]string{"chr1\t5\t.\tA\tC\t50\tPASS\tAC=1;AF=0.25;DP=10\tGT:AD:PL\t1/0:1,4:10,20,30\t0|0:3,.:.",
+				"chr1\t5\t.\tA\tT\t50\tPASS\tAC=2;AF=0.5;DP=10\tGT:AD:PL\t0/1:1,5:10,40,60\t0|0:3,.:."}},
+		{"chr1\t7\t.\tACA\tA,ACACA\t.\t.\tAC=1,1\tGT\t1/2",
+			DefaultNormalizeSettings,
+			[]string{"chr1\t3\t.\tGCA\tG\t.\t.\tAC=1\tGT\t1/0", "chr1\t3\t.\tG\tGCA\t.\t.\tAC=1\tGT\t0/1"}},
+		{"chr1\t7\t.\tACA\tA,ACACA\t.\t.\tAC=1,1\tGT\t1/2",
+			NormalizeSettings{CheckRef: "e"},
+			[]string{"chr1\t3\t.\tGCA\tG,GCACA\t.\t.\tAC=1,1\tGT\t1/2"}},
+		{"chr1\t5\t.\tA\tC,*\t.\t.\t.\tGT\t1/2", DefaultNormalizeSettings,
+			[]string{"chr1\t5\t.\tA\tC\t.\t.\t.\tGT\t1/0", "chr1\t5\t.\tA\t*\t.\t.\t.\tGT\t0/1"}},
+		{"chr1\t4\t.\tCAC\tC\t.\t.\t.\tGT\t0/1", DefaultNormalizeSettings,
+			[]string{"chr1\t3\t.\tGCA\tG\t.\t.\t.\tGT\t0/1"}},
+		{"chr1\t4\t.\tTAC\tT\t.\t.\t.\tGT\t0/1", NormalizeSettings{CheckRef: "x"}, nil},
+		{"chr1\t4\t.\tTAC\tT\t.\t.\t.\tGT\t0/1", NormalizeSettings{CheckRef: "w"},
+			[]string{"chr1\t4\t.\tTAC\tT\t.\t.\t.\tGT\t0/1"}},
+		{"chr1\t4\t.\tTAC\tC\t.\t.\t.\tGT\t0/1", NormalizeSettings{CheckRef: "s"},
+			[]string{"chr1\t3\t.\tGCA\tG\t.\t.\t.\tGT\t0/1"}},
+		{"chr2\t4\t.\tT\tC\t.\t.\t.\tGT\t0/1", NormalizeSettings{CheckRef: "x"}, nil},
+	}
+	for _, test := range tests {
+		if ans := normalizeLine(test.line, test.settings); !reflect.DeepEqual(ans, test.expected) {
+			t.Errorf("Error: %q was normalized to\n%q\nexpected\n%q\n", test.line, ans, test.expected)
+		}
+	}
+}
+
+func TestNormalizeAll(t *testing.T) {
+	records := make(chan Vcf, 10)
+	for _, line := range []string{
+		"chr1\t5\t.\tA\tC\t.\t.\t.\tGT\t0/1",
+		"chr1\t9\t.\tA\tACA\t.\t.\t.\tGT\t0/1",
+		"chr1\t13\t.\tT\tG\t.\t.\t.\tGT\t0/1",
+	} {
+		records <- *ParseVcf(line)
+	}
+	close(records)
+	var ans []int
+	for v := range NormalizeAll(records, testRef, DefaultNormalizeSettings) {
+		ans = append(ans, v.Pos)
+	}
+	if !reflect.DeepEqual(ans, []int{3, 5, 13}) {
+		t.Errorf("Error: normalized records were returned at %v, expected 3 5 13\n", ans)
+	}
+}
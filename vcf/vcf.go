@@ -3,7 +3,6 @@ package vcf
 
 import (
 	"bytes"
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
@@ -19,10 +18,13 @@ type Vcf struct {
 	Ref       string
 	Alt       string
 	Qual      float32
+	NoQual    bool // true when the quality is missing, written as '.'
 	Filter    string
 	Info      string
 	Format    []string
 	Genotypes []string
+	qual      string // the quality as it was written in the file, so it can be written back unchanged
+	header    *Header
 }
 
 //ReadToChan is a helper function.
@@ -33,14 +35,6 @@ func ReadToChan(file *VcfReader, data chan<- Vcf) {
 	close(data)
 }
 
-/*
-type Genotype struct {
-	One    int16
-	Two    int16
-	Phased bool
-	Depth  []byte
-}*/
-
 // VcfReader struct contains a simple reader and additional fields to help reduce memory allocation when processing lines in a vcf file.
 type VcfReader struct {
 	Reader *simpleio.SimpleReader
@@ -48,6 +42,7 @@ type VcfReader struct {
 	data   []string
 	done   bool
 	Info   map[string]int
+	header *Header
 }
 
 // NewHeader will allocate initial memory for a new Vcf Header and create maps used for genotypes.
@@ -57,6 +52,8 @@ func NewHeader() Header {
 	}
 	answer.Ref = make(map[string]int)
 	answer.Samples = make(map[string]int)
+	answer.Info = make(map[string]Field)
+	answer.Format = make(map[string]Field)
 	return answer
 }
 
@@ -77,25 +74,40 @@ func UnmarshalVcf(file *VcfReader) (*Vcf, bool) {
 	file.Reader.Buffer, file.done = simpleio.ReadLine(file.Reader)
 	if !file.done {
 		file.data = strings.SplitN(file.Reader.Buffer.String(), "\t", 10)
-		if len(file.data) < 9 {
-			log.Fatalf("Error when reading this vcf line:\n%s\nExpecting at least 9 columns", file.data)
-		}
-		file.record = &Vcf{Chr: file.data[0], Pos: simpleio.StringToInt(file.data[1]), Id: file.data[2], Ref: file.data[3], Alt: file.data[4], Filter: file.data[6], Info: file.data[7], Format: strings.Split(file.data[8], ":")}
-		if strings.Compare(file.data[5], ".") == 0 {
-			file.record.Qual = 255
-		} else {
-			file.record.Qual = simpleio.StringToFloat(file.data[5])
-		}
-		if len(file.data) > 9 {
-			file.record.Genotypes = strings.Split(file.data[9], "\t")
-			//file.record.Genotypes = GetAlleleGenotype(file.data[9])
-		}
+		file.record = columnsToVcf(file.data)
+		file.record.header = file.header
 		return file.record, file.done
 	} else {
 		return nil, file.done
 	}
 }
 
+// ParseVcf will convert a single line of vcf text into a Vcf struct.
+func ParseVcf(line string) *Vcf {
+	return columnsToVcf(strings.SplitN(line, "\t", 10))
+}
+
+// columnsToVcf is a helper function that assigns the data fields of a Vcf from the tab separated columns of a line.
+// Sites only lines have 8 columns and leave Format and Genotypes nil.
+func columnsToVcf(data []string) *Vcf {
+	if len(data) < 8 {
+		log.Fatalf("Error when reading this vcf line:\n%s\nExpecting at least 8 columns", data)
+	}
+	ans := &Vcf{Chr: data[0], Pos: simpleio.StringToInt(data[1]), Id: data[2], Ref: data[3], Alt: data[4], Filter: data[6], Info: data[7], qual: data[5]}
+	if len(data) > 8 {
+		ans.Format = strings.Split(data[8], ":")
+	}
+	if strings.Compare(data[5], ".") == 0 {
+		ans.NoQual = true
+	} else {
+		ans.Qual = simpleio.StringToFloat(data[5])
+	}
+	if len(data) > 9 {
+		ans.Genotypes = strings.Split(data[9], "\t")
+	}
+	return ans
+}
+
 // ReadHeader will allocate inital memory needed to process and store Vcf Header data.
 func ReadHeader(file *VcfReader) *Header {
 	var line *bytes.Buffer
@@ -109,6 +121,7 @@ func ReadHeader(file *VcfReader) *Header {
 			parseHeaderData(&header, line)
 		}
 	}
+	file.header = &header
 	return &header
 }
 
@@ -132,24 +145,43 @@ func ToString(v *Vcf) string {
 	var str strings.Builder
 	str.WriteString(v.Chr)
 	str.WriteByte('\t')
+	str.WriteString(strconv.Itoa(v.Pos))
+	str.WriteByte('\t')
 	str.WriteString(v.Id)
 	str.WriteByte('\t')
 	str.WriteString(v.Ref)
 	str.WriteByte('\t')
 	str.WriteString(v.Alt)
 	str.WriteByte('\t')
-	str.WriteString(fmt.Sprintf("%f", v.Qual))
+	str.WriteString(qualString(v))
 	str.WriteByte('\t')
 	str.WriteString(v.Filter)
 	str.WriteByte('\t')
 	str.WriteString(v.Info)
+	if len(v.Format) == 0 {
+		return str.String()
+	}
 	str.WriteByte('\t')
 	str.WriteString(strings.Join(v.Format, ":"))
-	str.WriteByte('\t')
-	str.WriteString(strings.Join(v.Genotypes, "\t"))
+	if len(v.Genotypes) > 0 {
+		str.WriteByte('\t')
+		str.WriteString(strings.Join(v.Genotypes, "\t"))
+	}
 	return str.String()
 }
 
+// qualString will format the quality of a record, keeping the text read from the file unless Qual has been changed.
+func qualString(v *Vcf) string {
+	switch {
+	case v.qual != "" && v.qual != "." && !v.NoQual && simpleio.StringToFloat(v.qual) == v.Qual:
+		return v.qual
+	case v.NoQual:
+		return "."
+	default:
+		return strconv.FormatFloat(float64(v.Qual), 'f', -1, 32)
+	}
+}
+
 func ReadVcfs(filename string) []Vcf {
 	file := NewReader(filename)
 	var ans []Vcf
@@ -168,13 +200,15 @@ func GenomeKeyUint64(chrom int, start int) uint64 {
 	return answer
 }
 
-// Header contains a string builder to hold header info from a vcf file.
+// Header contains a string builder to hold header info from a vcf file, along with the INFO and FORMAT fields
+// declared in the header.
 type Header struct {
-	Text strings.Builder
-	//Format map[string]int
+	Text       strings.Builder
 	Ref        map[string]int
 	ChromSizes []ChromSize
 	Samples    map[string]int
+	Info       map[string]Field
+	Format     map[string]Field
 }
 
 type ChromSize struct {
@@ -198,6 +232,14 @@ func parseHeaderData(header *Header, line *bytes.Buffer) {
 				header.ChromSizes = append(header.ChromSizes, chrom)
 			}
 		}
+		if strings.HasPrefix(line.String(), "##INFO=<") {
+			f := parseField(line.String())
+			header.Info[f.Id] = f
+		}
+		if strings.HasPrefix(line.String(), "##FORMAT=<") {
+			f := parseField(line.String())
+			header.Format[f.Id] = f
+		}
 		if strings.HasPrefix(line.String(), "#CHROM") {
 			words := strings.Split(line.String(), "\t")[9:]
 			for hapIdx = 0; hapIdx < len(words); hapIdx++ {
@@ -209,38 +251,6 @@ func parseHeaderData(header *Header, line *bytes.Buffer) {
 	}
 }
 
-func GetGenotypes(samples string) []Genotype {
-	text := strings.Split(samples, "\t")
-	var hap string
-	var alleles []string
-	var err error
-	var n int64
-	var answer []Genotype = make([]Genotype, len(text))
-	for i := 0; i < len(text); i++ {
-		hap = strings.Split(text[i], ":")[0]
-		if strings.Compare(hap, "./.") == 0 || strings.Compare(hap, ".|.") == 0 {
-			//answer[i] = nil
-			answer[i] = Genotype{AlleleOne: -1, AlleleTwo: -1, Phased: false}
-		} else if strings.Contains(hap, "|") {
-			alleles = strings.SplitN(hap, "|", 2)
-			answer[i] = Genotype{AlleleOne: int16(simpleio.StringToInt(alleles[0])), AlleleTwo: int16(simpleio.StringToInt(alleles[1])), Phased: true}
-		} else if strings.Contains(hap, "/") {
-			alleles = strings.SplitN(hap, "/", 2)
-			answer[i] = Genotype{AlleleOne: int16(simpleio.StringToInt(alleles[0])), AlleleTwo: int16(simpleio.StringToInt(alleles[1])), Phased: false}
-		} else {
-			//Deal with single haps. There might be a better soltuion, but I think this should work.
-			n, err = strconv.ParseInt(alleles[0], 10, 16)
-			if err != nil && n < int64(len(text)) {
-				answer[i] = Genotype{AlleleOne: int16(n), AlleleTwo: -1, Phased: false}
-			} else {
-				log.Fatalf("Error: Unexpected parsing error...\n")
-			}
-		}
-	}
-	return answer
-
-}
-
 //Parse Vcf header to quickly print sample names that appear inside Vcf
 func PrintSampleNames(header *Header) string {
 	var buffer strings.Builder
@@ -262,51 +272,6 @@ func PrintSampleNamesLine(header *Header) string {
 	return buffer.String()
 }
 
-type Genotype struct {
-	AlleleOne int16
-	AlleleTwo int16
-	Phased    bool
-}
-
-func ParseGt(data string) Genotype {
-	gt := []byte(data)
-	ans := Genotype{}
-	if gt[0] != '.' && gt[2] != '.' {
-		ans = Genotype{AlleleOne: int16(simpleio.StringToInt(string(gt[0]))), AlleleTwo: int16(simpleio.StringToInt(string(gt[2])))}
-		switch gt[1] {
-		case '/':
-			ans.Phased = false
-		case '|':
-			ans.Phased = true
-		default:
-			log.Fatalf("Error: unexpected parasing occured, expecting '/' or '|', but found %v\n", gt[1])
-		}
-	} else if gt[0] != '.' && gt[2] == '.' {
-		ans = Genotype{AlleleOne: int16(simpleio.StringToInt(string(gt[0]))), AlleleTwo: -1, Phased: false}
-	} else {
-		ans = Genotype{AlleleOne: -1, AlleleTwo: -1, Phased: false}
-	}
-	return ans
-}
-
-func GtToString(gt Genotype) string {
-	buffer := strings.Builder{}
-	buffer.Grow(3)
-	if gt.AlleleOne == -1 && gt.AlleleTwo == -1 {
-		buffer.WriteByte('.')
-		buffer.WriteByte('/')
-	} else {
-		buffer.WriteString(simpleio.Int16ToString((gt.AlleleOne)))
-		if !gt.Phased {
-			buffer.WriteByte('/')
-		} else {
-			buffer.WriteByte('|')
-		}
-		buffer.WriteString(simpleio.Int16ToString((gt.AlleleTwo)))
-	}
-	return buffer.String()
-}
-
 func MkFormatMap(v *Vcf) map[string]int {
 	ans := make(map[string]int)
 	for i := 0; i < len(v.Format); i++ {
@@ -330,11 +295,6 @@ func FindAlleleDepth(format map[string]int, line []string) string {
 	return line[format["AD"]]
 }
 
-type AlleleDepth struct {
-	Gt    Genotype
-	Depth []int
-}
-
 func GetGenotypeDepth(v *Vcf) []string {
 	format := MkFormatMap(v)
 	var ans []string = make([]string, len(v.Genotypes))
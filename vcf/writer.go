@@ -0,0 +1,97 @@
+package vcf
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/edotau/goFish/simpleio"
+)
+
+// Writer writes a vcf header followed by records as plain text, or as bgzf compressed text that can be indexed
+// with tabix when the file name ends in .gz.
+type Writer struct {
+	io.Writer
+	close func()
+}
+
+// NewWriter will create a vcf file and write the header. The header text is written unchanged, so the meta
+// lines and sample columns of a file that was read with ReadHeader are kept as they were.
+func NewWriter(filename string, header *Header) *Writer {
+	ans := &Writer{}
+	if strings.HasSuffix(filename, ".gz") {
+		bgzip := simpleio.NewBgzipWriter(filename)
+		ans.Writer, ans.close = bgzip, bgzip.Close
+	} else {
+		text := simpleio.NewWriter(filename)
+		ans.Writer, ans.close = text, text.Close
+	}
+	WriteHeader(ans, header)
+	return ans
+}
+
+// WriteHeader will write the text of a vcf header, adding a #CHROM line with the header samples if there is none.
+func WriteHeader(writer io.Writer, header *Header) {
+	text := header.Text.String()
+	_, err := io.WriteString(writer, text)
+	simpleio.StdError(err)
+	if !strings.HasPrefix(text, "#CHROM") && !strings.Contains(text, "\n#CHROM") {
+		_, err = io.WriteString(writer, columnLine(header))
+		simpleio.StdError(err)
+	}
+}
+
+// WriteVcf will write a single record as a line of text.
+func WriteVcf(writer *Writer, v *Vcf) {
+	_, err := io.WriteString(writer, ToString(v)+"\n")
+	simpleio.StdError(err)
+}
+
+// Close will flush any buffered text, finish the bgzf file with an end of file marker and close the file.
+func (writer *Writer) Close() {
+	writer.close()
+}
+
+// Write will create a vcf file with a header and write every record of a channel.
+func Write(filename string, header *Header, records <-chan Vcf) {
+	writer := NewWriter(filename, header)
+	for i := range records {
+		WriteVcf(writer, &i)
+	}
+	writer.Close()
+}
+
+// AddSourceLine will record the program that wrote a vcf in the header, as a ##source line followed by a
+// ##<name>Command line holding args joined by spaces. The lines are added after the other meta lines and before the
+// #CHROM line.
+func AddSourceLine(header *Header, name string, args []string) {
+	text := header.Text.String()
+	lines := "##source=" + name + "\n##" + name + "Command=" + strings.Join(args, " ") + "\n"
+	split := len(text)
+	if strings.HasPrefix(text, "#CHROM") {
+		split = 0
+	} else if i := strings.Index(text, "\n#CHROM"); i >= 0 {
+		split = i + 1
+	}
+	header.Text.Reset()
+	header.Text.WriteString(text[:split])
+	header.Text.WriteString(lines)
+	header.Text.WriteString(text[split:])
+}
+
+// columnLine returns the #CHROM line of a header, with the FORMAT column and sample names when there are samples.
+func columnLine(header *Header) string {
+	var ans strings.Builder
+	ans.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO")
+	samples := make([]string, 0, len(header.Samples))
+	for name := range header.Samples {
+		samples = append(samples, name)
+	}
+	sort.Slice(samples, func(i, j int) bool { return header.Samples[samples[i]] < header.Samples[samples[j]] })
+	if len(samples) > 0 {
+		ans.WriteString("\tFORMAT\t")
+		ans.WriteString(strings.Join(samples, "\t"))
+	}
+	ans.WriteByte('\n')
+	return ans.String()
+}
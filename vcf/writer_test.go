@@ -0,0 +1,113 @@
+package vcf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readText will return the uncompressed text of a plain or gzip compressed file.
+func readText(t *testing.T, filename string) []byte {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	}
+	ans, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ans
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	expected := readText(t, testVcf)
+	for _, name := range []string{"small.vcf", "small.vcf.gz"} {
+		output := filepath.Join(t.TempDir(), name)
+		reader := NewReader(testVcf)
+		header := ReadHeader(reader)
+		records := make(chan Vcf, 1000)
+		go ReadToChan(reader, records)
+		Write(output, header, records)
+		if ans := readText(t, output); !bytes.Equal(ans, expected) {
+			t.Errorf("Error: writing %s did not reproduce the text of %s\n", name, testVcf)
+		}
+	}
+	// the end of a bgzf file is marked by an empty block
+	output := filepath.Join(t.TempDir(), "small.vcf.gz")
+	empty := make(chan Vcf)
+	close(empty)
+	Write(output, ReadHeader(NewReader(testVcf)), empty)
+	eof := []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0, 'B', 'C', 0x02, 0, 0x1b, 0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if data, err := os.ReadFile(output); err != nil || !bytes.HasSuffix(data, eof) {
+		t.Errorf("Error: %s does not end with a bgzf end of file block\n", output)
+	}
+}
+
+func TestAddSourceLine(t *testing.T) {
+	header := NewHeader()
+	header.Text.WriteString("##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+	AddSourceLine(&header, "vcfTest", []string{"vcfTest", "-a", "in.vcf"})
+	expected := "##fileformat=VCFv4.2\n##source=vcfTest\n##vcfTestCommand=vcfTest -a in.vcf\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n"
+	if header.Text.String() != expected {
+		t.Errorf("Error: the header was\n%s\nexpected\n%s\n", header.Text.String(), expected)
+	}
+}
+
+func TestWriteHeaderColumns(t *testing.T) {
+	header := NewHeader()
+	header.Text.WriteString("##fileformat=VCFv4.2\n")
+	header.Samples["b"], header.Samples["a"] = 0, 1
+	var ans bytes.Buffer
+	WriteHeader(&ans, &header)
+	if expected := "##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tb\ta\n"; ans.String() != expected {
+		t.Errorf("Error: the header was written as\n%s\nexpected\n%s\n", ans.String(), expected)
+	}
+	v := Vcf{Chr: "chr1", Pos: 10, Id: ".", Ref: "A", Alt: "G", Qual: 30.5, Filter: "PASS", Info: "DP=3"}
+	if ans := ToString(&v); ans != "chr1\t10\t.\tA\tG\t30.5\tPASS\tDP=3" {
+		t.Errorf("Error: a record without samples was written as %s\n", ans)
+	}
+}
+
+func TestQualRoundTrip(t *testing.T) {
+	for _, line := range []string{
+		"chr1\t10\t.\tA\tG\t255\tPASS\tDP=3",
+		"chr1\t10\t.\tA\tG\t.\tPASS\tDP=3",
+		"chr1\t10\t.\tA\tG\t30.50\tPASS\tDP=3",
+		"chr1\t10\t.\tA\tG\t0\tPASS\tDP=3",
+	} {
+		if ans := ToString(ParseVcf(line)); ans != line {
+			t.Errorf("Error: %q was written as %q\n", line, ans)
+		}
+	}
+	v := ParseVcf("chr1\t10\t.\tA\tG\t.\tPASS\tDP=3")
+	if !v.NoQual || v.Qual != 0 {
+		t.Errorf("Error: a missing quality was read as %v %t\n", v.Qual, v.NoQual)
+	}
+	v.Qual, v.NoQual = 255, false
+	if ans := ToString(v); ans != "chr1\t10\t.\tA\tG\t255\tPASS\tDP=3" {
+		t.Errorf("Error: a quality of 255 was written as %q\n", ans)
+	}
+}
+
+func TestSitesOnly(t *testing.T) {
+	v := ParseVcf("chr1\t10\trs1\tA\tG,T\t50\tPASS\tAC=1,2")
+	if v.Format != nil || v.Genotypes != nil || v.Alt != "G,T" || v.Info != "AC=1,2" {
+		t.Errorf("Error: unexpected sites only record %+v\n", v)
+	}
+	if len(v.AllGenotypes()) != 0 {
+		t.Errorf("Error: a sites only record should not have genotypes...\n")
+	}
+}